@@ -122,6 +122,7 @@ build_init() {
     
     cd "${PROJECT_ROOT}/init" || exit 1
     go build -o "${BUILD_DIR}/init"
+    go build -o "${BUILD_DIR}/ldhctl" ./cmd/ldhctl
     
     # 返回项目根目录
     cd "${PROJECT_ROOT}" || exit 1
@@ -51,15 +51,15 @@ echo "复制init二进制..."
 cp "$INIT_BINARY" "$INITRAMFS_DIR/init"
 chmod 755 "$INITRAMFS_DIR/init"
 
+# 控制工具（可选）
+if [ -f "$OUTPUT_DIR/ldhctl" ]; then
+    cp "$OUTPUT_DIR/ldhctl" "$INITRAMFS_DIR/sbin/ldhctl"
+    chmod 755 "$INITRAMFS_DIR/sbin/ldhctl"
+fi
+
 echo "创建默认配置文件..."
 cat > "$INITRAMFS_DIR/etc/ldh-os/services.yaml" << 'EOF'
-# LDH-OS 默认服务配置
-syslog:
-  description: "系统日志服务"
-  type: "daemon"
-  exec: "/bin/echo 'Syslog服务启动模拟'"
-  restart: "always"
-
+# LDH-OS 默认服务配置（日志由 init 内置的 journal 收集）
 test:
   description: "测试服务"
   type: "oneshot"
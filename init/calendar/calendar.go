@@ -0,0 +1,363 @@
+// Package calendar 解析定时器使用的日历表达式并计算下一次触发时间。
+//
+// 支持两种格式：
+//   - cron：五个字段 "分 时 日 月 周"，例如 "*/5 * * * *"、"0 9 * * mon-fri"，以及 @hourly、@daily 等简写
+//   - OnCalendar：systemd 风格的 "[星期] [日期] [时间]"，例如 "Mon..Fri *-*-* 09:00"、"*-*-01 00:00:00"、
+//     "*:0/15"，以及 minutely、hourly、daily、weekly、monthly、quarterly、yearly 等简写
+package calendar
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxYear 查找下一次触发时间的年份上限，超过时认为表达式不再触发
+const maxYear = 2200
+
+// Schedule 解析后的日历表达式：每个字段允许的取值集合
+type Schedule struct {
+	expr     string
+	second   uint64 // 0-59
+	minute   uint64 // 0-59
+	hour     uint64 // 0-23
+	day      uint64 // 1-31
+	month    uint64 // 1-12
+	weekday  uint64 // 0-6，0 为星期日
+	years    []span // 为空时不限年份
+	dayOrDow bool   // cron 语义：日和周都受限时满足其一即可
+}
+
+// span 年份范围 [from, to]，步长 step
+type span struct {
+	from, to, step int
+}
+
+var weekdayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	"sunday": 0, "monday": 1, "tuesday": 2, "wednesday": 3, "thursday": 4, "friday": 5, "saturday": 6,
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+// cronShorthands cron 简写
+var cronShorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// calendarShorthands OnCalendar 简写
+var calendarShorthands = map[string]string{
+	"minutely":     "*-*-* *:*:00",
+	"hourly":       "*-*-* *:00:00",
+	"daily":        "*-*-* 00:00:00",
+	"weekly":       "Mon *-*-* 00:00:00",
+	"monthly":      "*-*-01 00:00:00",
+	"quarterly":    "*-01,04,07,10-01 00:00:00",
+	"semiannually": "*-01,07-01 00:00:00",
+	"yearly":       "*-01-01 00:00:00",
+	"annually":     "*-01-01 00:00:00",
+}
+
+// Parse 解析日历表达式：五个不含 ':' 的字段或以 @ 开头时按 cron 解析，否则按 OnCalendar 解析
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, fmt.Errorf("empty calendar expression")
+	}
+	fields := strings.Fields(expr)
+	var s *Schedule
+	var err error
+	if strings.HasPrefix(expr, "@") || (len(fields) == 5 && !strings.Contains(expr, ":")) {
+		s, err = parseCron(strings.ToLower(expr))
+	} else {
+		s, err = parseCalendar(expr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid calendar expression %q: %v", expr, err)
+	}
+	s.expr = expr
+	return s, nil
+}
+
+// String 返回原始表达式
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+// parseCron 解析 "分 时 日 月 周"
+func parseCron(expr string) (*Schedule, error) {
+	if full, ok := cronShorthands[expr]; ok {
+		expr = full
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	s := &Schedule{second: 1}
+	var err error
+	if s.minute, err = parseField(fields[0], 0, 59, nil, "-"); err != nil {
+		return nil, fmt.Errorf("minute: %v", err)
+	}
+	if s.hour, err = parseField(fields[1], 0, 23, nil, "-"); err != nil {
+		return nil, fmt.Errorf("hour: %v", err)
+	}
+	if s.day, err = parseField(fields[2], 1, 31, nil, "-"); err != nil {
+		return nil, fmt.Errorf("day of month: %v", err)
+	}
+	if s.month, err = parseField(fields[3], 1, 12, monthNames, "-"); err != nil {
+		return nil, fmt.Errorf("month: %v", err)
+	}
+	// 星期日可以写作 0 或 7
+	if s.weekday, err = parseField(fields[4], 0, 7, weekdayNames, "-"); err != nil {
+		return nil, fmt.Errorf("day of week: %v", err)
+	}
+	if s.weekday&(1<<7) != 0 {
+		s.weekday = s.weekday&^(1<<7) | 1
+	}
+	s.dayOrDow = fields[2] != "*" && fields[4] != "*"
+	return s, nil
+}
+
+// parseCalendar 解析 "[星期] [日期] [时间]"，日期为 [年-]月-日，时间为 时:分[:秒]；
+// 省略日期时为每天，省略时间时为 00:00:00
+func parseCalendar(expr string) (*Schedule, error) {
+	if full, ok := calendarShorthands[strings.ToLower(expr)]; ok {
+		expr = full
+	}
+
+	s := &Schedule{weekday: all(0, 6)}
+	date, clock := "*-*-*", "00:00:00"
+	fields := strings.Fields(expr)
+	if len(fields) > 0 && isWeekdayList(fields[0]) {
+		var err error
+		if s.weekday, err = parseField(strings.ToLower(fields[0]), 0, 6, weekdayNames, ".."); err != nil {
+			return nil, fmt.Errorf("weekday: %v", err)
+		}
+		fields = fields[1:]
+	}
+	for i, f := range fields {
+		switch {
+		case i > 1:
+			return nil, fmt.Errorf("unexpected %q", f)
+		case strings.Contains(f, ":"):
+			clock = f
+		case strings.Contains(f, "-") && i == 0:
+			date = f
+		default:
+			return nil, fmt.Errorf("unexpected %q", f)
+		}
+	}
+
+	parts := strings.Split(date, "-")
+	if len(parts) == 2 {
+		parts = append([]string{"*"}, parts...)
+	}
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("date must be [YEAR-]MONTH-DAY")
+	}
+	if parts[0] != "*" {
+		years, err := parseYears(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("year: %v", err)
+		}
+		s.years = years
+	}
+	var err error
+	if s.month, err = parseField(parts[1], 1, 12, nil, ".."); err != nil {
+		return nil, fmt.Errorf("month: %v", err)
+	}
+	if s.day, err = parseField(parts[2], 1, 31, nil, ".."); err != nil {
+		return nil, fmt.Errorf("day: %v", err)
+	}
+
+	parts = strings.Split(clock, ":")
+	if len(parts) == 2 {
+		parts = append(parts, "00")
+	}
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("time must be HOUR:MINUTE[:SECOND]")
+	}
+	if s.hour, err = parseField(parts[0], 0, 23, nil, ".."); err != nil {
+		return nil, fmt.Errorf("hour: %v", err)
+	}
+	if s.minute, err = parseField(parts[1], 0, 59, nil, ".."); err != nil {
+		return nil, fmt.Errorf("minute: %v", err)
+	}
+	if s.second, err = parseField(parts[2], 0, 59, nil, ".."); err != nil {
+		return nil, fmt.Errorf("second: %v", err)
+	}
+	return s, nil
+}
+
+// isWeekdayList 判断字段是否是星期列表（只含字母、逗号和点）
+func isWeekdayList(f string) bool {
+	for _, c := range f {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == ',' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseField 解析逗号分隔的取值列表，每项为 *、值、范围（a-b 或 a..b），可带 /步长；
+// 单个值带步长时表示从该值开始到最大值。返回取值的位集合
+func parseField(field string, min, max int, names map[string]int, rangeSep string) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", item)
+			}
+			step, item = n, item[:i]
+		}
+
+		from, to := min, max
+		switch {
+		case item == "*":
+		case strings.Contains(item, rangeSep):
+			bounds := strings.SplitN(item, rangeSep, 2)
+			var err error
+			if from, err = parseValue(bounds[0], min, max, names); err != nil {
+				return 0, err
+			}
+			if to, err = parseValue(bounds[1], min, max, names); err != nil {
+				return 0, err
+			}
+			if from > to {
+				return 0, fmt.Errorf("invalid range %q", item)
+			}
+		default:
+			v, err := parseValue(item, min, max, names)
+			if err != nil {
+				return 0, err
+			}
+			from = v
+			if step == 1 {
+				to = v
+			}
+		}
+		for v := from; v <= to; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func parseValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", v, min, max)
+	}
+	return v, nil
+}
+
+// parseYears 解析年份列表，范围使用 ..
+func parseYears(field string) ([]span, error) {
+	var spans []span
+	for _, item := range strings.Split(field, ",") {
+		sp := span{step: 1}
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", item)
+			}
+			sp.step, item = n, item[:i]
+		}
+		bounds := strings.SplitN(item, "..", 2)
+		var err error
+		if sp.from, err = parseValue(bounds[0], 1970, maxYear, nil); err != nil {
+			return nil, err
+		}
+		sp.to = sp.from
+		if len(bounds) == 2 {
+			if sp.to, err = parseValue(bounds[1], sp.from, maxYear, nil); err != nil {
+				return nil, err
+			}
+		} else if sp.step > 1 {
+			sp.to = maxYear
+		}
+		spans = append(spans, sp)
+	}
+	return spans, nil
+}
+
+func all(min, max int) uint64 {
+	var set uint64
+	for v := min; v <= max; v++ {
+		set |= 1 << uint(v)
+	}
+	return set
+}
+
+func has(set uint64, v int) bool {
+	return set&(1<<uint(v)) != 0
+}
+
+func (s *Schedule) yearMatches(year int) bool {
+	if len(s.years) == 0 {
+		return true
+	}
+	for _, sp := range s.years {
+		if year >= sp.from && year <= sp.to && (year-sp.from)%sp.step == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	day, dow := has(s.day, t.Day()), has(s.weekday, int(t.Weekday()))
+	if s.dayOrDow {
+		return day || dow
+	}
+	return day && dow
+}
+
+// Next 返回 after 之后（不含）第一个满足表达式的时间，按 after 的时区计算。
+// 表达式不再触发时（例如 2 月 30 日）返回零值
+func (s *Schedule) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := after.Truncate(time.Second).Add(time.Second)
+	for t.Year() <= maxYear {
+		y, m, d := t.Date()
+		switch {
+		case !s.yearMatches(y):
+			t = time.Date(y+1, 1, 1, 0, 0, 0, 0, loc)
+		case !has(s.month, int(m)):
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+		case !has(s.hour, t.Hour()):
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, loc)
+		case !has(s.minute, t.Minute()):
+			t = time.Date(y, m, d, t.Hour(), t.Minute()+1, 0, 0, loc)
+		case !has(s.second, t.Second()):
+			t = t.Add(time.Second)
+		default:
+			return t
+		}
+		if len(s.years) == 0 && t.Year() > after.Year()+8 {
+			break
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,61 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// 2026-10-19 是星期一
+	base := time.Date(2026, 10, 19, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"*/5 * * * *", "2026-10-19 10:10:00"},
+		{"0 9 * * mon-fri", "2026-10-20 09:00:00"},
+		{"30 2 1 * *", "2026-11-01 02:30:00"},
+		{"0 0 * * 0", "2026-10-25 00:00:00"},
+		{"0 0 * * 7", "2026-10-25 00:00:00"},
+		{"0 0 13 * fri", "2026-10-23 00:00:00"}, // 日和周都受限时满足其一
+		{"@monthly", "2026-11-01 00:00:00"},
+		{"0 12 * jan,jul *", "2027-01-01 12:00:00"},
+		{"daily", "2026-10-20 00:00:00"},
+		{"hourly", "2026-10-19 11:00:00"},
+		{"weekly", "2026-10-26 00:00:00"},
+		{"quarterly", "2027-01-01 00:00:00"},
+		{"Mon..Fri *-*-* 09:00", "2026-10-20 09:00:00"},
+		{"Sat,Sun 10:00", "2026-10-24 10:00:00"},
+		{"*:0/15", "2026-10-19 10:15:00"},
+		{"*-*-* *:*:0/20", "2026-10-19 10:07:40"},
+		{"*-*-01 00:00:00", "2026-11-01 00:00:00"},
+		{"12-25 08:00", "2026-12-25 08:00:00"},
+		{"2028-02-29", "2028-02-29 00:00:00"},
+		{"Fri *-*-13", "2026-11-13 00:00:00"}, // 日和周同时满足
+		{"2020-01-01", ""},
+		{"*-02-30", ""},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tt.expr, err)
+			continue
+		}
+		got := ""
+		if next := s.Next(base); !next.IsZero() {
+			got = next.Format("2006-01-02 15:04:05")
+		}
+		if got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.expr, tt.want, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *",
+		"* * * * funday", "Mon *-*-* 25:00", "*-13-01", "tomorrow", "*-*-* 00:00 extra"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Expected %q to be rejected", expr)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+// ldhctl 是 LDH-OS init 的命令行控制工具，通过控制套接字与 init 通信
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ldh-os/init/control"
+	"ldh-os/init/journal"
+	"ldh-os/init/service"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: ldhctl [-socket path] <command> [arguments]
+
+Commands:
+  list                          list services and their states
+  status <service>              show service status
+  start [-rollback] <service>   start a service and the services it depends on
+  stop|restart <service>        control a service
+  jobs                          list recent start jobs
+  targets                       list targets
+  timers                        list timers and their next trigger times
+  metrics [-history] [service]  show resource usage of running services
+  pressure                      show system memory, CPU and IO pressure
+  isolate [-rollback] <target>  switch to a target, stopping services outside it
+  events [options]              print events as JSON lines
+  logs [options]                query the journal
+  mcp <service> <function> [params-json]
+                                call an MCP function
+  continue                      leave emergency mode and resume booting
+  reexec                        re-execute init
+  poweroff|reboot|halt|kexec    shut the system down
+`)
+	os.Exit(2)
+}
+
+func main() {
+	socket := flag.String("socket", control.DefaultSocketPath, "control socket path")
+	flag.Usage = usage
+	flag.Parse()
+	if env := os.Getenv("LDH_CONTROL_SOCKET"); env != "" && !isFlagSet("socket") {
+		*socket = env
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+	}
+
+	var err error
+	switch cmd := args[0]; cmd {
+	case "list":
+		err = list(*socket)
+	case "status":
+		err = status(*socket, serviceArg(args))
+	case "start":
+		err = start(*socket, args[1:])
+	case "jobs":
+		err = jobs(*socket)
+	case "targets":
+		err = targets(*socket)
+	case "timers":
+		err = timers(*socket)
+	case "metrics":
+		err = metrics(*socket, args[1:])
+	case "pressure":
+		err = pressure(*socket)
+	case "isolate":
+		err = isolate(*socket, args[1:])
+	case "stop", "restart":
+		err = control.Call(*socket, cmd, map[string]string{"service": serviceArg(args)}, nil)
+	case "events":
+		err = events(*socket, args[1:])
+	case "logs":
+		err = logs(*socket, args[1:])
+	case "mcp":
+		err = mcp(*socket, args[1:])
+	case "continue", "reexec", "poweroff", "reboot", "halt", "kexec":
+		err = control.Call(*socket, cmd, nil, nil)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ldhctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func serviceArg(args []string) string {
+	if len(args) != 2 {
+		usage()
+	}
+	return args[1]
+}
+
+func list(socket string) error {
+	var services map[string]service.ServiceStatus
+	if err := control.Call(socket, "list", nil, &services); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tSTATE\tPID\tRESTARTS\tSINCE")
+	for _, name := range names {
+		st := services[name]
+		pid, since := "-", "-"
+		if st.Pid != 0 {
+			pid = fmt.Sprint(st.Pid)
+		}
+		if !st.StartTime.IsZero() {
+			since = st.StartTime.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", name, st.State, pid, st.RestartCount, since)
+	}
+	return w.Flush()
+}
+
+// start 启动服务并报告一并启动的依赖和失败的服务
+func start(socket string, args []string) error {
+	fs := flag.NewFlagSet("start", flag.ExitOnError)
+	rollback := fs.Bool("rollback", false, "stop the services started by this job if it fails")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	var job service.Job
+	req := map[string]interface{}{"service": fs.Arg(0), "rollback": *rollback}
+	if err := control.Call(socket, "start", req, &job); err != nil {
+		return err
+	}
+	return report(job)
+}
+
+// isolate 切换目标并报告作业结果
+func isolate(socket string, args []string) error {
+	fs := flag.NewFlagSet("isolate", flag.ExitOnError)
+	rollback := fs.Bool("rollback", false, "stop the services started by this job if it fails")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	var job service.Job
+	req := map[string]interface{}{"target": fs.Arg(0), "rollback": *rollback}
+	if err := control.Call(socket, "isolate", req, &job); err != nil {
+		return err
+	}
+	return report(job)
+}
+
+// report 输出一并启动的依赖和失败的服务，作业失败时返回错误
+func report(job service.Job) error {
+	if len(job.PulledIn) > 0 {
+		fmt.Printf("Pulled in: %s\n", strings.Join(job.PulledIn, ", "))
+	}
+	for _, u := range job.Units {
+		if u.Error != "" {
+			fmt.Fprintf(os.Stderr, "%s %s (%s): %s\n", u.Service, u.Result, u.Reason, u.Error)
+		}
+	}
+	switch job.State {
+	case service.JobFailed, service.JobRolledBack:
+		return fmt.Errorf("job %d %s", job.ID, job.State)
+	case service.JobDegraded:
+		fmt.Fprintf(os.Stderr, "ldhctl: job %d degraded\n", job.ID)
+	}
+	return nil
+}
+
+func targets(socket string) error {
+	var list []service.TargetStatus
+	if err := control.Call(socket, "targets", nil, &list); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tCURRENT\tREQUIRES\tSERVICES\tDESCRIPTION")
+	for _, t := range list {
+		current := ""
+		switch {
+		case t.Current:
+			current = "*"
+		case t.Default:
+			current = "default"
+		}
+		requires := "-"
+		if len(t.Requires) > 0 {
+			requires = strings.Join(t.Requires, ",")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", t.Name, current, requires, len(t.Services), t.Description)
+	}
+	return w.Flush()
+}
+
+func timers(socket string) error {
+	var list []service.TimerStatus
+	if err := control.Call(socket, "timers", nil, &list); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NEXT\tLEFT\tLAST\tSERVICE\tTRIGGER")
+	now := time.Now()
+	for _, t := range list {
+		next, left, last := "-", "-", "-"
+		if !t.Next.IsZero() {
+			next = t.Next.Local().Format(time.RFC3339)
+			left = t.Next.Sub(now).Round(time.Second).String()
+		}
+		if !t.Last.IsZero() {
+			last = t.Last.Local().Format(time.RFC3339)
+		}
+		trigger := t.Calendar
+		if t.OnBoot != "" {
+			trigger = append([]string{"boot+" + t.OnBoot}, trigger...)
+		}
+		if t.Persistent {
+			trigger = append(trigger, "(persistent)")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", next, left, last, t.Service, strings.Join(trigger, "; "))
+	}
+	return w.Flush()
+}
+
+func metrics(socket string, args []string) error {
+	fs := flag.NewFlagSet("metrics", flag.ExitOnError)
+	history := fs.Bool("history", false, "print every sample kept in memory instead of the latest")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		usage()
+	}
+
+	req := map[string]interface{}{"service": fs.Arg(0)}
+	if !*history {
+		req["limit"] = 1
+	}
+	var list []service.ServiceMetrics
+	if err := control.Call(socket, "metrics", req, &list); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tTIME\tPID\tPROCS\tCPU%\tCPU TIME\tRSS\tFDS\tTHREADS\tREAD\tWRITTEN\tMEM PSI")
+	for _, m := range list {
+		for _, u := range m.Samples {
+			psi := "-"
+			if u.Cgroup != nil && u.Cgroup.MemoryPressure != nil {
+				psi = fmt.Sprintf("%.2f", u.Cgroup.MemoryPressure.Some.Avg10)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.1f\t%.2fs\t%s\t%d\t%d\t%s\t%s\t%s\n",
+				m.Service, u.Time.Local().Format("15:04:05"), u.Pid, u.Processes, u.CPUPercent, u.CPUTime,
+				formatBytes(u.RSS), u.FDs, u.Threads, formatBytes(u.ReadBytes), formatBytes(u.WriteBytes), psi)
+		}
+	}
+	return w.Flush()
+}
+
+func pressure(socket string) error {
+	var status service.PressureStatus
+	if err := control.Call(socket, "pressure", nil, &status); err != nil {
+		return err
+	}
+
+	m := status.Memory
+	fmt.Printf("Memory: %s available of %s (%.1f%%), swap %s free of %s\n",
+		formatBytes(m.Available), formatBytes(m.Total), m.AvailablePercent, formatBytes(m.SwapFree), formatBytes(m.SwapTotal))
+	fmt.Printf("OOM kills: %d\n", status.OOMKills)
+	if len(status.Shed) > 0 {
+		fmt.Printf("Stopped by memory pressure: %s\n", strings.Join(status.Shed, ", "))
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RESOURCE\tLEVEL\tSINCE\tSOME AVG10\tSOME AVG60\tFULL AVG10\tFULL AVG60")
+	for _, r := range status.Resources {
+		since, psi := "-", "-\t-\t-\t-"
+		if !r.Since.IsZero() {
+			since = r.Since.Local().Format(time.RFC3339)
+		}
+		if p := r.Pressure; p != nil {
+			psi = fmt.Sprintf("%.2f\t%.2f\t%.2f\t%.2f", p.Some.Avg10, p.Some.Avg60, p.Full.Avg10, p.Full.Avg60)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Resource, r.Level, since, psi)
+	}
+	return w.Flush()
+}
+
+// formatBytes 以 1024 为进制输出易读的字节数
+func formatBytes(n uint64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	v, i := float64(n)/1024, 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%c", v, units[i])
+}
+
+func jobs(socket string) error {
+	var list []service.Job
+	if err := control.Call(socket, "jobs", nil, &list); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tREQUESTED\tMODE\tSTATE\tPULLED IN\tCREATED")
+	for _, job := range list {
+		pulled := "-"
+		if len(job.PulledIn) > 0 {
+			pulled = strings.Join(job.PulledIn, ",")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", job.ID, strings.Join(job.Requested, ","),
+			job.Mode, job.State, pulled, job.Created.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func status(socket, name string) error {
+	var st json.RawMessage
+	if err := control.Call(socket, "status", map[string]string{"service": name}, &st); err != nil {
+		return err
+	}
+	return printJSON(st)
+}
+
+func events(socket string, args []string) error {
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	svc := fs.String("service", "", "only events of services matching this pattern")
+	types := fs.String("type", "", "comma-separated event types")
+	since := fs.Int64("since", -1, "replay events after this sequence number")
+	follow := fs.Bool("follow", false, "keep streaming new events")
+	fs.Parse(args)
+
+	req := map[string]interface{}{"follow": *follow}
+	if *svc != "" {
+		req["service"] = *svc
+	}
+	if *types != "" {
+		req["types"] = strings.Split(*types, ",")
+	}
+	if *since >= 0 {
+		req["since"] = *since
+	} else if !*follow {
+		// 不跟随时默认输出全部保留的历史
+		req["since"] = 0
+	}
+
+	var header struct {
+		LastSeq  uint64 `json:"last_seq"`
+		Complete bool   `json:"complete"`
+	}
+	warned := false
+	warn := func() {
+		if !header.Complete && !warned {
+			fmt.Fprintln(os.Stderr, "ldhctl: some requested events are no longer available")
+			warned = true
+		}
+	}
+	err := control.Stream(socket, "events", req, &header, func(line []byte) error {
+		warn()
+		_, err := fmt.Printf("%s\n", line)
+		return err
+	})
+	if err == nil {
+		warn()
+	}
+	return err
+}
+
+func logs(socket string, args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	svc := fs.String("service", "", "only logs of services matching this pattern")
+	source := fs.String("source", "", "only logs from init, service, syslog or kernel")
+	priority := fs.String("priority", "", "only logs at this priority or more severe (emerg ... debug)")
+	since := fs.String("since", "", "only logs since this time (RFC 3339) or duration ago (e.g. 10m)")
+	until := fs.String("until", "", "only logs before this time or duration ago")
+	grep := fs.String("grep", "", "only messages containing this text")
+	limit := fs.Int("n", 0, "only the last n entries")
+	follow := fs.Bool("follow", false, "keep streaming new logs")
+	raw := fs.Bool("json", false, "print entries as JSON lines")
+	fs.Parse(args)
+
+	req := map[string]interface{}{"follow": *follow}
+	for name, value := range map[string]string{"service": *svc, "source": *source, "priority": *priority, "grep": *grep} {
+		if value != "" {
+			req[name] = value
+		}
+	}
+	for name, value := range map[string]string{"since": *since, "until": *until} {
+		if value == "" {
+			continue
+		}
+		t, err := parseTime(value)
+		if err != nil {
+			return fmt.Errorf("invalid -%s: %v", name, err)
+		}
+		req[name] = t
+	}
+	if *limit > 0 {
+		req["limit"] = *limit
+	}
+
+	return control.Stream(socket, "logs", req, nil, func(line []byte) error {
+		if *raw {
+			_, err := fmt.Printf("%s\n", line)
+			return err
+		}
+		var e journal.Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return err
+		}
+		ident := e.Service
+		if ident == "" {
+			ident = string(e.Source)
+		}
+		if e.Pid != 0 {
+			ident = fmt.Sprintf("%s[%d]", ident, e.Pid)
+		}
+		msg := e.Message
+		keys := make([]string, 0, len(e.Fields))
+		for k := range e.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			msg += fmt.Sprintf(" %s=%q", k, e.Fields[k])
+		}
+		_, err := fmt.Printf("%s %s %s: %s\n", e.Time.Format("2006-01-02 15:04:05"), ident, e.Priority, msg)
+		return err
+	})
+}
+
+// parseTime 解析 RFC 3339 时间或相对于现在的时长
+func parseTime(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func mcp(socket string, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		usage()
+	}
+	req := service.MCPRequest{Service: args[0], Function: args[1]}
+	if len(args) == 3 {
+		if err := json.Unmarshal([]byte(args[2]), &req.Params); err != nil {
+			return fmt.Errorf("invalid params: %v", err)
+		}
+	}
+
+	var resp service.MCPResponse
+	if err := control.Call(socket, "mcp", req, &resp); err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("%s", resp.Error)
+	}
+	data, err := json.Marshal(resp.Data)
+	if err != nil {
+		return err
+	}
+	return printJSON(data)
+}
+
+func printJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Printf("%s\n", out)
+	return err
+}
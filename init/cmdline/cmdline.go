@@ -0,0 +1,218 @@
+// Package cmdline 解析内核命令行中 ldh. 前缀的 init 选项
+package cmdline
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// DefaultPath 内核命令行的位置
+const DefaultPath = "/proc/cmdline"
+
+// 启动阶段，ldh.break=<stage> 在进入该阶段之前打开 shell
+const (
+	StageMount    = "mount"
+	StageDevices  = "devices"
+	StageNetwork  = "network"
+	StageServices = "services"
+)
+
+// Stages 按执行顺序排列的启动阶段
+var Stages = []string{StageMount, StageDevices, StageNetwork, StageServices}
+
+// DefaultWatchdogTimeout 只写 ldh.watchdog 时硬件看门狗的超时
+const DefaultWatchdogTimeout = 60 * time.Second
+
+// LogLevels ldh.log_level 接受的值
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// Options init 的内核命令行选项
+type Options struct {
+	Config   string   // ldh.config=：服务配置文件路径
+	Target   string   // ldh.target=：启动的目标
+	Debug    bool     // ldh.debug：输出调试日志，隐含 ldh.log_level=debug
+	Mask     []string // ldh.mask=<svc>[,<svc>]：不启动的服务，可重复
+	LogLevel string   // ldh.log_level=
+	Shell    string   // ldh.shell=<path>：紧急模式在控制台上运行的 shell，默认 /bin/sh
+	NoShell  bool     // ldh.shell=0：紧急模式不运行 shell，只等待 ldhctl continue
+	Break    string   // ldh.break[=<stage>]：在进入该阶段前打开 shell，省略阶段时为 services
+
+	MetricsInterval time.Duration // ldh.metrics_interval=<duration>：服务资源采样间隔，默认 10s
+	NoMetrics       bool          // ldh.metrics_interval=0：不采样服务资源
+	MetricsListen   string        // ldh.metrics_listen=<host:port|path>：OpenMetrics 导出端点，只接受回环地址或 unix 套接字
+
+	Watchdog       time.Duration // ldh.watchdog[=<timeout>]：启用硬件看门狗，省略超时时为 60s，0 关闭
+	WatchdogDevice string        // ldh.watchdog_device=<path>：看门狗设备，默认 /dev/watchdog
+}
+
+// Read 读取并解析内核命令行
+func Read(path string) (*Options, []string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read kernel command line: %v", err)
+	}
+	opts, warnings := Parse(string(data))
+	return opts, warnings, nil
+}
+
+// Parse 解析内核命令行。非 ldh. 前缀的参数属于内核或其他程序，直接忽略；
+// 未知的 ldh. 参数和无效的值不影响其他选项，以警告返回
+func Parse(cmdline string) (*Options, []string) {
+	opts := &Options{}
+	var warnings []string
+
+	for _, field := range Split(cmdline) {
+		if !strings.HasPrefix(field, "ldh.") {
+			continue
+		}
+		key, value, hasValue := field, "", false
+		if i := strings.IndexByte(field, '='); i >= 0 {
+			key, value, hasValue = field[:i], field[i+1:], true
+		}
+
+		var err error
+		switch key {
+		case "ldh.config":
+			opts.Config, err = nonEmpty(value)
+		case "ldh.target":
+			opts.Target, err = nonEmpty(value)
+		case "ldh.debug":
+			opts.Debug, err = parseBool(value, hasValue)
+		case "ldh.shell":
+			if strings.HasPrefix(value, "/") {
+				opts.Shell = value
+				break
+			}
+			var enabled bool
+			enabled, err = parseBool(value, hasValue)
+			opts.NoShell = err == nil && !enabled
+		case "ldh.mask":
+			if _, err = nonEmpty(value); err != nil {
+				break
+			}
+			for _, name := range strings.Split(value, ",") {
+				if name != "" {
+					opts.Mask = append(opts.Mask, name)
+				}
+			}
+		case "ldh.log_level":
+			if !contains(LogLevels, value) {
+				err = fmt.Errorf("must be one of %s", strings.Join(LogLevels, ", "))
+			} else {
+				opts.LogLevel = value
+			}
+		case "ldh.break":
+			if !hasValue {
+				value = StageServices
+			}
+			if !contains(Stages, value) {
+				err = fmt.Errorf("must be one of %s", strings.Join(Stages, ", "))
+			} else {
+				opts.Break = value
+			}
+		case "ldh.watchdog":
+			if enabled, perr := parseBool(value, hasValue); perr == nil {
+				opts.Watchdog = 0
+				if enabled {
+					opts.Watchdog = DefaultWatchdogTimeout
+				}
+				break
+			}
+			var timeout time.Duration
+			if timeout, err = time.ParseDuration(value); err == nil && timeout < time.Second {
+				err = fmt.Errorf("must be at least 1s")
+			}
+			if err == nil {
+				opts.Watchdog = timeout
+			}
+		case "ldh.watchdog_device":
+			opts.WatchdogDevice, err = nonEmpty(value)
+		case "ldh.metrics_listen":
+			opts.MetricsListen, err = nonEmpty(value)
+		case "ldh.metrics_interval":
+			if enabled, perr := parseBool(value, true); perr == nil && !enabled {
+				opts.NoMetrics = true
+				break
+			}
+			var interval time.Duration
+			if interval, err = time.ParseDuration(value); err == nil && interval <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+			if err == nil {
+				opts.MetricsInterval = interval
+			}
+		default:
+			warnings = append(warnings, fmt.Sprintf("unknown option %s", key))
+			continue
+		}
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("invalid %s=%q: %v", key, value, err))
+		}
+	}
+
+	if opts.Debug && opts.LogLevel == "" {
+		opts.LogLevel = "debug"
+	}
+	return opts, warnings
+}
+
+// Split 按内核的规则拆分命令行：参数以空白分隔，双引号内的空白不分隔参数，
+// 引号本身被去掉（例如 ldh.config="/etc/my dir/services.yaml"）
+func Split(cmdline string) []string {
+	var fields []string
+	var current strings.Builder
+	inQuote, inField := false, false
+
+	for _, r := range cmdline {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			inField = true
+		case !inQuote && (r == ' ' || r == '\t' || r == '\n'):
+			if inField {
+				fields = append(fields, current.String())
+				current.Reset()
+				inField = false
+			}
+		default:
+			current.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
+
+// parseBool 解析开关选项，只写键名时为 true
+func parseBool(value string, hasValue bool) (bool, error) {
+	if !hasValue {
+		return true, nil
+	}
+	switch value {
+	case "1", "true", "yes", "on":
+		return true, nil
+	case "0", "false", "no", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("not a boolean")
+}
+
+func nonEmpty(value string) (string, error) {
+	if value == "" {
+		return "", fmt.Errorf("value is empty")
+	}
+	return value, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
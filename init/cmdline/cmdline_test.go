@@ -0,0 +1,94 @@
+package cmdline
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		cmdline string
+		fields  []string
+	}{
+		{"", nil},
+		{"  quiet   ro\n", []string{"quiet", "ro"}},
+		{`ldh.config="/etc/my dir/s.yaml" rw`, []string{"ldh.config=/etc/my dir/s.yaml", "rw"}},
+		{`"ldh.target=rescue mode" x`, []string{"ldh.target=rescue mode", "x"}},
+		{`ldh.config=""`, []string{"ldh.config="}},
+		{`unterminated="a b`, []string{"unterminated=a b"}},
+	}
+	for _, tt := range tests {
+		if got := Split(tt.cmdline); !reflect.DeepEqual(got, tt.fields) {
+			t.Errorf("Split(%q) = %q, want %q", tt.cmdline, got, tt.fields)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	cmdline := `BOOT_IMAGE=/vmlinuz root=/dev/sda1 ro quiet ldh.target=rescue ` +
+		`ldh.config="/etc/ldh os/services.yaml" ldh.debug ldh.mask=dhcpcd,cron ldh.mask=monitoring ` +
+		`ldh.shell=/bin/ash ldh.break=network ldh.foo=bar ldh.log_level=verbose ldh.metrics_interval=30s ` +
+		`ldh.metrics_listen=127.0.0.1:9100`
+
+	opts, warnings := Parse(cmdline)
+	want := &Options{
+		Config:   "/etc/ldh os/services.yaml",
+		Target:   "rescue",
+		Debug:    true,
+		Mask:     []string{"dhcpcd", "cron", "monitoring"},
+		LogLevel: "debug",
+		Shell:    "/bin/ash",
+		Break:    StageNetwork,
+
+		MetricsInterval: 30 * time.Second,
+		MetricsListen:   "127.0.0.1:9100",
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("Parse() = %+v, want %+v", opts, want)
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "ldh.foo") || !strings.Contains(warnings[1], "ldh.log_level") {
+		t.Errorf("Unexpected warnings: %q", warnings)
+	}
+
+	opts, warnings = Parse("ldh.break ldh.debug=0 ldh.log_level=warn ldh.shell=off ldh.target= ldh.mask ldh.metrics_interval=0")
+	if opts.Break != StageServices || opts.Debug || opts.LogLevel != "warn" || !opts.NoShell || opts.Target != "" || opts.Mask != nil || !opts.NoMetrics {
+		t.Errorf("Unexpected options: %+v", opts)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("Expected 2 warnings, got %q", warnings)
+	}
+}
+
+func TestParseMetricsInterval(t *testing.T) {
+	for _, value := range []string{"", "fast", "-5s"} {
+		opts, warnings := Parse("ldh.metrics_interval=" + value)
+		if len(warnings) != 1 || opts.MetricsInterval != 0 || opts.NoMetrics {
+			t.Errorf("ldh.metrics_interval=%s: options %+v, warnings %q", value, opts, warnings)
+		}
+	}
+}
+
+func TestParseWatchdog(t *testing.T) {
+	tests := []struct {
+		cmdline  string
+		watchdog time.Duration
+		warnings int
+	}{
+		{"ldh.watchdog", DefaultWatchdogTimeout, 0},
+		{"ldh.watchdog=30s", 30 * time.Second, 0},
+		{"ldh.watchdog=30s ldh.watchdog=off", 0, 0},
+		{"ldh.watchdog=100ms", 0, 1},
+		{"ldh.watchdog=soon", 0, 1},
+	}
+	for _, tt := range tests {
+		opts, warnings := Parse(tt.cmdline)
+		if opts.Watchdog != tt.watchdog || len(warnings) != tt.warnings {
+			t.Errorf("Parse(%q): watchdog %v, warnings %q", tt.cmdline, opts.Watchdog, warnings)
+		}
+	}
+	if opts, _ := Parse("ldh.watchdog_device=/dev/watchdog1"); opts.WatchdogDevice != "/dev/watchdog1" {
+		t.Errorf("Unexpected watchdog device %q", opts.WatchdogDevice)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"ldh-os/init/control"
+	"ldh-os/init/logging"
+	"ldh-os/init/service"
+)
+
+const (
+	// defaultEventJournal 事件日志的默认路径，LDH_EVENT_JOURNAL=off 时禁用
+	defaultEventJournal = "/var/log/ldh-os/events.jsonl"
+	// journalFlushTimeout re-exec 和关机前等待事件写入日志的最长时间，磁盘卡住时不再等待
+	journalFlushTimeout = 2 * time.Second
+)
+
+// openEventJournal 启用磁盘事件日志，使外部消费者在 init 重启后仍能补齐事件
+func (i *InitSystem) openEventJournal() {
+	path := defaultEventJournal
+	if os.Getenv("LDH_EVENT_JOURNAL") != "" {
+		path = os.Getenv("LDH_EVENT_JOURNAL")
+	}
+	if path == "off" {
+		return
+	}
+
+	journal, err := service.OpenEventJournal(path, service.DefaultJournalSize)
+	if err != nil {
+		logging.Warn("Event journal disabled", "error", err)
+		return
+	}
+	i.serviceManager.EventBus().SetJournal(journal)
+}
+
+// serviceArgs 针对单个服务的命令参数
+type serviceArgs struct {
+	Service string `json:"service"`
+}
+
+// startArgs start 命令参数
+type startArgs struct {
+	Service  string `json:"service"`
+	Rollback bool   `json:"rollback"` // 失败时停止一并启动的依赖
+}
+
+// isolateArgs isolate 命令参数
+type isolateArgs struct {
+	Target   string `json:"target"`
+	Rollback bool   `json:"rollback"`
+}
+
+// metricsArgs metrics 命令参数
+type metricsArgs struct {
+	Service string `json:"service,omitempty"` // 省略时返回所有服务
+	Limit   int    `json:"limit,omitempty"`   // 每个服务返回的采样数，省略时返回全部
+}
+
+// eventsArgs events 命令参数
+type eventsArgs struct {
+	Since   *uint64  `json:"since,omitempty"` // 省略时只推送新事件
+	Service string   `json:"service,omitempty"`
+	Types   []string `json:"types,omitempty"`
+	Follow  bool     `json:"follow"` // false 时补发历史后结束
+}
+
+// eventsHeader events 命令的首行应答数据
+type eventsHeader struct {
+	LastSeq  uint64 `json:"last_seq"`
+	Complete bool   `json:"complete"`
+}
+
+// startControl 注册控制命令并启动控制套接字，已启动时什么也不做
+func (i *InitSystem) startControl() error {
+	if i.control != nil {
+		return nil
+	}
+	path := control.DefaultSocketPath
+	if os.Getenv("LDH_CONTROL_SOCKET") != "" {
+		path = os.Getenv("LDH_CONTROL_SOCKET")
+	}
+
+	sm := i.serviceManager
+	s := control.NewServer(path)
+
+	s.Handle("list", func(json.RawMessage) (interface{}, error) {
+		return sm.ListServices(), nil
+	})
+	s.Handle("status", func(args json.RawMessage) (interface{}, error) {
+		var a serviceArgs
+		if err := decodeArgs(args, &a); err != nil {
+			return nil, err
+		}
+		return sm.GetServiceStatus(a.Service)
+	})
+	s.Handle("start", func(args json.RawMessage) (interface{}, error) {
+		var a startArgs
+		if err := decodeArgs(args, &a); err != nil {
+			return nil, err
+		}
+		mode := service.JobPartial
+		if a.Rollback {
+			mode = service.JobRollback
+		}
+		job, err := sm.StartJob([]string{a.Service}, mode)
+		if err != nil && job.ID == 0 {
+			return nil, err
+		}
+		// 作业失败时也返回作业详情，由客户端根据 state 报告
+		return job, nil
+	})
+	s.Handle("jobs", func(json.RawMessage) (interface{}, error) {
+		return sm.Jobs(), nil
+	})
+	s.Handle("targets", func(json.RawMessage) (interface{}, error) {
+		return sm.Targets(), nil
+	})
+	s.Handle("timers", func(json.RawMessage) (interface{}, error) {
+		return sm.Timers(), nil
+	})
+	s.Handle("pressure", func(json.RawMessage) (interface{}, error) {
+		return sm.Pressure()
+	})
+	s.Handle("metrics", func(args json.RawMessage) (interface{}, error) {
+		var a metricsArgs
+		if err := decodeArgs(args, &a); err != nil {
+			return nil, err
+		}
+		if a.Service != "" {
+			m, err := sm.Metrics(a.Service, a.Limit)
+			if err != nil {
+				return nil, err
+			}
+			return []service.ServiceMetrics{m}, nil
+		}
+		return sm.AllMetrics(a.Limit), nil
+	})
+	s.Handle("isolate", func(args json.RawMessage) (interface{}, error) {
+		var a isolateArgs
+		if err := decodeArgs(args, &a); err != nil {
+			return nil, err
+		}
+		mode := service.JobPartial
+		if a.Rollback {
+			mode = service.JobRollback
+		}
+		job, err := sm.IsolateTarget(a.Target, mode)
+		if err != nil && job.ID == 0 {
+			return nil, err
+		}
+		return job, nil
+	})
+	serviceCommands := map[string]func(string) error{
+		"stop":    sm.StopService,
+		"restart": sm.RestartService,
+	}
+	for name, fn := range serviceCommands {
+		fn := fn
+		s.Handle(name, func(args json.RawMessage) (interface{}, error) {
+			var a serviceArgs
+			if err := decodeArgs(args, &a); err != nil {
+				return nil, err
+			}
+			return nil, fn(a.Service)
+		})
+	}
+	s.Handle("mcp", func(args json.RawMessage) (interface{}, error) {
+		var req service.MCPRequest
+		if err := decodeArgs(args, &req); err != nil {
+			return nil, err
+		}
+		return sm.HandleMCPRequest(&req), nil
+	})
+	s.HandleStream("events", i.streamEvents)
+	s.HandleStream("logs", i.streamLogs)
+
+	s.Handle("continue", func(json.RawMessage) (interface{}, error) {
+		return nil, i.continueBoot()
+	})
+
+	// 关机和 re-exec 在主循环中执行，与信号触发的操作串行
+	s.Handle("reexec", func(json.RawMessage) (interface{}, error) {
+		return nil, i.submit(func() {
+			logging.Info("Re-exec requested via control socket")
+			if err := i.reexec(); err != nil {
+				logging.Error("Re-exec failed, continuing with current init", "error", err)
+			}
+		})
+	})
+	for _, action := range []shutdownAction{actionPoweroff, actionReboot, actionHalt, actionKexec} {
+		action := action
+		s.Handle(string(action), func(json.RawMessage) (interface{}, error) {
+			return nil, i.submit(func() {
+				logging.Info("Shutdown requested via control socket", "action", action)
+				i.shutdown(action)
+			})
+		})
+	}
+
+	if err := s.Start(); err != nil {
+		return err
+	}
+	i.control = s
+	logging.Info("Control socket listening", "path", path)
+	return nil
+}
+
+// submit 把操作交给主循环执行，主循环忙（例如正在关机）时返回错误
+func (i *InitSystem) submit(action func()) error {
+	select {
+	case i.actions <- action:
+		return nil
+	default:
+		return fmt.Errorf("init is busy, try again later")
+	}
+}
+
+// streamEvents 以 JSON 行推送事件，支持按服务和类型过滤，以及从指定序号续传
+func (i *InitSystem) streamEvents(args json.RawMessage, conn *control.Conn) error {
+	var a eventsArgs
+	if err := decodeArgs(args, &a); err != nil {
+		return err
+	}
+
+	bus := i.serviceManager.EventBus()
+	filter := service.EventFilter{Service: a.Service}
+	for _, t := range a.Types {
+		filter.Types = append(filter.Types, service.EventType(t))
+	}
+	since := bus.LastSeq()
+	if a.Since != nil {
+		since = *a.Since
+	}
+
+	if !a.Follow {
+		events, complete, err := bus.History(since, filter, 0)
+		if err != nil {
+			return err
+		}
+		if err := conn.Begin(eventsHeader{LastSeq: bus.LastSeq(), Complete: complete}); err != nil {
+			return nil
+		}
+		for _, e := range events {
+			if err := conn.Send(e); err != nil {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	stream, err := bus.Follow(since, filter, 0)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if err := conn.Begin(eventsHeader{LastSeq: bus.LastSeq(), Complete: stream.Complete}); err != nil {
+		return nil
+	}
+	for {
+		select {
+		case e, ok := <-stream.C:
+			if !ok {
+				return nil
+			}
+			if err := conn.Send(e); err != nil {
+				return nil
+			}
+		case <-conn.Closed():
+			return nil
+		}
+	}
+}
+
+// decodeArgs 解码命令参数，参数为空时保持零值
+func decodeArgs(args json.RawMessage, v interface{}) error {
+	if len(args) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(args, v); err != nil {
+		return fmt.Errorf("invalid arguments: %v", err)
+	}
+	return nil
+}
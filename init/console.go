@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+
+	"ldh-os/init/logging"
+
+	"golang.org/x/sys/unix"
+)
+
+// setupConsole 以 PID 1 运行时把标准输入输出重定向到 /dev/console。
+// 内核在 initramfs 中找不到 /dev/console 时 init 没有任何输出，
+// devtmpfs 挂载之后再调用一次即可补上
+func (i *InitSystem) setupConsole() {
+	if !i.pid1 {
+		return
+	}
+
+	console, err := os.OpenFile("/dev/console", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return
+	}
+	defer console.Close()
+
+	// 标准输入输出已经指向控制台时不需要处理
+	var current, target unix.Stat_t
+	if unix.Fstat(1, &current) == nil && unix.Fstat(int(console.Fd()), &target) == nil &&
+		current.Rdev == target.Rdev && current.Mode&unix.S_IFMT == unix.S_IFCHR {
+		return
+	}
+
+	for fd := 0; fd <= 2; fd++ {
+		if err := unix.Dup3(int(console.Fd()), fd, 0); err != nil {
+			logging.Warn("Failed to attach to /dev/console", "fd", fd, "error", err)
+		}
+	}
+	logging.Debug("Attached to /dev/console")
+}
@@ -0,0 +1,100 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// send 连接控制套接字并发送一个请求
+func send(path, command string, args interface{}) (net.Conn, error) {
+	req := Request{Command: command}
+	if args != nil {
+		data, err := json.Marshal(args)
+		if err != nil {
+			return nil, err
+		}
+		req.Args = data
+	}
+	line, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.Write(append(line, '\n')); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Call 执行一个普通命令，应答数据解码到 result（可以为 nil）
+func Call(path, command string, args, result interface{}) error {
+	c, err := send(path, command, args)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var resp Response
+	if err := json.NewDecoder(c).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+	if !resp.OK {
+		return errors.New(resp.Error)
+	}
+	if result != nil && len(resp.Data) > 0 {
+		return json.Unmarshal(resp.Data, result)
+	}
+	return nil
+}
+
+// Stream 执行一个流式命令。首行应答的数据解码到 header（可以为 nil），
+// 之后每收到一行调用一次 fn，直到服务端结束流或 fn 返回错误
+func Stream(path, command string, args, header interface{}, fn func(line []byte) error) error {
+	c, err := send(path, command, args)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	scanner := bufio.NewScanner(c)
+	scanner.Buffer(make([]byte, 64*1024), maxRequestSize)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("connection closed before response")
+	}
+	var resp Response
+	if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+		return fmt.Errorf("invalid response: %v", err)
+	}
+	if !resp.OK {
+		return errors.New(resp.Error)
+	}
+	if header != nil && len(resp.Data) > 0 {
+		if err := json.Unmarshal(resp.Data, header); err != nil {
+			return err
+		}
+	}
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		// 流中途出错时以 {"ok":false,"error":...} 结束
+		var resp Response
+		if json.Unmarshal(line, &resp) == nil && !resp.OK && resp.Error != "" {
+			return errors.New(resp.Error)
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,247 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"ldh-os/init/logging"
+)
+
+// DefaultSocketPath 控制套接字的默认路径
+const DefaultSocketPath = "/run/ldh-os/control.sock"
+
+// maxRequestSize 单个请求行的最大长度
+const maxRequestSize = 1 << 20
+
+// Request 客户端请求，每个连接发送一行 JSON
+type Request struct {
+	Command string          `json:"command"`
+	Args    json.RawMessage `json:"args,omitempty"`
+}
+
+// Response 普通命令的应答；流式命令出错时也以该格式结束
+type Response struct {
+	OK    bool            `json:"ok"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// HandlerFunc 处理普通命令，返回值作为应答数据
+type HandlerFunc func(args json.RawMessage) (interface{}, error)
+
+// StreamFunc 处理流式命令：先调用 Conn.Begin 写出首行应答，再通过 Conn.Send 持续写出 JSON 行。
+// 客户端断开后 Conn.Closed 被关闭，处理函数应尽快返回
+type StreamFunc func(args json.RawMessage, conn *Conn) error
+
+// Conn 流式命令使用的客户端连接
+type Conn struct {
+	enc    *json.Encoder
+	closed chan struct{}
+}
+
+// Begin 写出表示命令已被接受的首行应答，之后的每一行都是流数据
+func (c *Conn) Begin(data interface{}) error {
+	resp := Response{OK: true}
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		resp.Data = raw
+	}
+	return c.enc.Encode(resp)
+}
+
+// Send 写出一行 JSON
+func (c *Conn) Send(v interface{}) error {
+	return c.enc.Encode(v)
+}
+
+// Closed 返回在客户端断开时关闭的通道
+func (c *Conn) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// Server 控制套接字服务端。协议为按行分隔的 JSON：客户端发送一个 Request，
+// 普通命令返回一个 Response；流式命令先返回一个 Response，成功时随后持续返回 JSON 行
+type Server struct {
+	path     string
+	listener net.Listener
+	handlers map[string]HandlerFunc
+	streams  map[string]StreamFunc
+	conns    map[net.Conn]struct{}
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+}
+
+// NewServer 创建控制套接字服务端
+func NewServer(path string) *Server {
+	if path == "" {
+		path = DefaultSocketPath
+	}
+	return &Server{
+		path:     path,
+		handlers: make(map[string]HandlerFunc),
+		streams:  make(map[string]StreamFunc),
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+// Handle 注册普通命令
+func (s *Server) Handle(command string, fn HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[command] = fn
+}
+
+// HandleStream 注册流式命令
+func (s *Server) HandleStream(command string, fn StreamFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams[command] = fn
+}
+
+// Start 创建套接字并开始接受连接。套接字只允许 root 访问
+func (s *Server) Start() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	// 上一个 init 进程（re-exec 或崩溃前）留下的套接字文件
+	os.Remove(s.path)
+
+	l, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", s.path, err)
+	}
+	if err := os.Chmod(s.path, 0600); err != nil {
+		l.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.serve(l)
+	return nil
+}
+
+// Stop 关闭套接字和所有连接，等待处理中的请求结束
+func (s *Server) Stop() error {
+	s.mu.Lock()
+	l := s.listener
+	s.listener = nil
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	if l == nil {
+		return nil
+	}
+	err := l.Close()
+	s.wg.Wait()
+	return err
+}
+
+// serve 接受连接直到监听被关闭
+func (s *Server) serve(l net.Listener) {
+	defer s.wg.Done()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(c)
+
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+			c.Close()
+		}()
+	}
+}
+
+// handleConn 读取一个请求并分派给对应的处理函数
+func (s *Server) handleConn(c net.Conn) {
+	reader := bufio.NewReader(io.LimitReader(c, maxRequestSize))
+	line, err := reader.ReadBytes('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return
+	}
+
+	enc := json.NewEncoder(c)
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		enc.Encode(errorResponse(fmt.Errorf("invalid request: %v", err)))
+		return
+	}
+
+	s.mu.Lock()
+	handler := s.handlers[req.Command]
+	stream := s.streams[req.Command]
+	s.mu.Unlock()
+
+	switch {
+	case handler != nil:
+		enc.Encode(call(handler, req.Args))
+	case stream != nil:
+		conn := &Conn{enc: enc, closed: make(chan struct{})}
+		// 请求之后客户端不再发送数据，读到 EOF 表示客户端已断开
+		go func() {
+			io.Copy(io.Discard, c)
+			close(conn.closed)
+		}()
+		if err := stream(req.Args, conn); err != nil {
+			enc.Encode(errorResponse(err))
+		}
+	default:
+		enc.Encode(errorResponse(fmt.Errorf("unknown command: %s", req.Command)))
+	}
+}
+
+// call 执行普通命令并构造应答，处理函数的 panic 不会影响 init
+func call(handler HandlerFunc, args json.RawMessage) (resp Response) {
+	defer func() {
+		if r := recover(); r != nil {
+			logging.Error("Control command panicked", "panic", r)
+			resp = errorResponse(fmt.Errorf("internal error: %v", r))
+		}
+	}()
+
+	result, err := handler(args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	resp = Response{OK: true}
+	if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return errorResponse(fmt.Errorf("failed to marshal result: %v", err))
+		}
+		resp.Data = data
+	}
+	return resp
+}
+
+func errorResponse(err error) Response {
+	return Response{OK: false, Error: err.Error()}
+}
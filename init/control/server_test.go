@@ -0,0 +1,94 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	s := NewServer(path)
+
+	s.Handle("echo", func(args json.RawMessage) (interface{}, error) {
+		var v map[string]string
+		if err := json.Unmarshal(args, &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	s.Handle("fail", func(json.RawMessage) (interface{}, error) {
+		return nil, fmt.Errorf("boom")
+	})
+	s.Handle("panic", func(json.RawMessage) (interface{}, error) {
+		panic("boom")
+	})
+	closed := make(chan struct{})
+	s.HandleStream("count", func(args json.RawMessage, conn *Conn) error {
+		defer close(closed)
+		if err := conn.Begin(map[string]int{"total": 3}); err != nil {
+			return err
+		}
+		for i := 1; i <= 3; i++ {
+			if err := conn.Send(map[string]int{"n": i}); err != nil {
+				return err
+			}
+		}
+		// 等待客户端断开
+		<-conn.Closed()
+		return nil
+	})
+
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	var echoed map[string]string
+	if err := Call(path, "echo", map[string]string{"hello": "world"}, &echoed); err != nil {
+		t.Fatalf("echo failed: %v", err)
+	}
+	if echoed["hello"] != "world" {
+		t.Errorf("Unexpected echo result: %v", echoed)
+	}
+
+	if err := Call(path, "fail", nil, nil); err == nil || err.Error() != "boom" {
+		t.Errorf("Expected error boom, got %v", err)
+	}
+	if err := Call(path, "panic", nil, nil); err == nil {
+		t.Error("Expected error from panicking handler")
+	}
+	if err := Call(path, "missing", nil, nil); err == nil {
+		t.Error("Expected error for unknown command")
+	}
+
+	var header struct{ Total int }
+	var got []int
+	errStop := fmt.Errorf("stop")
+	err := Stream(path, "count", nil, &header, func(line []byte) error {
+		var v struct{ N int }
+		if err := json.Unmarshal(line, &v); err != nil {
+			return err
+		}
+		got = append(got, v.N)
+		if len(got) == 3 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if header.Total != 3 || len(got) != 3 || got[2] != 3 {
+		t.Errorf("Unexpected stream result: header=%+v lines=%v", header, got)
+	}
+
+	// 客户端断开后流式处理函数必须返回
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream handler did not notice client disconnect")
+	}
+}
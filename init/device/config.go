@@ -0,0 +1,87 @@
+package device
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Rule 设备节点的所有权、权限和符号链接规则
+type Rule struct {
+	Subsystem string `yaml:"subsystem,omitempty"`
+	DevName   string `yaml:"devname,omitempty"` // /dev 下的相对路径，支持通配符
+	Owner     *int   `yaml:"owner,omitempty"`   // uid
+	Group     *int   `yaml:"group,omitempty"`   // gid
+	Mode      string `yaml:"mode,omitempty"`    // 八进制，例如 "0660"
+	Symlink   string `yaml:"symlink,omitempty"` // /dev 下的链接名
+}
+
+// Config 设备管理配置（/etc/ldh-os/devices.yaml）
+type Config struct {
+	Modprobe string `yaml:"modprobe"` // 按 modalias 加载模块的工具，为空则不加载
+	Coldplug bool   `yaml:"coldplug"`
+	Rules    []Rule `yaml:"rules"`
+}
+
+// DefaultConfig 返回默认的设备管理配置
+func DefaultConfig() *Config {
+	return &Config{
+		Modprobe: "/sbin/modprobe",
+		Coldplug: true,
+	}
+}
+
+// LoadConfig 读取设备管理配置，文件不存在时返回默认配置
+func LoadConfig(file string) (*Config, error) {
+	config := DefaultConfig()
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device config: %v", err)
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse device config: %v", err)
+	}
+	for i, r := range config.Rules {
+		if _, err := r.fileMode(); err != nil {
+			return nil, fmt.Errorf("device rule %d: %v", i, err)
+		}
+		if r.DevName != "" {
+			if _, err := path.Match(r.DevName, ""); err != nil {
+				return nil, fmt.Errorf("device rule %d: invalid devname pattern %q", i, r.DevName)
+			}
+		}
+	}
+	return config, nil
+}
+
+// Matches 判断规则是否适用于该设备
+func (r Rule) Matches(ev *Uevent) bool {
+	if r.Subsystem != "" && r.Subsystem != ev.Subsystem {
+		return false
+	}
+	if r.DevName != "" {
+		ok, _ := path.Match(r.DevName, ev.DevName)
+		return ok
+	}
+	return true
+}
+
+// fileMode 解析规则中的权限，未设置时返回 0
+func (r Rule) fileMode() (os.FileMode, error) {
+	if r.Mode == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(r.Mode, 8, 32)
+	if err != nil || mode > 07777 {
+		return 0, fmt.Errorf("invalid mode %q", r.Mode)
+	}
+	return os.FileMode(mode), nil
+}
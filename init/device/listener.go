@@ -0,0 +1,80 @@
+package device
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// kernelGroup 内核 uevent 广播的 netlink 多播组
+const kernelGroup = 1
+
+// Listener 监听内核 uevent netlink 套接字
+type Listener struct {
+	fd     int
+	closed chan struct{}
+}
+
+// Listen 打开 NETLINK_KOBJECT_UEVENT 套接字并加入内核事件组
+func Listen() (*Listener, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open uevent socket: %v", err)
+	}
+
+	// 冷插拔时会瞬间产生大量事件，尽量加大接收缓冲区
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUFFORCE, 8<<20); err != nil {
+		unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, 8<<20)
+	}
+
+	// 设置接收超时，使 Receive 可以感知 Close
+	tv := unix.Timeval{Sec: 1}
+	unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+
+	addr := &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: kernelGroup}
+	if err := unix.Bind(fd, addr); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind uevent socket: %v", err)
+	}
+	return &Listener{fd: fd, closed: make(chan struct{})}, nil
+}
+
+// Receive 阻塞直到收到下一条内核 uevent，忽略非内核发送者的消息。
+// 接收缓冲区溢出时返回 unix.ENOBUFS，此后仍可继续调用
+func (l *Listener) Receive() (*Uevent, error) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := unix.Recvfrom(l.fd, buf, 0)
+		if err == unix.EINTR || err == unix.EAGAIN {
+			select {
+			case <-l.closed:
+				return nil, fmt.Errorf("uevent listener closed")
+			default:
+				continue
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		// 只接受来自内核 (portid 0) 的消息，防止用户空间伪造
+		if nl, ok := from.(*unix.SockaddrNetlink); !ok || nl.Pid != 0 {
+			continue
+		}
+		ev, err := ParseUevent(buf[:n])
+		if err != nil {
+			continue
+		}
+		return ev, nil
+	}
+}
+
+// Close 停止监听，阻塞中的 Receive 最多在一秒内返回错误
+func (l *Listener) Close() error {
+	select {
+	case <-l.closed:
+		return nil
+	default:
+		close(l.closed)
+	}
+	return unix.Close(l.fd)
+}
@@ -0,0 +1,264 @@
+package device
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ldh-os/init/logging"
+	"ldh-os/init/service"
+
+	"golang.org/x/sys/unix"
+)
+
+// Manager 处理设备事件：加载模块、创建设备节点和符号链接，并发布到事件总线
+type Manager struct {
+	config   *Config
+	eventBus *service.EventBus
+	devDir   string // 设备节点目录，默认 /dev
+	listener *Listener
+	modules  chan string
+	loaded   map[string]bool     // 已尝试加载的 modalias
+	links    map[string][]string // devpath -> 创建的符号链接
+	resync   int32               // 重新冷插拔正在进行时为 1
+	mu       sync.Mutex
+}
+
+// NewManager 创建设备管理器
+func NewManager(config *Config, eventBus *service.EventBus) *Manager {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	return &Manager{
+		config:   config,
+		eventBus: eventBus,
+		devDir:   "/dev",
+		modules:  make(chan string, 1024),
+		loaded:   make(map[string]bool),
+		links:    make(map[string][]string),
+	}
+}
+
+// Start 开始监听内核 uevent，如果配置了冷插拔则触发已有设备的 add 事件
+func (m *Manager) Start() error {
+	listener, err := Listen()
+	if err != nil {
+		return err
+	}
+	m.listener = listener
+
+	go m.loadModules()
+	go m.receive(listener)
+
+	if m.config.Coldplug {
+		count, err := Coldplug("/sys/devices")
+		if err != nil {
+			logging.Warn("Coldplug incomplete", "error", err)
+		}
+		logging.Info("Coldplug triggered devices", "count", count)
+	}
+	return nil
+}
+
+// receive 读取并处理 uevent，直到监听被关闭
+func (m *Manager) receive(listener *Listener) {
+	for {
+		ev, err := listener.Receive()
+		if err == unix.ENOBUFS {
+			// 接收缓冲区溢出，部分事件已经丢失；之后的事件仍然可用，重新冷插拔以补齐设备状态
+			logging.Warn("Uevent listener overrun, some device events were lost, resyncing")
+			go m.resyncDevices()
+			continue
+		}
+		if err != nil {
+			logging.Warn("Uevent listener stopped", "error", err)
+			return
+		}
+		m.Handle(ev)
+	}
+}
+
+// resyncDevices 事件丢失后重新触发已有设备的 add 事件，同一时间只进行一次
+func (m *Manager) resyncDevices() {
+	if !atomic.CompareAndSwapInt32(&m.resync, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&m.resync, 0)
+	count, err := Coldplug("/sys/devices")
+	if err != nil {
+		logging.Warn("Device resync incomplete", "error", err)
+	}
+	logging.Info("Device resync triggered devices", "count", count)
+}
+
+// Stop 停止监听 uevent
+func (m *Manager) Stop() {
+	if m.listener != nil {
+		m.listener.Close()
+	}
+}
+
+// Handle 处理一条 uevent
+func (m *Manager) Handle(ev *Uevent) {
+	if ev.Action == "add" && ev.Modalias != "" {
+		m.requestModule(ev.Modalias)
+	}
+
+	if ev.HasNode() {
+		switch ev.Action {
+		case "add", "change":
+			m.setupNode(ev)
+		case "remove":
+			m.removeLinks(ev)
+		}
+	}
+
+	eventType := ev.EventType()
+	if eventType == "" || m.eventBus == nil {
+		return
+	}
+	// 不等待订阅者处理，读取 uevent 的协程不能被事件处理阻塞，否则接收缓冲区会溢出
+	m.eventBus.Emit(service.ServiceEvent{
+		Type:      eventType,
+		Data:      ev.ServiceEvent(),
+		Timestamp: time.Now(),
+	})
+}
+
+// requestModule 将 modalias 放入加载队列，同一别名只加载一次
+func (m *Manager) requestModule(alias string) {
+	if m.config.Modprobe == "" {
+		return
+	}
+
+	m.mu.Lock()
+	if m.loaded[alias] {
+		m.mu.Unlock()
+		return
+	}
+	m.loaded[alias] = true
+	m.mu.Unlock()
+
+	select {
+	case m.modules <- alias:
+	default:
+		logging.Warn("Module queue full, dropping alias", "alias", alias)
+	}
+}
+
+// loadModules 串行执行 modprobe，避免冷插拔时同时启动大量进程
+func (m *Manager) loadModules() {
+	if _, err := os.Stat(m.config.Modprobe); err != nil {
+		logging.Info("Module loading disabled", "error", err)
+		for range m.modules {
+		}
+		return
+	}
+	for alias := range m.modules {
+		cmd := exec.Command(m.config.Modprobe, "-b", "-q", alias)
+		if err := cmd.Run(); err != nil {
+			// 大多数 modalias 本来就没有对应模块，只在调试时有意义
+			continue
+		}
+	}
+}
+
+// setupNode 确保设备节点存在，并应用匹配的规则
+func (m *Manager) setupNode(ev *Uevent) {
+	node := filepath.Join(m.devDir, ev.DevName)
+
+	// 没有 devtmpfs 时自行创建节点
+	if _, err := os.Lstat(node); os.IsNotExist(err) {
+		mode := uint32(unix.S_IFCHR)
+		if ev.Subsystem == "block" {
+			mode = unix.S_IFBLK
+		}
+		os.MkdirAll(filepath.Dir(node), 0755)
+		if err := unix.Mknod(node, mode|0600, int(unix.Mkdev(uint32(ev.Major), uint32(ev.Minor)))); err != nil {
+			logging.Warn("Failed to create device node", "node", node, "error", err)
+			return
+		}
+	}
+
+	for _, rule := range m.config.Rules {
+		if !rule.Matches(ev) {
+			continue
+		}
+		if mode, _ := rule.fileMode(); mode != 0 {
+			if err := os.Chmod(node, mode); err != nil {
+				logging.Warn("Failed to set device node mode", "node", node, "error", err)
+			}
+		}
+		if rule.Owner != nil || rule.Group != nil {
+			uid, gid := -1, -1
+			if rule.Owner != nil {
+				uid = *rule.Owner
+			}
+			if rule.Group != nil {
+				gid = *rule.Group
+			}
+			if err := os.Lchown(node, uid, gid); err != nil {
+				logging.Warn("Failed to set device node owner", "node", node, "error", err)
+			}
+		}
+		if rule.Symlink != "" {
+			m.createLink(ev, node, rule.Symlink)
+		}
+	}
+}
+
+// createLink 在 /dev 下创建指向设备节点的符号链接
+func (m *Manager) createLink(ev *Uevent, node, name string) {
+	link := filepath.Join(m.devDir, name)
+	target, err := filepath.Rel(filepath.Dir(link), node)
+	if err != nil {
+		target = node
+	}
+
+	os.MkdirAll(filepath.Dir(link), 0755)
+	os.Remove(link)
+	if err := os.Symlink(target, link); err != nil {
+		logging.Warn("Failed to create device symlink", "link", link, "error", err)
+		return
+	}
+
+	m.mu.Lock()
+	m.links[ev.DevPath] = append(m.links[ev.DevPath], link)
+	m.mu.Unlock()
+}
+
+// removeLinks 删除为已移除设备创建的符号链接
+func (m *Manager) removeLinks(ev *Uevent) {
+	m.mu.Lock()
+	links := m.links[ev.DevPath]
+	delete(m.links, ev.DevPath)
+	m.mu.Unlock()
+
+	for _, link := range links {
+		os.Remove(link)
+	}
+}
+
+// Coldplug 向 root 下所有设备的 uevent 文件写入 "add"，
+// 让内核为启动前已存在的设备重新发送事件。返回触发的设备数
+func Coldplug(root string) (int, error) {
+	count := 0
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// 某些 sysfs 目录不可读，跳过即可
+			return nil
+		}
+		if info.IsDir() || info.Name() != "uevent" {
+			return nil
+		}
+		if err := ioutil.WriteFile(path, []byte("add"), 0); err == nil {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
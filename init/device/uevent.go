@@ -0,0 +1,114 @@
+package device
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"ldh-os/init/service"
+)
+
+// Uevent 内核通过 netlink 发送的设备事件
+type Uevent struct {
+	Action    string
+	DevPath   string
+	Subsystem string
+	DevName   string
+	DevType   string
+	Major     int
+	Minor     int
+	Modalias  string
+	Seqnum    uint64
+	Env       map[string]string
+}
+
+// ParseUevent 解析一条内核 uevent 消息。
+// 格式为 "ACTION@DEVPATH\0KEY=VALUE\0KEY=VALUE\0..."，
+// udev 转发的 "libudev" 消息不是内核格式，返回错误
+func ParseUevent(msg []byte) (*Uevent, error) {
+	parts := bytes.Split(bytes.TrimRight(msg, "\x00"), []byte{0})
+	if len(parts) == 0 || len(parts[0]) == 0 {
+		return nil, fmt.Errorf("empty uevent")
+	}
+
+	header := string(parts[0])
+	if header == "libudev" {
+		return nil, fmt.Errorf("ignoring libudev message")
+	}
+	at := strings.IndexByte(header, '@')
+	if at <= 0 {
+		return nil, fmt.Errorf("malformed uevent header %q", header)
+	}
+
+	ev := &Uevent{
+		Action:  header[:at],
+		DevPath: header[at+1:],
+		Major:   -1,
+		Minor:   -1,
+		Env:     make(map[string]string, len(parts)-1),
+	}
+	for _, p := range parts[1:] {
+		kv := string(p)
+		eq := strings.IndexByte(kv, '=')
+		if eq <= 0 {
+			continue
+		}
+		ev.Env[kv[:eq]] = kv[eq+1:]
+	}
+
+	if action, ok := ev.Env["ACTION"]; ok {
+		ev.Action = action
+	}
+	if devpath, ok := ev.Env["DEVPATH"]; ok {
+		ev.DevPath = devpath
+	}
+	ev.Subsystem = ev.Env["SUBSYSTEM"]
+	ev.DevName = ev.Env["DEVNAME"]
+	ev.DevType = ev.Env["DEVTYPE"]
+	ev.Modalias = ev.Env["MODALIAS"]
+	if v, ok := ev.Env["MAJOR"]; ok {
+		ev.Major, _ = strconv.Atoi(v)
+	}
+	if v, ok := ev.Env["MINOR"]; ok {
+		ev.Minor, _ = strconv.Atoi(v)
+	}
+	if v, ok := ev.Env["SEQNUM"]; ok {
+		ev.Seqnum, _ = strconv.ParseUint(v, 10, 64)
+	}
+	return ev, nil
+}
+
+// HasNode 判断事件是否对应一个设备节点
+func (ev *Uevent) HasNode() bool {
+	return ev.DevName != "" && ev.Major >= 0 && ev.Minor >= 0
+}
+
+// EventType 返回该 uevent 对应的事件总线类型，不关心的动作返回空字符串
+func (ev *Uevent) EventType() service.EventType {
+	switch ev.Action {
+	case "add":
+		return service.EventDeviceAdded
+	case "remove":
+		return service.EventDeviceRemoved
+	case "change", "move", "bind", "unbind":
+		return service.EventDeviceChanged
+	default:
+		return ""
+	}
+}
+
+// ServiceEvent 转换为事件总线上的设备事件
+func (ev *Uevent) ServiceEvent() service.DeviceEvent {
+	env := make(map[string]string, len(ev.Env))
+	for k, v := range ev.Env {
+		env[k] = v
+	}
+	return service.DeviceEvent{
+		Action:    ev.Action,
+		DevPath:   ev.DevPath,
+		Subsystem: ev.Subsystem,
+		DevName:   ev.DevName,
+		Env:       env,
+	}
+}
@@ -0,0 +1,131 @@
+package device
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ldh-os/init/service"
+)
+
+// 从 QEMU 中抓取的 USB 麦克风插入事件
+var micAdd = []byte("add@/devices/pci0000:00/0000:00:1d.0/usb2/2-1/2-1:1.0/sound/card1/pcmC1D0c\x00" +
+	"ACTION=add\x00" +
+	"DEVPATH=/devices/pci0000:00/0000:00:1d.0/usb2/2-1/2-1:1.0/sound/card1/pcmC1D0c\x00" +
+	"SUBSYSTEM=sound\x00" +
+	"MAJOR=116\x00" +
+	"MINOR=10\x00" +
+	"DEVNAME=snd/pcmC1D0c\x00" +
+	"SEQNUM=2154\x00")
+
+var usbIfaceAdd = []byte("add@/devices/pci0000:00/0000:00:1d.0/usb2/2-1/2-1:1.0\x00" +
+	"ACTION=add\x00" +
+	"DEVPATH=/devices/pci0000:00/0000:00:1d.0/usb2/2-1/2-1:1.0\x00" +
+	"SUBSYSTEM=usb\x00" +
+	"DEVTYPE=usb_interface\x00" +
+	"MODALIAS=usb:v0D8Cp0014d0100dc00dsc00dp00ic01isc01ip00in00\x00" +
+	"SEQNUM=2150\x00")
+
+func TestParseUevent(t *testing.T) {
+	ev, err := ParseUevent(micAdd)
+	if err != nil {
+		t.Fatalf("Failed to parse uevent: %v", err)
+	}
+	if ev.Action != "add" || ev.Subsystem != "sound" || ev.DevName != "snd/pcmC1D0c" {
+		t.Errorf("Unexpected uevent: %+v", ev)
+	}
+	if !ev.HasNode() || ev.Major != 116 || ev.Minor != 10 || ev.Seqnum != 2154 {
+		t.Errorf("Unexpected device numbers: %+v", ev)
+	}
+
+	ev, err = ParseUevent(usbIfaceAdd)
+	if err != nil {
+		t.Fatalf("Failed to parse uevent: %v", err)
+	}
+	if ev.HasNode() || !strings.HasPrefix(ev.Modalias, "usb:v0D8C") {
+		t.Errorf("Unexpected interface uevent: %+v", ev)
+	}
+
+	if _, err := ParseUevent([]byte("libudev\x00\xfe\xed\xca\xfe")); err == nil {
+		t.Error("Expected libudev message to be rejected")
+	}
+}
+
+func TestDeviceStartsService(t *testing.T) {
+	devDir := t.TempDir()
+	// 用普通文件代替设备节点，避免测试依赖 mknod 权限
+	node := filepath.Join(devDir, "snd", "pcmC1D0c")
+	os.MkdirAll(filepath.Dir(node), 0755)
+	if err := os.WriteFile(node, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sm := service.NewServiceManager()
+	err := sm.RegisterService(service.ServiceConfig{
+		Name:          "voice",
+		Type:          service.TypeDaemon,
+		ExecPath:      "/bin/sleep",
+		Args:          []string{"1000"},
+		Restart:       "never",
+		StartOnDevice: []service.DeviceMatch{{Subsystem: "sound", DevName: "snd/pcmC*c"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register service: %v", err)
+	}
+	if err := sm.StartAll(); err != nil {
+		t.Fatalf("StartAll failed: %v", err)
+	}
+	if status, _ := sm.GetServiceStatus("voice"); status.State == service.StateRunning {
+		t.Fatal("Device-triggered service started without its device")
+	}
+
+	received := make(chan service.DeviceEvent, 4)
+	sm.EventBus().Subscribe(service.EventDeviceAdded, func(e service.ServiceEvent) {
+		received <- e.Data.(service.DeviceEvent)
+	})
+
+	config := &Config{Rules: []Rule{{Subsystem: "sound", Mode: "0660", Symlink: "mic"}}}
+	m := NewManager(config, sm.EventBus())
+	m.devDir = devDir
+
+	ev, _ := ParseUevent(micAdd)
+	m.Handle(ev)
+
+	if info, err := os.Stat(node); err != nil || info.Mode().Perm() != 0660 {
+		t.Errorf("Expected node mode 0660, got %v (%v)", info.Mode().Perm(), err)
+	}
+	if target, err := os.Readlink(filepath.Join(devDir, "mic")); err != nil || target != "snd/pcmC1D0c" {
+		t.Errorf("Unexpected symlink target %q (%v)", target, err)
+	}
+	// 事件异步发布
+	select {
+	case e := <-received:
+		if e.DevName != "snd/pcmC1D0c" {
+			t.Errorf("Unexpected device event: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a device added event")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, _ := sm.GetServiceStatus("voice")
+		if status.State == service.StateRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected voice service running after device add, got %s", status.State)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	sm.StopAll()
+
+	remove := *ev
+	remove.Action = "remove"
+	m.Handle(&remove)
+	if _, err := os.Lstat(filepath.Join(devDir, "mic")); !os.IsNotExist(err) {
+		t.Error("Expected symlink to be removed with the device")
+	}
+}
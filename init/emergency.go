@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"ldh-os/init/logging"
+)
+
+// defaultShell 紧急模式和 ldh.break 使用的 shell，可用 ldh.shell=<path> 覆盖
+const defaultShell = "/bin/sh"
+
+const (
+	// shellRespawnDelay 紧急 shell 退出后重新启动前的等待时间
+	shellRespawnDelay = time.Second
+	// continueExitStatus 紧急 shell 以该状态退出（exit 99）时继续启动，
+	// 控制套接字不可用时 ldhctl continue 无法送达，这是离开紧急模式的后备途径
+	continueExitStatus = 99
+	// maxShellFailures shell 连续启动失败的次数上限，控制套接字也不可用时重启系统
+	maxShellFailures = 5
+	// emergencyRebootDelay 没有任何途径离开紧急模式时，重启系统前的等待时间
+	emergencyRebootDelay = 5 * time.Minute
+)
+
+// shellEnv 返回 shell 的最小环境，不继承 init 自身的环境变量
+func shellEnv(prompt string) []string {
+	term := os.Getenv("TERM")
+	if term == "" {
+		term = "linux"
+	}
+	env := []string{
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"HOME=/root",
+		"TERM=" + term,
+		"PS1=" + prompt,
+	}
+	// ldhctl 通过它找到非默认位置的控制套接字
+	if socket := os.Getenv("LDH_CONTROL_SOCKET"); socket != "" {
+		env = append(env, "LDH_CONTROL_SOCKET="+socket)
+	}
+	return env
+}
+
+// runShell 在控制台上运行 shell 并等待其退出。以 PID 1 运行时 shell 在新会话中运行，
+// 并以 /dev/console 作为控制终端，使作业控制和 Ctrl-C 可用
+func (i *InitSystem) runShell(prompt string) error {
+	path := defaultShell
+	if i.options.Shell != "" {
+		path = i.options.Shell
+	}
+
+	cmd := exec.Command(path)
+	cmd.Env = shellEnv(prompt)
+	cmd.Dir = "/"
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	if i.pid1 {
+		console, err := os.OpenFile("/dev/console", os.O_RDWR, 0)
+		if err != nil {
+			logging.Warn("Failed to open /dev/console", "error", err)
+		} else {
+			defer console.Close()
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = console, console, console
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+		}
+	}
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("shell %s failed: %w", path, err)
+	}
+	return nil
+}
+
+// breakpoint 内核命令行指定 ldh.break=<stage> 时，在进入该阶段前打开 shell，
+// shell 退出后继续启动
+func (i *InitSystem) breakpoint(stage string) {
+	if i.options.Break != stage || i.reexeced {
+		return
+	}
+	logging.Warn("Break before stage, exit the shell to continue booting", "stage", stage)
+	if err := i.runShell("(ldh-os break:" + stage + ") # "); err != nil {
+		logging.Warn("Break shell failed", "error", err)
+	}
+}
+
+// emergency 启动失败时进入紧急模式：在控制台上运行 shell（退出后重新启动），
+// 同时继续处理信号和控制命令，直到通过 ldhctl continue 或以状态 99 退出 shell 继续启动
+func (i *InitSystem) emergency(reason string, err error) {
+	logging.Error(reason, "error", err)
+
+	resume := make(chan struct{})
+	i.mu.Lock()
+	i.resume = resume
+	previous := i.state
+	i.state = "emergency"
+	i.mu.Unlock()
+
+	// ldhctl continue 需要控制套接字，尚未启动时先启动它
+	haveControl := true
+	if err := i.startControl(); err != nil {
+		haveControl = false
+		logging.Warn("Failed to start control socket", "error", err)
+	}
+	logging.Error("Entering emergency mode, run 'ldhctl continue' or 'exit 99' in the shell to resume booting")
+	switch {
+	case !i.options.NoShell:
+		go i.emergencyShell(resume, haveControl)
+	case !haveControl:
+		go i.emergencyReboot(resume, "no emergency shell and no control socket")
+	}
+
+	for {
+		select {
+		case sig := <-i.signals:
+			i.handleSignal(sig)
+		case action := <-i.actions:
+			action()
+		case <-i.watchdogTick:
+			i.petWatchdog()
+		case <-resume:
+			logging.Info("Leaving emergency mode, continuing boot")
+			i.mu.Lock()
+			i.state = previous
+			i.mu.Unlock()
+			return
+		}
+	}
+}
+
+// emergencyShell 在紧急模式期间保持控制台上有一个 shell。shell 以 continueExitStatus 退出时继续启动；
+// shell 无法启动且控制套接字不可用时没有办法离开紧急模式，连续失败后重启系统
+func (i *InitSystem) emergencyShell(resume <-chan struct{}, haveControl bool) {
+	failures := 0
+	for {
+		err := i.runShell("(ldh-os emergency) # ")
+		var exitErr *exec.ExitError
+		switch {
+		case errors.As(err, &exitErr) && exitErr.ExitCode() == continueExitStatus:
+			logging.Info("Emergency shell asked to continue booting")
+			i.continueBoot()
+			return
+		case err != nil && !errors.As(err, &exitErr):
+			// shell 没有运行起来（例如找不到 shell 程序）
+			failures++
+			logging.Warn("Emergency shell failed", "error", err, "failures", failures)
+			if failures >= maxShellFailures && !haveControl {
+				i.emergencyReboot(resume, "emergency shell cannot be started and no control socket")
+				return
+			}
+		case err != nil:
+			failures = 0
+			logging.Warn("Emergency shell failed", "error", err)
+		default:
+			failures = 0
+		}
+		select {
+		case <-resume:
+			return
+		case <-time.After(shellRespawnDelay):
+			logging.Info("Emergency shell exited, starting a new one")
+		}
+	}
+}
+
+// emergencyReboot 无法离开紧急模式时，等待 emergencyRebootDelay 后重启系统，期间继续启动则取消
+func (i *InitSystem) emergencyReboot(resume <-chan struct{}, reason string) {
+	logging.Error("No way to leave emergency mode, rebooting", "reason", reason, "delay", emergencyRebootDelay)
+	select {
+	case <-resume:
+		return
+	case <-time.After(emergencyRebootDelay):
+	}
+	i.actions <- func() { i.shutdown(actionReboot) }
+}
+
+// continueBoot 离开紧急模式，不在紧急模式时返回错误
+func (i *InitSystem) continueBoot() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.resume == nil {
+		return fmt.Errorf("not in emergency mode")
+	}
+	close(i.resume)
+	i.resume = nil
+	return nil
+}
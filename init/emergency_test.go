@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"ldh-os/init/control"
+)
+
+func TestEmergencyMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "services.yaml")
+	cmdlinePath := filepath.Join(tmpDir, "cmdline")
+	socketPath := filepath.Join(tmpDir, "control.sock")
+
+	// 无法解析的服务配置使启动进入紧急模式，ldh.shell=0 不启动交互 shell
+	os.WriteFile(configPath, []byte("sleeper: [unclosed\n"), 0644)
+	os.WriteFile(cmdlinePath, []byte("console=ttyS0 ldh.shell=0\n"), 0644)
+
+	cmd, waitForLog := startInit(t,
+		"LDH_SERVICES_CONFIG="+configPath,
+		"LDH_STATE_FILE="+filepath.Join(tmpDir, "services.state"),
+		"LDH_CONTROL_SOCKET="+socketPath,
+		"LDH_EVENT_JOURNAL=off",
+		"LDH_INIT_LOG=off",
+		"LDH_JOURNAL=off",
+		"LDH_CMDLINE="+cmdlinePath,
+		"LDH_NOTIFY_SOCKET="+filepath.Join(tmpDir, "notify"),
+	)
+	defer cmd.Process.Kill()
+
+	waitForLog("Entering emergency mode")
+	// 紧急模式下主循环仍在处理控制命令
+	var services map[string]interface{}
+	if err := control.Call(socketPath, "list", nil, &services); err != nil {
+		t.Fatalf("Control socket unavailable in emergency mode: %v", err)
+	}
+
+	if err := control.Call(socketPath, "continue", nil, nil); err != nil {
+		t.Fatalf("Failed to continue boot: %v", err)
+	}
+	waitForLog("Leaving emergency mode")
+	waitForLog("Init system ready")
+
+	if err := control.Call(socketPath, "continue", nil, nil); err == nil {
+		t.Error("Expected continue to fail outside emergency mode")
+	}
+
+	cmd.Process.Signal(syscall.SIGTERM)
+	cmd.Wait()
+}
+
+func TestEmergencyShellContinue(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "services.yaml")
+	cmdlinePath := filepath.Join(tmpDir, "cmdline")
+	shellPath := filepath.Join(tmpDir, "shell")
+	blocked := filepath.Join(tmpDir, "blocked")
+
+	// 控制套接字的目录无法创建，ldhctl continue 不可用；shell 以状态 99 退出时继续启动
+	os.WriteFile(configPath, []byte("sleeper: [unclosed\n"), 0644)
+	os.WriteFile(shellPath, []byte("#!/bin/sh\nexit 99\n"), 0755)
+	os.WriteFile(blocked, nil, 0644)
+	os.WriteFile(cmdlinePath, []byte("console=ttyS0 ldh.shell="+shellPath+"\n"), 0644)
+
+	cmd, waitForLog := startInit(t,
+		"LDH_SERVICES_CONFIG="+configPath,
+		"LDH_STATE_FILE="+filepath.Join(tmpDir, "services.state"),
+		"LDH_CONTROL_SOCKET="+filepath.Join(blocked, "control.sock"),
+		"LDH_EVENT_JOURNAL=off",
+		"LDH_INIT_LOG=off",
+		"LDH_JOURNAL=off",
+		"LDH_CMDLINE="+cmdlinePath,
+		"LDH_NOTIFY_SOCKET="+filepath.Join(tmpDir, "notify"),
+	)
+	defer cmd.Process.Kill()
+
+	waitForLog("Entering emergency mode")
+	waitForLog("Emergency shell asked to continue booting")
+	waitForLog("Leaving emergency mode")
+	waitForLog("Init system ready")
+
+	cmd.Process.Signal(syscall.SIGTERM)
+	cmd.Wait()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ldh-os/init/logging"
+	"ldh-os/init/service"
+)
+
+// startExporter 在 addr 上提供 OpenMetrics 格式的 /metrics。addr 为 unix 套接字的绝对路径，
+// 或只监听回环地址的 host:port（例如 127.0.0.1:9100），指标不对外暴露
+func (i *InitSystem) startExporter(addr string) error {
+	l, err := listenExporter(addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exporterHandler(i.serviceManager))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(l); err != nil {
+			logging.Warn("Metrics exporter stopped", "error", err)
+		}
+	}()
+	logging.Info("Serving metrics", "listen", addr)
+	return nil
+}
+
+// listenExporter 打开导出端点的监听套接字
+func listenExporter(addr string) (net.Listener, error) {
+	if filepath.IsAbs(addr) {
+		if err := os.MkdirAll(filepath.Dir(addr), 0755); err != nil {
+			return nil, err
+		}
+		// 上次运行（或 re-exec 之前）留下的套接字文件
+		os.Remove(addr)
+		l, err := net.Listen("unix", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %v", addr, err)
+		}
+		if err := os.Chmod(addr, 0660); err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %s: %v", addr, err)
+	}
+	if host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return nil, fmt.Errorf("listen address %s is not a loopback address", addr)
+		}
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+	return l, nil
+}
+
+// exporterHandler 每次抓取时生成当前的指标
+func exporterHandler(sm *service.ServiceManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", service.OpenMetricsContentType)
+		if err := sm.WriteOpenMetrics(w); err != nil {
+			logging.Debug("Failed to write metrics", "error", err)
+		}
+	})
+}
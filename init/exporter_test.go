@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ldh-os/init/service"
+)
+
+func TestExporter(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:9100", ":9100", "192.0.2.1:9100", "no-port"} {
+		if l, err := listenExporter(addr); err == nil {
+			l.Close()
+			t.Errorf("Expected %s to be rejected", addr)
+		}
+	}
+	l, err := listenExporter("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	path := filepath.Join(t.TempDir(), "metrics.sock")
+	l, err = listenExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	sm := service.NewServiceManager()
+	go http.Serve(l, exporterHandler(sm))
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			return net.Dial("unix", path)
+		},
+	}}
+	resp, err := client.Get("http://localhost/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != service.OpenMetricsContentType {
+		t.Fatalf("Unexpected response %s %q", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), "ldh_events_total ") || !strings.HasSuffix(string(body), "# EOF\n") {
+		t.Errorf("Unexpected body:\n%s", body)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"ldh-os/init/control"
+	"ldh-os/init/journal"
+	"ldh-os/init/logging"
+)
+
+// defaultJournalDir 日志存储目录，LDH_JOURNAL=off 时日志只保留在内存中
+const defaultJournalDir = "/var/log/ldh-os/journal"
+
+const (
+	// outputFilePrefix 跨 re-exec 保留的服务输出管道的名称前缀，后跟服务名和 :r 或 :w
+	outputFilePrefix = "output:"
+	// syslogFile 跨 re-exec 保留的 /dev/log 套接字
+	syslogFile = "syslog"
+)
+
+// defaultLogsLimit system.logs 单次返回的默认条数
+const defaultLogsLimit = 100
+
+// logsArgs logs 命令和 system.logs 的参数
+type logsArgs struct {
+	journal.Query
+	Follow bool `json:"follow"` // 输出历史后继续推送新日志，只用于控制套接字
+}
+
+// logsHeader logs 命令的首行应答数据
+type logsHeader struct {
+	LastSeq uint64 `json:"last_seq"`
+}
+
+// LogsResult system.logs 的返回值
+type LogsResult struct {
+	Entries []journal.Entry `json:"entries"`
+	LastSeq uint64          `json:"last_seq"`
+}
+
+// startJournal 创建日志系统并接收 init 自身的日志。存储打开之前日志保存在内存中
+func (i *InitSystem) startJournal() {
+	i.journal = journal.New(journal.DefaultMemoryEntries)
+	logging.SetSink("journal", i.journal.LogSink(), logging.LevelDebug)
+	i.serviceManager.RegisterSystemFunction("logs", i.mcpLogs)
+}
+
+// openJournal 打开磁盘存储并开始接收服务输出、/dev/log 和内核日志，在文件系统挂载之后调用。
+// re-exec 后从继承的描述符恢复服务输出管道和 syslog 套接字，已读过的内核日志被跳过
+func (i *InitSystem) openJournal() {
+	dir := defaultJournalDir
+	if os.Getenv("LDH_JOURNAL") != "" {
+		dir = os.Getenv("LDH_JOURNAL")
+	}
+	if dir != "off" {
+		if err := i.journal.Open(dir, journal.DefaultMaxSize); err != nil {
+			logging.Warn("Journal storage unavailable, keeping logs in memory", "error", err)
+		}
+	}
+
+	i.restoreOutputPipes()
+	i.serviceManager.SetOutput(i.serviceOutput)
+
+	// 测试模式下不接管系统的 /dev/log，除非显式指定了套接字路径
+	if i.pid1 || os.Getenv("LDH_SYSLOG_SOCKET") != "" {
+		if err := i.startSyslog(); err != nil {
+			logging.Warn("Syslog socket disabled", "error", err)
+		}
+	}
+	if i.pid1 {
+		if err := i.journal.ReadKmsg(logging.KmsgPath, i.reexeced, kmsgTag); err != nil {
+			logging.Warn("Kernel log not collected", "error", err)
+		}
+	}
+}
+
+// closeJournal 关闭磁盘存储，在卸载文件系统之前调用
+func (i *InitSystem) closeJournal() {
+	if err := i.journal.Close(); err != nil {
+		logging.Warn("Failed to close journal", "error", err)
+	}
+}
+
+// serviceOutput 返回服务输出管道的写端，首次调用时创建管道并登记为跨 re-exec 保留
+func (i *InitSystem) serviceOutput(name string) (*os.File, error) {
+	i.mu.Lock()
+	pipe, exists := i.outputs[name]
+	i.mu.Unlock()
+	if exists {
+		return pipe.W, nil
+	}
+
+	pipe, err := i.journal.OpenPipe(name)
+	if err != nil {
+		return nil, err
+	}
+	i.mu.Lock()
+	i.outputs[name] = pipe
+	i.mu.Unlock()
+	i.keepFile(outputFilePrefix+name+":r", pipe.R)
+	i.keepFile(outputFilePrefix+name+":w", pipe.W)
+	return pipe.W, nil
+}
+
+// restoreOutputPipes 继续读取 re-exec 前创建的服务输出管道，管道中未读的输出不会丢失
+func (i *InitSystem) restoreOutputPipes() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for name, r := range i.files {
+		if !strings.HasPrefix(name, outputFilePrefix) || !strings.HasSuffix(name, ":r") {
+			continue
+		}
+		service := strings.TrimSuffix(strings.TrimPrefix(name, outputFilePrefix), ":r")
+		w, ok := i.files[outputFilePrefix+service+":w"]
+		if !ok {
+			continue
+		}
+		i.outputs[service] = i.journal.AttachPipe(service, r, w)
+	}
+}
+
+// startSyslog 在 /dev/log（LDH_SYSLOG_SOCKET 指定时为该路径）上接收 syslog 数据报
+func (i *InitSystem) startSyslog() error {
+	i.mu.Lock()
+	f, inherited := i.files[syslogFile]
+	i.mu.Unlock()
+
+	var conn net.PacketConn
+	if inherited {
+		c, err := net.FilePacketConn(f)
+		if err != nil {
+			return fmt.Errorf("failed to restore syslog socket: %v", err)
+		}
+		conn = c
+	} else {
+		path := journal.DefaultSyslogPath
+		if os.Getenv("LDH_SYSLOG_SOCKET") != "" {
+			path = os.Getenv("LDH_SYSLOG_SOCKET")
+		}
+		c, err := journal.ListenSyslog(path)
+		if err != nil {
+			return err
+		}
+		f, err := c.File()
+		if err != nil {
+			c.Close()
+			return err
+		}
+		i.keepFile(syslogFile, f)
+		conn = c
+	}
+	go i.journal.ServeSyslog(conn)
+	return nil
+}
+
+// streamLogs 实现 logs 命令：输出满足条件的历史日志，follow 时继续推送新日志
+func (i *InitSystem) streamLogs(args json.RawMessage, conn *control.Conn) error {
+	var a logsArgs
+	if err := decodeArgs(args, &a); err != nil {
+		return err
+	}
+
+	// 先订阅再查询，查询期间写入的日志按序号去重
+	var follower *journal.Follower
+	if a.Follow {
+		follower = i.journal.Follow(a.Query)
+		defer follower.Close()
+	}
+	entries, err := i.journal.Query(a.Query)
+	if err != nil {
+		return err
+	}
+
+	if err := conn.Begin(logsHeader{LastSeq: i.journal.LastSeq()}); err != nil {
+		return nil
+	}
+	var last uint64
+	for _, e := range entries {
+		if err := conn.Send(e); err != nil {
+			return nil
+		}
+		last = e.Seq
+	}
+	if follower == nil {
+		return nil
+	}
+
+	for {
+		select {
+		case e := <-follower.C:
+			if e.Seq <= last {
+				continue
+			}
+			if err := conn.Send(e); err != nil {
+				return nil
+			}
+		case <-conn.Closed():
+			return nil
+		}
+	}
+}
+
+// mcpLogs 实现 system.logs：按 service、source、priority、since、until（RFC 3339）、
+// grep、after 查询日志，返回最新的 limit 条（默认 100）。调用方用 last_seq 作为下一次的 after
+func (i *InitSystem) mcpLogs(params map[string]interface{}) (interface{}, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	var q journal.Query
+	if err := json.Unmarshal(data, &q); err != nil {
+		return nil, fmt.Errorf("invalid parameters: %v", err)
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultLogsLimit
+	}
+
+	last := i.journal.LastSeq()
+	entries, err := i.journal.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	result := LogsResult{Entries: entries, LastSeq: last}
+	if n := len(entries); n > 0 && entries[n-1].Seq > last {
+		result.LastSeq = entries[n-1].Seq
+	}
+	if result.Entries == nil {
+		result.Entries = []journal.Entry{}
+	}
+	return result, nil
+}
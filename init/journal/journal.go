@@ -0,0 +1,332 @@
+// Package journal 是 init 内置的日志系统：汇集 init 自身的日志、服务的标准输出和标准错误、
+// /dev/log 上的 syslog 数据报和内核日志，按序号编号后保存在内存中，存储可用后写入
+// 按大小轮转的 JSON 行分段文件，支持按服务、优先级、时间范围和文本查询
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Priority syslog 优先级，数值越小越严重
+type Priority int
+
+const (
+	PriEmerg Priority = iota
+	PriAlert
+	PriCrit
+	PriErr
+	PriWarning
+	PriNotice
+	PriInfo
+	PriDebug
+)
+
+var priorityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// priorityAliases 常见的优先级别名
+var priorityAliases = map[string]Priority{
+	"panic": PriEmerg,
+	"error": PriErr,
+	"warn":  PriWarning,
+}
+
+func (p Priority) String() string {
+	if p < PriEmerg || p > PriDebug {
+		return fmt.Sprintf("priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+// ParsePriority 解析优先级名称（emerg ... debug，以及 error、warn）或数字 0-7
+func ParsePriority(s string) (Priority, error) {
+	s = strings.ToLower(s)
+	for i, name := range priorityNames {
+		if s == name {
+			return Priority(i), nil
+		}
+	}
+	if p, ok := priorityAliases[s]; ok {
+		return p, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= int(PriEmerg) && n <= int(PriDebug) {
+		return Priority(n), nil
+	}
+	return PriInfo, fmt.Errorf("unknown priority %q", s)
+}
+
+func (p Priority) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+// UnmarshalJSON 接受优先级名称或数字
+func (p *Priority) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		data = []byte(strconv.Quote(strconv.Itoa(n)))
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := ParsePriority(s)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// Source 日志来源
+type Source string
+
+const (
+	SourceInit    Source = "init"    // init 自身的日志
+	SourceService Source = "service" // 服务的标准输出和标准错误
+	SourceSyslog  Source = "syslog"  // /dev/log 上的 syslog 数据报
+	SourceKernel  Source = "kernel"  // /dev/kmsg
+)
+
+// Entry 一条日志
+type Entry struct {
+	Seq      uint64            `json:"seq"`
+	Time     time.Time         `json:"time"`
+	Priority Priority          `json:"priority"`
+	Source   Source            `json:"source"`
+	Service  string            `json:"service,omitempty"` // 服务名、syslog 标识或 kernel
+	Pid      int               `json:"pid,omitempty"`
+	Message  string            `json:"message"`
+	Fields   map[string]string `json:"fields,omitempty"`
+}
+
+// Query 日志查询条件，零值匹配所有日志
+type Query struct {
+	Service  string    `json:"service,omitempty"`  // 服务名或 syslog 标识，支持通配符
+	Source   Source    `json:"source,omitempty"`   // 只返回该来源的日志
+	Priority *Priority `json:"priority,omitempty"` // 只返回该优先级及更严重的日志
+	Since    time.Time `json:"since,omitempty"`    // 不早于该时间
+	Until    time.Time `json:"until,omitempty"`    // 早于该时间
+	Grep     string    `json:"grep,omitempty"`     // 消息包含的文本，不区分大小写
+	After    uint64    `json:"after,omitempty"`    // 只返回序号大于它的日志
+	Limit    int       `json:"limit,omitempty"`    // 只返回最新的 limit 条，给出 After 时返回其后最早的 limit 条
+}
+
+// Matches 判断日志是否满足查询条件（不考虑 Limit）
+func (q *Query) Matches(e *Entry) bool {
+	if e.Seq <= q.After {
+		return false
+	}
+	if q.Source != "" && e.Source != q.Source {
+		return false
+	}
+	if q.Priority != nil && e.Priority > *q.Priority {
+		return false
+	}
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !e.Time.Before(q.Until) {
+		return false
+	}
+	if q.Service != "" {
+		if ok, err := path.Match(q.Service, e.Service); err != nil || !ok {
+			return false
+		}
+	}
+	if q.Grep != "" && !strings.Contains(strings.ToLower(e.Message), strings.ToLower(q.Grep)) {
+		return false
+	}
+	return true
+}
+
+// DefaultMemoryEntries 内存中保留的最近日志条数，存储可用之前的日志也保存在这里
+const DefaultMemoryEntries = 2048
+
+// DefaultMaxSize 磁盘存储的默认总大小上限
+const DefaultMaxSize = 16 << 20
+
+// followerBuffer 每个跟随者的缓冲条数，消费过慢时丢弃新日志
+const followerBuffer = 256
+
+// Journal 日志系统。存储打开之前日志只保存在内存中，打开时补写到磁盘
+type Journal struct {
+	seq       uint64
+	ring      []Entry
+	start     int
+	count     int
+	store     *store
+	storeErr  bool // 已经报告过写入错误
+	followers map[*Follower]struct{}
+	mu        sync.Mutex
+}
+
+// New 创建日志系统，memory 是内存中保留的条数
+func New(memory int) *Journal {
+	if memory <= 0 {
+		memory = DefaultMemoryEntries
+	}
+	return &Journal{
+		ring:      make([]Entry, memory),
+		followers: make(map[*Follower]struct{}),
+	}
+}
+
+// Open 打开 dir 下的磁盘存储，总大小超过 maxSize 时删除最旧的分段。
+// 已在内存中的日志接着存储中最大的序号重新编号后写入
+func (j *Journal) Open(dir string, maxSize int64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.store != nil {
+		return fmt.Errorf("journal storage already open")
+	}
+
+	s, err := openStore(dir, maxSize)
+	if err != nil {
+		return err
+	}
+	seq := s.lastSeq()
+	for i := 0; i < j.count; i++ {
+		e := &j.ring[(j.start+i)%len(j.ring)]
+		seq++
+		e.Seq = seq
+		if err := s.append(e); err != nil {
+			s.close()
+			return err
+		}
+	}
+	j.seq = seq
+	j.store = s
+	return nil
+}
+
+// Write 为日志分配序号并保存，未设置时间时使用当前时间，返回分配的序号
+func (j *Journal) Write(e Entry) uint64 {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.seq++
+	e.Seq = j.seq
+
+	idx := (j.start + j.count) % len(j.ring)
+	j.ring[idx] = e
+	if j.count < len(j.ring) {
+		j.count++
+	} else {
+		j.start = (j.start + 1) % len(j.ring)
+	}
+
+	if j.store != nil {
+		if err := j.store.append(&e); err != nil && !j.storeErr {
+			// 不能通过 init 日志报告：init 日志本身也写入 journal
+			j.storeErr = true
+			fmt.Fprintf(os.Stderr, "journal: failed to write storage: %v\n", err)
+		}
+	}
+
+	for f := range j.followers {
+		if !f.query.Matches(&e) {
+			continue
+		}
+		select {
+		case f.c <- e:
+		default:
+			atomic.AddUint64(&f.dropped, 1)
+		}
+	}
+	return e.Seq
+}
+
+// LastSeq 返回最近一条日志的序号
+func (j *Journal) LastSeq() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seq
+}
+
+// Query 返回满足条件的日志，按序号升序。存储打开后查询磁盘，否则查询内存
+func (j *Journal) Query(q Query) ([]Entry, error) {
+	var result []Entry
+	collect := func(e *Entry) {
+		if !q.Matches(e) {
+			return
+		}
+		// 按序号续读时保留最早的 limit 条
+		if q.After > 0 && q.Limit > 0 && len(result) >= q.Limit {
+			return
+		}
+		result = append(result, *e)
+		// 只保留最新的 limit 条，避免大范围查询占用过多内存
+		if q.Limit > 0 && len(result) >= 2*q.Limit {
+			result = append(result[:0], result[len(result)-q.Limit:]...)
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.store != nil {
+		if err := j.store.scan(&q, collect); err != nil {
+			return nil, err
+		}
+	} else {
+		for i := 0; i < j.count; i++ {
+			collect(&j.ring[(j.start+i)%len(j.ring)])
+		}
+	}
+	if q.Limit > 0 && len(result) > q.Limit {
+		result = result[len(result)-q.Limit:]
+	}
+	return result, nil
+}
+
+// Follower 接收新写入的、满足查询条件的日志
+type Follower struct {
+	dropped uint64 // 原子访问，放在首位以保证 64 位对齐
+	C       <-chan Entry
+	c       chan Entry
+	query   Query
+	j       *Journal
+}
+
+// Follow 订阅之后写入的日志，q 的 Limit 被忽略
+func (j *Journal) Follow(q Query) *Follower {
+	c := make(chan Entry, followerBuffer)
+	f := &Follower{C: c, c: c, query: q, j: j}
+	j.mu.Lock()
+	j.followers[f] = struct{}{}
+	j.mu.Unlock()
+	return f
+}
+
+// Dropped 返回因缓冲区满而丢弃的日志条数
+func (f *Follower) Dropped() uint64 {
+	return atomic.LoadUint64(&f.dropped)
+}
+
+// Close 取消订阅
+func (f *Follower) Close() {
+	f.j.mu.Lock()
+	delete(f.j.followers, f)
+	f.j.mu.Unlock()
+}
+
+// Close 关闭磁盘存储，之后的日志只保存在内存中
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.store == nil {
+		return nil
+	}
+	err := j.store.close()
+	j.store = nil
+	return err
+}
@@ -0,0 +1,170 @@
+package journal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalStorage(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "journal")
+	j := New(16)
+
+	// 存储打开之前的日志保存在内存中，打开后补写到磁盘
+	j.Write(Entry{Priority: PriInfo, Source: SourceInit, Message: "early boot"})
+	if err := j.Open(dir, 0); err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	base := time.Now()
+	j.Write(Entry{Time: base, Priority: PriErr, Source: SourceService, Service: "web", Message: "Connection refused"})
+	j.Write(Entry{Time: base.Add(time.Second), Priority: PriInfo, Source: SourceService, Service: "web", Message: "listening on :80"})
+	j.Write(Entry{Time: base.Add(2 * time.Second), Priority: PriWarning, Source: SourceSyslog, Service: "crond", Message: "job took too long"})
+	j.Close()
+
+	// 重新打开后序号接着磁盘上的最大序号
+	j = New(16)
+	j.Write(Entry{Priority: PriInfo, Source: SourceInit, Message: "second boot"})
+	if err := j.Open(dir, 0); err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer j.Close()
+	if j.LastSeq() != 5 {
+		t.Errorf("Expected last seq 5, got %d", j.LastSeq())
+	}
+
+	warning := PriWarning
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"all", Query{}, []string{"early boot", "Connection refused", "listening on :80", "job took too long", "second boot"}},
+		{"service", Query{Service: "w*"}, []string{"Connection refused", "listening on :80"}},
+		{"priority", Query{Priority: &warning}, []string{"Connection refused", "job took too long"}},
+		{"time", Query{Since: base.Add(time.Second), Until: base.Add(2 * time.Second)}, []string{"listening on :80"}},
+		{"grep", Query{Grep: "REFUSED"}, []string{"Connection refused"}},
+		{"source", Query{Source: SourceSyslog}, []string{"job took too long"}},
+		{"limit", Query{Limit: 2}, []string{"job took too long", "second boot"}},
+		{"after", Query{After: 2, Limit: 2}, []string{"listening on :80", "job took too long"}},
+	}
+	for _, tt := range tests {
+		entries, err := j.Query(tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Message)
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestJournalSizeCap(t *testing.T) {
+	dir := t.TempDir()
+	j := New(16)
+	if err := j.Open(dir, 8*minSegmentSize); err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	msg := strings.Repeat("x", 1000)
+	for n := 0; n < 2000; n++ {
+		j.Write(Entry{Priority: PriInfo, Source: SourceService, Service: "noisy", Message: fmt.Sprintf("%d %s", n, msg)})
+	}
+
+	var total int64
+	files, _ := os.ReadDir(dir)
+	for _, f := range files {
+		info, _ := f.Info()
+		total += info.Size()
+	}
+	if total > 8*minSegmentSize || len(files) > maxSegments {
+		t.Errorf("Expected at most %d bytes in %d segments, got %d bytes in %d", 8*minSegmentSize, maxSegments, total, len(files))
+	}
+
+	// 最旧的日志被删除，最新的仍然可以查询
+	entries, err := j.Query(Query{Limit: 1})
+	if err != nil || len(entries) != 1 || !strings.HasPrefix(entries[0].Message, "1999 ") {
+		t.Fatalf("Expected newest entry, got %v (%v)", entries, err)
+	}
+	entries, _ = j.Query(Query{})
+	if len(entries) == 0 || entries[0].Seq == 1 || len(entries) >= 2000 {
+		t.Errorf("Expected oldest entries to be removed, %d left", len(entries))
+	}
+}
+
+func TestServiceOutput(t *testing.T) {
+	j := New(16)
+	pipe, err := j.OpenPipe("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipe.Close()
+	f := j.Follow(Query{Service: "web"})
+	defer f.Close()
+
+	fmt.Fprintf(pipe.W, "ready\r\n<3>disk full\n\n")
+	for _, want := range []Entry{{Priority: PriInfo, Message: "ready"}, {Priority: PriErr, Message: "disk full"}} {
+		select {
+		case e := <-f.C:
+			if e.Priority != want.Priority || e.Message != want.Message || e.Source != SourceService {
+				t.Errorf("Expected %s %q, got %s %q", want.Priority, want.Message, e.Priority, e.Message)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %q", want.Message)
+		}
+	}
+}
+
+func TestParseSyslog(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		msg      string
+		priority Priority
+		service  string
+		pid      int
+		message  string
+		facility string
+	}{
+		{"<13>Oct 19 02:41:46 root: hello world", PriNotice, "root", 0, "hello world", "user"},
+		{"<86>sshd[412]: Accepted publickey for root", PriInfo, "sshd", 412, "Accepted publickey for root", "authpriv"},
+		{"<11>1 2026-10-19T02:41:46Z host app 77 ID1 [x@1 a=\"b\"] failed", PriErr, "app", 77, "failed", "user"},
+		{"<30>1 2026-10-19T02:41:46Z - dhcpcd - - - lease acquired", PriInfo, "dhcpcd", 0, "lease acquired", "daemon"},
+		{"no header at all", PriNotice, "", 0, "no header at all", "user"},
+	}
+	for _, tt := range tests {
+		e := parseSyslog([]byte(tt.msg+"\n"), now)
+		if e.Priority != tt.priority || e.Service != tt.service || e.Pid != tt.pid || e.Message != tt.message || e.Fields["facility"] != tt.facility {
+			t.Errorf("%q: unexpected entry %+v", tt.msg, e)
+		}
+	}
+}
+
+func TestParseKmsg(t *testing.T) {
+	boot := time.Unix(1000, 0)
+	e, ok := parseKmsg([]byte("3,412,2500000,-;usb 1-1: device descriptor read error\n SUBSYSTEM=usb\n DEVICE=c189:1\n"), boot)
+	if !ok {
+		t.Fatal("Expected record to parse")
+	}
+	if e.Priority != PriErr || e.Service != "kernel" || e.Message != "usb 1-1: device descriptor read error" {
+		t.Errorf("Unexpected entry: %+v", e)
+	}
+	if !e.Time.Equal(boot.Add(2500*time.Millisecond)) || e.Fields["subsystem"] != "usb" {
+		t.Errorf("Unexpected time or fields: %v %v", e.Time, e.Fields)
+	}
+
+	// 用户空间写入的记录没有内核设施
+	e, _ = parseKmsg([]byte("14,413,2600000,-;ldh-init: Init system ready"), boot)
+	if e.Service != "" || e.Fields["facility"] != "user" {
+		t.Errorf("Unexpected userspace entry: %+v", e)
+	}
+	if _, ok := parseKmsg([]byte("garbage"), boot); ok {
+		t.Error("Expected garbage to be rejected")
+	}
+}
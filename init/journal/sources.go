@@ -0,0 +1,302 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"ldh-os/init/logging"
+)
+
+// levelPriority init 日志级别对应的优先级
+var levelPriority = map[logging.Level]Priority{
+	logging.LevelDebug: PriDebug,
+	logging.LevelInfo:  PriInfo,
+	logging.LevelWarn:  PriWarning,
+	logging.LevelError: PriErr,
+}
+
+// logSink 把 init 自身的日志写入 journal
+type logSink struct {
+	j *Journal
+}
+
+// LogSink 返回写入 journal 的 init 日志输出。
+// 日志的 service 字段作为服务名，便于按服务查询时同时看到 init 对该服务的记录
+func (j *Journal) LogSink() logging.Sink {
+	return logSink{j: j}
+}
+
+func (s logSink) Write(e *logging.Entry) error {
+	entry := Entry{
+		Time:     e.Time,
+		Priority: levelPriority[e.Level],
+		Source:   SourceInit,
+		Pid:      os.Getpid(),
+		Message:  e.Message,
+	}
+	for _, f := range e.Fields {
+		if name, ok := f.Value.(string); ok && f.Key == "service" {
+			entry.Service = name
+			continue
+		}
+		if entry.Fields == nil {
+			entry.Fields = make(map[string]string, len(e.Fields))
+		}
+		entry.Fields[f.Key] = fmt.Sprint(f.Value)
+	}
+	s.j.Write(entry)
+	return nil
+}
+
+// maxLineLength 服务输出单行的长度上限，更长的行被拆分为多条日志
+const maxLineLength = 16 << 10
+
+// Pipe 服务输出管道：服务进程以写端作为标准输出和标准错误，journal 逐行读取读端。
+// 管道在服务重启之间复用，init 持有两端，re-exec 时一并交接
+type Pipe struct {
+	Service string
+	R       *os.File
+	W       *os.File
+}
+
+// OpenPipe 为服务创建输出管道并开始读取
+func (j *Journal) OpenPipe(service string) (*Pipe, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output pipe for %s: %v", service, err)
+	}
+	return j.AttachPipe(service, r, w), nil
+}
+
+// AttachPipe 读取已有的输出管道，例如 re-exec 继承的描述符
+func (j *Journal) AttachPipe(service string, r, w *os.File) *Pipe {
+	p := &Pipe{Service: service, R: r, W: w}
+	go j.readOutput(service, r)
+	return p
+}
+
+// readOutput 把服务输出的每一行写为一条日志，直到管道关闭。
+// 行首的 <N>（N 为 0-7）指定优先级，否则为 info
+func (j *Journal) readOutput(service string, r io.Reader) {
+	br := bufio.NewReaderSize(r, maxLineLength)
+	for {
+		line, err := br.ReadSlice('\n')
+		if len(line) > 0 {
+			if msg := strings.TrimRight(string(line), "\r\n"); msg != "" {
+				priority, msg := splitPriority(msg)
+				j.Write(Entry{Priority: priority, Source: SourceService, Service: service, Message: msg})
+			}
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return
+		}
+	}
+}
+
+// splitPriority 解析行首的 <N> 优先级前缀
+func splitPriority(msg string) (Priority, string) {
+	if len(msg) >= 3 && msg[0] == '<' && msg[2] == '>' && msg[1] >= '0' && msg[1] <= '7' {
+		return Priority(msg[1] - '0'), msg[3:]
+	}
+	return PriInfo, msg
+}
+
+// Close 关闭管道两端，读取随之结束
+func (p *Pipe) Close() {
+	p.W.Close()
+	p.R.Close()
+}
+
+// DefaultSyslogPath 本地 syslog 套接字
+const DefaultSyslogPath = "/dev/log"
+
+// facilityNames syslog 设施名称
+var facilityNames = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+
+// ListenSyslog 在 path 上创建 syslog 数据报套接字，已存在的文件被替换
+func ListenSyslog(path string) (*net.UnixConn, error) {
+	os.Remove(path)
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", path, err)
+	}
+	// 所有用户都可以写日志
+	if err := os.Chmod(path, 0666); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ServeSyslog 读取 syslog 数据报直到 conn 关闭
+func (j *Journal) ServeSyslog(conn net.PacketConn) {
+	buf := make([]byte, 64<<10)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		if n > 0 {
+			j.Write(parseSyslog(buf[:n], time.Now()))
+		}
+	}
+}
+
+// parseSyslog 解析 RFC 3164（"<PRI>Mmm dd hh:mm:ss TAG[PID]: MSG"，时间戳可省略）
+// 或 RFC 5424 格式的消息。消息自带的时间戳没有年份和时区，日志时间使用接收时间
+func parseSyslog(data []byte, now time.Time) Entry {
+	msg := string(bytes.TrimRight(data, "\x00\r\n"))
+	e := Entry{Time: now, Priority: PriNotice, Source: SourceSyslog}
+	facility := 1
+
+	if strings.HasPrefix(msg, "<") {
+		if end := strings.IndexByte(msg, '>'); end > 1 && end <= 4 {
+			if pri, err := strconv.Atoi(msg[1:end]); err == nil && pri >= 0 && pri < len(facilityNames)*8 {
+				e.Priority, facility = Priority(pri&7), pri>>3
+				msg = msg[end+1:]
+			}
+		}
+	}
+	e.Fields = map[string]string{"facility": facilityNames[facility]}
+
+	if strings.HasPrefix(msg, "1 ") {
+		// RFC 5424：VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+		parts := strings.SplitN(msg, " ", 7)
+		if len(parts) == 7 {
+			e.Service = nilValue(parts[3])
+			e.Pid, _ = strconv.Atoi(parts[4])
+			rest := parts[6]
+			if strings.HasPrefix(rest, "-") {
+				rest = strings.TrimPrefix(rest[1:], " ")
+			} else if i := strings.LastIndex(rest, "] "); strings.HasPrefix(rest, "[") && i >= 0 {
+				rest = rest[i+2:]
+			}
+			e.Message = strings.TrimPrefix(rest, "\ufeff")
+			return e
+		}
+	}
+
+	// RFC 3164 时间戳 "Mmm dd hh:mm:ss "
+	if len(msg) >= 16 && msg[3] == ' ' && msg[6] == ' ' && msg[9] == ':' && msg[12] == ':' && msg[15] == ' ' {
+		msg = msg[16:]
+	}
+	// TAG 由不含空白的字符组成，后跟 "[PID]:" 或 ":"
+	if i := strings.IndexAny(msg, ":[ "); i > 0 && i <= 48 && msg[i] != ' ' {
+		tag, rest := msg[:i], msg[i:]
+		if rest[0] == '[' {
+			if end := strings.Index(rest, "]"); end > 0 {
+				e.Pid, _ = strconv.Atoi(rest[1:end])
+				rest = rest[end+1:]
+			}
+		}
+		if strings.HasPrefix(rest, ":") {
+			e.Service = tag
+			msg = strings.TrimPrefix(rest[1:], " ")
+		}
+	}
+	e.Message = msg
+	return e
+}
+
+// nilValue 把 RFC 5424 的空值 "-" 转换为空字符串
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// ReadKmsg 读取内核日志缓冲区直到出错。fromEnd 为 true 时跳过已有记录（re-exec 之后它们已在 journal 中）；
+// 以 "skipTag: " 开头的用户空间记录是 init 自己写入的日志，已经直接进入 journal，被忽略
+func (j *Journal) ReadKmsg(path string, fromEnd bool, skipTag string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	if fromEnd {
+		if _, err := unix.Seek(int(f.Fd()), 0, io.SeekEnd); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	// 内核日志的时间戳是启动以来的单调时间
+	var ts unix.Timespec
+	unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts)
+	boot := time.Now().Add(-time.Duration(ts.Nano()))
+
+	go func() {
+		defer f.Close()
+		buf := make([]byte, 8192)
+		for {
+			// 每次 read 返回一条记录
+			n, err := f.Read(buf)
+			if err != nil {
+				// 未读的记录已被覆盖
+				if pe, ok := err.(*os.PathError); ok && pe.Err == unix.EPIPE {
+					continue
+				}
+				return
+			}
+			e, ok := parseKmsg(buf[:n], boot)
+			if !ok || (e.Fields["facility"] != "kern" && strings.HasPrefix(e.Message, skipTag+": ")) {
+				continue
+			}
+			j.Write(e)
+		}
+	}()
+	return nil
+}
+
+// parseKmsg 解析一条 /dev/kmsg 记录："PRI,SEQ,USEC,FLAGS;MESSAGE"，后续以空格开头的行是 KEY=VALUE 属性
+func parseKmsg(record []byte, boot time.Time) (Entry, bool) {
+	text := strings.TrimRight(string(record), "\n")
+	semi := strings.IndexByte(text, ';')
+	if semi < 0 {
+		return Entry{}, false
+	}
+	header := strings.Split(text[:semi], ",")
+	if len(header) < 3 {
+		return Entry{}, false
+	}
+	pri, err1 := strconv.Atoi(header[0])
+	usec, err2 := strconv.ParseInt(header[2], 10, 64)
+	if err1 != nil || err2 != nil || pri < 0 {
+		return Entry{}, false
+	}
+
+	lines := strings.Split(text[semi+1:], "\n")
+	e := Entry{
+		Time:     boot.Add(time.Duration(usec) * time.Microsecond),
+		Priority: Priority(pri & 7),
+		Source:   SourceKernel,
+		Message:  lines[0],
+		Fields:   map[string]string{"facility": "user"},
+	}
+	if facility := pri >> 3; facility < len(facilityNames) {
+		e.Fields["facility"] = facilityNames[facility]
+	}
+	if pri>>3 == 0 {
+		e.Service = "kernel"
+	}
+	for _, line := range lines[1:] {
+		if kv := strings.SplitN(strings.TrimPrefix(line, " "), "=", 2); len(kv) == 2 {
+			e.Fields[strings.ToLower(kv[0])] = kv[1]
+		}
+	}
+	return e, true
+}
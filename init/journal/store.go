@@ -0,0 +1,259 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxSegments 存储保留的分段数，单个分段的大小上限为总大小的 1/maxSegments
+const maxSegments = 8
+
+// minSegmentSize 分段大小的下限
+const minSegmentSize = 64 << 10
+
+// segment 一个分段文件及其索引。索引在打开存储时扫描分段建立，
+// 查询时跳过时间范围、服务或优先级不可能匹配的分段
+type segment struct {
+	path     string
+	size     int64
+	firstSeq uint64
+	lastSeq  uint64
+	minTime  time.Time // 日志时间来自不同来源，不保证单调
+	maxTime  time.Time
+	severest Priority        // 分段中最严重（数值最小）的优先级
+	services map[string]bool // 分段中出现的服务
+	count    int
+}
+
+func (s *segment) add(e *Entry, size int64) {
+	if s.count == 0 {
+		s.firstSeq, s.minTime, s.maxTime, s.severest = e.Seq, e.Time, e.Time, e.Priority
+	}
+	s.lastSeq = e.Seq
+	if e.Time.Before(s.minTime) {
+		s.minTime = e.Time
+	}
+	if e.Time.After(s.maxTime) {
+		s.maxTime = e.Time
+	}
+	if e.Priority < s.severest {
+		s.severest = e.Priority
+	}
+	s.services[e.Service] = true
+	s.size += size
+	s.count++
+}
+
+// mayMatch 根据索引判断分段中是否可能有满足条件的日志
+func (s *segment) mayMatch(q *Query) bool {
+	if s.count == 0 || s.lastSeq <= q.After {
+		return false
+	}
+	if q.Priority != nil && s.severest > *q.Priority {
+		return false
+	}
+	if !q.Since.IsZero() && s.maxTime.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !s.minTime.Before(q.Until) {
+		return false
+	}
+	if q.Service != "" {
+		for name := range s.services {
+			if ok, _ := path.Match(q.Service, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// store 磁盘存储：目录下按首条序号命名的 JSON 行分段文件，只追加写入最新的分段
+type store struct {
+	dir         string
+	maxSize     int64
+	segmentSize int64
+	segments    []*segment // 按序号升序
+	file        *os.File   // 最新分段
+}
+
+func openStore(dir string, maxSize int64) (*store, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %v", err)
+	}
+	segmentSize := maxSize / maxSegments
+	if segmentSize < minSegmentSize {
+		segmentSize = minSegmentSize
+	}
+	s := &store{dir: dir, maxSize: maxSize, segmentSize: segmentSize}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal directory: %v", err)
+	}
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".jsonl") {
+			names = append(names, f.Name())
+		}
+	}
+	// 文件名是定长十六进制序号，字典序即序号顺序
+	sort.Strings(names)
+	for _, name := range names {
+		seg, err := indexSegment(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+	}
+
+	if n := len(s.segments); n > 0 {
+		f, err := os.OpenFile(s.segments[n-1].path, os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open journal segment: %v", err)
+		}
+		s.file = f
+	}
+	return s, nil
+}
+
+// indexSegment 扫描分段文件建立索引，损坏的行被跳过
+func indexSegment(path string) (*segment, error) {
+	seg := &segment{path: path, services: make(map[string]bool)}
+	err := scanSegment(path, func(e *Entry, size int64) bool {
+		seg.add(e, size)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	seg.size = info.Size()
+	return seg, nil
+}
+
+// lastSeq 返回存储中最大的序号
+func (s *store) lastSeq() uint64 {
+	for i := len(s.segments) - 1; i >= 0; i-- {
+		if s.segments[i].count > 0 {
+			return s.segments[i].lastSeq
+		}
+	}
+	return 0
+}
+
+// append 追加一条日志，当前分段写满时开始新的分段并删除超出总大小的旧分段
+func (s *store) append(e *Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	n := len(s.segments)
+	if s.file == nil || (s.segments[n-1].count > 0 && s.segments[n-1].size+int64(len(line)) > s.segmentSize) {
+		if err := s.rotate(e.Seq); err != nil {
+			return err
+		}
+		n = len(s.segments)
+	}
+	written, err := s.file.Write(line)
+	if err != nil {
+		s.segments[n-1].size += int64(written)
+		return err
+	}
+	s.segments[n-1].add(e, int64(written))
+	return nil
+}
+
+// rotate 关闭当前分段，以 seq 命名创建新的分段，然后按总大小删除最旧的分段
+func (s *store) rotate(seq uint64) error {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("%016x.jsonl", seq))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to create journal segment: %v", err)
+	}
+	s.file = f
+	s.segments = append(s.segments, &segment{path: path, services: make(map[string]bool)})
+
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+	for len(s.segments) > 1 && (total+s.segmentSize > s.maxSize || len(s.segments) > maxSegments) {
+		total -= s.segments[0].size
+		os.Remove(s.segments[0].path)
+		s.segments = s.segments[1:]
+	}
+	return nil
+}
+
+// scan 按序号顺序对满足条件的日志调用 fn，跳过索引表明不可能匹配的分段
+func (s *store) scan(q *Query, fn func(*Entry)) error {
+	for _, seg := range s.segments {
+		if !seg.mayMatch(q) {
+			continue
+		}
+		err := scanSegment(seg.path, func(e *Entry, _ int64) bool {
+			fn(e)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *store) close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// scanSegment 逐行解析分段文件，fn 返回 false 时停止。文件不存在不是错误
+func scanSegment(path string, fn func(e *Entry, size int64) bool) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if !fn(&e, int64(len(scanner.Bytes())+1)) {
+			break
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,312 @@
+// Package logging 是 init 的结构化分级日志：每条日志带级别、消息和键值字段，
+// 同时写入多个命名的输出（控制台、/dev/kmsg、日志文件），每个输出可以有自己的最低级别
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level 日志级别
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel 解析级别名称：debug、info、warn、error
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	if strings.EqualFold(s, "warning") {
+		return LevelWarn, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+func (l Level) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.String())
+}
+
+func (l *Level) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	level, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// Field 日志的一个键值字段
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Entry 一条日志
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  []Field
+}
+
+// Field 返回字段的值，不存在时返回 nil
+func (e *Entry) Field(key string) interface{} {
+	for _, f := range e.Fields {
+		if f.Key == key {
+			return f.Value
+		}
+	}
+	return nil
+}
+
+// MarshalJSON 把日志编码为一个扁平的 JSON 对象，字段保持原有顺序
+func (e *Entry) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	t, _ := json.Marshal(e.Time)
+	buf.Write(t)
+	buf.WriteString(`,"level":"`)
+	buf.WriteString(e.Level.String())
+	buf.WriteString(`","msg":`)
+	m, _ := json.Marshal(e.Message)
+	buf.Write(m)
+	for _, f := range e.Fields {
+		if f.Key == "time" || f.Key == "level" || f.Key == "msg" {
+			continue
+		}
+		k, _ := json.Marshal(f.Key)
+		v, err := json.Marshal(jsonValue(f.Value))
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(f.Value))
+		}
+		buf.WriteByte(',')
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonValue 把 error 等没有导出字段的值转换为字符串
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+// Text 把日志格式化为单行文本：消息后跟 key=value 字段
+func (e *Entry) Text() string {
+	var b strings.Builder
+	b.WriteString(e.Message)
+	for _, f := range e.Fields {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		b.WriteString(quote(fmt.Sprint(jsonValue(f.Value))))
+	}
+	return b.String()
+}
+
+// quote 为包含空白、引号或等号的值加引号
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// Sink 日志输出
+type Sink interface {
+	Write(e *Entry) error
+}
+
+type sinkEntry struct {
+	sink  Sink
+	level Level
+}
+
+var (
+	level int32 = int32(LevelInfo)
+	mu    sync.Mutex
+	sinks = map[string]sinkEntry{
+		"console": {sink: NewTextSink(os.Stderr), level: LevelDebug},
+	}
+	names = []string{"console"}
+)
+
+// SetLevel 设置全局日志级别，低于它的日志不会写入任何输出
+func SetLevel(l Level) {
+	atomic.StoreInt32(&level, int32(l))
+}
+
+// GetLevel 返回全局日志级别
+func GetLevel() Level {
+	return Level(atomic.LoadInt32(&level))
+}
+
+// Enabled 判断该级别的日志是否会被记录
+func Enabled(l Level) bool {
+	return l >= GetLevel()
+}
+
+// SetSink 添加或替换命名的输出，minLevel 是该输出接受的最低级别
+func SetSink(name string, sink Sink, minLevel Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, exists := sinks[name]; !exists {
+		names = append(names, name)
+		sort.Strings(names)
+	}
+	sinks[name] = sinkEntry{sink: sink, level: minLevel}
+}
+
+// RemoveSink 移除命名的输出，返回被移除的输出
+func RemoveSink(name string) Sink {
+	mu.Lock()
+	defer mu.Unlock()
+	entry, exists := sinks[name]
+	if !exists {
+		return nil
+	}
+	delete(sinks, name)
+	for i, n := range names {
+		if n == name {
+			names = append(names[:i], names[i+1:]...)
+			break
+		}
+	}
+	return entry.sink
+}
+
+// Logger 带有固定字段的日志记录器
+type Logger struct {
+	fields []Field
+}
+
+// With 返回附加了字段的日志记录器
+func With(kv ...interface{}) *Logger {
+	return (&Logger{}).With(kv...)
+}
+
+// With 返回附加了字段的日志记录器
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]Field, 0, len(l.fields)+len(kv)/2)
+	fields = append(fields, l.fields...)
+	return &Logger{fields: append(fields, toFields(kv)...)}
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.log(LevelDebug, msg, kv) }
+func (l *Logger) Info(msg string, kv ...interface{})  { l.log(LevelInfo, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.log(LevelWarn, msg, kv) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.log(LevelError, msg, kv) }
+
+var std = &Logger{}
+
+// Debug 记录调试日志，kv 是交替的键和值
+func Debug(msg string, kv ...interface{}) { std.log(LevelDebug, msg, kv) }
+
+// Info 记录一般日志
+func Info(msg string, kv ...interface{}) { std.log(LevelInfo, msg, kv) }
+
+// Warn 记录警告
+func Warn(msg string, kv ...interface{}) { std.log(LevelWarn, msg, kv) }
+
+// Error 记录错误
+func Error(msg string, kv ...interface{}) { std.log(LevelError, msg, kv) }
+
+// Log 以指定级别记录日志
+func Log(l Level, msg string, kv ...interface{}) { std.log(l, msg, kv) }
+
+func (l *Logger) log(lv Level, msg string, kv []interface{}) {
+	if !Enabled(lv) {
+		return
+	}
+	e := &Entry{
+		Time:    time.Now(),
+		Level:   lv,
+		Message: msg,
+		Fields:  append(append([]Field(nil), l.fields...), toFields(kv)...),
+	}
+	Write(e)
+}
+
+// Write 把日志写入所有接受该级别的输出，不检查全局级别。
+// 用于转发已经带有级别的外部日志
+func Write(e *Entry) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, name := range names {
+		s := sinks[name]
+		if e.Level < s.level {
+			continue
+		}
+		if err := s.sink.Write(e); err != nil && name != "console" {
+			fmt.Fprintf(os.Stderr, "logging: %s: %v\n", name, err)
+		}
+	}
+}
+
+// toFields 把交替的键和值转换为字段，落单的值以 "extra" 为键
+func toFields(kv []interface{}) []Field {
+	fields := make([]Field, 0, len(kv)/2+1)
+	for i := 0; i < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok || i+1 >= len(kv) {
+			fields = append(fields, Field{Key: "extra", Value: kv[i]})
+			i--
+			continue
+		}
+		fields = append(fields, Field{Key: key, Value: kv[i+1]})
+	}
+	return fields
+}
+
+// textSink 以单行文本输出，用于控制台
+type textSink struct {
+	w io.Writer
+}
+
+// NewTextSink 创建文本输出：时间、级别、消息和 key=value 字段
+func NewTextSink(w io.Writer) Sink {
+	return &textSink{w: w}
+}
+
+func (s *textSink) Write(e *Entry) error {
+	_, err := fmt.Fprintf(s.w, "%s %-5s %s\n", e.Time.Format("2006/01/02 15:04:05"),
+		strings.ToUpper(e.Level.String()), e.Text())
+	return err
+}
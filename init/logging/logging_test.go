@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+type memorySink struct {
+	entries []*Entry
+}
+
+func (s *memorySink) Write(e *Entry) error {
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	defer SetLevel(GetLevel())
+	console := RemoveSink("console")
+	defer SetSink("console", console, LevelDebug)
+
+	all, warn := &memorySink{}, &memorySink{}
+	SetSink("all", all, LevelDebug)
+	SetSink("warn", warn, LevelWarn)
+	defer RemoveSink("all")
+	defer RemoveSink("warn")
+
+	SetLevel(LevelInfo)
+	Debug("hidden")
+	svc := With("service", "web")
+	svc.Info("Service started", "pid", 42)
+	svc.Warn("Service failed", "error", errors.New("exit status 1"), "seq", uint64(7))
+
+	if len(all.entries) != 2 || len(warn.entries) != 1 {
+		t.Fatalf("Expected 2 and 1 entries, got %d and %d", len(all.entries), len(warn.entries))
+	}
+	if got := all.entries[0].Text(); got != "Service started service=web pid=42" {
+		t.Errorf("Unexpected text: %q", got)
+	}
+	if got := warn.entries[0].Text(); got != `Service failed service=web error="exit status 1" seq=7` {
+		t.Errorf("Unexpected text: %q", got)
+	}
+
+	data, err := json.Marshal(warn.entries[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	json.Unmarshal(data, &decoded)
+	if decoded["level"] != "warn" || decoded["service"] != "web" || decoded["error"] != "exit status 1" || decoded["seq"] != float64(7) {
+		t.Errorf("Unexpected JSON: %s", data)
+	}
+
+	// 标准库 log 的输出按前缀确定级别
+	var buf bytes.Buffer
+	std := log.New(StdWriter(), "", 0)
+	SetSink("all", &memorySink{}, LevelDebug)
+	SetSink("text", NewTextSink(&buf), LevelDebug)
+	defer RemoveSink("text")
+	std.Printf("Warning: link %s down", "eth0")
+	if !strings.Contains(buf.String(), "WARN  link eth0 down") {
+		t.Errorf("Unexpected bridged output: %q", buf.String())
+	}
+
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected unknown level to be rejected")
+	}
+}
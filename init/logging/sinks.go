@@ -0,0 +1,180 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KmsgPath 内核日志缓冲区的写入接口
+const KmsgPath = "/dev/kmsg"
+
+// kmsgMaxRecord /dev/kmsg 单条记录的长度上限（不含优先级前缀）
+const kmsgMaxRecord = 976
+
+// kmsgPriority 日志级别对应的 syslog 优先级
+var kmsgPriority = map[Level]int{
+	LevelDebug: 7,
+	LevelInfo:  6,
+	LevelWarn:  4,
+	LevelError: 3,
+}
+
+// kmsgSink 把日志写入内核日志缓冲区，每条日志一条记录
+type kmsgSink struct {
+	f   *os.File
+	tag string
+}
+
+// OpenKmsg 打开 /dev/kmsg，tag 是每条记录的前缀（例如 ldh-init）
+func OpenKmsg(path, tag string) (Sink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	return &kmsgSink{f: f, tag: tag}, nil
+}
+
+func (s *kmsgSink) Write(e *Entry) error {
+	text := s.tag + ": " + e.Text()
+	if len(text) > kmsgMaxRecord {
+		text = text[:kmsgMaxRecord]
+	}
+	// 多行消息在 kmsg 中只能占一条记录
+	text = strings.ReplaceAll(text, "\n", " ")
+	_, err := fmt.Fprintf(s.f, "<%d>%s\n", kmsgPriority[e.Level], text)
+	return err
+}
+
+// DefaultFileSize 日志文件的默认大小上限，超过后轮转为 .1
+const DefaultFileSize = 4 << 20
+
+// FileSink 以 JSON 行写入文件，超过大小上限时轮转，只保留一个旧文件
+type FileSink struct {
+	path    string
+	maxSize int64
+	f       *os.File
+	w       *bufio.Writer
+	size    int64
+	mu      sync.Mutex
+}
+
+// OpenFile 打开（必要时创建）日志文件
+func OpenFile(path string, maxSize int64) (*FileSink, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultFileSize
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %v", err)
+	}
+	s := &FileSink{path: path, maxSize: maxSize}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.w, s.size = f, bufio.NewWriter(f), info.Size()
+	return nil
+}
+
+func (s *FileSink) Write(e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return fmt.Errorf("log file closed")
+	}
+	if s.size+int64(len(data))+1 > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	s.w.Write(data)
+	s.w.WriteByte('\n')
+	s.size += int64(len(data)) + 1
+	// 警告和错误立即落盘，其余日志随缓冲区写出
+	if e.Level >= LevelWarn || s.w.Buffered() > 32<<10 {
+		return s.w.Flush()
+	}
+	return nil
+}
+
+// rotate 把当前文件改名为 .1 并重新打开，调用方需持有 mu
+func (s *FileSink) rotate() error {
+	s.w.Flush()
+	s.f.Close()
+	s.f = nil
+	if err := os.Rename(s.path, s.path+".1"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate log file: %v", err)
+	}
+	return s.open()
+}
+
+// Flush 把缓冲的日志写入文件
+func (s *FileSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	return s.w.Flush()
+}
+
+// Close 写出缓冲的日志并关闭文件
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	s.w.Flush()
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// stdWriter 把标准库 log 的输出转换为日志，供尚未迁移的模块使用
+type stdWriter struct{}
+
+// StdWriter 返回用于 log.SetOutput 的 Writer（配合 log.SetFlags(0)）。
+// 以 "Warning: " 或 "Error: " 开头的行分别记为警告和错误，其余为一般日志
+func StdWriter() io.Writer {
+	return stdWriter{}
+}
+
+func (stdWriter) Write(p []byte) (int, error) {
+	msg := string(bytes.TrimRight(p, "\n"))
+	lv := LevelInfo
+	switch {
+	case strings.HasPrefix(msg, "Warning: "):
+		lv, msg = LevelWarn, strings.TrimPrefix(msg, "Warning: ")
+	case strings.HasPrefix(msg, "Error: "):
+		lv, msg = LevelError, strings.TrimPrefix(msg, "Error: ")
+	}
+	if Enabled(lv) {
+		Write(&Entry{Time: time.Now(), Level: lv, Message: msg})
+	}
+	return len(p), nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+
+	"ldh-os/init/logging"
+)
+
+// defaultInitLog init 日志文件的默认路径
+const defaultInitLog = "/var/log/ldh-os/init.log"
+
+// kmsgTag init 写入内核日志的记录前缀
+const kmsgTag = "ldh-init"
+
+// openKmsg 以 PID 1 运行时把日志写入内核日志缓冲区。
+// 控制台在没有 /dev/console 时不可见，kmsg 可用后不再单独写标准错误，
+// 内核会按 printk 级别把日志显示到控制台
+func (i *InitSystem) openKmsg() {
+	if !i.pid1 || i.kmsg {
+		return
+	}
+	sink, err := logging.OpenKmsg(logging.KmsgPath, kmsgTag)
+	if err != nil {
+		return
+	}
+	logging.SetSink("kmsg", sink, logging.LevelDebug)
+	logging.RemoveSink("console")
+	i.kmsg = true
+}
+
+// openInitLog 打开 init 日志文件，路径由 LDH_INIT_LOG 指定，off 表示不写文件。
+// 文件可用后 kmsg 只保留警告和错误，避免占满内核日志缓冲区
+func (i *InitSystem) openInitLog() {
+	path := defaultInitLog
+	if os.Getenv("LDH_INIT_LOG") != "" {
+		path = os.Getenv("LDH_INIT_LOG")
+	}
+	if path == "off" || i.logFile != nil {
+		return
+	}
+
+	sink, err := logging.OpenFile(path, logging.DefaultFileSize)
+	if err != nil {
+		logging.Warn("Init log file disabled", "error", err)
+		return
+	}
+	logging.SetSink("file", sink, logging.LevelDebug)
+	i.logFile = sink
+	if i.kmsg {
+		if kmsg := logging.RemoveSink("kmsg"); kmsg != nil {
+			logging.SetSink("kmsg", kmsg, logging.LevelWarn)
+		}
+	}
+}
+
+// flushInitLog 把缓冲的日志写入文件，在 re-exec 和卸载文件系统之前调用
+func (i *InitSystem) flushInitLog() {
+	if i.logFile != nil {
+		i.logFile.Flush()
+	}
+}
+
+// closeInitLog 关闭日志文件，之后的日志只写入其余输出
+func (i *InitSystem) closeInitLog() {
+	if i.logFile == nil {
+		return
+	}
+	logging.RemoveSink("file")
+	i.logFile.Close()
+	i.logFile = nil
+}
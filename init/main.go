@@ -1,90 +1,218 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"ldh-os/init/cmdline"
+	"ldh-os/init/control"
+	"ldh-os/init/device"
+	"ldh-os/init/journal"
+	"ldh-os/init/logging"
+	"ldh-os/init/mount"
+	"ldh-os/init/network"
 	"ldh-os/init/service"
 
 	"golang.org/x/sys/unix"
 )
 
 type InitSystem struct {
-	state          string
-	serviceManager *service.ServiceManager
-	signals        chan os.Signal
+	state           string
+	serviceManager  *service.ServiceManager
+	signals         chan os.Signal
+	files           map[string]*os.File // 需要跨 re-exec 保留的文件
+	pid1            bool
+	reexeced        bool // 由上一个 init 进程 re-exec 而来
+	mounter         mount.Mounter
+	devices         *device.Manager
+	network         *network.Manager
+	rebootHook      func(cmd int) error // 关机流程的最后一步
+	control         *control.Server
+	actions         chan func() // 由控制套接字提交、在主循环中执行的操作
+	options         *cmdline.Options
+	resume          chan struct{}     // 紧急模式下非空，关闭后继续启动
+	kmsg            bool              // 日志写入 /dev/kmsg
+	logFile         *logging.FileSink // init 日志文件，未打开时为 nil
+	journal         *journal.Journal
+	outputs         map[string]*journal.Pipe // 服务输出管道，受 mu 保护
+	watchdog        watchdogDevice           // 硬件看门狗，未启用时为 nil，受 mu 保护
+	watchdogTimeout time.Duration            // 看门狗当前生效的超时
+	watchdogTicker  *time.Ticker
+	watchdogTick    <-chan time.Time // 主循环喂狗的时钟，未启用时为 nil
+	mu              sync.Mutex
 }
 
 func NewInitSystem() *InitSystem {
-	return &InitSystem{
+	i := &InitSystem{
 		state:          "booting",
 		serviceManager: service.NewServiceManager(),
 		signals:        make(chan os.Signal, 1),
+		files:          make(map[string]*os.File),
+		outputs:        make(map[string]*journal.Pipe),
+		actions:        make(chan func(), 1),
+		options:        &cmdline.Options{},
+		pid1:           os.Getpid() == 1,
+		mounter:        mount.SystemMounter{},
+		rebootHook:     systemReboot,
 	}
+	// 非 PID 1 的测试模式下不真正重启机器
+	if !i.pid1 {
+		i.rebootHook = testModeReboot
+	}
+	return i
 }
 
 func (i *InitSystem) mountEssentialFS() error {
-	log.Println("Mounting essential filesystems...")
-
-	// 挂载 proc 文件系统
-	if err := unix.Mount("proc", "/proc", "proc", 0, ""); err != nil {
-		log.Printf("Warning: Failed to mount proc: %v", err)
+	if !i.pid1 {
+		logging.Info("Not running as PID 1, skipping filesystem setup")
+		return nil
 	}
 
-	// 挂载 sysfs
-	if err := unix.Mount("sysfs", "/sys", "sysfs", 0, ""); err != nil {
-		log.Printf("Warning: Failed to mount sysfs: %v", err)
+	logging.Info("Mounting essential filesystems")
+
+	// 读取挂载配置（YAML 版 fstab），不存在时只挂载基础文件系统
+	configPath := "/etc/ldh-os/mounts.yaml"
+	if os.Getenv("LDH_MOUNTS_CONFIG") != "" {
+		configPath = os.Getenv("LDH_MOUNTS_CONFIG")
 	}
+	config, err := mount.LoadConfig(configPath)
+	if err != nil {
+		logging.Warn("Using default mount config", "error", err)
+		config = &mount.Config{Fstab: "/etc/fstab"}
+	}
+
+	existing, _ := mount.ReadMountInfo()
+	results := mount.MountAll(i.mounter, mount.EssentialEntries(config), mount.Options{Existing: existing})
 
-	// 挂载 devtmpfs
-	if err := unix.Mount("devtmpfs", "/dev", "devtmpfs", unix.MS_NOSUID, "mode=755"); err != nil {
-		log.Printf("Warning: Failed to mount devtmpfs: %v", err)
+	// 挂载配置文件和 fstab 中声明的其他文件系统
+	entries := config.Mounts
+	if config.Fstab != "" {
+		fstab, err := mount.ReadFstab(config.Fstab)
+		if err != nil {
+			logging.Warn("Failed to read fstab", "path", config.Fstab, "error", err)
+		}
+		entries = append(entries, fstab...)
 	}
+	existing, _ = mount.ReadMountInfo()
+	results = append(results, mount.MountAll(i.mounter, entries, mount.Options{
+		Existing: existing,
+		Fsck:     mount.DefaultFsck,
+	})...)
 
+	logging.Info("Mount results:\n" + mount.FormatResults(results))
+
+	if failed := mount.Failed(results); len(failed) > 0 {
+		targets := make([]string, 0, len(failed))
+		for _, r := range failed {
+			targets = append(targets, r.Entry.Target)
+		}
+		return fmt.Errorf("required filesystems failed to mount: %s", strings.Join(targets, ", "))
+	}
 	return nil
 }
 
 func (i *InitSystem) initializeDevices() error {
-	log.Println("Initializing devices...")
-	return nil
+	if !i.pid1 {
+		logging.Info("Not running as PID 1, skipping device management")
+		return nil
+	}
+
+	logging.Info("Initializing devices")
+
+	configPath := "/etc/ldh-os/devices.yaml"
+	if os.Getenv("LDH_DEVICES_CONFIG") != "" {
+		configPath = os.Getenv("LDH_DEVICES_CONFIG")
+	}
+	config, err := device.LoadConfig(configPath)
+	if err != nil {
+		logging.Warn("Using default device config", "error", err)
+		config = device.DefaultConfig()
+	}
+
+	// re-exec 后设备早已就绪，只需要重新监听 uevent
+	if i.reexeced {
+		config.Coldplug = false
+	}
+
+	// 设备事件发布到服务管理器的事件总线，设备触发的服务由服务管理器启动
+	i.devices = device.NewManager(config, i.serviceManager.EventBus())
+	return i.devices.Start()
+}
+
+func (i *InitSystem) setupNetwork() error {
+	if !i.pid1 {
+		logging.Info("Not running as PID 1, skipping network setup")
+		return nil
+	}
+
+	logging.Info("Configuring network")
+
+	configPath := "/etc/ldh-os/network.yaml"
+	if os.Getenv("LDH_NETWORK_CONFIG") != "" {
+		configPath = os.Getenv("LDH_NETWORK_CONFIG")
+	}
+	config, err := network.LoadConfig(configPath)
+	if err != nil {
+		logging.Warn("Using default network config, only bringing up lo", "error", err)
+		config = network.DefaultConfig()
+	}
+
+	// DHCP 客户端作为托管服务注册到服务管理器，随 StartAll 启动
+	i.network = network.NewManager(config, i.serviceManager)
+	return i.network.Start()
 }
 
 func (i *InitSystem) handleSignals() {
-	for sig := range i.signals {
-		switch sig {
-		case syscall.SIGTERM:
-			log.Println("Received SIGTERM, initiating shutdown...")
-			i.shutdown()
-		case syscall.SIGINT:
-			log.Println("Received SIGINT, initiating shutdown...")
-			i.shutdown()
-		default:
-			log.Printf("Received signal: %v", sig)
+	for {
+		select {
+		case sig := <-i.signals:
+			i.handleSignal(sig)
+		case action := <-i.actions:
+			action()
+		case <-i.watchdogTick:
+			i.petWatchdog()
 		}
 	}
 }
 
-func (i *InitSystem) shutdown() {
-	log.Println("Shutting down all services...")
-	if err := i.serviceManager.StopAll(); err != nil {
-		log.Printf("Error stopping services: %v", err)
+func (i *InitSystem) handleSignal(sig os.Signal) {
+	switch sig {
+	case syscall.SIGTERM:
+		logging.Info("Received SIGTERM, initiating shutdown")
+		i.shutdown(actionPoweroff)
+	case syscall.SIGPWR:
+		logging.Info("Received SIGPWR, initiating shutdown")
+		i.shutdown(actionPoweroff)
+	case syscall.SIGINT:
+		// 禁用 Ctrl-Alt-Del 直接重启后，内核会向 init 发送 SIGINT
+		logging.Info("Received SIGINT, initiating reboot")
+		i.shutdown(actionReboot)
+	case syscall.SIGUSR2:
+		logging.Info("Received SIGUSR2, initiating halt")
+		i.shutdown(actionHalt)
+	case syscall.SIGUSR1:
+		logging.Info("Received SIGUSR1, re-executing init")
+		if err := i.reexec(); err != nil {
+			logging.Error("Re-exec failed, continuing with current init", "error", err)
+		}
+	default:
+		logging.Debug("Received signal", "signal", sig)
 	}
-
-	log.Println("Unmounting filesystems...")
-	// 按照相反的顺序卸载文件系统
-	unix.Unmount("/dev", 0)
-	unix.Unmount("/sys", 0)
-	unix.Unmount("/proc", 0)
-
-	os.Exit(0)
 }
 
 func (i *InitSystem) loadServices() error {
 	// 获取配置文件路径
 	configPath := "/etc/ldh-os/services.yaml"
+	if i.options.Config != "" {
+		configPath = i.options.Config
+	}
 	if os.Getenv("LDH_SERVICES_CONFIG") != "" {
 		configPath = os.Getenv("LDH_SERVICES_CONFIG")
 	}
@@ -107,27 +235,69 @@ func (i *InitSystem) loadServices() error {
 		return err
 	}
 
+	// 目标配置不存在时使用内置目标
+	targetsPath := "/etc/ldh-os/targets.yaml"
+	if os.Getenv("LDH_TARGETS_CONFIG") != "" {
+		targetsPath = os.Getenv("LDH_TARGETS_CONFIG")
+	}
+	targets, err := service.LoadTargets(targetsPath)
+	if err != nil {
+		logging.Warn("Using built-in targets", "error", err)
+		targets = service.DefaultTargets()
+	}
+	i.serviceManager.SetTargets(targets)
+
+	// 恢复持久化的服务状态，接管仍在运行的服务进程
+	statePath := "/var/lib/ldh-os/services.state"
+	if os.Getenv("LDH_STATE_FILE") != "" {
+		statePath = os.Getenv("LDH_STATE_FILE")
+	}
+	if err := i.serviceManager.EnablePersistence(statePath); err != nil {
+		logging.Warn("Failed to restore service state", "error", err)
+	}
+
 	return nil
 }
 
+// startPressureMonitor 按 /etc/ldh-os/pressure.yaml 的阈值监视系统资源压力，配置无效时使用内置阈值
+func (i *InitSystem) startPressureMonitor() {
+	path := "/etc/ldh-os/pressure.yaml"
+	if os.Getenv("LDH_PRESSURE_CONFIG") != "" {
+		path = os.Getenv("LDH_PRESSURE_CONFIG")
+	}
+	config, err := service.LoadPressureConfig(path)
+	if err != nil {
+		logging.Warn("Using built-in pressure thresholds", "error", err)
+		config = service.DefaultPressureConfig()
+	}
+	i.serviceManager.StartPressureMonitor(config)
+}
+
+// bootTarget 返回启动时要进入的目标：re-exec 前的目标、内核命令行的 ldh.target，
+// 或配置的默认目标
+func (i *InitSystem) bootTarget(state *reexecState) string {
+	if state != nil && state.Target != "" {
+		return state.Target
+	}
+	if target := i.options.Target; target != "" {
+		for _, t := range i.serviceManager.Targets() {
+			if t.Name == target {
+				return target
+			}
+		}
+		logging.Warn("Unknown target on kernel command line", "target", target)
+	}
+	return i.serviceManager.DefaultTarget()
+}
+
 func (i *InitSystem) createDefaultConfig(configPath string) error {
 	defaultConfig := `
 # LDH-OS 默认服务配置
-syslog:
-  description: "System logging service"
-  type: "daemon"
-  exec: "/usr/sbin/syslogd"
-  restart: "always"
-  mcp:
-    functions: ["start", "stop", "restart", "status"]
-    permissions: ["read", "write"]
-
 cron:
   description: "Cron daemon"
   type: "daemon"
   exec: "/usr/sbin/crond"
   args: ["-n"]
-  dependencies: ["syslog"]
   restart: "always"
   mcp:
     functions: ["start", "stop", "restart", "status"]
@@ -137,39 +307,150 @@ cron:
 }
 
 func main() {
+	// 由套接字激活的服务进程，不作为 init 运行
+	service.RunActivationTrampoline()
+
+	// 其他包仍通过标准库 log 输出，统一转入结构化日志
+	log.SetFlags(0)
+	log.SetOutput(logging.StdWriter())
+
 	if os.Getpid() != 1 {
-		log.Printf("Warning: Not running as PID 1 (current PID: %d)", os.Getpid())
+		logging.Warn("Not running as PID 1", "pid", os.Getpid())
 	}
 
-	log.Println("LDH-OS Init starting...")
+	logging.Info("LDH-OS Init starting")
 
 	init := NewInitSystem()
+	init.startJournal()
+	init.setupConsole()
+	init.openKmsg()
 
 	// 设置信号处理
 	signal.Notify(init.signals,
 		syscall.SIGTERM,
 		syscall.SIGINT,
 		syscall.SIGHUP,
-		syscall.SIGQUIT)
+		syscall.SIGQUIT,
+		syscall.SIGUSR1,
+		syscall.SIGUSR2,
+		syscall.SIGPWR)
+
+	// 由 init 处理 Ctrl-Alt-Del（内核改为发送 SIGINT）
+	if init.pid1 {
+		if err := unix.Reboot(unix.LINUX_REBOOT_CMD_CAD_OFF); err != nil {
+			logging.Warn("Failed to disable Ctrl-Alt-Del", "error", err)
+		}
+	}
+
+	// 非 PID 1 运行时成为子进程收割者，孤儿进程由本进程回收
+	if !init.pid1 {
+		if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+			logging.Warn("Failed to become child subreaper", "error", err)
+		}
+	}
+
+	// 由上一个 init 进程 re-exec 而来时，文件系统已经就绪
+	reexecState, err := loadReexecState()
+	if err != nil {
+		logging.Warn("Failed to load re-exec state", "error", err)
+	}
+
+	init.reexeced = reexecState != nil
+	if init.reexeced {
+		init.inheritFiles(reexecState)
+		init.serviceManager.EventBus().ResumeSeq(reexecState.EventSeq)
+	}
 
-	if err := init.mountEssentialFS(); err != nil {
-		log.Fatal("Failed to mount filesystems:", err)
+	// 内核命令行选项影响之后的每个启动阶段
+	init.loadOptions()
+
+	// 被过继给 init 的孤儿进程由 init 回收
+	go reapOrphans()
+
+	if !init.reexeced {
+		init.breakpoint(cmdline.StageMount)
+		if err := init.mountEssentialFS(); err != nil {
+			init.emergency("Failed to mount filesystems", err)
+		}
+		// devtmpfs 挂载之后 /dev/console 和 /dev/kmsg 一定存在
+		init.setupConsole()
+		init.openKmsg()
 	}
 
+	// 日志文件位于刚挂载的文件系统上
+	init.openInitLog()
+
+	// 事件日志和 journal 存储可能位于刚挂载的文件系统上
+	init.openEventJournal()
+	init.openJournal()
+
+	init.breakpoint(cmdline.StageDevices)
 	if err := init.initializeDevices(); err != nil {
-		log.Fatal("Failed to initialize devices:", err)
+		init.emergency("Failed to initialize devices", err)
+	}
+
+	init.breakpoint(cmdline.StageNetwork)
+	// 网络配置是幂等的，re-exec 后重新运行以恢复链路监控和 DHCP 服务
+	if err := init.setupNetwork(); err != nil {
+		logging.Warn("Failed to set up network", "error", err)
 	}
 
 	// 加载并启动服务
+	init.breakpoint(cmdline.StageServices)
 	if err := init.loadServices(); err != nil {
-		log.Printf("Warning: Failed to load services: %v", err)
-	} else {
-		if err := init.serviceManager.StartAll(); err != nil {
-			log.Printf("Warning: Failed to start all services: %v", err)
+		init.emergency("Failed to load services", err)
+	} else if reexecState != nil {
+		init.restoreReexecState(reexecState)
+	}
+	// 套接字激活的服务在启动目标时跳过，改为等待连接
+	init.activateSockets()
+
+	// 控制套接字在启动服务之前就绪，外部消费者可以观察完整的启动过程
+	if err := init.startControl(); err != nil {
+		logging.Warn("Failed to start control socket", "error", err)
+	}
+	// 通知套接字在启动服务之前就绪，服务进程从环境变量得到它的地址
+	notifyPath := "/run/ldh-os/notify"
+	if os.Getenv("LDH_NOTIFY_SOCKET") != "" {
+		notifyPath = os.Getenv("LDH_NOTIFY_SOCKET")
+	}
+	if err := init.serviceManager.StartNotify(notifyPath); err != nil {
+		logging.Warn("Service watchdogs disabled", "error", err)
+	}
+	if addr := init.options.MetricsListen; addr != "" {
+		if err := init.startExporter(addr); err != nil {
+			logging.Warn("Metrics exporter disabled", "error", err)
 		}
 	}
+	target := init.bootTarget(reexecState)
+	if job, err := init.serviceManager.StartTarget(target, service.JobPartial); err != nil {
+		init.emergency("Failed to reach target "+target, err)
+	} else if job.State == service.JobDegraded {
+		logging.Warn("Target degraded", "target", target, "error", job.Error)
+	}
+	init.serviceManager.StartTimers()
+	if err := init.serviceManager.StartPathTriggers(); err != nil {
+		logging.Warn("Path triggers disabled", "error", err)
+	}
+	if !init.options.NoMetrics {
+		init.serviceManager.StartMetrics(init.options.MetricsInterval)
+	}
+	init.startPressureMonitor()
 
-	log.Println("Init system ready")
+	logging.Info("Init system ready", "target", target)
+
+	// 看门狗在进入主循环时启用，由主循环喂狗
+	if timeout := init.options.Watchdog; timeout > 0 {
+		path := defaultWatchdogDevice
+		if init.options.WatchdogDevice != "" {
+			path = init.options.WatchdogDevice
+		}
+		if err := init.startWatchdog(path, timeout); err != nil {
+			logging.Warn("Hardware watchdog disabled", "error", err)
+		}
+	} else {
+		init.releaseWatchdog()
+	}
 
 	// 处理系统信号
 	init.handleSignals()
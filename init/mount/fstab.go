@@ -0,0 +1,114 @@
+package mount
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Entry 描述一个需要挂载的文件系统
+type Entry struct {
+	Source  string   `yaml:"source"` // 设备路径或 UUID=/LABEL=/PARTUUID=/PARTLABEL=
+	Target  string   `yaml:"target"`
+	FSType  string   `yaml:"type"`
+	Options []string `yaml:"options,omitempty"`
+	Fsck    bool     `yaml:"fsck,omitempty"`   // 挂载前检查文件系统
+	NoFail  bool     `yaml:"nofail,omitempty"` // 挂载失败不影响启动
+	Mode    uint32   `yaml:"mode,omitempty"`   // 挂载点不存在时创建的目录权限
+}
+
+// Config ldh-os 的挂载配置文件（YAML 版的 fstab）
+type Config struct {
+	DebugFS   bool    `yaml:"debugfs"`
+	TraceFS   bool    `yaml:"tracefs"`
+	HugetlbFS bool    `yaml:"hugetlbfs"` // 为 LLM 推理的大页内存准备
+	Fstab     string  `yaml:"fstab"`     // 额外读取的 fstab 文件，默认 /etc/fstab
+	Mounts    []Entry `yaml:"mounts"`
+}
+
+// LoadConfig 读取 YAML 挂载配置，文件不存在时返回默认配置
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{Fstab: "/etc/fstab"}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mount config: %v", err)
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse mount config: %v", err)
+	}
+	for i, e := range config.Mounts {
+		if e.Target == "" {
+			return nil, fmt.Errorf("mount entry %d has no target", i)
+		}
+		if hasOption(e.Options, "nofail") {
+			config.Mounts[i].NoFail = true
+		}
+	}
+	return config, nil
+}
+
+// ReadFstab 读取 fstab 文件，文件不存在时返回空列表
+func ReadFstab(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseFstab(f)
+}
+
+// ParseFstab 解析 fstab(5) 格式。swap 和 noauto 条目会被跳过
+func ParseFstab(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("fstab line %d: expected at least 3 fields", lineNo)
+		}
+
+		entry := Entry{
+			Source:  unescape(fields[0]),
+			Target:  unescape(fields[1]),
+			FSType:  fields[2],
+			Options: []string{"defaults"},
+		}
+		if len(fields) > 3 {
+			entry.Options = strings.Split(fields[3], ",")
+		}
+		if len(fields) > 5 {
+			passno, err := strconv.Atoi(fields[5])
+			if err != nil {
+				return nil, fmt.Errorf("fstab line %d: invalid pass number %q", lineNo, fields[5])
+			}
+			entry.Fsck = passno > 0
+		}
+		entry.NoFail = hasOption(entry.Options, "nofail")
+
+		if entry.FSType == "swap" || entry.Target == "none" || hasOption(entry.Options, "noauto") {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
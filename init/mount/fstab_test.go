@@ -0,0 +1,103 @@
+package mount
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseFstab(t *testing.T) {
+	fstab := `
+# <file system> <mount point> <type> <options> <dump> <pass>
+UUID=1234-abcd  /          ext4   defaults,noatime  0 1
+LABEL=models    /opt/my\040models ext4 ro,nofail    0 2
+/dev/sdb2       none       swap   sw                0 0
+tmpfs           /var/tmp   tmpfs  size=64m,mode=1777 0 0
+/dev/sdc1       /mnt/usb   vfat   noauto            0 0
+`
+	entries, err := ParseFstab(strings.NewReader(fstab))
+	if err != nil {
+		t.Fatalf("Failed to parse fstab: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d: %+v", len(entries), entries)
+	}
+
+	if !entries[0].Fsck || entries[0].NoFail {
+		t.Errorf("Unexpected flags for root entry: %+v", entries[0])
+	}
+	if entries[1].Target != "/opt/my models" || !entries[1].NoFail {
+		t.Errorf("Unexpected models entry: %+v", entries[1])
+	}
+
+	flags, data := ParseOptions(entries[2].Options)
+	if flags != 0 || data != "size=64m,mode=1777" {
+		t.Errorf("Unexpected options: flags=%#x data=%q", flags, data)
+	}
+	flags, data = ParseOptions(entries[1].Options)
+	if flags != unix.MS_RDONLY || data != "" {
+		t.Errorf("Unexpected options: flags=%#x data=%q", flags, data)
+	}
+}
+
+func TestMountAll(t *testing.T) {
+	root := t.TempDir()
+	entries := []Entry{
+		{Source: "LABEL=models", Target: filepath.Join(root, "models", "cache"), FSType: "ext4", Fsck: true, NoFail: true},
+		{Source: "LABEL=models", Target: filepath.Join(root, "models"), FSType: "ext4", Fsck: true},
+		{Source: "LABEL=missing", Target: filepath.Join(root, "data"), FSType: "ext4", NoFail: true},
+		{Source: "tmpfs", Target: filepath.Join(root, "run"), FSType: "tmpfs"},
+	}
+
+	m := &fakeMounter{}
+	var checked []string
+	results := MountAll(m, entries, Options{
+		Existing: []MountInfo{{MountPoint: filepath.Join(root, "run")}},
+		Fsck: func(device, fstype string) error {
+			checked = append(checked, device)
+			if len(checked) > 1 {
+				return errors.New("filesystem has errors")
+			}
+			return nil
+		},
+		Resolve: func(source string) (string, error) {
+			if source == "LABEL=models" {
+				return "/dev/vdb1", nil
+			}
+			if strings.Contains(source, "=") {
+				return "", errors.New("device not found")
+			}
+			return source, nil
+		},
+	})
+
+	statuses := make(map[string]Status)
+	for _, r := range results {
+		statuses[filepath.Base(r.Entry.Target)] = r.Status
+	}
+	expected := map[string]Status{
+		"models": StatusMounted,
+		"cache":  StatusFailed, // 第二次 fsck 失败
+		"data":   StatusFailed,
+		"run":    StatusPresent,
+	}
+	for name, status := range expected {
+		if statuses[name] != status {
+			t.Errorf("Expected %s to be %s, got %s", name, status, statuses[name])
+		}
+	}
+
+	// 父目录先于子目录挂载
+	if len(m.calls) != 1 || m.calls[0] != "mount "+filepath.Join(root, "models") {
+		t.Errorf("Unexpected mount calls: %v", m.calls)
+	}
+	if failed := Failed(results); len(failed) != 0 {
+		t.Errorf("Expected no fatal failures, got %d", len(failed))
+	}
+	if table := FormatResults(results); !strings.Contains(table, "(nofail)") {
+		t.Errorf("Expected nofail marker in result table:\n%s", table)
+	}
+}
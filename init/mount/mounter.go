@@ -0,0 +1,24 @@
+package mount
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// Mounter 抽象挂载相关的系统调用，测试中可以替换为假实现
+type Mounter interface {
+	Mount(source, target, fstype string, flags uintptr, data string) error
+	Unmount(target string, flags int) error
+}
+
+// SystemMounter 直接调用内核的挂载接口
+type SystemMounter struct{}
+
+// Mount 挂载文件系统
+func (SystemMounter) Mount(source, target, fstype string, flags uintptr, data string) error {
+	return unix.Mount(source, target, fstype, flags, data)
+}
+
+// Unmount 卸载文件系统
+func (SystemMounter) Unmount(target string, flags int) error {
+	return unix.Unmount(target, flags)
+}
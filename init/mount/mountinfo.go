@@ -0,0 +1,124 @@
+package mount
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MountInfo 对应 /proc/self/mountinfo 中的一行
+type MountInfo struct {
+	ID         int
+	ParentID   int
+	MountPoint string
+	Options    string
+	FSType     string
+	Source     string
+}
+
+// ReadMountInfo 读取当前进程的挂载表
+func ReadMountInfo() ([]MountInfo, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseMountInfo(f)
+}
+
+// ParseMountInfo 解析 mountinfo 格式的挂载表，顺序与内核输出一致（先挂载的在前）
+func ParseMountInfo(r io.Reader) ([]MountInfo, error) {
+	var mounts []MountInfo
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		// 格式: id parent major:minor root mountpoint options [optional...] - fstype source superopts
+		fields := strings.Fields(line)
+		sep := -1
+		for i, f := range fields {
+			if f == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 6 || sep < 6 || len(fields) < sep+3 {
+			return nil, fmt.Errorf("malformed mountinfo line: %q", line)
+		}
+
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("malformed mount id in %q", line)
+		}
+		parent, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("malformed parent id in %q", line)
+		}
+
+		mounts = append(mounts, MountInfo{
+			ID:         id,
+			ParentID:   parent,
+			MountPoint: unescape(fields[4]),
+			Options:    fields[5],
+			FSType:     fields[sep+1],
+			Source:     unescape(fields[sep+2]),
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+// SortDeepestFirst 按卸载顺序排序：路径越深越靠前，同一挂载点上后挂载的先卸载
+func SortDeepestFirst(mounts []MountInfo) []MountInfo {
+	sorted := make([]MountInfo, len(mounts))
+	copy(sorted, mounts)
+
+	order := make(map[int]int, len(mounts))
+	for i, m := range mounts {
+		order[m.ID] = i
+	}
+
+	sort.SliceStable(sorted, func(a, b int) bool {
+		da, db := depth(sorted[a].MountPoint), depth(sorted[b].MountPoint)
+		if da != db {
+			return da > db
+		}
+		return order[sorted[a].ID] > order[sorted[b].ID]
+	})
+	return sorted
+}
+
+// depth 返回挂载点路径的层级，"/" 为 0
+func depth(path string) int {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return 0
+	}
+	return strings.Count(path, "/") + 1
+}
+
+// unescape 还原 mountinfo 中以八进制转义的空格、制表符、换行和反斜杠
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
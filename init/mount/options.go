@@ -0,0 +1,81 @@
+package mount
+
+import (
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// optionFlags 挂载选项到 MS_* 标志的映射，clear 为 true 表示清除该标志
+var optionFlags = map[string]struct {
+	clear bool
+	flag  uintptr
+}{
+	"ro":          {false, unix.MS_RDONLY},
+	"rw":          {true, unix.MS_RDONLY},
+	"nosuid":      {false, unix.MS_NOSUID},
+	"suid":        {true, unix.MS_NOSUID},
+	"nodev":       {false, unix.MS_NODEV},
+	"dev":         {true, unix.MS_NODEV},
+	"noexec":      {false, unix.MS_NOEXEC},
+	"exec":        {true, unix.MS_NOEXEC},
+	"sync":        {false, unix.MS_SYNCHRONOUS},
+	"async":       {true, unix.MS_SYNCHRONOUS},
+	"dirsync":     {false, unix.MS_DIRSYNC},
+	"noatime":     {false, unix.MS_NOATIME},
+	"atime":       {true, unix.MS_NOATIME},
+	"nodiratime":  {false, unix.MS_NODIRATIME},
+	"diratime":    {true, unix.MS_NODIRATIME},
+	"relatime":    {false, unix.MS_RELATIME},
+	"norelatime":  {true, unix.MS_RELATIME},
+	"strictatime": {false, unix.MS_STRICTATIME},
+	"lazytime":    {false, unix.MS_LAZYTIME},
+	"bind":        {false, unix.MS_BIND},
+	"rbind":       {false, unix.MS_BIND | unix.MS_REC},
+	"silent":      {false, unix.MS_SILENT},
+}
+
+// ignoredOptions 只对用户空间有意义、不传给内核的选项
+var ignoredOptions = map[string]bool{
+	"defaults": true,
+	"auto":     true,
+	"noauto":   true,
+	"user":     true,
+	"nouser":   true,
+	"users":    true,
+	"owner":    true,
+	"group":    true,
+	"nofail":   true,
+	"_netdev":  true,
+}
+
+// ParseOptions 将 fstab 风格的选项列表拆分为挂载标志和传给文件系统的数据字符串
+func ParseOptions(options []string) (flags uintptr, data string) {
+	var extra []string
+	for _, opt := range options {
+		opt = strings.TrimSpace(opt)
+		if opt == "" || ignoredOptions[opt] || strings.HasPrefix(opt, "x-") {
+			continue
+		}
+		if f, ok := optionFlags[opt]; ok {
+			if f.clear {
+				flags &^= f.flag
+			} else {
+				flags |= f.flag
+			}
+			continue
+		}
+		extra = append(extra, opt)
+	}
+	return flags, strings.Join(extra, ",")
+}
+
+// hasOption 判断选项列表中是否包含指定选项
+func hasOption(options []string, name string) bool {
+	for _, opt := range options {
+		if strings.TrimSpace(opt) == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,265 @@
+package mount
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Status 单个挂载操作的结果
+type Status string
+
+const (
+	StatusMounted Status = "mounted"
+	StatusPresent Status = "present" // 已经挂载（例如 re-exec 或内核自动挂载）
+	StatusSkipped Status = "skipped"
+	StatusFailed  Status = "failed"
+)
+
+// Result 记录一个挂载条目的处理结果
+type Result struct {
+	Entry    Entry
+	Device   string // 解析 UUID/LABEL 后的实际设备
+	Status   Status
+	Err      error
+	Duration time.Duration
+}
+
+// FsckFunc 在挂载前检查文件系统，返回错误表示文件系统不可用
+type FsckFunc func(device, fstype string) error
+
+// Options 控制 MountAll 的行为
+type Options struct {
+	Existing []MountInfo                         // 当前挂载表，用于跳过已挂载的目标
+	Fsck     FsckFunc                            // 为 nil 时不做检查
+	Resolve  func(source string) (string, error) // 为 nil 时使用 ResolveSource
+}
+
+// EssentialEntries 返回早期启动必需的文件系统，顺序即挂载顺序
+func EssentialEntries(config *Config) []Entry {
+	entries := []Entry{
+		{Source: "proc", Target: "/proc", FSType: "proc", Options: []string{"nosuid", "nodev", "noexec"}},
+		{Source: "sysfs", Target: "/sys", FSType: "sysfs", Options: []string{"nosuid", "nodev", "noexec"}},
+		{Source: "devtmpfs", Target: "/dev", FSType: "devtmpfs", Options: []string{"nosuid", "mode=755"}},
+		{Source: "devpts", Target: "/dev/pts", FSType: "devpts", Options: []string{"nosuid", "noexec", "gid=5", "mode=620", "ptmxmode=666"}, Mode: 0755},
+		{Source: "tmpfs", Target: "/dev/shm", FSType: "tmpfs", Options: []string{"nosuid", "nodev", "mode=1777"}, Mode: 01777},
+		{Source: "tmpfs", Target: "/run", FSType: "tmpfs", Options: []string{"nosuid", "nodev", "mode=755"}, Mode: 0755},
+		{Source: "tmpfs", Target: "/tmp", FSType: "tmpfs", Options: []string{"nosuid", "nodev", "mode=1777"}, Mode: 01777, NoFail: true},
+		{Source: "cgroup2", Target: "/sys/fs/cgroup", FSType: "cgroup2", Options: []string{"nosuid", "nodev", "noexec", "nsdelegate"}, NoFail: true},
+	}
+	if config == nil {
+		return entries
+	}
+	if config.DebugFS {
+		entries = append(entries, Entry{Source: "debugfs", Target: "/sys/kernel/debug", FSType: "debugfs", Options: []string{"nosuid", "nodev", "noexec"}, NoFail: true})
+	}
+	if config.TraceFS {
+		entries = append(entries, Entry{Source: "tracefs", Target: "/sys/kernel/tracing", FSType: "tracefs", Options: []string{"nosuid", "nodev", "noexec"}, NoFail: true})
+	}
+	if config.HugetlbFS {
+		entries = append(entries, Entry{Source: "hugetlbfs", Target: "/dev/hugepages", FSType: "hugetlbfs", Options: []string{"nosuid", "nodev"}, Mode: 0755, NoFail: true})
+	}
+	return entries
+}
+
+// MountAll 按父目录优先的顺序挂载 entries，单个条目失败不会中断后续条目
+func MountAll(m Mounter, entries []Entry, opts Options) []Result {
+	resolve := opts.Resolve
+	if resolve == nil {
+		resolve = ResolveSource
+	}
+
+	present := make(map[string]bool, len(opts.Existing))
+	for _, mi := range opts.Existing {
+		present[filepath.Clean(mi.MountPoint)] = true
+	}
+
+	sorted := make([]Entry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return depth(sorted[a].Target) < depth(sorted[b].Target)
+	})
+
+	results := make([]Result, 0, len(sorted))
+	for _, e := range sorted {
+		start := time.Now()
+		result := Result{Entry: e, Device: e.Source}
+
+		switch {
+		case present[filepath.Clean(e.Target)]:
+			result.Status = StatusPresent
+		default:
+			result.Device, result.Err = mountEntry(m, e, resolve, opts.Fsck)
+			if result.Err != nil {
+				result.Status = StatusFailed
+			} else {
+				result.Status = StatusMounted
+				present[filepath.Clean(e.Target)] = true
+			}
+		}
+
+		result.Duration = time.Since(start)
+		results = append(results, result)
+	}
+	return results
+}
+
+// mountEntry 解析设备、执行 fsck、创建挂载点并挂载
+func mountEntry(m Mounter, e Entry, resolve func(string) (string, error), fsck FsckFunc) (string, error) {
+	device, err := resolve(e.Source)
+	if err != nil {
+		return e.Source, err
+	}
+
+	if e.Fsck && fsck != nil {
+		if err := fsck(device, e.FSType); err != nil {
+			return device, fmt.Errorf("fsck failed: %v", err)
+		}
+	}
+
+	mode := os.FileMode(e.Mode)
+	if mode == 0 {
+		mode = 0755
+	}
+	if err := os.MkdirAll(e.Target, mode); err != nil {
+		return device, fmt.Errorf("failed to create mount point: %v", err)
+	}
+
+	flags, data := ParseOptions(e.Options)
+	if err := m.Mount(device, e.Target, e.FSType, flags, data); err != nil {
+		return device, err
+	}
+	return device, nil
+}
+
+// Failed 返回导致启动失败的条目（没有设置 nofail 的失败条目）
+func Failed(results []Result) []Result {
+	var failed []Result
+	for _, r := range results {
+		if r.Status == StatusFailed && !r.Entry.NoFail {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// FormatResults 将挂载结果格式化为表格
+func FormatResults(results []Result) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tTYPE\tDEVICE\tSTATUS\tTIME\tERROR")
+	for _, r := range results {
+		errText := ""
+		if r.Err != nil {
+			errText = r.Err.Error()
+			if r.Entry.NoFail {
+				errText += " (nofail)"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Entry.Target, r.Entry.FSType, r.Device, r.Status,
+			r.Duration.Round(time.Millisecond), errText)
+	}
+	w.Flush()
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+// ResolveSource 将 UUID=/LABEL=/PARTUUID=/PARTLABEL= 形式的设备标识解析为设备路径。
+// 优先使用 /dev/disk/by-* 符号链接，不存在时直接探测 ext2/3/4 超级块
+func ResolveSource(source string) (string, error) {
+	idx := strings.IndexByte(source, '=')
+	if idx < 0 || strings.HasPrefix(source, "/") {
+		return source, nil
+	}
+
+	key, value := strings.ToUpper(source[:idx]), strings.Trim(source[idx+1:], `"`)
+	dirs := map[string]string{
+		"UUID":      "/dev/disk/by-uuid",
+		"LABEL":     "/dev/disk/by-label",
+		"PARTUUID":  "/dev/disk/by-partuuid",
+		"PARTLABEL": "/dev/disk/by-partlabel",
+	}
+	dir, ok := dirs[key]
+	if !ok {
+		// 不是设备标识，例如 tmpfs 的 size=...
+		return source, nil
+	}
+
+	if dev, err := filepath.EvalSymlinks(filepath.Join(dir, value)); err == nil {
+		return dev, nil
+	}
+
+	if key == "UUID" || key == "LABEL" {
+		if dev := probeExt(key, value); dev != "" {
+			return dev, nil
+		}
+	}
+	return "", fmt.Errorf("device %s not found", source)
+}
+
+// probeExt 遍历块设备，按 ext 系列文件系统的超级块匹配 UUID 或卷标
+func probeExt(key, value string) string {
+	entries, err := ioutil.ReadDir("/sys/class/block")
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		dev := "/dev/" + entry.Name()
+		uuid, label, ok := readExtSuperblock(dev)
+		if !ok {
+			continue
+		}
+		if (key == "UUID" && strings.EqualFold(uuid, value)) || (key == "LABEL" && label == value) {
+			return dev
+		}
+	}
+	return ""
+}
+
+// readExtSuperblock 读取 ext2/3/4 超级块中的 UUID 和卷标
+func readExtSuperblock(dev string) (uuid, label string, ok bool) {
+	f, err := os.Open(dev)
+	if err != nil {
+		return "", "", false
+	}
+	defer f.Close()
+
+	sb := make([]byte, 1024)
+	if _, err := f.ReadAt(sb, 1024); err != nil {
+		return "", "", false
+	}
+	// s_magic 位于超级块偏移 0x38
+	if sb[0x38] != 0x53 || sb[0x39] != 0xEF {
+		return "", "", false
+	}
+	u := sb[0x68:0x78]
+	uuid = fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+	label = strings.TrimRight(string(sb[0x78:0x88]), "\x00")
+	return uuid, label, true
+}
+
+// DefaultFsck 调用 fsck.<type> -a 检查文件系统，工具不存在时跳过。
+// 退出码 0 和 1（已修复错误）视为成功
+func DefaultFsck(device, fstype string) error {
+	tool, err := exec.LookPath("fsck." + fstype)
+	if err != nil {
+		return nil
+	}
+
+	cmd := exec.Command(tool, "-a", device)
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return nil
+	}
+	return fmt.Errorf("%s: %v: %s", tool, err, strings.TrimSpace(string(output)))
+}
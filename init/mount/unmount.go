@@ -0,0 +1,56 @@
+package mount
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// UnmountResult 记录单个挂载点在关机时的处理结果
+type UnmountResult struct {
+	MountPoint string
+	Unmounted  bool  // 成功卸载
+	ReadOnly   bool  // 卸载失败但已重新挂载为只读
+	Err        error // 卸载和只读重挂载都失败
+}
+
+// UnmountAll 按从深到浅的顺序卸载所有挂载点。
+// 根文件系统以及卸载失败的挂载点会退而重新挂载为只读，保证数据落盘
+func UnmountAll(m Mounter, mounts []MountInfo) []UnmountResult {
+	var results []UnmountResult
+	pending := SortDeepestFirst(mounts)
+
+	// 卸载上层挂载点后，下层的挂载点可能才能卸载，因此重复直到没有进展
+	for {
+		var failed []MountInfo
+		progress := false
+		for _, mi := range pending {
+			if mi.MountPoint == "/" {
+				failed = append(failed, mi)
+				continue
+			}
+			if err := m.Unmount(mi.MountPoint, 0); err != nil {
+				failed = append(failed, mi)
+				continue
+			}
+			results = append(results, UnmountResult{MountPoint: mi.MountPoint, Unmounted: true})
+			progress = true
+		}
+		pending = failed
+		if !progress || len(pending) == 0 {
+			break
+		}
+	}
+
+	for _, mi := range pending {
+		result := UnmountResult{MountPoint: mi.MountPoint}
+		if err := m.Mount("", mi.MountPoint, "", unix.MS_REMOUNT|unix.MS_RDONLY, ""); err != nil {
+			result.Err = fmt.Errorf("failed to unmount or remount %s read-only: %v", mi.MountPoint, err)
+		} else {
+			result.ReadOnly = true
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
@@ -0,0 +1,70 @@
+package mount
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+const testMountInfo = `1 0 8:1 / / rw,relatime - ext4 /dev/sda1 rw
+2 1 0:4 / /proc rw,nosuid - proc proc rw
+3 1 0:5 / /dev rw,nosuid - devtmpfs devtmpfs rw
+4 3 0:6 / /dev/pts rw - devpts devpts rw
+5 1 0:7 / /mnt/my\040disk rw - ext4 /dev/sdb1 rw
+6 1 0:8 / /var/lib/busy rw - ext4 /dev/sdc1 rw
+`
+
+// fakeMounter 记录调用并按配置让指定挂载点卸载失败
+type fakeMounter struct {
+	calls []string
+	busy  map[string]bool
+}
+
+func (f *fakeMounter) Mount(source, target, fstype string, flags uintptr, data string) error {
+	if flags&unix.MS_REMOUNT != 0 {
+		f.calls = append(f.calls, "remount-ro "+target)
+		return nil
+	}
+	f.calls = append(f.calls, "mount "+target)
+	return nil
+}
+
+func (f *fakeMounter) Unmount(target string, flags int) error {
+	if f.busy[target] {
+		return fmt.Errorf("device or resource busy")
+	}
+	f.calls = append(f.calls, "umount "+target)
+	return nil
+}
+
+func TestUnmountAllDeepestFirst(t *testing.T) {
+	mounts, err := ParseMountInfo(strings.NewReader(testMountInfo))
+	if err != nil {
+		t.Fatalf("Failed to parse mountinfo: %v", err)
+	}
+	if mounts[4].MountPoint != "/mnt/my disk" {
+		t.Errorf("Expected unescaped mount point, got %q", mounts[4].MountPoint)
+	}
+
+	m := &fakeMounter{busy: map[string]bool{"/var/lib/busy": true}}
+	results := UnmountAll(m, mounts)
+
+	expected := []string{
+		"umount /mnt/my disk",
+		"umount /dev/pts",
+		"umount /dev",
+		"umount /proc",
+		"remount-ro /var/lib/busy",
+		"remount-ro /",
+	}
+	if strings.Join(m.calls, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected call order:\n%s\nexpected:\n%s", strings.Join(m.calls, "\n"), strings.Join(expected, "\n"))
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("Unexpected error for %s: %v", r.MountPoint, r.Err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Interface 单个网络接口的配置
+type Interface struct {
+	Addresses []string `yaml:"addresses,omitempty"` // CIDR 格式，例如 10.0.2.15/24
+	MTU       int      `yaml:"mtu,omitempty"`
+	DHCP      bool     `yaml:"dhcp,omitempty"` // 由托管的 DHCP 客户端服务配置地址
+}
+
+// Route 静态路由
+type Route struct {
+	To     string `yaml:"to"`            // CIDR 或 "default"
+	Via    string `yaml:"via,omitempty"` // 网关
+	Dev    string `yaml:"dev,omitempty"` // 出接口
+	Metric uint32 `yaml:"metric,omitempty"`
+}
+
+// DNS resolv.conf 配置
+type DNS struct {
+	Nameservers []string `yaml:"nameservers"`
+	Search      []string `yaml:"search,omitempty"`
+}
+
+// Config 网络配置（/etc/ldh-os/network.yaml）
+type Config struct {
+	Interfaces map[string]Interface `yaml:"interfaces"`
+	Routes     []Route              `yaml:"routes,omitempty"`
+	DNS        *DNS                 `yaml:"dns,omitempty"`
+	DHCPClient string               `yaml:"dhcp_client,omitempty"` // DHCP 客户端程序
+	DHCPArgs   []string             `yaml:"dhcp_args,omitempty"`   // 参数中的 {interface} 会被替换为接口名
+	ResolvConf string               `yaml:"resolv_conf,omitempty"`
+}
+
+// DefaultConfig 返回只启用 lo 的默认配置
+func DefaultConfig() *Config {
+	return &Config{
+		Interfaces: make(map[string]Interface),
+		DHCPClient: "/sbin/udhcpc",
+		DHCPArgs:   []string{"-f", "-i", "{interface}"},
+		ResolvConf: "/etc/resolv.conf",
+	}
+}
+
+// LoadConfig 读取网络配置，文件不存在时返回默认配置
+func LoadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read network config: %v", err)
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse network config: %v", err)
+	}
+	if config.Interfaces == nil {
+		config.Interfaces = make(map[string]Interface)
+	}
+	return config, config.validate()
+}
+
+// validate 检查地址和路由格式
+func (c *Config) validate() error {
+	for name, iface := range c.Interfaces {
+		for _, addr := range iface.Addresses {
+			if _, _, err := net.ParseCIDR(addr); err != nil {
+				return fmt.Errorf("interface %s: invalid address %q", name, addr)
+			}
+		}
+	}
+	for i, r := range c.Routes {
+		if _, err := r.destination(); err != nil {
+			return fmt.Errorf("route %d: %v", i, err)
+		}
+		if r.Via != "" && net.ParseIP(r.Via) == nil {
+			return fmt.Errorf("route %d: invalid gateway %q", i, r.Via)
+		}
+		if r.Via == "" && r.Dev == "" {
+			return fmt.Errorf("route %d: needs a gateway or a device", i)
+		}
+	}
+	if c.DNS != nil {
+		for _, ns := range c.DNS.Nameservers {
+			if net.ParseIP(ns) == nil {
+				return fmt.Errorf("invalid nameserver %q", ns)
+			}
+		}
+	}
+	return nil
+}
+
+// destination 解析路由目标，"default" 根据网关地址族选择 0.0.0.0/0 或 ::/0
+func (r Route) destination() (*net.IPNet, error) {
+	if r.To == "default" {
+		if gw := net.ParseIP(r.Via); gw != nil && gw.To4() == nil {
+			_, dst, _ := net.ParseCIDR("::/0")
+			return dst, nil
+		}
+		_, dst, _ := net.ParseCIDR("0.0.0.0/0")
+		return dst, nil
+	}
+	_, dst, err := net.ParseCIDR(r.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination %q", r.To)
+	}
+	return dst, nil
+}
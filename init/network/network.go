@@ -0,0 +1,307 @@
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"ldh-os/init/logging"
+	"ldh-os/init/service"
+
+	"golang.org/x/sys/unix"
+)
+
+// Manager 负责早期用户空间的网络配置和链路状态监控
+type Manager struct {
+	config     *Config
+	services   *service.ServiceManager
+	eventBus   *service.EventBus
+	conn       *rtConn
+	monitor    *rtConn
+	configured map[string]bool // 已完成静态配置的接口
+	running    map[int]bool    // 接口索引 -> 链路是否就绪
+	mu         sync.Mutex
+}
+
+// NewManager 创建网络管理器，services 用于注册托管的 DHCP 客户端服务，可以为 nil
+func NewManager(config *Config, services *service.ServiceManager) *Manager {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	m := &Manager{
+		config:     config,
+		services:   services,
+		configured: make(map[string]bool),
+		running:    make(map[int]bool),
+	}
+	if services != nil {
+		m.eventBus = services.EventBus()
+	}
+	return m
+}
+
+// Start 启用 lo，配置已存在的接口并写入 resolv.conf，
+// 之后持续监听链路变化：新出现的接口会被配置，链路状态变化发布到事件总线
+func (m *Manager) Start() error {
+	conn, err := dialRoute(0)
+	if err != nil {
+		return err
+	}
+	m.conn = conn
+
+	// 先订阅链路事件，避免错过配置期间出现的接口
+	monitor, err := dialRoute(unix.RTMGRP_LINK)
+	if err != nil {
+		return err
+	}
+	m.monitor = monitor
+
+	if err := m.bringUpLoopback(); err != nil {
+		return fmt.Errorf("failed to bring up lo: %v", err)
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+	for _, iface := range ifaces {
+		m.mu.Lock()
+		m.running[iface.Index] = iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagRunning != 0
+		m.mu.Unlock()
+		m.configureInterface(iface.Name, iface.Index)
+	}
+
+	if m.config.DNS != nil {
+		if err := m.writeResolvConf(); err != nil {
+			logging.Warn("Failed to write resolv.conf", "path", m.config.ResolvConf, "error", err)
+		}
+	}
+
+	if err := m.registerDHCP(); err != nil {
+		logging.Warn("Failed to register DHCP services", "error", err)
+	}
+
+	go m.watchLinks()
+	return nil
+}
+
+// Stop 关闭 netlink 套接字
+func (m *Manager) Stop() {
+	if m.monitor != nil {
+		m.monitor.Close()
+	}
+	if m.conn != nil {
+		m.conn.Close()
+	}
+}
+
+// bringUpLoopback 启用 lo，内核会自动为其分配 127.0.0.1/8 和 ::1
+func (m *Manager) bringUpLoopback() error {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		return err
+	}
+	return m.conn.setLinkUp(lo.Index, 0)
+}
+
+// configureInterface 按配置启用接口、添加静态地址及经由该接口的路由
+func (m *Manager) configureInterface(name string, index int) {
+	cfg, ok := m.config.Interfaces[name]
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	if m.configured[name] {
+		m.mu.Unlock()
+		return
+	}
+	m.configured[name] = true
+	m.mu.Unlock()
+
+	if err := m.conn.setLinkUp(index, cfg.MTU); err != nil {
+		logging.Warn("Failed to bring up interface", "interface", name, "error", err)
+		return
+	}
+	for _, addr := range cfg.Addresses {
+		ip, ipnet, _ := net.ParseCIDR(addr)
+		ipnet.IP = ip
+		if err := m.conn.addAddress(index, ipnet); err != nil {
+			logging.Warn("Failed to add address", "interface", name, "address", addr, "error", err)
+		}
+	}
+	logging.Info("Configured interface", "interface", name, "addresses", len(cfg.Addresses))
+
+	m.addRoutes(name, index)
+}
+
+// addRoutes 添加出接口为 dev 的路由，以及网关位于 dev 子网内的未指定接口的路由
+func (m *Manager) addRoutes(dev string, index int) {
+	for _, r := range m.config.Routes {
+		if r.Dev != dev && !(r.Dev == "" && m.gatewayReachableVia(r, dev)) {
+			continue
+		}
+
+		dst, _ := r.destination()
+		var gateway net.IP
+		if r.Via != "" {
+			gateway = net.ParseIP(r.Via)
+		}
+		oif := 0
+		if r.Dev != "" {
+			oif = index
+		}
+		if err := m.conn.addRoute(dst, gateway, oif, r.Metric); err != nil {
+			logging.Warn("Failed to add route", "to", r.To, "via", r.Via, "error", err)
+		}
+	}
+}
+
+// gatewayReachableVia 判断未指定接口的路由的网关是否位于 dev 的静态子网中
+func (m *Manager) gatewayReachableVia(r Route, dev string) bool {
+	gw := net.ParseIP(r.Via)
+	if gw == nil {
+		return false
+	}
+	for _, addr := range m.config.Interfaces[dev].Addresses {
+		if _, ipnet, err := net.ParseCIDR(addr); err == nil && ipnet.Contains(gw) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeResolvConf 原子地写入 resolv.conf
+func (m *Manager) writeResolvConf() error {
+	var b strings.Builder
+	b.WriteString("# Generated by ldh-os init\n")
+	if len(m.config.DNS.Search) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(m.config.DNS.Search, " "))
+	}
+	for _, ns := range m.config.DNS.Nameservers {
+		fmt.Fprintf(&b, "nameserver %s\n", ns)
+	}
+
+	path := m.config.ResolvConf
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// registerDHCP 为启用 DHCP 的接口注册托管的 DHCP 客户端服务
+func (m *Manager) registerDHCP() error {
+	if m.services == nil {
+		return nil
+	}
+
+	var errs []string
+	for name, iface := range m.config.Interfaces {
+		if !iface.DHCP {
+			continue
+		}
+		args := make([]string, len(m.config.DHCPArgs))
+		for i, a := range m.config.DHCPArgs {
+			args[i] = strings.ReplaceAll(a, "{interface}", name)
+		}
+		err := m.services.RegisterService(service.ServiceConfig{
+			Name:        "dhcp-" + name,
+			Description: "DHCP client for " + name,
+			Type:        service.TypeDaemon,
+			ExecPath:    m.config.DHCPClient,
+			Args:        args,
+			Restart:     "always",
+			Targets:     []string{service.TargetNetwork},
+			MCPConfig: service.MCPConfig{
+				Functions:   []string{"start", "stop", "restart", "status"},
+				Permissions: []string{"read", "write"},
+			},
+		})
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to register DHCP services: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// watchLinks 处理内核的链路通知
+func (m *Manager) watchLinks() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, _, err := unix.Recvfrom(m.monitor.fd, buf, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		if err == unix.ENOBUFS {
+			// 事件过多导致丢失，之后的事件仍然可用
+			logging.Warn("Link monitor overrun, some link events were lost")
+			continue
+		}
+		if err != nil {
+			logging.Warn("Link monitor stopped", "error", err)
+			return
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, msg := range msgs {
+			if lm, ok := parseLinkMessage(msg); ok {
+				m.handleLink(lm)
+			}
+		}
+	}
+}
+
+// handleLink 配置新出现的接口，并在链路就绪状态变化时发布事件
+func (m *Manager) handleLink(lm linkMessage) {
+	if !lm.Deleted && lm.Name != "" {
+		m.configureInterface(lm.Name, lm.Index)
+	}
+
+	up := !lm.Deleted && lm.Flags&unix.IFF_UP != 0 && lm.Flags&(unix.IFF_RUNNING|unix.IFF_LOWER_UP) != 0
+
+	m.mu.Lock()
+	was, known := m.running[lm.Index]
+	if lm.Deleted {
+		delete(m.running, lm.Index)
+		delete(m.configured, lm.Name)
+	} else {
+		m.running[lm.Index] = up
+	}
+	m.mu.Unlock()
+
+	if known && was == up {
+		return
+	}
+	if !known && !up {
+		return
+	}
+
+	eventType := service.EventLinkDown
+	if up {
+		eventType = service.EventLinkUp
+	}
+	logging.Info("Link state changed", "interface", lm.Name, "event", eventType)
+	if m.eventBus != nil {
+		m.eventBus.EmitSync(service.ServiceEvent{
+			Type:      eventType,
+			Data:      service.LinkEvent{Interface: lm.Name, Index: lm.Index, Up: up},
+			Timestamp: time.Now(),
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package network
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"ldh-os/init/service"
+
+	"golang.org/x/sys/unix"
+)
+
+// inNetns 在新的网络命名空间中运行 fn。执行 fn 的线程不会被解锁，
+// goroutine 结束时 Go 运行时会销毁该线程，命名空间不会泄漏到其他测试
+func inNetns(t *testing.T, fn func()) {
+	done := make(chan struct{})
+	skipped := false
+	go func() {
+		defer close(done)
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			skipped = true
+			return
+		}
+		fn()
+	}()
+	<-done
+	if skipped {
+		t.Skip("unable to create network namespace (needs CAP_SYS_ADMIN)")
+	}
+}
+
+func TestNetworkBringUp(t *testing.T) {
+	resolvConf := filepath.Join(t.TempDir(), "resolv.conf")
+	config := DefaultConfig()
+	config.Interfaces["lo"] = Interface{Addresses: []string{"10.99.0.1/24"}}
+	config.Routes = []Route{{To: "10.98.0.0/16", Via: "10.99.0.254"}}
+	config.DNS = &DNS{Nameservers: []string{"10.99.0.53"}, Search: []string{"ldh.local"}}
+	config.ResolvConf = resolvConf
+	if err := config.validate(); err != nil {
+		t.Fatalf("Invalid config: %v", err)
+	}
+
+	inNetns(t, func() {
+		sm := service.NewServiceManager()
+		events := make(chan service.LinkEvent, 10)
+		handler := func(e service.ServiceEvent) { events <- e.Data.(service.LinkEvent) }
+		sm.EventBus().Subscribe(service.EventLinkUp, handler)
+		sm.EventBus().Subscribe(service.EventLinkDown, handler)
+
+		m := NewManager(config, sm)
+		if err := m.Start(); err != nil {
+			t.Errorf("Failed to start network: %v", err)
+			return
+		}
+		defer m.Stop()
+
+		lo, err := net.InterfaceByName("lo")
+		if err != nil || lo.Flags&net.FlagUp == 0 {
+			t.Errorf("Expected lo to be up: %v %v", lo, err)
+			return
+		}
+		addrs, _ := lo.Addrs()
+		found := false
+		for _, a := range addrs {
+			if a.String() == "10.99.0.1/24" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected 10.99.0.1/24 on lo, got %v", addrs)
+		}
+
+		routes, _ := ioutil.ReadFile("/proc/thread-self/net/route")
+		// 10.98.0.0 经由 10.99.0.254，以小端十六进制表示
+		if !strings.Contains(string(routes), "0000620A\tFE00630A") {
+			t.Errorf("Expected route to 10.98.0.0/16, got:\n%s", routes)
+		}
+
+		data, _ := ioutil.ReadFile(resolvConf)
+		if !strings.Contains(string(data), "nameserver 10.99.0.53") || !strings.Contains(string(data), "search ldh.local") {
+			t.Errorf("Unexpected resolv.conf:\n%s", data)
+		}
+
+		// 关闭再开启 lo，应依次收到 link-down 和 link-up
+		if err := m.conn.request(unix.RTM_NEWLINK, 0, ifInfomsg(lo.Index, 0, unix.IFF_UP)); err != nil {
+			t.Errorf("Failed to set lo down: %v", err)
+			return
+		}
+		if err := m.conn.setLinkUp(lo.Index, 0); err != nil {
+			t.Errorf("Failed to set lo up: %v", err)
+			return
+		}
+		for _, want := range []bool{false, true} {
+			select {
+			case ev := <-events:
+				if ev.Interface != "lo" || ev.Up != want {
+					t.Errorf("Unexpected link event %+v, want up=%v", ev, want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("Timed out waiting for link event up=%v", want)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// nativeEndian netlink 消息使用主机字节序
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// rtConn 一个 NETLINK_ROUTE 套接字，用于下发配置请求
+type rtConn struct {
+	fd  int
+	seq uint32
+}
+
+// dialRoute 打开 rtnetlink 套接字
+func dialRoute(groups uint32) (*rtConn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rtnetlink socket: %v", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: groups}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind rtnetlink socket: %v", err)
+	}
+	return &rtConn{fd: fd}, nil
+}
+
+func (c *rtConn) Close() error {
+	return unix.Close(c.fd)
+}
+
+// request 发送一条带 ACK 的请求并等待内核应答
+func (c *rtConn) request(typ, flags uint16, body []byte, attrs ...[]byte) error {
+	seq := atomic.AddUint32(&c.seq, 1)
+
+	length := unix.SizeofNlMsghdr + len(body)
+	for _, a := range attrs {
+		length += len(a)
+	}
+	msg := make([]byte, unix.SizeofNlMsghdr, length)
+	nativeEndian.PutUint32(msg[0:4], uint32(length))
+	nativeEndian.PutUint16(msg[4:6], typ)
+	nativeEndian.PutUint16(msg[6:8], flags|unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	nativeEndian.PutUint32(msg[8:12], seq)
+	msg = append(msg, body...)
+	for _, a := range attrs {
+		msg = append(msg, a...)
+	}
+
+	if err := unix.Sendto(c.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 8192)
+	for {
+		n, _, err := unix.Recvfrom(c.fd, buf, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != seq || m.Header.Type != unix.NLMSG_ERROR {
+				continue
+			}
+			if len(m.Data) < 4 {
+				return fmt.Errorf("short netlink error message")
+			}
+			if errno := int32(nativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+				return unix.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
+
+// rtAttr 编码一个 rtattr，自动按 4 字节对齐
+func rtAttr(typ uint16, data []byte) []byte {
+	length := unix.SizeofRtAttr + len(data)
+	buf := make([]byte, rtaAlign(length))
+	nativeEndian.PutUint16(buf[0:2], uint16(length))
+	nativeEndian.PutUint16(buf[2:4], typ)
+	copy(buf[4:], data)
+	return buf
+}
+
+func rtaAlign(n int) int {
+	return (n + unix.RTA_ALIGNTO - 1) &^ (unix.RTA_ALIGNTO - 1)
+}
+
+func uint32Attr(typ uint16, v uint32) []byte {
+	b := make([]byte, 4)
+	nativeEndian.PutUint32(b, v)
+	return rtAttr(typ, b)
+}
+
+// ifInfomsg 编码 struct ifinfomsg
+func ifInfomsg(index int, flags, change uint32) []byte {
+	b := make([]byte, unix.SizeofIfInfomsg)
+	b[0] = unix.AF_UNSPEC
+	nativeEndian.PutUint32(b[4:8], uint32(int32(index)))
+	nativeEndian.PutUint32(b[8:12], flags)
+	nativeEndian.PutUint32(b[12:16], change)
+	return b
+}
+
+// setLinkUp 设置接口为 UP，mtu 大于 0 时同时设置 MTU
+func (c *rtConn) setLinkUp(index, mtu int) error {
+	var attrs [][]byte
+	if mtu > 0 {
+		attrs = append(attrs, uint32Attr(unix.IFLA_MTU, uint32(mtu)))
+	}
+	return c.request(unix.RTM_NEWLINK, 0, ifInfomsg(index, unix.IFF_UP, unix.IFF_UP), attrs...)
+}
+
+// addAddress 为接口添加地址，地址已存在时不报错
+func (c *rtConn) addAddress(index int, addr *net.IPNet) error {
+	family, ip := ipFamily(addr.IP)
+	ones, _ := addr.Mask.Size()
+
+	body := make([]byte, unix.SizeofIfAddrmsg)
+	body[0] = family
+	body[1] = uint8(ones)
+	nativeEndian.PutUint32(body[4:8], uint32(index))
+
+	attrs := [][]byte{rtAttr(unix.IFA_LOCAL, ip), rtAttr(unix.IFA_ADDRESS, ip)}
+	if family == unix.AF_INET && ones < 31 {
+		bcast := make(net.IP, 4)
+		for i := range bcast {
+			bcast[i] = ip[i] | ^addr.Mask[len(addr.Mask)-4+i]
+		}
+		attrs = append(attrs, rtAttr(unix.IFA_BROADCAST, bcast))
+	}
+
+	err := c.request(unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_EXCL, body, attrs...)
+	if err == unix.EEXIST {
+		return nil
+	}
+	return err
+}
+
+// addRoute 添加路由，gateway 为空时添加直连路由，index 为 0 时由内核根据网关选择接口
+func (c *rtConn) addRoute(dst *net.IPNet, gateway net.IP, index int, metric uint32) error {
+	family, dstIP := ipFamily(dst.IP)
+	ones, _ := dst.Mask.Size()
+
+	body := make([]byte, unix.SizeofRtMsg)
+	body[0] = family
+	body[1] = uint8(ones)
+	body[4] = unix.RT_TABLE_MAIN
+	body[5] = unix.RTPROT_BOOT
+	body[6] = unix.RT_SCOPE_UNIVERSE
+	body[7] = unix.RTN_UNICAST
+	if gateway == nil {
+		body[6] = unix.RT_SCOPE_LINK
+	}
+
+	var attrs [][]byte
+	if ones > 0 {
+		attrs = append(attrs, rtAttr(unix.RTA_DST, dstIP))
+	}
+	if gateway != nil {
+		_, gw := ipFamily(gateway)
+		attrs = append(attrs, rtAttr(unix.RTA_GATEWAY, gw))
+	}
+	if index > 0 {
+		attrs = append(attrs, uint32Attr(unix.RTA_OIF, uint32(index)))
+	}
+	if metric > 0 {
+		attrs = append(attrs, uint32Attr(unix.RTA_PRIORITY, metric))
+	}
+
+	err := c.request(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_EXCL, body, attrs...)
+	if err == unix.EEXIST {
+		return nil
+	}
+	return err
+}
+
+// ipFamily 返回地址族以及对应长度的地址字节
+func ipFamily(ip net.IP) (uint8, net.IP) {
+	if v4 := ip.To4(); v4 != nil {
+		return unix.AF_INET, v4
+	}
+	return unix.AF_INET6, ip.To16()
+}
+
+// linkMessage 从 RTM_NEWLINK/RTM_DELLINK 消息中解析出的接口信息
+type linkMessage struct {
+	Deleted bool
+	Index   int
+	Name    string
+	Flags   uint32
+}
+
+// parseLinkMessage 解析链路消息，非链路消息返回 false
+func parseLinkMessage(m syscall.NetlinkMessage) (linkMessage, bool) {
+	if m.Header.Type != unix.RTM_NEWLINK && m.Header.Type != unix.RTM_DELLINK {
+		return linkMessage{}, false
+	}
+	if len(m.Data) < unix.SizeofIfInfomsg {
+		return linkMessage{}, false
+	}
+
+	lm := linkMessage{
+		Deleted: m.Header.Type == unix.RTM_DELLINK,
+		Index:   int(int32(nativeEndian.Uint32(m.Data[4:8]))),
+		Flags:   nativeEndian.Uint32(m.Data[8:12]),
+	}
+	attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+	if err != nil {
+		return lm, true
+	}
+	for _, a := range attrs {
+		if a.Attr.Type == unix.IFLA_IFNAME {
+			lm.Name = string(trimNull(a.Value))
+		}
+	}
+	return lm, true
+}
+
+func trimNull(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
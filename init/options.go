@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"ldh-os/init/cmdline"
+	"ldh-os/init/logging"
+
+	"golang.org/x/sys/unix"
+)
+
+// loadOptions 解析内核命令行中的 init 选项。以 PID 1 运行时 /proc 可能尚未挂载，
+// 先单独挂载它，之后的挂载流程会发现它已存在
+func (i *InitSystem) loadOptions() {
+	path := cmdline.DefaultPath
+	if os.Getenv("LDH_CMDLINE") != "" {
+		path = os.Getenv("LDH_CMDLINE")
+	}
+
+	if i.pid1 && path == cmdline.DefaultPath {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			os.MkdirAll("/proc", 0555)
+			if err := unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+				logging.Warn("Failed to mount /proc", "error", err)
+			}
+		}
+	}
+
+	options, warnings, err := cmdline.Read(path)
+	if err != nil {
+		logging.Warn("Kernel command line unavailable", "error", err)
+		return
+	}
+	for _, w := range warnings {
+		logging.Warn("Kernel command line: " + w)
+	}
+	i.options = options
+
+	// ldh.debug 隐含 ldh.log_level=debug，取值已在解析时校验
+	if options.LogLevel != "" {
+		if level, err := logging.ParseLevel(options.LogLevel); err == nil {
+			logging.SetLevel(level)
+			logging.Info("Log level set from kernel command line", "level", level)
+		}
+	}
+	if len(options.Mask) > 0 {
+		i.serviceManager.Mask(options.Mask...)
+		logging.Info("Masked services", "services", strings.Join(options.Mask, ","))
+	}
+}
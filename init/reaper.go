@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// reapInterval 孤儿进程回收的扫描间隔
+const reapInterval = 2 * time.Second
+
+// reapOrphans 定期回收被过继给 init 的孤儿僵尸进程。
+// 服务、shell 等子进程各自有等待者，它们的退出状态不能被抢先回收，
+// 所以只回收连续两次扫描都处于僵尸状态、显然没有等待者的子进程
+func reapOrphans() {
+	self := os.Getpid()
+	seen := make(map[int]bool)
+
+	for range time.Tick(reapInterval) {
+		zombies := make(map[int]bool)
+		for _, pid := range zombieChildren(self) {
+			if !seen[pid] {
+				zombies[pid] = true
+				continue
+			}
+			var ws unix.WaitStatus
+			unix.Wait4(pid, &ws, unix.WNOHANG, nil)
+		}
+		seen = zombies
+	}
+}
+
+// zombieChildren 返回本进程处于僵尸状态的子进程
+func zombieChildren(self int) []int {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if ppid, state, ok := readProcStat(pid); ok && ppid == self && state == "Z" {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"ldh-os/init/logging"
+	"ldh-os/init/service"
+
+	"golang.org/x/sys/unix"
+)
+
+// reexecStateEnv 保存序列化状态的文件描述符编号，新的 init 进程通过它恢复监管
+const reexecStateEnv = "LDH_REEXEC_STATE"
+
+// reexecState 在 re-exec 前后传递的 init 状态
+type reexecState struct {
+	Services map[string]service.ServiceRecord `json:"services"`
+	Files    map[string]int                   `json:"files,omitempty"`  // 名称 -> 继承的文件描述符
+	EventSeq uint64                           `json:"event_seq"`        // 事件序号，新进程从这里继续
+	Target   string                           `json:"target,omitempty"` // 当前目标，新进程保持不变
+}
+
+// keepFile 登记一个需要跨 re-exec 保留的文件描述符（日志管道、通知套接字等）
+func (i *InitSystem) keepFile(name string, f *os.File) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.files[name] = f
+}
+
+// reexec 序列化服务监管状态并 exec 新的 init 二进制，
+// PID 不变，运行中的服务仍是本进程的子进程，由新进程重新接管
+func (i *InitSystem) reexec() error {
+	binary := os.Args[0]
+	if os.Getenv("LDH_INIT_PATH") != "" {
+		binary = os.Getenv("LDH_INIT_PATH")
+	}
+	if !filepath.IsAbs(binary) {
+		return fmt.Errorf("init binary path %q is not absolute", binary)
+	}
+	if _, err := os.Stat(binary); err != nil {
+		return fmt.Errorf("init binary not found: %v", err)
+	}
+
+	// 冻结到 exec 为止，快照之后的启停和崩溃重启留给新进程处理；
+	// exec 成功后不会返回，只有失败时才解冻
+	thaw := i.serviceManager.Freeze()
+	defer thaw()
+
+	state := reexecState{
+		Services: i.serviceManager.Snapshot(),
+		Files:    make(map[string]int),
+		EventSeq: i.serviceManager.EventBus().LastSeq(),
+		Target:   i.serviceManager.CurrentTarget(),
+	}
+	i.mu.Lock()
+	for name, f := range i.files {
+		fd := int(f.Fd())
+		if err := clearCloexec(fd); err != nil {
+			i.mu.Unlock()
+			return fmt.Errorf("failed to keep %s across re-exec: %v", name, err)
+		}
+		state.Files[name] = fd
+	}
+	i.mu.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal re-exec state: %v", err)
+	}
+
+	// 状态写入匿名内存文件，不依赖任何可写的文件系统
+	fd, err := unix.MemfdCreate("ldh-init-state", 0)
+	if err != nil {
+		return fmt.Errorf("failed to create state memfd: %v", err)
+	}
+	if _, err := unix.Write(fd, data); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("failed to write re-exec state: %v", err)
+	}
+
+	env := append(os.Environ(), fmt.Sprintf("%s=%d", reexecStateEnv, fd))
+	logging.Info("Re-executing init", "binary", binary, "services", len(state.Services))
+	i.serviceManager.EventBus().FlushJournal(journalFlushTimeout)
+	i.flushInitLog()
+	restoreWatchdog := i.reexecWatchdog()
+	err = unix.Exec(binary, os.Args, env)
+
+	// 只有 exec 失败才会走到这里
+	restoreWatchdog()
+	unix.Close(fd)
+	return fmt.Errorf("exec %s failed: %v", binary, err)
+}
+
+// loadReexecState 读取上一个 init 进程留下的状态，不是 re-exec 启动时返回 nil
+func loadReexecState() (*reexecState, error) {
+	value := os.Getenv(reexecStateEnv)
+	if value == "" {
+		return nil, nil
+	}
+	os.Unsetenv(reexecStateEnv)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %q", reexecStateEnv, value)
+	}
+
+	f := os.NewFile(uintptr(fd), "ldh-init-state")
+	defer f.Close()
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var state reexecState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse re-exec state: %v", err)
+	}
+
+	// 继承的描述符重新设置 CLOEXEC，避免泄漏给服务进程
+	for _, fd := range state.Files {
+		unix.CloseOnExec(fd)
+	}
+	return &state, nil
+}
+
+// inheritFiles 接收继承的文件描述符，在使用它们的模块（journal 等）启动之前调用
+func (i *InitSystem) inheritFiles(state *reexecState) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for name, fd := range state.Files {
+		i.files[name] = os.NewFile(uintptr(fd), name)
+	}
+}
+
+// restoreReexecState 恢复服务监管
+func (i *InitSystem) restoreReexecState(state *reexecState) {
+	i.serviceManager.Restore(state.Services)
+	logging.Info("Restored supervision after re-exec", "services", len(state.Services))
+}
+
+// activateSockets 开始监听套接字激活的服务的套接字，re-exec 后沿用继承的监听套接字，
+// 已经排队的连接不会丢失
+func (i *InitSystem) activateSockets() {
+	inherited := func(name string) *os.File {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		return i.files[name]
+	}
+	if err := i.serviceManager.ActivateSockets(inherited, i.keepFile); err != nil {
+		logging.Warn("Socket activation incomplete", "error", err)
+	}
+}
+
+// clearCloexec 清除描述符上的 FD_CLOEXEC 标志，使其在 exec 后保持打开
+func clearCloexec(fd int) error {
+	flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0)
+	if err != nil {
+		return err
+	}
+	_, err = unix.FcntlInt(uintptr(fd), unix.F_SETFD, flags&^unix.FD_CLOEXEC)
+	return err
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"ldh-os/init/control"
+	"ldh-os/init/service"
+)
+
+// TestMain 在设置了 LDH_INIT_TEST_MAIN 时把测试二进制当作 init 运行，
+// 这样 re-exec 测试可以 exec 自身；同样处理套接字激活的跳板
+func TestMain(m *testing.M) {
+	service.RunActivationTrampoline()
+	if os.Getenv("LDH_INIT_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestReexecKeepsServicesRunning(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "services.yaml")
+	statePath := filepath.Join(tmpDir, "services.state")
+	socketPath := filepath.Join(tmpDir, "control.sock")
+	syslogPath := filepath.Join(tmpDir, "log.sock")
+
+	config := `
+sleeper:
+  description: "Sleeper"
+  type: "daemon"
+  exec: "/bin/sh"
+  args: ["-c", "echo sleeper up; sleep 1; echo still here; exec sleep 1000"]
+  restart: "never"
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+
+	cmd, waitForLog := startInit(t,
+		"LDH_SERVICES_CONFIG="+configPath,
+		"LDH_STATE_FILE="+statePath,
+		"LDH_CONTROL_SOCKET="+socketPath,
+		"LDH_EVENT_JOURNAL="+filepath.Join(tmpDir, "events.jsonl"),
+		"LDH_INIT_LOG="+filepath.Join(tmpDir, "init.log"),
+		"LDH_JOURNAL="+filepath.Join(tmpDir, "journal"),
+		"LDH_SYSLOG_SOCKET="+syslogPath,
+		"LDH_NOTIFY_SOCKET="+filepath.Join(tmpDir, "notify"),
+	)
+	defer cmd.Process.Kill()
+
+	waitForLog("Init system ready")
+	before := readRecord(t, statePath, "sleeper")
+	if before.State != service.StateRunning {
+		t.Fatalf("Expected sleeper running before re-exec, got %s", before.State)
+	}
+	started := readEvents(t, socketPath, 0, "sleeper")
+	if len(started) == 0 || started[len(started)-1].Type != service.EventReady {
+		t.Fatalf("Expected ready event for sleeper, got %+v", started)
+	}
+	lastSeq := started[len(started)-1].Seq
+
+	if err := cmd.Process.Signal(syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	waitForLog("Restored supervision")
+	waitForLog("Init system ready")
+
+	after := readRecord(t, statePath, "sleeper")
+	if after.State != service.StateRunning || after.Pid != before.Pid {
+		t.Fatalf("Expected sleeper still running as pid %d, got %s pid %d", before.Pid, after.State, after.Pid)
+	}
+	if ppid := parentPid(t, after.Pid); ppid != cmd.Process.Pid {
+		t.Errorf("Expected sleeper parent %d, got %d", cmd.Process.Pid, ppid)
+	}
+
+	// 服务输出管道和 syslog 套接字跨 re-exec 保留，日志存储接着之前的内容
+	conn, err := net.Dial("unixgram", syslogPath)
+	if err != nil {
+		t.Fatalf("Syslog socket not inherited: %v", err)
+	}
+	conn.Write([]byte("<12>cron[7]: after re-exec"))
+	conn.Close()
+	waitJournal(t, socketPath, map[string]interface{}{"service": "sleeper", "grep": "still here"})
+	waitJournal(t, socketPath, map[string]interface{}{"service": "cron", "priority": "warning"})
+	waitJournal(t, socketPath, map[string]interface{}{"source": "init", "grep": "Re-executing init"})
+
+	// 杀掉服务进程，新的 init 进程必须能感知到退出
+	syscall.Kill(after.Pid, syscall.SIGKILL)
+	deadline := time.Now().Add(10 * time.Second)
+	for readRecord(t, statePath, "sleeper").State != service.StateFailed {
+		if time.Now().After(deadline) {
+			t.Fatal("Re-executed init did not notice the service exit")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// 事件序号跨 re-exec 继续递增，客户端可以从之前的序号续传
+	events := readEvents(t, socketPath, lastSeq, "sleeper")
+	if len(events) == 0 || events[len(events)-1].Type != service.EventFailed {
+		t.Fatalf("Expected failed event after seq %d, got %+v", lastSeq, events)
+	}
+
+	cmd.Process.Signal(syscall.SIGTERM)
+	cmd.Wait()
+
+	// 两个 init 进程的日志都写入同一个文件，re-exec 之前的日志已经落盘
+	data, err := os.ReadFile(filepath.Join(tmpDir, "init.log"))
+	if err != nil {
+		t.Fatalf("Failed to read init log: %v", err)
+	}
+	for _, msg := range []string{`"msg":"Re-executing init"`, `"msg":"Restored supervision after re-exec"`} {
+		if !strings.Contains(string(data), msg) {
+			t.Errorf("Expected %s in init log", msg)
+		}
+	}
+}
+
+// startInit 以测试模式启动 init 进程，返回进程和等待指定日志行的函数
+func startInit(t *testing.T, env ...string) (*exec.Cmd, func(substr string)) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(append(os.Environ(), "LDH_INIT_TEST_MAIN=1"), env...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start init: %v", err)
+	}
+
+	lines := make(chan string, 100)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	waitForLog := func(substr string) {
+		t.Helper()
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatalf("init exited while waiting for %q", substr)
+				}
+				if strings.Contains(line, substr) {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("Timed out waiting for %q", substr)
+			}
+		}
+	}
+	return cmd, waitForLog
+}
+
+func readEvents(t *testing.T, socket string, since uint64, name string) []service.ServiceEvent {
+	t.Helper()
+	var events []service.ServiceEvent
+	args := map[string]interface{}{"since": since, "service": name}
+	err := control.Stream(socket, "events", args, nil, func(line []byte) error {
+		var e service.ServiceEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			return err
+		}
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to read events: %v", err)
+	}
+	return events
+}
+
+// waitJournal 等待 journal 中出现满足条件的日志
+func waitJournal(t *testing.T, socket string, query map[string]interface{}) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		found := false
+		err := control.Stream(socket, "logs", query, nil, func([]byte) error {
+			found = true
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to query logs: %v", err)
+		}
+		if found {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for logs matching %v", query)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func readRecord(t *testing.T, path, name string) service.ServiceRecord {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read state file: %v", err)
+	}
+	var sf struct {
+		Services map[string]service.ServiceRecord `json:"services"`
+	}
+	if err := json.Unmarshal(data, &sf); err != nil {
+		t.Fatalf("Failed to parse state file: %v", err)
+	}
+	return sf.Services[name]
+}
+
+func parentPid(t *testing.T, pid int) int {
+	t.Helper()
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		t.Fatalf("Failed to read stat of %d: %v", pid, err)
+	}
+	s := string(data)
+	fields := strings.Fields(s[strings.LastIndexByte(s, ')')+1:])
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"ldh-os/init/logging"
+)
+
+// requirements 返回服务的硬依赖：dependencies、requires 和 binds_to
+func (c ServiceConfig) requirements() []string {
+	return mergeNames(c.Dependencies, c.Requires, c.BindsTo)
+}
+
+// startsAfter 返回服务必须排在其后启动的服务，不含其他服务 before 的反向关系。
+// requires、wants、binds_to 都隐含排序
+func (c ServiceConfig) startsAfter() []string {
+	return mergeNames(c.Dependencies, c.Requires, c.Wants, c.BindsTo, c.After)
+}
+
+// mergeNames 合并多个服务名列表并去重，保持首次出现的顺序
+func mergeNames(lists ...[]string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, list := range lists {
+		for _, name := range list {
+			if !seen[name] {
+				seen[name] = true
+				result = append(result, name)
+			}
+		}
+	}
+	return result
+}
+
+func containsName(list []string, name string) bool {
+	for _, n := range list {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup 按名称查找服务
+func (sm *ServiceManager) lookup(name string) (*Service, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	service, exists := sm.services[name]
+	return service, exists
+}
+
+// orderingEdges 返回每个服务必须排在其后启动的服务，已合并 before 的反向关系
+func (sm *ServiceManager) orderingEdges() map[string][]string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	edges := make(map[string][]string, len(sm.services))
+	for name, service := range sm.services {
+		edges[name] = append(edges[name], service.Config.startsAfter()...)
+		for _, later := range service.Config.Before {
+			edges[later] = append(edges[later], name)
+		}
+	}
+	return edges
+}
+
+// unsatisfied 返回尚未满足的硬依赖
+func (sm *ServiceManager) unsatisfied(name string) []string {
+	service, exists := sm.lookup(name)
+	if !exists {
+		return nil
+	}
+
+	var missing []string
+	for _, dep := range service.Config.requirements() {
+		if s, ok := sm.lookup(dep); !ok || !s.active() {
+			missing = append(missing, dep)
+		}
+	}
+	return missing
+}
+
+// dependents 返回以硬依赖关系依赖 name 的服务，bindsOnly 时只包括 binds_to
+func (sm *ServiceManager) dependents(name string, bindsOnly bool) []string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	var result []string
+	for other, service := range sm.services {
+		deps := service.Config.requirements()
+		if bindsOnly {
+			deps = service.Config.BindsTo
+		}
+		if containsName(deps, name) {
+			result = append(result, other)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// conflicting 返回与 name 互斥的服务，conflicts 在任一方声明即生效
+func (sm *ServiceManager) conflicting(name string) []string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	service, exists := sm.services[name]
+	if !exists {
+		return nil
+	}
+	result := mergeNames(service.Config.Conflicts)
+	for other, s := range sm.services {
+		if containsName(s.Config.Conflicts, name) && !containsName(result, other) {
+			result = append(result, other)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// stopDependents 停止所有依赖 name 的运行中服务，更深层的依赖者先停止。
+// bindsOnly 只影响直接依赖者：被停止的服务的所有硬依赖者都会随之停止。
+// 返回按停止顺序排列的服务名
+func (sm *ServiceManager) stopDependents(name string, bindsOnly bool) ([]string, error) {
+	var stopped, errs []string
+	visited := map[string]bool{name: true}
+
+	var visit func(name string, bindsOnly bool)
+	visit = func(name string, bindsOnly bool) {
+		for _, dep := range sm.dependents(name, bindsOnly) {
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			visit(dep, false)
+
+			service, ok := sm.lookup(dep)
+			if !ok || service.GetStatus().State != StateRunning {
+				continue
+			}
+			logging.Info("Stopping dependent service", "service", dep, "dependency", name)
+			if err := service.Stop(); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", dep, err))
+				continue
+			}
+			stopped = append(stopped, dep)
+		}
+	}
+	visit(name, bindsOnly)
+
+	if len(errs) > 0 {
+		return stopped, fmt.Errorf("failed to stop dependents of %s: %s", name, strings.Join(errs, "; "))
+	}
+	return stopped, nil
+}
+
+// startAgain 按停止的反序重新启动因依赖传播而停止的服务
+func (sm *ServiceManager) startAgain(stopped []string) {
+	for i := len(stopped) - 1; i >= 0; i-- {
+		// 期间已被手动启动的服务不需要处理
+		if service, ok := sm.lookup(stopped[i]); !ok || service.active() {
+			continue
+		}
+		if err := sm.StartService(stopped[i]); err != nil {
+			logging.Warn("Failed to start service again", "service", stopped[i], "error", err)
+		}
+	}
+}
+
+// handleDependencyEvent 在服务失败或停止时传播到依赖者：
+// 失败时停止 requires/binds_to 它的服务，停止时停止 binds_to 它的服务。
+// 因失败而停止的依赖者在该服务重新启动后恢复。
+// 只在单个订阅协程中调用，propagated 不需要加锁
+func (sm *ServiceManager) handleDependencyEvent(event ServiceEvent) {
+	switch event.Type {
+	case EventFailed:
+		stopped, err := sm.stopDependents(event.Service, false)
+		if err != nil {
+			logging.Warn("Failed to stop dependents", "service", event.Service, "error", err)
+		}
+		sm.propagated[event.Service] = mergeNames(sm.propagated[event.Service], stopped)
+	case EventStopped:
+		// 处理事件时服务可能已经重新启动（例如 RestartService）
+		if service, ok := sm.lookup(event.Service); !ok || service.GetStatus().State == StateRunning {
+			return
+		}
+		if _, err := sm.stopDependents(event.Service, true); err != nil {
+			logging.Warn("Failed to stop dependents", "service", event.Service, "error", err)
+		}
+	case EventStarted:
+		if stopped := sm.propagated[event.Service]; len(stopped) > 0 {
+			delete(sm.propagated, event.Service)
+			logging.Info("Dependency is back, restarting dependents", "service", event.Service, "dependents", strings.Join(stopped, ","))
+			sm.startAgain(stopped)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"sync"
+
+	"ldh-os/init/logging"
+)
+
+// deviceTable 记录当前存在的设备，用于判断设备触发的服务是否应该启动
+type deviceTable struct {
+	devices map[string]DeviceEvent // devpath -> 最近一次 add/change 事件
+	mu      sync.RWMutex
+}
+
+func newDeviceTable() *deviceTable {
+	return &deviceTable{devices: make(map[string]DeviceEvent)}
+}
+
+// present 判断是否存在满足任一匹配条件的设备
+func (t *deviceTable) present(matches []DeviceMatch) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, dev := range t.devices {
+		for _, m := range matches {
+			if m.Matches(dev) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleDeviceEvent 更新设备表，并启动等待该设备的服务
+func (sm *ServiceManager) handleDeviceEvent(event ServiceEvent) {
+	dev, ok := event.Data.(DeviceEvent)
+	if !ok {
+		return
+	}
+
+	sm.devices.mu.Lock()
+	if event.Type == EventDeviceRemoved {
+		delete(sm.devices.devices, dev.DevPath)
+	} else {
+		sm.devices.devices[dev.DevPath] = dev
+	}
+	sm.devices.mu.Unlock()
+
+	if event.Type != EventDeviceAdded {
+		return
+	}
+
+	sm.mu.RLock()
+	var matched []string
+	for name, service := range sm.services {
+		for _, m := range service.Config.StartOnDevice {
+			if m.Matches(dev) && service.GetStatus().State != StateRunning && sm.inTargetLocked(name) {
+				matched = append(matched, name)
+				break
+			}
+		}
+	}
+	sm.mu.RUnlock()
+
+	for _, name := range matched {
+		logging.Info("Device appeared, starting service", "service", name, "device", dev.DevPath)
+		if err := sm.StartService(name); err != nil {
+			logging.Warn("Failed to start device service", "service", name, "error", err)
+		}
+	}
+}
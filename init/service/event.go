@@ -1,88 +1,605 @@
 package service
 
 import (
+	"fmt"
+	"path"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"ldh-os/init/logging"
 )
 
 // ServiceEvent 定义服务事件
 type ServiceEvent struct {
-	Type      EventType
-	Service   string
-	Data      interface{}
-	Timestamp time.Time
+	Seq       uint64      `json:"seq"` // 事件总线分配的单调递增序号
+	Type      EventType   `json:"type"`
+	Service   string      `json:"service,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
 }
 
 // EventHandler 定义事件处理函数类型
 type EventHandler func(event ServiceEvent)
 
+// EventFilter 描述订阅者关心的事件
+type EventFilter struct {
+	Types   []EventType `json:"types,omitempty"`   // 为空表示所有类型
+	Service string      `json:"service,omitempty"` // 服务名通配符（path.Match 语法），为空表示所有服务
+}
+
+// Matches 判断事件是否满足过滤条件
+func (f EventFilter) Matches(event ServiceEvent) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == event.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Service != "" {
+		ok, err := path.Match(f.Service, event.Service)
+		if err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	// DefaultQueueSize 订阅者事件队列的默认长度，队列满时新事件被丢弃
+	DefaultQueueSize = 256
+	// SlowHandlerThreshold 处理单个事件超过该时间的订阅者被记为慢订阅者
+	SlowHandlerThreshold = 100 * time.Millisecond
+	// SyncTimeout EmitSync 等待所有订阅者处理完成的最长时间
+	SyncTimeout = time.Second
+	// journalQueueSize 等待写入磁盘事件日志的最大事件数，磁盘卡住导致队列满时新事件不再写入日志
+	journalQueueSize = 4096
+)
+
+// queuedEvent 订阅者队列中的事件，ack 不为空时表示有 EmitSync 在等待
+type queuedEvent struct {
+	event ServiceEvent
+	ack   *sync.WaitGroup
+}
+
+// Subscription 表示一个事件订阅。每个订阅者拥有独立的有序队列和处理协程，
+// 处理函数的 panic 不会影响其他订阅者
+type Subscription struct {
+	id      uint64
+	bus     *EventBus
+	filter  EventFilter
+	handler EventHandler
+	queue   chan queuedEvent
+	done    chan struct{}
+	once    sync.Once
+
+	// 内部订阅者使用不限长度的队列，事件从不丢弃
+	unbounded bool
+	pending   []queuedEvent
+	pendingMu sync.Mutex
+	wake      chan struct{}
+
+	delivered uint64
+	dropped   uint64
+	panics    uint64
+	slow      uint64
+}
+
+// SubscriberStats 单个订阅者的统计信息
+type SubscriberStats struct {
+	ID        uint64      `json:"id"`
+	Filter    EventFilter `json:"filter"`
+	Delivered uint64      `json:"delivered"`
+	Dropped   uint64      `json:"dropped"`
+	Panics    uint64      `json:"panics"`
+	Slow      uint64      `json:"slow"`
+	Queued    int         `json:"queued"`
+	QueueSize int         `json:"queue_size"` // 0 表示队列不限长度
+
+}
+
+// EventBusStats 事件总线统计信息
+type EventBusStats struct {
+	Emitted     uint64            `json:"emitted"`
+	Dropped     uint64            `json:"dropped"`
+	Subscribers []SubscriberStats `json:"subscribers"`
+}
+
 // EventBus 实现事件总线
 type EventBus struct {
-	handlers map[EventType][]EventHandler
-	mu       sync.RWMutex
+	subscribers map[uint64]*Subscription
+	nextID      uint64
+	seq         uint64
+	emitted     uint64
+	dropped     uint64
+	history     *eventRing    // 最近的事件，供订阅者断线后补齐
+	journal     *EventJournal // 可选的磁盘事件日志
+	writer      *journalWriter
+	journalFull bool       // 已报告过日志队列满
+	mu          sync.Mutex // 保护订阅表，并保证所有订阅者看到相同的事件顺序
+}
+
+// journalEntry 交给日志写入协程的事件；flushed 不为空时表示等待之前的事件写完
+type journalEntry struct {
+	event   ServiceEvent
+	flushed chan struct{}
+}
+
+// journalWriter 按序号顺序把事件写入磁盘日志的协程
+type journalWriter struct {
+	queue chan journalEntry
+	done  chan struct{} // 关闭后协程退出
 }
 
 // NewEventBus 创建新的事件总线
 func NewEventBus() *EventBus {
 	return &EventBus{
-		handlers: make(map[EventType][]EventHandler),
+		subscribers: make(map[uint64]*Subscription),
+		history:     newEventRing(DefaultHistorySize),
 	}
 }
 
-// Subscribe 订阅特定类型的事件
-func (eb *EventBus) Subscribe(eventType EventType, handler EventHandler) {
+// SetJournal 启用磁盘事件日志。序号从日志中最大的序号继续，
+// 使重启或 re-exec 后的事件序号仍然单调递增。
+// 事件由单独的协程按序号顺序写入，Emit 不等待磁盘
+func (eb *EventBus) SetJournal(j *EventJournal) {
+	last := j.LastSeq()
+	w := &journalWriter{queue: make(chan journalEntry, journalQueueSize), done: make(chan struct{})}
+	go w.run(j)
+
 	eb.mu.Lock()
 	defer eb.mu.Unlock()
+	if eb.writer != nil {
+		close(eb.writer.done)
+	}
+	eb.journal = j
+	eb.writer = w
+	eb.journalFull = false
+	if last > eb.seq {
+		eb.seq = last
+	}
+}
 
-	if _, exists := eb.handlers[eventType]; !exists {
-		eb.handlers[eventType] = []EventHandler{}
+// run 按入队顺序写入事件，直到 done 被关闭
+func (w *journalWriter) run(j *EventJournal) {
+	reported := false
+	for {
+		select {
+		case <-w.done:
+			return
+		case entry := <-w.queue:
+			if entry.flushed != nil {
+				close(entry.flushed)
+				continue
+			}
+			if err := j.Append(entry.event); err != nil && !reported {
+				reported = true
+				logging.Warn("Failed to write event journal", "error", err)
+			}
+		}
 	}
-	eb.handlers[eventType] = append(eb.handlers[eventType], handler)
 }
 
-// Unsubscribe 取消订阅特定类型的事件
-func (eb *EventBus) Unsubscribe(eventType EventType, handler EventHandler) {
+// FlushJournal 等待已发送的事件写入磁盘日志，最多等待 timeout，返回是否写完。
+// 在 re-exec 和关机之前调用
+func (eb *EventBus) FlushJournal(timeout time.Duration) bool {
+	eb.mu.Lock()
+	w := eb.writer
+	eb.mu.Unlock()
+	if w == nil {
+		return true
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	flushed := make(chan struct{})
+	select {
+	case w.queue <- journalEntry{flushed: flushed}:
+	case <-w.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+	select {
+	case <-flushed:
+		return true
+	case <-w.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Subscribe 订阅特定类型的事件，eventType 为空表示订阅所有事件
+func (eb *EventBus) Subscribe(eventType EventType, handler EventHandler) *Subscription {
+	filter := EventFilter{}
+	if eventType != "" {
+		filter.Types = []EventType{eventType}
+	}
+	return eb.SubscribeFilter(filter, handler, DefaultQueueSize)
+}
+
+// SubscribeFilter 按过滤条件订阅事件，queueSize <= 0 时使用默认队列长度。
+// 队列满时事件被丢弃，适用于外部和 MCP 订阅者
+func (eb *EventBus) SubscribeFilter(filter EventFilter, handler EventHandler, queueSize int) *Subscription {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	return eb.add(&Subscription{
+		filter:  filter,
+		handler: handler,
+		queue:   make(chan queuedEvent, queueSize),
+		done:    make(chan struct{}),
+	})
+}
+
+// subscribeInternal 为服务管理器自身的控制逻辑（依赖传播、看门狗、路径触发等）订阅事件。
+// 队列不限长度，事件突发时也不会丢失
+func (eb *EventBus) subscribeInternal(filter EventFilter, handler EventHandler) *Subscription {
+	return eb.add(&Subscription{
+		filter:    filter,
+		handler:   handler,
+		done:      make(chan struct{}),
+		unbounded: true,
+		wake:      make(chan struct{}, 1),
+	})
+}
+
+// add 登记订阅者并启动处理协程
+func (eb *EventBus) add(sub *Subscription) *Subscription {
 	eb.mu.Lock()
 	defer eb.mu.Unlock()
 
-	if handlers, exists := eb.handlers[eventType]; exists {
-		for i, h := range handlers {
-			if &h == &handler {
-				eb.handlers[eventType] = append(handlers[:i], handlers[i+1:]...)
-				break
+	eb.nextID++
+	sub.id = eb.nextID
+	sub.bus = eb
+	eb.subscribers[sub.id] = sub
+	go sub.run()
+	return sub
+}
+
+// Unsubscribe 取消订阅，等价于 sub.Cancel()
+func (eb *EventBus) Unsubscribe(sub *Subscription) {
+	if sub != nil {
+		sub.Cancel()
+	}
+}
+
+// Emit 异步发送事件，返回分配的序号。
+// 事件按发送顺序进入每个订阅者的队列，队列已满的外部订阅者会丢弃该事件
+func (eb *EventBus) Emit(event ServiceEvent) uint64 {
+	seq, _ := eb.dispatch(event, nil)
+	return seq
+}
+
+// EmitSync 发送事件并等待所有匹配的订阅者处理完成，最多等待 SyncTimeout。
+// 订阅者处理函数中不应再调用 EmitSync 发送自己订阅的事件
+func (eb *EventBus) EmitSync(event ServiceEvent) uint64 {
+	var wg sync.WaitGroup
+	seq, n := eb.dispatch(event, &wg)
+	if n == 0 {
+		return seq
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(SyncTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		logging.Warn("Event not handled in time", "event", event.Type, "seq", seq, "timeout", SyncTimeout)
+	}
+	return seq
+}
+
+// dispatch 分配序号并将事件放入匹配订阅者的队列，返回序号和成功入队的订阅者数
+func (eb *EventBus) dispatch(event ServiceEvent, ack *sync.WaitGroup) (uint64, int) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	eb.seq++
+	event.Seq = eb.seq
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	eb.emitted++
+
+	eb.history.push(event)
+	if eb.writer != nil {
+		// 持有 eb.mu 时只入队，磁盘写入由写入协程完成
+		select {
+		case eb.writer.queue <- journalEntry{event: event}:
+		default:
+			if !eb.journalFull {
+				eb.journalFull = true
+				logging.Warn("Event journal queue full, events are not being written", "seq", event.Seq)
 			}
 		}
 	}
+
+	queued := 0
+	for _, sub := range eb.subscribers {
+		if !sub.filter.Matches(event) {
+			continue
+		}
+		if ack != nil {
+			ack.Add(1)
+		}
+		if sub.unbounded {
+			sub.enqueue(queuedEvent{event: event, ack: ack})
+			queued++
+			continue
+		}
+		select {
+		case sub.queue <- queuedEvent{event: event, ack: ack}:
+			queued++
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+			eb.dropped++
+			if ack != nil {
+				ack.Done()
+			}
+		}
+	}
+	return event.Seq, queued
+}
+
+// ResumeSeq 让事件序号从 seq 之后继续，用于 re-exec 后保持序号单调递增
+func (eb *EventBus) ResumeSeq(seq uint64) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	if seq > eb.seq {
+		eb.seq = seq
+	}
+}
+
+// LastSeq 返回最近一次分配的事件序号
+func (eb *EventBus) LastSeq() uint64 {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	return eb.seq
+}
+
+// History 返回序号大于 since 且满足过滤条件的历史事件，limit <= 0 表示不限数量。
+// 优先从内存环形缓冲区读取，不够时读取磁盘日志；
+// complete 为 false 表示 since 之后的部分事件已经无法找回
+func (eb *EventBus) History(since uint64, filter EventFilter, limit int) (events []ServiceEvent, complete bool, err error) {
+	eb.mu.Lock()
+	complete = true
+	if since > eb.seq {
+		// 序号来自另一个事件总线实例（例如没有日志时重启前的序号），从头补发
+		since = 0
+		complete = false
+	}
+	if since == eb.seq {
+		eb.mu.Unlock()
+		return nil, complete, nil
+	}
+	covered := eb.history.count > 0 && since+1 >= eb.history.oldest()
+	events = eb.history.since(since, filter, limit)
+	journal := eb.journal
+	eb.mu.Unlock()
+
+	if covered {
+		return events, complete, nil
+	}
+	if journal == nil {
+		return events, false, nil
+	}
+	// 已经移出环形缓冲区的事件可能还在写入队列中
+	eb.FlushJournal(SyncTimeout)
+
+	// 磁盘日志包含环形缓冲区中的所有事件
+	fromJournal, oldest, err := journal.Since(since, filter, limit)
+	if err != nil {
+		return events, false, fmt.Errorf("failed to read event journal: %v", err)
+	}
+	return fromJournal, complete && oldest != 0 && since+1 >= oldest, nil
+}
+
+// Stats 返回事件总线和各订阅者的统计信息
+func (eb *EventBus) Stats() EventBusStats {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	stats := EventBusStats{
+		Emitted:     eb.emitted,
+		Dropped:     eb.dropped,
+		Subscribers: make([]SubscriberStats, 0, len(eb.subscribers)),
+	}
+	for _, sub := range eb.subscribers {
+		stats.Subscribers = append(stats.Subscribers, sub.Stats())
+	}
+	return stats
 }
 
-// Emit 发送事件
-func (eb *EventBus) Emit(event ServiceEvent) {
-	eb.mu.RLock()
-	defer eb.mu.RUnlock()
+// Cancel 取消订阅，尚未处理的事件不再投递。可以在处理函数中调用
+func (s *Subscription) Cancel() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subscribers, s.id)
+		s.bus.mu.Unlock()
+		close(s.done)
+	})
+}
+
+// Stats 返回订阅者的统计信息
+func (s *Subscription) Stats() SubscriberStats {
+	queued := len(s.queue)
+	if s.unbounded {
+		s.pendingMu.Lock()
+		queued = len(s.pending)
+		s.pendingMu.Unlock()
+	}
+	return SubscriberStats{
+		ID:        s.id,
+		Filter:    s.filter,
+		Delivered: atomic.LoadUint64(&s.delivered),
+		Dropped:   atomic.LoadUint64(&s.dropped),
+		Panics:    atomic.LoadUint64(&s.panics),
+		Slow:      atomic.LoadUint64(&s.slow),
+		Queued:    queued,
+		QueueSize: cap(s.queue),
+	}
+}
 
-	// 调用所有注册的处理函数
-	if handlers, exists := eb.handlers[event.Type]; exists {
-		for _, handler := range handlers {
-			go handler(event)
+// enqueue 把事件追加到不限长度的队列并唤醒处理协程
+func (s *Subscription) enqueue(qe queuedEvent) {
+	s.pendingMu.Lock()
+	s.pending = append(s.pending, qe)
+	s.pendingMu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run 按顺序处理队列中的事件，直到订阅被取消
+func (s *Subscription) run() {
+	if s.unbounded {
+		s.runUnbounded()
+		return
+	}
+	for {
+		select {
+		case <-s.done:
+			// 释放仍在等待的 EmitSync
+			for {
+				select {
+				case qe := <-s.queue:
+					if qe.ack != nil {
+						qe.ack.Done()
+					}
+				default:
+					return
+				}
+			}
+		case qe := <-s.queue:
+			s.deliver(qe.event)
+			if qe.ack != nil {
+				qe.ack.Done()
+			}
+		}
+	}
+}
+
+// runUnbounded 按顺序处理不限长度队列中的事件，直到订阅被取消
+func (s *Subscription) runUnbounded() {
+	for {
+		select {
+		case <-s.done:
+			s.release(nil)
+			return
+		case <-s.wake:
+		}
+		for {
+			s.pendingMu.Lock()
+			batch := s.pending
+			s.pending = nil
+			s.pendingMu.Unlock()
+			if len(batch) == 0 {
+				break
+			}
+			for i, qe := range batch {
+				select {
+				case <-s.done:
+					s.release(batch[i:])
+					return
+				default:
+				}
+				s.deliver(qe.event)
+				if qe.ack != nil {
+					qe.ack.Done()
+				}
+			}
 		}
 	}
+}
 
-	// 调用通用事件处理函数（如果有的话）
-	if handlers, exists := eb.handlers[""]; exists {
-		for _, handler := range handlers {
-			go handler(event)
+// release 订阅取消后释放未处理事件上仍在等待的 EmitSync
+func (s *Subscription) release(batch []queuedEvent) {
+	s.pendingMu.Lock()
+	batch = append(batch, s.pending...)
+	s.pending = nil
+	s.pendingMu.Unlock()
+	for _, qe := range batch {
+		if qe.ack != nil {
+			qe.ack.Done()
 		}
 	}
 }
 
-// EmitSync 同步发送事件
-func (eb *EventBus) EmitSync(event ServiceEvent) {
-	eb.mu.RLock()
-	defer eb.mu.RUnlock()
+// deliver 调用处理函数，隔离 panic 并统计慢处理
+func (s *Subscription) deliver(event ServiceEvent) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddUint64(&s.panics, 1)
+			logging.Error("Event handler panicked", "handler", s.id, "event", event.Type, "seq", event.Seq, "panic", r, "stack", string(debug.Stack()))
+		}
+		if time.Since(start) > SlowHandlerThreshold {
+			atomic.AddUint64(&s.slow, 1)
+		}
+	}()
 
-	if handlers, exists := eb.handlers[event.Type]; exists {
-		for _, handler := range handlers {
-			handler(event)
+	s.handler(event)
+	atomic.AddUint64(&s.delivered, 1)
+}
+
+// logEvent 把事件写入 init 日志：服务启动、停止和重启为一般日志，
+// 失败、不健康、资源压力升高和 OOM 为警告，其余事件只在调试级别输出
+func logEvent(event ServiceEvent) {
+	level := logging.LevelDebug
+	switch event.Type {
+	case EventStarted, EventStopped, EventRestarting:
+		level = logging.LevelInfo
+	case EventFailed, EventUnhealthy, EventOOMKill:
+		level = logging.LevelWarn
+	case EventPressure:
+		level = logging.LevelWarn
+		if pe, ok := event.Data.(PressureEvent); ok && pe.Level == PressureNormal {
+			level = logging.LevelInfo
 		}
 	}
+	if !logging.Enabled(level) {
+		return
+	}
+
+	kv := []interface{}{"event", event.Type, "seq", event.Seq}
+	if event.Service != "" {
+		kv = append(kv, "service", event.Service)
+	}
+	if lc, ok := event.Data.(LifecycleEvent); ok {
+		if lc.Pid != 0 {
+			kv = append(kv, "pid", lc.Pid)
+		}
+		if lc.Reason != "" {
+			kv = append(kv, "reason", lc.Reason)
+		}
+		if lc.Attempt != 0 {
+			kv = append(kv, "attempt", lc.Attempt)
+		}
+	}
+	if pe, ok := event.Data.(PressureEvent); ok {
+		kv = append(kv, "resource", pe.Resource, "level", pe.Level)
+		if pe.Action != "" {
+			kv = append(kv, "action", pe.Action)
+		}
+	}
+	if oe, ok := event.Data.(OOMEvent); ok {
+		kv = append(kv, "kills", oe.Kills)
+	}
+	logging.Log(level, "Service event", kv...)
 }
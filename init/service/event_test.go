@@ -0,0 +1,232 @@
+package service
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventBus(t *testing.T) {
+	eb := NewEventBus()
+
+	// 通配订阅者必须按发送顺序收到所有事件，包括 EmitSync 发送的事件
+	var mu sync.Mutex
+	var seqs []uint64
+	all := eb.Subscribe("", func(e ServiceEvent) {
+		mu.Lock()
+		seqs = append(seqs, e.Seq)
+		mu.Unlock()
+	})
+
+	// 只关心 llm-* 服务的失败事件
+	filtered := make(chan ServiceEvent, 10)
+	eb.SubscribeFilter(EventFilter{Types: []EventType{EventFailed}, Service: "llm-*"}, func(e ServiceEvent) {
+		filtered <- e
+	}, 0)
+
+	// panic 的订阅者不能影响其他订阅者
+	panicky := eb.Subscribe(EventFailed, func(e ServiceEvent) { panic("boom") })
+
+	for i := 0; i < 50; i++ {
+		eb.Emit(ServiceEvent{Type: EventStarted, Service: "syslog"})
+	}
+	eb.EmitSync(ServiceEvent{Type: EventFailed, Service: "syslog"})
+	last := eb.EmitSync(ServiceEvent{Type: EventFailed, Service: "llm-agent"})
+
+	mu.Lock()
+	if len(seqs) != 52 {
+		t.Errorf("Expected 52 events for wildcard subscriber, got %d", len(seqs))
+	}
+	for i := 1; i < len(seqs); i++ {
+		if seqs[i] != seqs[i-1]+1 {
+			t.Errorf("Events out of order: %v", seqs)
+			break
+		}
+	}
+	mu.Unlock()
+
+	select {
+	case e := <-filtered:
+		if e.Service != "llm-agent" || e.Seq != last {
+			t.Errorf("Unexpected filtered event: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("Filtered subscriber did not receive llm-agent failure")
+	}
+	if len(filtered) != 0 {
+		t.Errorf("Filtered subscriber received %d unexpected events", len(filtered))
+	}
+	if stats := panicky.Stats(); stats.Panics != 2 {
+		t.Errorf("Expected 2 isolated panics, got %d", stats.Panics)
+	}
+
+	// 取消订阅后不再收到事件
+	all.Cancel()
+	eb.EmitSync(ServiceEvent{Type: EventStarted, Service: "syslog"})
+	mu.Lock()
+	if len(seqs) != 52 {
+		t.Errorf("Cancelled subscriber still received events")
+	}
+	mu.Unlock()
+}
+
+func TestEventBusDropsForSlowSubscriber(t *testing.T) {
+	eb := NewEventBus()
+	release := make(chan struct{})
+	sub := eb.SubscribeFilter(EventFilter{}, func(e ServiceEvent) { <-release }, 2)
+
+	for i := 0; i < 10; i++ {
+		eb.Emit(ServiceEvent{Type: EventStarted, Service: "slow"})
+	}
+	close(release)
+
+	stats := eb.Stats()
+	if stats.Emitted != 10 || stats.Dropped == 0 {
+		t.Errorf("Expected dropped events to be counted, got %+v", stats)
+	}
+	if sub.Stats().Dropped != stats.Dropped {
+		t.Errorf("Subscriber drop count %d does not match bus count %d", sub.Stats().Dropped, stats.Dropped)
+	}
+	sub.Cancel()
+}
+
+func TestInternalSubscriberNeverDrops(t *testing.T) {
+	eb := NewEventBus()
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var seqs []uint64
+	sub := eb.subscribeInternal(EventFilter{}, func(e ServiceEvent) {
+		<-release
+		mu.Lock()
+		seqs = append(seqs, e.Seq)
+		mu.Unlock()
+	})
+
+	// 处理函数阻塞期间的事件远多于默认队列长度
+	n := DefaultQueueSize * 4
+	for i := 0; i < n; i++ {
+		eb.Emit(ServiceEvent{Type: EventStarted, Service: "burst"})
+	}
+	close(release)
+	eb.EmitSync(ServiceEvent{Type: EventStopped, Service: "burst"})
+
+	mu.Lock()
+	if len(seqs) != n+1 {
+		t.Errorf("Expected %d events for internal subscriber, got %d", n+1, len(seqs))
+	}
+	for i := 1; i < len(seqs); i++ {
+		if seqs[i] != seqs[i-1]+1 {
+			t.Errorf("Events out of order at %d: %d after %d", i, seqs[i], seqs[i-1])
+			break
+		}
+	}
+	mu.Unlock()
+	if stats := eb.Stats(); stats.Dropped != 0 {
+		t.Errorf("Expected no dropped events, got %d", stats.Dropped)
+	}
+	sub.Cancel()
+}
+
+func TestEventHistoryAndJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	journal, err := OpenEventJournal(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eb := NewEventBus()
+	eb.history = newEventRing(4)
+	eb.SetJournal(journal)
+	for i := 0; i < 10; i++ {
+		svc := "a"
+		if i%2 == 1 {
+			svc = "b"
+		}
+		eb.Emit(ServiceEvent{Type: EventStarted, Service: svc})
+	}
+	// 日志由单独的协程写入
+	if !eb.FlushJournal(time.Second) {
+		t.Fatal("Event journal not flushed in time")
+	}
+	if last := journal.LastSeq(); last != 10 {
+		t.Fatalf("Expected journal to contain seq 10, got %d", last)
+	}
+
+	// 环形缓冲区只保留最后 4 个事件，更早的从日志读取
+	events, complete, err := eb.History(2, EventFilter{Service: "b"}, 0)
+	if err != nil || !complete {
+		t.Fatalf("History failed: complete=%v err=%v", complete, err)
+	}
+	if len(events) != 4 || events[0].Seq != 4 || events[3].Seq != 10 {
+		t.Fatalf("Unexpected history: %+v", events)
+	}
+
+	events, complete, _ = eb.History(8, EventFilter{}, 0)
+	if len(events) != 2 || !complete {
+		t.Fatalf("Expected 2 events from the ring, got %d (complete=%v)", len(events), complete)
+	}
+
+	// 新的事件总线从日志中的序号继续
+	journal.Close()
+	journal, err = OpenEventJournal(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	restarted := NewEventBus()
+	restarted.SetJournal(journal)
+	if seq := restarted.Emit(ServiceEvent{Type: EventStopped, Service: "a"}); seq != 11 {
+		t.Errorf("Expected seq 11 after restart, got %d", seq)
+	}
+	events, complete, _ = restarted.History(9, EventFilter{}, 0)
+	if len(events) != 2 || !complete {
+		t.Errorf("Expected events 10 and 11 after restart, got %+v", events)
+	}
+
+	// 没有日志时，超出环形缓冲区的请求不完整
+	eb2 := NewEventBus()
+	eb2.history = newEventRing(2)
+	for i := 0; i < 5; i++ {
+		eb2.Emit(ServiceEvent{Type: EventStarted})
+	}
+	if events, complete, _ := eb2.History(0, EventFilter{}, 0); complete || len(events) != 2 {
+		t.Errorf("Expected 2 events and incomplete history, got %d (complete=%v)", len(events), complete)
+	}
+}
+
+func TestEventFollow(t *testing.T) {
+	eb := NewEventBus()
+	for i := 0; i < 5; i++ {
+		eb.Emit(ServiceEvent{Type: EventStarted, Service: "a"})
+	}
+
+	stream, err := eb.Follow(2, EventFilter{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	// 消费者暂停期间订阅队列溢出，丢失的事件从历史中补齐
+	for i := 0; i < 20; i++ {
+		eb.Emit(ServiceEvent{Type: EventStopped, Service: "a"})
+	}
+
+	want := uint64(3)
+	timeout := time.After(5 * time.Second)
+	for want <= 25 {
+		select {
+		case e := <-stream.C:
+			if e.Seq != want {
+				t.Fatalf("Expected seq %d, got %d", want, e.Seq)
+			}
+			want++
+		case <-timeout:
+			t.Fatalf("Timed out waiting for seq %d", want)
+		}
+	}
+
+	stream.Close()
+	for range stream.C {
+	}
+}
@@ -0,0 +1,377 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"ldh-os/init/logging"
+)
+
+// JobMode 作业失败时的处理方式
+type JobMode string
+
+const (
+	JobPartial  JobMode = "partial"  // 保留已启动的服务，报告部分失败
+	JobRollback JobMode = "rollback" // 停止本作业启动的所有服务
+)
+
+// JobState 作业状态
+type JobState string
+
+const (
+	JobRunning    JobState = "running"
+	JobDone       JobState = "done"        // 所有服务均已运行
+	JobDegraded   JobState = "degraded"    // 请求的服务已运行，但部分 wants 服务失败
+	JobFailed     JobState = "failed"      // 请求的服务未能启动
+	JobRolledBack JobState = "rolled-back" // 失败后已停止本作业启动的服务
+)
+
+// UnitResult 作业中单个服务的结果
+type UnitResult string
+
+const (
+	UnitPending    UnitResult = "pending"
+	UnitActive     UnitResult = "active"  // 已在运行，无需操作
+	UnitStarted    UnitResult = "started" // 由本作业启动
+	UnitFailed     UnitResult = "failed"
+	UnitSkipped    UnitResult = "skipped" // 硬依赖失败，未启动
+	UnitRolledBack UnitResult = "rolled-back"
+)
+
+// JobUnit 作业中的一个服务
+type JobUnit struct {
+	Service  string     `json:"service"`
+	Reason   string     `json:"reason"`   // requested、required by X、wanted by X
+	Required bool       `json:"required"` // 是否是请求的服务的硬依赖（失败会导致作业失败）
+	Result   UnitResult `json:"result"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Job 一次启动事务：请求的服务及其依赖闭包，按依赖顺序启动
+type Job struct {
+	ID        uint64    `json:"id"`
+	Requested []string  `json:"requested"`
+	Mode      JobMode   `json:"mode"`
+	State     JobState  `json:"state"`
+	Units     []JobUnit `json:"units"`               // 按启动顺序排列
+	PulledIn  []string  `json:"pulled_in,omitempty"` // 因依赖关系被一并启动的服务
+	Created   time.Time `json:"created"`
+	Finished  time.Time `json:"finished"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// maxJobHistory 保留的最近作业数
+const maxJobHistory = 32
+
+// EventJobFinished 作业结束事件，负载为 Job
+const EventJobFinished EventType = "job-finished"
+
+// StartJob 启动 names 中的服务及其依赖闭包：requires/binds_to 的服务必须成功，
+// wants 的服务尽力启动。作业之间串行执行。请求的服务未能启动时返回错误，
+// JobRollback 模式下同时停止本作业已启动的服务
+func (sm *ServiceManager) StartJob(names []string, mode JobMode) (Job, error) {
+	for _, name := range names {
+		if _, exists := sm.lookup(name); !exists {
+			return Job{}, fmt.Errorf("service %s not found", name)
+		}
+	}
+
+	sm.jobMu.Lock()
+	defer sm.jobMu.Unlock()
+	return sm.startJob(names, mode)
+}
+
+// startJob 执行作业，调用方需持有 jobMu
+func (sm *ServiceManager) startJob(names []string, mode JobMode) (Job, error) {
+	if mode == "" {
+		mode = JobPartial
+	}
+
+	job := sm.newJob(names, mode)
+	if err := sm.checkJobConflicts(job); err != nil {
+		sm.jobsMu.Lock()
+		job.State = JobFailed
+		job.Error = err.Error()
+		sm.jobsMu.Unlock()
+		sm.finishJob(job)
+		return job.snapshot(), err
+	}
+
+	for i := range job.Units {
+		sm.runUnit(job, i)
+	}
+	sm.completeJob(job)
+	sm.finishJob(job)
+
+	snapshot := job.snapshot()
+	if job.State == JobFailed || job.State == JobRolledBack {
+		return snapshot, fmt.Errorf("%s", job.Error)
+	}
+	return snapshot, nil
+}
+
+// Jobs 返回最近的作业，最新的在最后
+func (sm *ServiceManager) Jobs() []Job {
+	sm.jobsMu.Lock()
+	defer sm.jobsMu.Unlock()
+
+	jobs := make([]Job, 0, len(sm.jobs))
+	for _, job := range sm.jobs {
+		jobs = append(jobs, job.snapshot())
+	}
+	return jobs
+}
+
+// closure 计算 names 的依赖闭包，返回每个服务被加入的原因，
+// 以及哪些服务是 names 的硬依赖（沿 requires/binds_to 可达）
+func (sm *ServiceManager) closure(names []string) (map[string]string, map[string]bool) {
+	requested := mergeNames(names)
+	reasons := make(map[string]string)
+	required := make(map[string]bool)
+
+	// 第一遍只沿硬依赖遍历，确定哪些服务是必需的
+	var walk func(name, reason string, hardOnly bool)
+	walk = func(name, reason string, hardOnly bool) {
+		if hardOnly {
+			if required[name] {
+				return
+			}
+			required[name] = true
+		} else {
+			if _, seen := reasons[name]; seen {
+				return
+			}
+			reasons[name] = reason
+		}
+
+		service, exists := sm.lookup(name)
+		if !exists {
+			return
+		}
+		for _, dep := range service.Config.requirements() {
+			walk(dep, "required by "+name, hardOnly)
+		}
+		if !hardOnly {
+			for _, dep := range service.Config.Wants {
+				walk(dep, "wanted by "+name, false)
+			}
+		}
+	}
+	for _, name := range requested {
+		walk(name, "", true)
+	}
+	for _, name := range requested {
+		walk(name, "requested", false)
+	}
+	return reasons, required
+}
+
+// newJob 计算依赖闭包并按启动顺序排列，登记到作业列表
+func (sm *ServiceManager) newJob(names []string, mode JobMode) *Job {
+	requested := mergeNames(names)
+	reasons, required := sm.closure(requested)
+
+	all := make([]string, 0, len(reasons))
+	for name := range reasons {
+		all = append(all, name)
+	}
+	sort.Strings(all)
+
+	job := &Job{
+		Requested: requested,
+		Mode:      mode,
+		State:     JobRunning,
+		Created:   time.Now(),
+	}
+	for _, name := range orderNames(all, sm.orderingEdges()) {
+		job.Units = append(job.Units, JobUnit{
+			Service:  name,
+			Reason:   reasons[name],
+			Required: required[name],
+			Result:   UnitPending,
+		})
+	}
+
+	sm.jobsMu.Lock()
+	sm.nextJobID++
+	job.ID = sm.nextJobID
+	sm.jobs = append(sm.jobs, job)
+	if len(sm.jobs) > maxJobHistory {
+		sm.jobs = sm.jobs[len(sm.jobs)-maxJobHistory:]
+	}
+	sm.jobsMu.Unlock()
+	return job
+}
+
+// checkJobConflicts 拒绝同时包含两个互斥服务的作业
+func (sm *ServiceManager) checkJobConflicts(job *Job) error {
+	inJob := make(map[string]bool, len(job.Units))
+	for _, u := range job.Units {
+		inJob[u.Service] = true
+	}
+	for _, u := range job.Units {
+		for _, other := range sm.conflicting(u.Service) {
+			if inJob[other] {
+				return fmt.Errorf("job contains conflicting services %s and %s", u.Service, other)
+			}
+		}
+	}
+	return nil
+}
+
+// runUnit 启动作业中的第 i 个服务
+func (sm *ServiceManager) runUnit(job *Job, i int) {
+	name := job.Units[i].Service
+	result, err := sm.startUnit(job, name)
+
+	sm.jobsMu.Lock()
+	job.Units[i].Result = result
+	if err != nil {
+		job.Units[i].Error = err.Error()
+	}
+	sm.jobsMu.Unlock()
+}
+
+// startUnit 检查依赖并启动单个服务
+func (sm *ServiceManager) startUnit(job *Job, name string) (UnitResult, error) {
+	service, exists := sm.lookup(name)
+	if !exists {
+		return UnitFailed, fmt.Errorf("service %s not found", name)
+	}
+	if service.active() {
+		return UnitActive, nil
+	}
+	if sm.IsMasked(name) {
+		return UnitSkipped, fmt.Errorf("service %s is masked", name)
+	}
+
+	// 硬依赖都在本作业中且排在前面
+	for _, dep := range service.Config.requirements() {
+		if r := job.result(dep); r == UnitFailed || r == UnitSkipped {
+			return UnitSkipped, fmt.Errorf("dependency %s failed", dep)
+		}
+	}
+	if missing := sm.unsatisfied(name); len(missing) > 0 {
+		return UnitSkipped, fmt.Errorf("dependencies not satisfied: %v", missing)
+	}
+
+	// 停止作业之外的互斥服务
+	for _, other := range sm.conflicting(name) {
+		s, ok := sm.lookup(other)
+		if !ok || s.GetStatus().State != StateRunning {
+			continue
+		}
+		logging.Info("Stopping conflicting service", "service", other, "conflict", name)
+		if err := sm.StopService(other); err != nil {
+			return UnitFailed, fmt.Errorf("failed to stop conflicting service %s: %v", other, err)
+		}
+	}
+
+	if err := service.Start(); err != nil {
+		// 可能已被并发的操作启动
+		if service.active() {
+			return UnitActive, nil
+		}
+		return UnitFailed, err
+	}
+	return UnitStarted, nil
+}
+
+// completeJob 根据各服务的结果确定作业状态，必要时回滚
+func (sm *ServiceManager) completeJob(job *Job) {
+	var failed, degraded, pulledIn []string
+	for _, u := range job.Units {
+		if u.Result == UnitStarted && u.Reason != "requested" {
+			pulledIn = append(pulledIn, u.Service)
+		}
+		if u.Result != UnitFailed && u.Result != UnitSkipped {
+			continue
+		}
+		msg := fmt.Sprintf("%s: %s", u.Service, u.Error)
+		if u.Required {
+			failed = append(failed, msg)
+		} else {
+			degraded = append(degraded, msg)
+		}
+	}
+
+	state, message := JobDone, ""
+	switch {
+	case len(failed) > 0:
+		state = JobFailed
+		message = fmt.Sprintf("job %d failed: %s", job.ID, strings.Join(failed, "; "))
+		if job.Mode == JobRollback {
+			sm.rollback(job)
+			state = JobRolledBack
+		}
+	case len(degraded) > 0:
+		state = JobDegraded
+		message = "optional services failed: " + strings.Join(degraded, "; ")
+	}
+
+	sm.jobsMu.Lock()
+	job.State = state
+	job.Error = message
+	job.PulledIn = pulledIn
+	sm.jobsMu.Unlock()
+}
+
+// rollback 按启动的反序停止本作业启动的服务
+func (sm *ServiceManager) rollback(job *Job) {
+	for i := len(job.Units) - 1; i >= 0; i-- {
+		if job.Units[i].Result != UnitStarted {
+			continue
+		}
+		name := job.Units[i].Service
+		service, ok := sm.lookup(name)
+		if !ok || service.GetStatus().State != StateRunning {
+			continue
+		}
+		logging.Info("Rolling back job", "job", job.ID, "service", name)
+		if err := sm.StopService(name); err != nil {
+			logging.Warn("Failed to roll back service", "job", job.ID, "service", name, "error", err)
+			continue
+		}
+		sm.jobsMu.Lock()
+		job.Units[i].Result = UnitRolledBack
+		sm.jobsMu.Unlock()
+	}
+}
+
+// finishJob 记录结束时间并发送作业结束事件
+func (sm *ServiceManager) finishJob(job *Job) {
+	sm.jobsMu.Lock()
+	job.Finished = time.Now()
+	snapshot := job.snapshot()
+	sm.jobsMu.Unlock()
+
+	switch job.State {
+	case JobFailed, JobRolledBack:
+		logging.Warn("Job failed", "job", job.ID, "state", job.State, "error", job.Error)
+	case JobDegraded:
+		logging.Warn("Job degraded", "job", job.ID, "error", job.Error)
+	}
+	sm.eventBus.Emit(ServiceEvent{Type: EventJobFinished, Data: snapshot})
+}
+
+// result 返回服务在作业中的结果，不在作业中时返回空
+func (job *Job) result(name string) UnitResult {
+	for _, u := range job.Units {
+		if u.Service == name {
+			return u.Result
+		}
+	}
+	return ""
+}
+
+// snapshot 复制作业，切片不与原作业共享。
+// 作业结束前调用方需持有 jobsMu
+func (job *Job) snapshot() Job {
+	c := *job
+	c.Requested = append([]string(nil), job.Requested...)
+	c.Units = append([]JobUnit(nil), job.Units...)
+	c.PulledIn = append([]string(nil), job.PulledIn...)
+	return c
+}
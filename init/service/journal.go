@@ -0,0 +1,206 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultHistorySize 事件总线在内存中保留的最近事件数
+const DefaultHistorySize = 1024
+
+// eventRing 固定容量的事件环形缓冲区
+type eventRing struct {
+	events []ServiceEvent
+	start  int // 最旧事件的位置
+	count  int
+}
+
+func newEventRing(size int) *eventRing {
+	return &eventRing{events: make([]ServiceEvent, size)}
+}
+
+func (r *eventRing) push(event ServiceEvent) {
+	if len(r.events) == 0 {
+		return
+	}
+	idx := (r.start + r.count) % len(r.events)
+	r.events[idx] = event
+	if r.count < len(r.events) {
+		r.count++
+	} else {
+		r.start = (r.start + 1) % len(r.events)
+	}
+}
+
+// oldest 返回缓冲区中最旧事件的序号，缓冲区为空时返回 0
+func (r *eventRing) oldest() uint64 {
+	if r.count == 0 {
+		return 0
+	}
+	return r.events[r.start].Seq
+}
+
+// since 返回序号大于 seq 且满足过滤条件的事件
+func (r *eventRing) since(seq uint64, filter EventFilter, limit int) []ServiceEvent {
+	var result []ServiceEvent
+	for i := 0; i < r.count; i++ {
+		e := r.events[(r.start+i)%len(r.events)]
+		if e.Seq <= seq || !filter.Matches(e) {
+			continue
+		}
+		result = append(result, e)
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+	}
+	return result
+}
+
+// EventJournal 以 JSON Lines 格式把事件追加到磁盘，超过大小上限时轮转为 .1 文件
+type EventJournal struct {
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+	mu      sync.Mutex
+}
+
+// DefaultJournalSize 事件日志文件的默认大小上限
+const DefaultJournalSize = 4 << 20
+
+// OpenEventJournal 打开（或创建）事件日志文件
+func OpenEventJournal(path string, maxSize int64) (*EventJournal, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultJournalSize
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event journal: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &EventJournal{path: path, maxSize: maxSize, file: f, size: info.Size()}, nil
+}
+
+// Append 追加一个事件
+func (j *EventJournal) Append(event ServiceEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.size+int64(len(line)) > j.maxSize {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	return err
+}
+
+// rotate 将当前文件改名为 .1 并重新创建，调用方需持有锁
+func (j *EventJournal) rotate() error {
+	j.file.Close()
+	if err := os.Rename(j.path, j.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	j.file = f
+	j.size = 0
+	return nil
+}
+
+// Since 从磁盘读取序号大于 seq 且满足过滤条件的事件，按序号升序返回，
+// 同时返回日志中最旧事件的序号（日志为空时为 0）
+func (j *EventJournal) Since(seq uint64, filter EventFilter, limit int) ([]ServiceEvent, uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var result []ServiceEvent
+	var oldest uint64
+	for _, path := range []string{j.path + ".1", j.path} {
+		err := scanJournal(path, func(e ServiceEvent) bool {
+			if oldest == 0 || e.Seq < oldest {
+				oldest = e.Seq
+			}
+			if e.Seq > seq && filter.Matches(e) {
+				result = append(result, e)
+			}
+			return limit <= 0 || len(result) < limit
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+	}
+	return result, oldest, nil
+}
+
+// LastSeq 返回日志中最大的事件序号
+func (j *EventJournal) LastSeq() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var last uint64
+	for _, path := range []string{j.path + ".1", j.path} {
+		scanJournal(path, func(e ServiceEvent) bool {
+			if e.Seq > last {
+				last = e.Seq
+			}
+			return true
+		})
+	}
+	return last
+}
+
+// Close 关闭日志文件
+func (j *EventJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// scanJournal 逐行解析日志文件，fn 返回 false 时停止。文件不存在不是错误，损坏的行被跳过
+func scanJournal(path string, fn func(ServiceEvent) bool) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var e ServiceEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if !fn(e) {
+			break
+		}
+	}
+	return scanner.Err()
+}
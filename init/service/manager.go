@@ -3,9 +3,14 @@ package service
 import (
 	"fmt"
 	"io/ioutil"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"gopkg.in/yaml.v2"
+
+	"ldh-os/init/logging"
 )
 
 // ServiceManager 服务管理器
@@ -14,17 +19,49 @@ type ServiceManager struct {
 	stateManager *StateManager
 	eventBus     *EventBus
 	mcpHandler   *MCPHandler
+	devices      *deviceTable
+	propagated   map[string][]string // 服务 -> 因它失败而被停止的依赖者
+	jobs         []*Job              // 最近的作业，受 jobsMu 保护
+	nextJobID    uint64
+	jobMu        sync.Mutex // 串行执行作业
+	targets      *TargetsConfig
+	target       string // 当前目标
+	masked       map[string]bool
+	timers       map[string]*timer
+	pressure     *pressureMonitor
+	notify       string // 通知套接字的路径
+	output       OutputFunc
+	jobsMu       sync.Mutex
 	mu           sync.RWMutex
 }
 
 // NewServiceManager 创建新的服务管理器
 func NewServiceManager() *ServiceManager {
-	return &ServiceManager{
+	sm := &ServiceManager{
 		services:     make(map[string]*Service),
 		stateManager: NewStateManager(),
 		eventBus:     NewEventBus(),
 		mcpHandler:   NewMCPHandler(),
+		devices:      newDeviceTable(),
+		propagated:   make(map[string][]string),
+		targets:      DefaultTargets(),
+		masked:       make(map[string]bool),
+		timers:       make(map[string]*timer),
 	}
+	// 内部订阅者不丢弃事件，否则依赖传播和看门狗计时会在事件突发时悄悄丢失
+	sm.eventBus.subscribeInternal(EventFilter{Types: []EventType{EventDeviceAdded, EventDeviceRemoved}}, sm.handleDeviceEvent)
+	sm.eventBus.subscribeInternal(EventFilter{Types: []EventType{EventStarted, EventStopped, EventFailed}},
+		sm.handleDependencyEvent)
+	sm.eventBus.subscribeInternal(EventFilter{Types: []EventType{EventStarted, EventStopping, EventExited, EventStopped, EventFailed}},
+		sm.handleWatchdogEvent)
+	sm.eventBus.subscribeInternal(EventFilter{}, logEvent)
+	sm.registerSystemFunctions()
+	return sm
+}
+
+// EventBus 返回服务管理器使用的事件总线，供设备、网络等模块发布事件
+func (sm *ServiceManager) EventBus() *EventBus {
+	return sm.eventBus
 }
 
 // LoadServices 从配置文件加载服务
@@ -57,10 +94,38 @@ func (sm *ServiceManager) RegisterService(config ServiceConfig) error {
 	if _, exists := sm.services[config.Name]; exists {
 		return fmt.Errorf("service %s already exists", config.Name)
 	}
+	if config.Name == SystemService {
+		return fmt.Errorf("service name %s is reserved", config.Name)
+	}
+
+	if config.OOMScoreAdj != nil && (*config.OOMScoreAdj < -1000 || *config.OOMScoreAdj > 1000) {
+		return fmt.Errorf("oom_score_adj of service %s must be between -1000 and 1000", config.Name)
+	}
+	if config.StopOnPressure != "" && config.StopOnPressure.rank() < PressureWarning.rank() {
+		return fmt.Errorf("stop_on_pressure of service %s must be warning or critical", config.Name)
+	}
+	if config.Watchdog < 0 {
+		return fmt.Errorf("watchdog of service %s must not be negative", config.Name)
+	}
+	for _, trigger := range config.StartOnPath {
+		if _, _, err := trigger.validate(); err != nil {
+			return err
+		}
+	}
+	if config.Timer != nil {
+		t, err := newTimer(config.Name, *config.Timer)
+		if err != nil {
+			return err
+		}
+		sm.timers[config.Name] = t
+	}
 
 	service := NewService(config, sm.eventBus)
+	service.states = sm.stateManager
+	service.output = sm.output
+	service.notify = sm.notify
 	sm.services[config.Name] = service
-	sm.stateManager.SetDependencies(config.Name, config.Dependencies)
+	sm.stateManager.SetDependencies(config.Name, config.requirements())
 
 	// 注册 MCP 功能
 	for _, funcName := range config.MCPConfig.Functions {
@@ -70,58 +135,150 @@ func (sm *ServiceManager) RegisterService(config ServiceConfig) error {
 	return nil
 }
 
-// StartService 启动服务
-func (sm *ServiceManager) StartService(name string) error {
-	sm.mu.RLock()
-	service, exists := sm.services[name]
-	sm.mu.RUnlock()
+// SetOutput 设置服务标准输出和标准错误的去处，之后启动的进程生效。
+// 未设置时输出被丢弃；终端服务总是使用终端
+func (sm *ServiceManager) SetOutput(output OutputFunc) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.output = output
+	for _, service := range sm.services {
+		service.mu.Lock()
+		service.output = output
+		service.mu.Unlock()
+	}
+}
 
-	if !exists {
-		return fmt.Errorf("service %s not found", name)
+// EnablePersistence 启用服务状态持久化，并与上次保存的状态对账：
+// 恢复重启计数和最近错误，接管 PID 与启动时间都匹配的存活进程。
+// 状态文件无法读取或版本不符时返回错误，持久化保持关闭。
+// 应在 LoadServices 之后、StartAll 之前调用
+func (sm *ServiceManager) EnablePersistence(path string) error {
+	records, sameBoot, err := sm.stateManager.Open(path)
+	if err != nil {
+		return err
 	}
 
-	// 检查依赖
-	if !sm.stateManager.CheckDependencies(name) {
-		// 获取依赖列表以提供更好的错误信息
-		deps := sm.stateManager.GetDependencies(name)
-		return fmt.Errorf("dependencies not satisfied for service %s: %v", name, deps)
+	sm.reconcile(records, sameBoot)
+	return nil
+}
+
+// Snapshot 导出所有服务的运行时记录，用于 re-exec 时交接给新的 init 进程
+func (sm *ServiceManager) Snapshot() map[string]ServiceRecord {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	result := make(map[string]ServiceRecord, len(sm.services))
+	for name, service := range sm.services {
+		result[name] = newServiceRecord(service.GetStatus(), 0)
 	}
+	return result
+}
+
+// Freeze 暂停服务监管：等待进行中的作业和生命周期操作（包括崩溃重启）完成，
+// 并阻止新的操作开始，直到调用返回的 thaw。re-exec 从导出快照到 exec 期间保持冻结，
+// 快照之后服务不会再被启动、停止或重启
+func (sm *ServiceManager) Freeze() (thaw func()) {
+	sm.jobMu.Lock()
 
-	// 启动服务
-	if err := service.Start(); err != nil {
-		return fmt.Errorf("failed to start service %s: %v", name, err)
+	sm.mu.RLock()
+	names := make([]string, 0, len(sm.services))
+	for name := range sm.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	services := make([]*Service, 0, len(names))
+	for _, name := range names {
+		services = append(services, sm.services[name])
 	}
+	sm.mu.RUnlock()
 
-	// 更新状态管理器中的状态
-	sm.stateManager.UpdateState(name, service.Status.State)
+	// 按名称顺序加锁，生命周期操作一次只持有一个服务的 opMu
+	for _, service := range services {
+		service.opMu.Lock()
+	}
+	return func() {
+		for i := len(services) - 1; i >= 0; i-- {
+			services[i].opMu.Unlock()
+		}
+		sm.jobMu.Unlock()
+	}
+}
 
-	return nil
+// Restore 根据 Snapshot 导出的记录恢复监管，运行中的服务进程被直接接管
+func (sm *ServiceManager) Restore(records map[string]ServiceRecord) {
+	sm.reconcile(records, true)
 }
 
-// StopService 停止服务
-func (sm *ServiceManager) StopService(name string) error {
+// reconcile 将记录与当前服务及存活进程对账
+func (sm *ServiceManager) reconcile(records map[string]ServiceRecord, sameBoot bool) {
 	sm.mu.RLock()
-	service, exists := sm.services[name]
-	sm.mu.RUnlock()
+	defer sm.mu.RUnlock()
 
+	for name, rec := range records {
+		service, exists := sm.services[name]
+		if !exists {
+			continue
+		}
+
+		// 已经接管过的进程（例如持久化存储和 re-exec 状态同时存在）
+		if status := service.GetStatus(); status.State == StateRunning && status.Pid == rec.Pid {
+			continue
+		}
+
+		service.restoreCounters(rec.RestartCount, rec.LastError)
+
+		if sameBoot && rec.State == StateRunning && processMatches(rec.Pid, rec.ProcStart) {
+			if err := service.Adopt(rec.Pid, rec.StartTime); err != nil {
+				logging.Warn("Failed to adopt service", "service", name, "error", err)
+				continue
+			}
+			logging.Info("Adopted running service", "service", name, "pid", rec.Pid)
+			continue
+		}
+
+		// 记录中的进程已不存在，按已停止处理，但保留计数和历史
+		if rec.State == StateRunning || rec.State == StateStarting || rec.State == StateStopping {
+			service.setState(StateStopped, EventStopped, LifecycleEvent{})
+		}
+	}
+}
+
+// StartService 启动服务及其尚未运行的依赖闭包，失败时保留已启动的依赖
+func (sm *ServiceManager) StartService(name string) error {
+	_, err := sm.StartJob([]string{name}, JobPartial)
+	return err
+}
+
+// StopService 停止服务，requires 或 binds_to 它的运行中服务先被停止
+func (sm *ServiceManager) StopService(name string) error {
+	service, exists := sm.lookup(name)
 	if !exists {
 		return fmt.Errorf("service %s not found", name)
 	}
 
-	return service.Stop()
+	_, depErr := sm.stopDependents(name, false)
+	if err := service.Stop(); err != nil {
+		return err
+	}
+	return depErr
 }
 
-// RestartService 重启服务
+// RestartService 重启服务，依赖它的服务先停止，重启完成后恢复
 func (sm *ServiceManager) RestartService(name string) error {
-	sm.mu.RLock()
-	service, exists := sm.services[name]
-	sm.mu.RUnlock()
-
+	service, exists := sm.lookup(name)
 	if !exists {
 		return fmt.Errorf("service %s not found", name)
 	}
 
-	return service.Restart()
+	stopped, err := sm.stopDependents(name, false)
+	if err != nil {
+		return err
+	}
+	if err := service.Restart(); err != nil {
+		return err
+	}
+	sm.startAgain(stopped)
+	return nil
 }
 
 // GetServiceStatus 获取服务状态
@@ -160,65 +317,172 @@ func (sm *ServiceManager) createMCPHandler(service *Service, funcName string) MC
 		var err error
 		switch funcName {
 		case "start":
-			err = service.Start()
-			if err == nil {
-				sm.stateManager.UpdateState(service.Config.Name, service.Status.State)
+			// 参数 rollback 为 true 时失败会停止本次一并启动的依赖
+			rollback, err := paramBool(params, "rollback")
+			if err != nil {
+				return nil, err
 			}
-			return nil, err
-		case "stop":
-			err = service.Stop()
-			if err == nil {
-				sm.stateManager.UpdateState(service.Config.Name, service.Status.State)
+			mode := JobPartial
+			if rollback {
+				mode = JobRollback
 			}
+			return sm.StartJob([]string{service.Config.Name}, mode)
+		case "stop":
+			err = sm.StopService(service.Config.Name)
 			return nil, err
 		case "restart":
-			err = service.Restart()
-			if err == nil {
-				sm.stateManager.UpdateState(service.Config.Name, service.Status.State)
-			}
+			err = sm.RestartService(service.Config.Name)
 			return nil, err
 		case "status":
 			return service.GetStatus(), nil
+		case "metrics", "get_metrics":
+			limit, err := paramUint(params, "limit")
+			if err != nil {
+				return nil, err
+			}
+			return sm.Metrics(service.Config.Name, int(limit))
 		default:
 			return nil, fmt.Errorf("unknown function: %s", funcName)
 		}
 	}
 }
 
-// StartAll 启动所有服务
-func (sm *ServiceManager) StartAll() error {
+// dependencyOrder 按依赖和排序关系对所有服务做拓扑排序，先启动的服务排在前面
+func (sm *ServiceManager) dependencyOrder() []*Service {
+	edges := sm.orderingEdges()
+
 	sm.mu.RLock()
-	services := make([]*Service, 0, len(sm.services))
-	for _, service := range sm.services {
-		services = append(services, service)
+	defer sm.mu.RUnlock()
+
+	names := make([]string, 0, len(sm.services))
+	for name := range sm.services {
+		names = append(names, name)
 	}
-	sm.mu.RUnlock()
+	sort.Strings(names)
 
-	// 按依赖顺序启动服务
-	for _, service := range services {
-		if err := sm.StartService(service.Config.Name); err != nil {
-			return fmt.Errorf("failed to start service %s: %v", service.Config.Name, err)
+	order := make([]*Service, 0, len(names))
+	for _, name := range orderNames(names, edges) {
+		order = append(order, sm.services[name])
+	}
+	return order
+}
+
+// orderNames 按排序关系对 names 做拓扑排序，只考虑 names 内部的关系。
+// names 应已按名称排序以保证结果稳定；存在循环时按遍历顺序打破
+func orderNames(names []string, edges map[string][]string) []string {
+	include := make(map[string]bool, len(names))
+	for _, name := range names {
+		include[name] = true
+	}
+
+	visited := make(map[string]int) // 0: 未访问 1: 访问中 2: 已完成
+	order := make([]string, 0, len(names))
+	var visit func(name string)
+	visit = func(name string) {
+		if !include[name] || visited[name] != 0 {
+			return
 		}
+		visited[name] = 1
+		for _, dep := range edges[name] {
+			visit(dep)
+		}
+		visited[name] = 2
+		order = append(order, name)
+	}
+	for _, name := range names {
+		visit(name)
 	}
+	return order
+}
 
+// StartAll 在一个作业中按依赖顺序启动所有服务。单个服务启动失败不会中断整个流程，
+// 硬依赖失败的服务不会被启动，所有错误汇总返回
+func (sm *ServiceManager) StartAll() error {
+	var all []string
+	for _, service := range sm.dependencyOrder() {
+		all = append(all, service.Config.Name)
+	}
+	names := sm.startable(all)
+	if len(names) == 0 {
+		return nil
+	}
+
+	job, err := sm.StartJob(names, JobPartial)
+	if err != nil {
+		return err
+	}
+	if job.State == JobDegraded {
+		return fmt.Errorf("%s", job.Error)
+	}
 	return nil
 }
 
+// firstConflict 返回 names 中第一个出现在 conflicts 里的服务
+func firstConflict(conflicts, names []string) string {
+	for _, name := range names {
+		if containsName(conflicts, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// Mask 屏蔽服务：被屏蔽的服务不会被启动，无论是直接请求还是作为依赖
+func (sm *ServiceManager) Mask(names ...string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for _, name := range names {
+		sm.masked[name] = true
+	}
+}
+
+// IsMasked 判断服务是否被屏蔽
+func (sm *ServiceManager) IsMasked(name string) bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.masked[name]
+}
+
 // StopAll 停止所有服务
 func (sm *ServiceManager) StopAll() error {
-	sm.mu.RLock()
-	services := make([]*Service, 0, len(sm.services))
-	for _, service := range sm.services {
-		services = append(services, service)
-	}
-	sm.mu.RUnlock()
+	return sm.StopAllWithin(DefaultStopAllTimeout)
+}
+
+// DefaultStopAllTimeout 停止所有服务的默认总时限
+const DefaultStopAllTimeout = 90 * time.Second
+
+// StopAllWithin 按依赖顺序的反序停止所有运行中的服务，总耗时不超过 timeout。
+// 时限用尽后剩余服务直接 SIGKILL。单个服务停止失败不会中断整个流程
+func (sm *ServiceManager) StopAllWithin(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	services := sm.dependencyOrder()
 
-	// 按依赖顺序的反序停止服务
+	var errs []string
 	for i := len(services) - 1; i >= 0; i-- {
-		if err := sm.StopService(services[i].Config.Name); err != nil {
-			return fmt.Errorf("failed to stop service %s: %v", services[i].Config.Name, err)
+		service := services[i]
+		if service.GetStatus().State != StateRunning {
+			continue
+		}
+
+		stopTimeout := service.Config.StopTimeout
+		if stopTimeout <= 0 {
+			stopTimeout = DefaultStopTimeout
+		}
+		if remaining := time.Until(deadline); remaining < stopTimeout {
+			stopTimeout = remaining
+		}
+		if stopTimeout <= 0 {
+			// 时限已到，不再给服务优雅退出的机会
+			stopTimeout = time.Millisecond
+		}
+
+		if err := service.StopTimeout(stopTimeout); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", service.Config.Name, err))
 		}
 	}
 
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to stop services: %s", strings.Join(errs, "; "))
+	}
 	return nil
 }
@@ -1,12 +1,28 @@
 package service
 
 import (
+	"bufio"
+	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
+// TestMain 处理套接字激活的跳板：测试中服务进程以测试二进制启动（/proc/self/exe）
+func TestMain(m *testing.M) {
+	RunActivationTrampoline()
+	os.Exit(m.Run())
+}
+
 func TestServiceManager(t *testing.T) {
 	// 创建临时配置文件
 	tmpDir := t.TempDir()
@@ -159,3 +175,1496 @@ func TestServiceManagerErrors(t *testing.T) {
 		}
 	})
 }
+
+func TestStatePersistence(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "services.yaml")
+	statePath := filepath.Join(tmpDir, "state", "services.state")
+
+	config := `
+persistent-service:
+  description: "Persistent Service"
+  type: "daemon"
+  exec: "/bin/sleep"
+  args: ["1000"]
+  restart: "never"
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+
+	first := NewServiceManager()
+	if err := first.LoadServices(configPath); err != nil {
+		t.Fatalf("Failed to load services: %v", err)
+	}
+	if err := first.EnablePersistence(statePath); err != nil {
+		t.Fatalf("Failed to enable persistence: %v", err)
+	}
+	if err := first.StartService("persistent-service"); err != nil {
+		t.Fatalf("Failed to start service: %v", err)
+	}
+	if err := first.RestartService("persistent-service"); err != nil {
+		t.Fatalf("Failed to restart service: %v", err)
+	}
+	before, _ := first.GetServiceStatus("persistent-service")
+
+	// 模拟 init 重启：新的管理器从同一状态文件恢复
+	second := NewServiceManager()
+	if err := second.LoadServices(configPath); err != nil {
+		t.Fatalf("Failed to load services: %v", err)
+	}
+	if err := second.EnablePersistence(statePath); err != nil {
+		t.Fatalf("Failed to enable persistence: %v", err)
+	}
+
+	after, _ := second.GetServiceStatus("persistent-service")
+	if after.State != StateRunning {
+		t.Errorf("Expected adopted service state %s, got %s", StateRunning, after.State)
+	}
+	if after.Pid != before.Pid {
+		t.Errorf("Expected adopted pid %d, got %d", before.Pid, after.Pid)
+	}
+	if after.RestartCount != 1 {
+		t.Errorf("Expected restart count 1, got %d", after.RestartCount)
+	}
+
+	if err := second.StopService("persistent-service"); err != nil {
+		t.Errorf("Failed to stop adopted service: %v", err)
+	}
+}
+
+func TestCorruptStateFile(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "services.state")
+	if err := os.WriteFile(statePath, []byte(`{"version": 1, "services": {"a": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sm := NewServiceManager()
+	err := sm.RegisterService(ServiceConfig{Name: "a", Type: TypeDaemon, ExecPath: "/bin/sleep", Args: []string{"1000"}, Restart: "never"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.EnablePersistence(statePath); err != nil {
+		t.Fatalf("Expected corrupt state file to be tolerated, got %v", err)
+	}
+	if _, err := os.Stat(statePath + ".corrupt"); err != nil {
+		t.Errorf("Expected corrupt state file to be moved aside: %v", err)
+	}
+
+	// 持久化仍然启用：新的状态写入原路径
+	if err := sm.StartService("a"); err != nil {
+		t.Fatal(err)
+	}
+	sf, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatalf("Expected a fresh state file, got %v", err)
+	}
+	if rec, ok := sf.Services["a"]; !ok || rec.State != StateRunning {
+		t.Errorf("Expected running service a in the new state file, got %+v", sf.Services)
+	}
+
+	// 停止后等待最后一次写入完成，避免与临时目录的清理竞争
+	if err := sm.StopService("a"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sf, err := readStateFile(statePath)
+		if err == nil && sf.Services["a"].State == StateStopped {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected stopped service a in the state file, got %+v (%v)", sf, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestUnreadableStateFile(t *testing.T) {
+	// 版本不符：返回错误，文件原样保留
+	statePath := filepath.Join(t.TempDir(), "services.state")
+	newer := []byte(`{"version": 99, "services": {}}`)
+	if err := os.WriteFile(statePath, newer, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sm := NewServiceManager()
+	if err := sm.EnablePersistence(statePath); err == nil {
+		t.Error("Expected an error for an unsupported state file version")
+	}
+	if data, err := os.ReadFile(statePath); err != nil || string(data) != string(newer) {
+		t.Errorf("Expected state file to be left untouched, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(statePath + ".corrupt"); !os.IsNotExist(err) {
+		t.Errorf("Expected no .corrupt file for a version mismatch: %v", err)
+	}
+
+	// 读取失败：返回错误，不改名
+	dirPath := t.TempDir()
+	if err := NewServiceManager().EnablePersistence(dirPath); err == nil {
+		t.Error("Expected an error for an unreadable state file")
+	}
+	if _, err := os.Stat(dirPath + ".corrupt"); !os.IsNotExist(err) {
+		t.Errorf("Expected no .corrupt file for a read error: %v", err)
+	}
+}
+
+func TestConcurrentLifecycle(t *testing.T) {
+	sm := NewServiceManager()
+	config := ServiceConfig{
+		Name:     "racy",
+		Type:     TypeDaemon,
+		ExecPath: "/bin/sleep",
+		Args:     []string{"1000"},
+		Restart:  "always",
+	}
+	if err := sm.RegisterService(config); err != nil {
+		t.Fatalf("Failed to register service: %v", err)
+	}
+
+	// 订阅者在事件处理中读取服务状态，验证不会死锁
+	sm.EventBus().Subscribe(EventStarted, func(event ServiceEvent) {
+		sm.GetServiceStatus(event.Service)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				switch (i + j) % 5 {
+				case 0:
+					sm.StartService("racy")
+				case 1:
+					sm.StopService("racy")
+				case 2:
+					sm.RestartService("racy")
+				case 3:
+					// 模拟崩溃：直接杀掉当前进程
+					if status, _ := sm.GetServiceStatus("racy"); status.Pid > 0 {
+						killChild(status.Pid)
+					}
+				case 4:
+					sm.ListServices()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 可能有崩溃重启正在进行，反复停止直到服务停下
+	var status ServiceStatus
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		sm.StopService("racy")
+		status, _ = sm.GetServiceStatus("racy")
+		down := status.State != StateRunning && status.State != StateStarting && status.State != StateStopping
+		if down || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if status.State == StateRunning || status.State == StateStarting || status.State == StateStopping {
+		t.Errorf("Expected service to be down, got %s", status.State)
+	}
+	if status.Pid > 0 && syscall.Kill(status.Pid, 0) == nil {
+		if ppid, _, ok := readStat(status.Pid); ok && ppid == os.Getpid() {
+			t.Errorf("Process %d still running after stop", status.Pid)
+		}
+	}
+}
+
+func TestCrashRestart(t *testing.T) {
+	sm := NewServiceManager()
+	config := ServiceConfig{
+		Name:     "crashy",
+		Type:     TypeDaemon,
+		ExecPath: "/bin/sleep",
+		Args:     []string{"1000"},
+		Restart:  "on-failure",
+	}
+	if err := sm.RegisterService(config); err != nil {
+		t.Fatalf("Failed to register service: %v", err)
+	}
+	if err := sm.StartService("crashy"); err != nil {
+		t.Fatalf("Failed to start service: %v", err)
+	}
+	defer sm.StopService("crashy")
+
+	before, _ := sm.GetServiceStatus("crashy")
+	killChild(before.Pid)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		after, _ := sm.GetServiceStatus("crashy")
+		if after.State == StateRunning && after.Pid != before.Pid {
+			if after.RestartCount != 1 || after.LastError == "" {
+				t.Errorf("Unexpected status after crash restart: %+v", after)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Service was not restarted after crash: %+v", after)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	sm := NewServiceManager()
+	config := ServiceConfig{
+		Name:     "frozen",
+		Type:     TypeDaemon,
+		ExecPath: "/bin/sleep",
+		Args:     []string{"1000"},
+		Restart:  "on-failure",
+	}
+	if err := sm.RegisterService(config); err != nil {
+		t.Fatalf("Failed to register service: %v", err)
+	}
+	if err := sm.StartService("frozen"); err != nil {
+		t.Fatalf("Failed to start service: %v", err)
+	}
+	defer sm.StopService("frozen")
+
+	before, _ := sm.GetServiceStatus("frozen")
+	thaw := sm.Freeze()
+	killChild(before.Pid)
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- sm.StopService("frozen") }()
+
+	// 冻结期间崩溃不会被处理，停止请求也不会执行
+	time.Sleep(300 * time.Millisecond)
+	if status, _ := sm.GetServiceStatus("frozen"); status.State != StateRunning || status.Pid != before.Pid {
+		t.Errorf("Service changed while frozen: %+v", status)
+	}
+	select {
+	case err := <-stopped:
+		t.Fatalf("StopService returned while frozen: %v", err)
+	default:
+	}
+
+	thaw()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("StopService still blocked after thaw")
+	}
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran")
+	sm := NewServiceManager()
+	configs := []ServiceConfig{
+		{Name: "daemon", Type: TypeDaemon, ExecPath: "/bin/sleep", Args: []string{"1000"}, Restart: "never"},
+		{Name: "job", Type: TypeOneshot, ExecPath: "/bin/true", Restart: "never"},
+		{Name: "broken", Type: TypeOneshot, ExecPath: "/bin/sh", Args: []string{"-c", "test -e $0 && exit 0; touch $0; exit 3", marker}, Restart: "on-failure"},
+	}
+	for _, config := range configs {
+		if err := sm.RegisterService(config); err != nil {
+			t.Fatalf("Failed to register service: %v", err)
+		}
+	}
+
+	events := make(chan ServiceEvent, 100)
+	sm.EventBus().SubscribeFilter(EventFilter{Service: "*"}, func(e ServiceEvent) {
+		events <- e
+	}, 0)
+
+	// collect 收集服务的事件直到出现 last 类型
+	collect := func(name string, last EventType) []ServiceEvent {
+		t.Helper()
+		var got []ServiceEvent
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case e := <-events:
+				if e.Service != name {
+					continue
+				}
+				got = append(got, e)
+				if e.Type == last {
+					return got
+				}
+			case <-timeout:
+				t.Fatalf("Timed out waiting for %s of %s, got %v", last, name, got)
+			}
+		}
+	}
+	expectTypes := func(got []ServiceEvent, want ...EventType) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("Expected events %v, got %v", want, got)
+		}
+		for i := range want {
+			if got[i].Type != want[i] {
+				t.Fatalf("Expected events %v, got %v", want, got)
+			}
+			if i > 0 && got[i].Seq <= got[i-1].Seq {
+				t.Errorf("Sequence numbers not increasing: %v", got)
+			}
+		}
+	}
+
+	sm.StartService("daemon")
+	sm.StopService("daemon")
+	got := collect("daemon", EventStopped)
+	expectTypes(got, EventStarting, EventStarted, EventReady, EventStopping, EventExited, EventStopped)
+	if exit := got[4].Data.(LifecycleEvent).Exit; exit == nil || exit.Signal != "SIGTERM" {
+		t.Errorf("Expected exit by SIGTERM, got %+v", exit)
+	}
+
+	// oneshot 成功完成后就绪并停止
+	sm.StartService("job")
+	got = collect("job", EventStopped)
+	expectTypes(got, EventStarting, EventStarted, EventExited, EventReady, EventStopped)
+
+	// 第一次运行失败的 oneshot 携带退出码、原因和重启次数，重启后成功
+	sm.StartService("broken")
+	got = collect("broken", EventStopped)
+	expectTypes(got, EventStarting, EventStarted, EventExited, EventFailed, EventRestarting,
+		EventStarting, EventStarted, EventExited, EventReady, EventStopped)
+
+	exited := got[2].Data.(LifecycleEvent)
+	if exited.Exit == nil || exited.Exit.Code != 3 {
+		t.Errorf("Expected exit code 3, got %+v", exited.Exit)
+	}
+	if failed := got[3].Data.(LifecycleEvent); failed.Reason != "exit status 3" || failed.State != StateFailed {
+		t.Errorf("Unexpected failed payload: %+v", failed)
+	}
+	if restarting := got[4].Data.(LifecycleEvent); restarting.Attempt != 1 {
+		t.Errorf("Expected restart attempt 1, got %+v", restarting)
+	}
+}
+
+func TestDependencyKinds(t *testing.T) {
+	sm := NewServiceManager()
+	sleeper := func(name string) ServiceConfig {
+		return ServiceConfig{Name: name, Type: TypeDaemon, ExecPath: "/bin/sleep", Args: []string{"1000"}, Restart: "never"}
+	}
+
+	base := sleeper("base")
+	req := sleeper("req")
+	req.Requires = []string{"base"}
+	bound := sleeper("bound")
+	bound.BindsTo = []string{"base"}
+	app := sleeper("app")
+	app.Requires = []string{"base"}
+	app.Wants = []string{"broken"}
+	alt := sleeper("alt")
+	alt.Conflicts = []string{"app"}
+	broken := ServiceConfig{Name: "broken", Type: TypeDaemon, ExecPath: "/nonexistent", Restart: "never"}
+	late := sleeper("late")
+	late.Before = []string{"base"}
+	job := ServiceConfig{Name: "job", Type: TypeOneshot, ExecPath: "/bin/sleep", Args: []string{"0.3"}, Restart: "never"}
+	jobUser := sleeper("job-user")
+	jobUser.BindsTo = []string{"job"}
+
+	for _, config := range []ServiceConfig{base, req, bound, app, alt, broken, late, job, jobUser} {
+		if err := sm.RegisterService(config); err != nil {
+			t.Fatalf("Failed to register %s: %v", config.Name, err)
+		}
+	}
+	defer sm.StopAll()
+
+	state := func(name string) ServiceState {
+		status, _ := sm.GetServiceStatus(name)
+		return status.State
+	}
+	waitState := func(name string, want ServiceState) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for state(name) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %s to be %s, got %s", name, want, state(name))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// before 只影响排序
+	var order []string
+	for _, s := range sm.dependencyOrder() {
+		order = append(order, s.Config.Name)
+	}
+	if strings.Index(strings.Join(order, " "), "late") > strings.Index(strings.Join(order, " "), "base") {
+		t.Errorf("Expected late before base, got %v", order)
+	}
+
+	// requires 的服务被一并启动，停止时依赖者先停止
+	if err := sm.StartService("req"); err != nil {
+		t.Fatalf("Failed to start req: %v", err)
+	}
+	if state("base") != StateRunning {
+		t.Errorf("Expected base pulled in by req, got %s", state("base"))
+	}
+	sm.StopService("base")
+	if state("req") != StateStopped {
+		t.Errorf("Expected req stopped with base, got %s", state("req"))
+	}
+
+	// wants 中的服务失败不影响本服务
+	sm.StartService("base")
+	if err := sm.StartService("app"); err != nil {
+		t.Fatalf("Failed to start app: %v", err)
+	}
+	if state("broken") != StateFailed || state("app") != StateRunning {
+		t.Errorf("Expected broken failed and app running, got %s and %s", state("broken"), state("app"))
+	}
+
+	// conflicts 互斥，任一方声明即生效
+	if err := sm.StartService("alt"); err != nil {
+		t.Fatalf("Failed to start alt: %v", err)
+	}
+	if state("app") != StateStopped {
+		t.Errorf("Expected app stopped by conflicting alt, got %s", state("app"))
+	}
+	sm.StartService("app")
+	if state("alt") != StateStopped {
+		t.Errorf("Expected alt stopped by conflicting app, got %s", state("alt"))
+	}
+
+	// 停止被依赖的服务时依赖者先停止
+	sm.StartService("req")
+	sm.StartService("bound")
+	if err := sm.StopService("base"); err != nil {
+		t.Fatalf("Failed to stop base: %v", err)
+	}
+	for _, name := range []string{"req", "bound", "app"} {
+		if state(name) != StateStopped {
+			t.Errorf("Expected %s stopped with base, got %s", name, state(name))
+		}
+	}
+
+	// 被依赖的服务崩溃时依赖者停止，恢复后依赖者重新启动
+	sm.StartService("base")
+	sm.StartService("req")
+	status, _ := sm.GetServiceStatus("base")
+	killChild(status.Pid)
+	waitState("req", StateStopped)
+	sm.StartService("base")
+	waitState("req", StateRunning)
+
+	// binds_to 的服务正常结束时依赖者也停止
+	sm.StartService("job")
+	if err := sm.StartService("job-user"); err != nil {
+		t.Fatalf("Failed to start job-user: %v", err)
+	}
+	waitState("job", StateStopped)
+	waitState("job-user", StateStopped)
+}
+
+func TestStartJob(t *testing.T) {
+	sm := NewServiceManager()
+	configs := []ServiceConfig{
+		{Name: "db", Type: TypeDaemon, ExecPath: "/bin/sleep", Args: []string{"1000"}, Restart: "never"},
+		{Name: "cache", Type: TypeDaemon, ExecPath: "/bin/sleep", Args: []string{"1000"}, Restart: "never"},
+		{Name: "bad", Type: TypeDaemon, ExecPath: "/nonexistent", Restart: "never"},
+		{Name: "api", Type: TypeDaemon, ExecPath: "/bin/sleep", Args: []string{"1000"}, Restart: "never",
+			Requires: []string{"db"}, Wants: []string{"cache"}},
+		{Name: "web", Type: TypeDaemon, ExecPath: "/bin/sleep", Args: []string{"1000"}, Restart: "never",
+			Requires: []string{"api", "bad"}},
+	}
+	for _, config := range configs {
+		if err := sm.RegisterService(config); err != nil {
+			t.Fatalf("Failed to register %s: %v", config.Name, err)
+		}
+	}
+	defer sm.StopAll()
+
+	state := func(name string) ServiceState {
+		status, _ := sm.GetServiceStatus(name)
+		return status.State
+	}
+
+	// 回滚模式：bad 失败导致 web 被跳过，已启动的依赖全部停止
+	job, err := sm.StartJob([]string{"web"}, JobRollback)
+	if err == nil || job.State != JobRolledBack {
+		t.Fatalf("Expected rolled back job, got %s (err=%v)", job.State, err)
+	}
+	results := make(map[string]UnitResult)
+	for _, u := range job.Units {
+		results[u.Service] = u.Result
+	}
+	if results["bad"] != UnitFailed || results["web"] != UnitSkipped || results["db"] != UnitRolledBack {
+		t.Errorf("Unexpected unit results: %v", results)
+	}
+	for _, name := range []string{"db", "cache", "api"} {
+		if state(name) == StateRunning {
+			t.Errorf("Expected %s rolled back, still running", name)
+		}
+	}
+
+	// 部分失败模式：已启动的依赖保留
+	if _, err := sm.StartJob([]string{"web"}, JobPartial); err == nil {
+		t.Fatal("Expected partial job to fail")
+	}
+	if state("api") != StateRunning || state("db") != StateRunning {
+		t.Errorf("Expected api and db left running, got %s and %s", state("api"), state("db"))
+	}
+
+	// 已在运行的服务不算被拉起
+	sm.StopService("api")
+	job, err = sm.StartJob([]string{"api"}, JobPartial)
+	if err != nil || job.State != JobDone {
+		t.Fatalf("Expected done job, got %s (err=%v)", job.State, err)
+	}
+	if len(job.PulledIn) != 0 {
+		t.Errorf("Expected nothing pulled in, got %v", job.PulledIn)
+	}
+
+	jobs := sm.Jobs()
+	if len(jobs) != 3 || jobs[2].ID != job.ID {
+		t.Errorf("Expected 3 recorded jobs, got %d", len(jobs))
+	}
+}
+
+func TestTargets(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "targets.yaml")
+	os.WriteFile(file, []byte(`
+default: app
+targets:
+  app:
+    requires: ["basic"]
+    services: ["web"]
+  loop:
+    requires: ["loop"]
+`), 0644)
+	if _, err := LoadTargets(file); err == nil {
+		t.Error("Expected cyclic targets to be rejected")
+	}
+	os.WriteFile(file, []byte(`
+default: app
+targets:
+  app:
+    requires: ["basic"]
+    services: ["web"]
+`), 0644)
+	targets, err := LoadTargets(file)
+	if err != nil {
+		t.Fatalf("Failed to load targets: %v", err)
+	}
+
+	sm := NewServiceManager()
+	configs := []ServiceConfig{
+		{Name: "db", Type: TypeDaemon, ExecPath: "/bin/sleep", Args: []string{"1000"}, Restart: "never"},
+		{Name: "lib", Type: TypeDaemon, ExecPath: "/bin/sleep", Args: []string{"1000"}, Restart: "never",
+			Targets: []string{"network"}},
+		{Name: "web", Type: TypeDaemon, ExecPath: "/bin/sleep", Args: []string{"1000"}, Restart: "never",
+			Targets: []string{"network"}, Requires: []string{"lib"}},
+		{Name: "shell", Type: TypeDaemon, ExecPath: "/bin/sleep", Args: []string{"1000"}, Restart: "never",
+			Targets: []string{TargetRescue}},
+	}
+	for _, config := range configs {
+		if err := sm.RegisterService(config); err != nil {
+			t.Fatalf("Failed to register %s: %v", config.Name, err)
+		}
+	}
+	sm.SetTargets(targets)
+	defer sm.StopAll()
+
+	state := func(name string) ServiceState {
+		status, _ := sm.GetServiceStatus(name)
+		return status.State
+	}
+
+	// app 包含 basic（db）和 web，web 依赖的 lib 一并启动
+	if _, err := sm.StartTarget(sm.DefaultTarget(), JobPartial); err != nil {
+		t.Fatalf("Failed to start default target: %v", err)
+	}
+	for _, name := range []string{"db", "web", "lib"} {
+		if state(name) != StateRunning {
+			t.Errorf("Expected %s running, got %s", name, state(name))
+		}
+	}
+	if state("shell") == StateRunning {
+		t.Error("Expected shell not started outside rescue")
+	}
+	if sm.CurrentTarget() != "app" {
+		t.Errorf("Expected current target app, got %q", sm.CurrentTarget())
+	}
+
+	// 切换到 rescue 时停止其他服务
+	if _, err := sm.IsolateTarget(TargetRescue, JobPartial); err != nil {
+		t.Fatalf("Failed to isolate rescue: %v", err)
+	}
+	for _, name := range []string{"db", "web", "lib"} {
+		if state(name) == StateRunning {
+			t.Errorf("Expected %s stopped in rescue", name)
+		}
+	}
+	if state("shell") != StateRunning {
+		t.Errorf("Expected shell running, got %s", state("shell"))
+	}
+
+	// 切换到 basic 时保留被目标中服务依赖的服务
+	sm.StartService("web")
+	sm.mu.Lock()
+	sm.targets.Targets[TargetBasic] = TargetConfig{Services: []string{"web"}}
+	sm.mu.Unlock()
+	if _, err := sm.IsolateTarget(TargetBasic, JobPartial); err != nil {
+		t.Fatalf("Failed to isolate basic: %v", err)
+	}
+	if state("lib") != StateRunning || state("shell") == StateRunning {
+		t.Errorf("Expected lib kept and shell stopped, got %s and %s", state("lib"), state("shell"))
+	}
+
+	if _, err := sm.IsolateTarget("missing", JobPartial); err == nil {
+		t.Error("Expected unknown target to fail")
+	}
+}
+
+func TestTTYService(t *testing.T) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("No pseudo terminals: %v", err)
+	}
+	defer master.Close()
+	if err := unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 输出 pid、会话 id 和控制终端的前台进程组，然后等待终端输入
+	script := `read -r _ _ _ _ _ sid _ tpgid _ < /proc/self/stat; echo "$$ $sid $tpgid"; read -r line`
+	sm := NewServiceManager()
+	err = sm.RegisterService(ServiceConfig{
+		Name: "getty", Type: TypeDaemon, ExecPath: "/bin/sh", Args: []string{"-c", script},
+		TTY: fmt.Sprintf("/dev/pts/%d", n), Restart: "never",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sm.StopAll()
+	if err := sm.StartService("getty"); err != nil {
+		t.Fatalf("Failed to start tty service: %v", err)
+	}
+
+	master.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 256)
+	nr, err := master.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read from terminal: %v", err)
+	}
+	fields := strings.Fields(string(buf[:nr]))
+	status, _ := sm.GetServiceStatus("getty")
+	pid := strconv.Itoa(status.Pid)
+	if len(fields) != 3 || fields[0] != pid || fields[1] != pid || fields[2] != pid {
+		t.Errorf("Expected session leader %s owning the terminal, got %q", pid, fields)
+	}
+
+	// 会话正常结束时服务停止而不是失败
+	master.Write([]byte("bye\n"))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, _ = sm.GetServiceStatus("getty")
+		if status.State != StateRunning || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if status.State != StateStopped {
+		t.Errorf("Expected getty stopped after logout, got %s", status.State)
+	}
+}
+
+// killChild 用 SIGKILL 杀死本进程的子进程。状态快照中的 PID 可能已被回收并分配给无关进程，
+// 先通过 pidfd 固定进程，确认它仍是本进程的子进程后再发送信号
+func killChild(pid int) {
+	fd, err := unix.PidfdOpen(pid, 0)
+	if err != nil {
+		return
+	}
+	defer unix.Close(fd)
+	if ppid, _, ok := readStat(pid); !ok || ppid != os.Getpid() {
+		return
+	}
+	unix.PidfdSendSignal(fd, unix.SIGKILL, nil, 0)
+}
+
+// readStat 读取进程的父进程号和状态
+func readStat(pid int) (ppid int, state string, ok bool) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, "", false
+	}
+	s := string(data)
+	fields := strings.Fields(s[strings.LastIndexByte(s, ')')+1:])
+	ppid, err = strconv.Atoi(fields[1])
+	return ppid, fields[0], err == nil && fields[0] != "Z"
+}
+
+func TestSocketActivation(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "api.sock")
+	out := filepath.Join(dir, "out")
+
+	// 服务进程是本测试二进制中的 TestSocketServerHelper
+	sm := NewServiceManager()
+	err := sm.RegisterService(ServiceConfig{
+		Name: "model", Type: TypeDaemon, ExecPath: os.Args[0], Args: []string{"-test.run=^TestSocketServerHelper$"},
+		Environment: map[string]string{"LDH_SOCKET_TEST_OUT": out}, Restart: "never",
+		Sockets: []SocketConfig{{Listen: sock, Name: "api"}}, IdleTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sm.StopAll()
+
+	kept := make(map[string]*os.File)
+	err = sm.ActivateSockets(func(string) *os.File { return nil }, func(name string, f *os.File) { kept[name] = f })
+	if err != nil {
+		t.Fatalf("Failed to activate sockets: %v", err)
+	}
+	if kept["socket:model:0"] == nil {
+		t.Errorf("Expected listening socket to be kept, got %v", kept)
+	}
+
+	// 启动目标时不启动服务
+	if _, err := sm.StartTarget(TargetBasic, JobPartial); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := sm.GetServiceStatus("model"); status.State == StateRunning {
+		t.Fatal("Expected socket-activated service not to start with the target")
+	}
+
+	waitState := func(want ServiceState) ServiceStatus {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			status, _ := sm.GetServiceStatus("model")
+			if status.State == want || time.Now().After(deadline) {
+				return status
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	for round := 0; round < 2; round++ {
+		// 首个连接启动服务，服务接受 init 传入的套接字上排队的连接
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		fmt.Fprintln(conn, "ping")
+		reply, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if err != nil || reply != "ping\n" {
+			t.Fatalf("Expected echo, got %q (%v)", reply, err)
+		}
+
+		status := waitState(StateRunning)
+		data, _ := os.ReadFile(out)
+		if want := fmt.Sprintf("%d 1 api", status.Pid); strings.TrimSpace(string(data)) != want {
+			t.Errorf("Expected LISTEN_PID LISTEN_FDS LISTEN_FDNAMES %q, got %q", want, data)
+		}
+
+		// 没有连接超过 idle_timeout 后停止
+		time.Sleep(time.Second)
+		if status := waitState(StateStopped); status.State != StateStopped {
+			t.Fatalf("Expected idle service to stop, got %s", status.State)
+		}
+	}
+}
+
+// TestSocketServerHelper 是 TestSocketActivation 的服务进程：记录激活环境变量，
+// 在描述符 3 上接受连接并回显一行
+func TestSocketServerHelper(t *testing.T) {
+	out := os.Getenv("LDH_SOCKET_TEST_OUT")
+	if out == "" {
+		t.Skip("Only runs as a socket-activated service")
+	}
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		os.Exit(2)
+	}
+	os.WriteFile(out, []byte(os.Getenv("LISTEN_PID")+" "+os.Getenv("LISTEN_FDS")+" "+os.Getenv("LISTEN_FDNAMES")), 0644)
+
+	l, err := net.FileListener(os.NewFile(3, "api"))
+	if err != nil {
+		os.Exit(3)
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			os.Exit(4)
+		}
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte(line))
+		conn.Close()
+	}
+}
+
+func TestProcNetConnections(t *testing.T) {
+	tcp := strings.Fields("1: 0100007F:1F90 0100007F:C350 01 00000000:00000000 00:00000000 00000000 0 0 12345 1")
+	if !tcpConnected(tcp, []int{8080}) || tcpConnected(tcp, []int{80}) {
+		t.Error("Expected established connection on port 8080 only")
+	}
+	listening := strings.Fields("0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000 0 0 12344 1")
+	if tcpConnected(listening, []int{8080}) {
+		t.Error("Expected listening socket not to count")
+	}
+	unixLine := strings.Fields("0000000000000000: 00000003 00000000 00000000 0001 03 23456 /run/model.sock")
+	if !unixConnected(unixLine, "/run/model.sock") || unixConnected(unixLine, "/run/other.sock") {
+		t.Error("Expected connected unix socket on /run/model.sock only")
+	}
+}
+
+func TestTimerSchedule(t *testing.T) {
+	now := time.Date(2026, 10, 19, 10, 30, 0, 0, time.Local)
+	boot := now.Add(-time.Hour)
+
+	tm, err := newTimer("backup", TimerConfig{OnCalendar: []string{"hourly"}, Persistent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 停机期间错过了 08:00 到 10:00 的触发，启动时立即补上一次
+	tm.last = now.Add(-3 * time.Hour)
+	if next, missed := tm.schedule(now, boot, true); !missed || !next.Equal(now) {
+		t.Errorf("Expected catch-up at %v, got %v (missed %v)", now, next, missed)
+	}
+	if next, _ := tm.schedule(now, boot, false); !next.Equal(now.Add(30 * time.Minute)) {
+		t.Errorf("Expected next trigger at 11:00, got %v", next)
+	}
+	tm.config.Persistent = false
+	if _, missed := tm.schedule(now, boot, true); missed {
+		t.Error("Expected no catch-up without persistent")
+	}
+
+	// on_boot 每次启动触发一次，随机延迟不超过上限
+	tm, _ = newTimer("warmup", TimerConfig{OnBoot: 5 * time.Minute, RandomDelay: time.Minute})
+	next, _ := tm.schedule(now, boot, true)
+	if next.Before(boot.Add(5*time.Minute)) || !next.Before(boot.Add(6*time.Minute)) {
+		t.Errorf("Expected trigger within a minute after boot+5m, got %v", next)
+	}
+	tm.last = boot.Add(5 * time.Minute)
+	if next, _ := tm.schedule(now, boot, true); !next.IsZero() {
+		t.Errorf("Expected no further triggers this boot, got %v", next)
+	}
+
+	if _, err := newTimer("bad", TimerConfig{}); err == nil {
+		t.Error("Expected empty timer to be rejected")
+	}
+	if _, err := newTimer("bad", TimerConfig{OnCalendar: []string{"* * *"}}); err == nil {
+		t.Error("Expected invalid calendar to be rejected")
+	}
+}
+
+func TestTimerService(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ran")
+	statePath := filepath.Join(dir, "services.state")
+	config := ServiceConfig{
+		Name: "cleanup", Type: TypeOneshot, ExecPath: "/bin/sh", Args: []string{"-c", "echo ran >> " + marker},
+		Restart: "never", Timer: &TimerConfig{OnBoot: time.Millisecond},
+	}
+
+	sm := NewServiceManager()
+	if err := sm.RegisterService(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.EnablePersistence(statePath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sm.StartTarget(TargetBasic, JobPartial); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("Expected timer service not to start with the target")
+	}
+
+	// 系统启动早已超过 on_boot 的延迟，定时器立即触发
+	sm.StartTimers()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, _ := os.ReadFile(marker); string(data) == "ran\n" || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	timers := sm.Timers()
+	if len(timers) != 1 || timers[0].Last.IsZero() || !timers[0].Next.IsZero() || timers[0].OnBoot != "1ms" {
+		t.Fatalf("Expected a triggered boot timer, got %+v", timers)
+	}
+
+	// 已完成的服务在下一次触发时再次运行
+	sm.triggerTimer(sm.timers["cleanup"])
+	for {
+		if data, _ := os.ReadFile(marker); string(data) == "ran\nran\n" || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// 触发时间持久化，本次启动不再触发
+	sm = NewServiceManager()
+	sm.RegisterService(config)
+	if err := sm.EnablePersistence(statePath); err != nil {
+		t.Fatal(err)
+	}
+	sm.StartTimers()
+	time.Sleep(200 * time.Millisecond)
+	if data, _ := os.ReadFile(marker); string(data) != "ran\nran\n" {
+		t.Errorf("Expected the boot timer not to run again, got %q", data)
+	}
+	if timers := sm.Timers(); len(timers) != 1 || !timers[0].Last.Equal(sm.stateManager.LastTrigger("cleanup")) || timers[0].Last.IsZero() {
+		t.Errorf("Expected last trigger restored, got %+v", timers)
+	}
+}
+
+func TestPathTriggers(t *testing.T) {
+	dir := t.TempDir()
+	inbox := filepath.Join(dir, "inbox")
+	os.Mkdir(inbox, 0755)
+	os.WriteFile(filepath.Join(dir, "ready"), nil, 0644)
+	out := filepath.Join(dir, "out")
+	script := `echo "$0 $LDH_TRIGGER_TYPE $LDH_TRIGGER_OPS $(echo $LDH_TRIGGER_FILES)" >> ` + out
+
+	sm := NewServiceManager()
+	configs := []ServiceConfig{
+		{Name: "ingest", Type: TypeOneshot, ExecPath: "/bin/sh", Args: []string{"-c", script, "ingest"}, Restart: "never",
+			StartOnPath: []PathTrigger{{DirNotEmpty: inbox, Debounce: 200 * time.Millisecond}}},
+		{Name: "setup", Type: TypeOneshot, ExecPath: "/bin/sh", Args: []string{"-c", script, "setup"}, Restart: "never",
+			StartOnPath: []PathTrigger{{Exists: filepath.Join(dir, "ready")}}},
+	}
+	for _, config := range configs {
+		if err := sm.RegisterService(config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sm.RegisterService(ServiceConfig{Name: "bad", StartOnPath: []PathTrigger{{Exists: "relative"}}}); err == nil {
+		t.Error("Expected relative path to be rejected")
+	}
+	events := make(chan PathEvent, 16)
+	sm.EventBus().Subscribe(EventPathChanged, func(e ServiceEvent) {
+		events <- e.Data.(PathEvent)
+	})
+
+	if _, err := sm.StartTarget(TargetBasic, JobPartial); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Fatal("Expected path-triggered services not to start with the target")
+	}
+
+	// 启动时已经存在的路径立即触发
+	if err := sm.StartPathTriggers(); err != nil {
+		t.Fatal(err)
+	}
+	waitOutput := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			data, _ := os.ReadFile(out)
+			if string(data) == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected output %q, got %q", want, data)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	ready := "setup exists exists " + filepath.Join(dir, "ready") + "\n"
+	waitOutput(ready)
+
+	// 防抖期间的变化合并为一次触发，文件列表通过环境变量传入
+	a, b := filepath.Join(inbox, "a.gguf"), filepath.Join(inbox, "b.gguf")
+	os.WriteFile(a, []byte("x"), 0644)
+	os.WriteFile(b, []byte("x"), 0644)
+	waitOutput(ready + "ingest dir_not_empty create " + a + " " + b + "\n")
+
+	var got []PathEvent
+	timeout := time.After(2 * time.Second)
+	for len(got) < 2 {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-timeout:
+			t.Fatalf("Expected 2 path events, got %+v", got)
+		}
+	}
+	if got[1].Trigger != pathDirNotEmpty || len(got[1].Files) != 2 {
+		t.Errorf("Unexpected path event %+v", got[1])
+	}
+}
+
+func TestPathTriggerAfterFailure(t *testing.T) {
+	dir := t.TempDir()
+	watched := filepath.Join(dir, "watched")
+	os.Mkdir(watched, 0755)
+	out := filepath.Join(dir, "out")
+	script := `echo "$(echo $LDH_TRIGGER_FILES)" >> ` + out + `; exec sleep 1000`
+
+	sm := NewServiceManager()
+	err := sm.RegisterService(ServiceConfig{Name: "worker", Type: TypeDaemon, ExecPath: "/bin/sh", Args: []string{"-c", script},
+		Restart: "never", StartOnPath: []PathTrigger{{Changed: watched, Debounce: 50 * time.Millisecond}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sm.StopService("worker")
+	if _, err := sm.StartTarget(TargetBasic, JobPartial); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.StartPathTriggers(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitOutput := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			data, _ := os.ReadFile(out)
+			if string(data) == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected output %q, got %q", want, data)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	first, second := filepath.Join(watched, "first"), filepath.Join(watched, "second")
+	os.WriteFile(first, nil, 0644)
+	waitOutput(first + "\n")
+
+	// 服务运行期间的变化被推迟，服务失败后触发
+	os.WriteFile(second, nil, 0644)
+	time.Sleep(200 * time.Millisecond)
+	status, _ := sm.GetServiceStatus("worker")
+	killChild(status.Pid)
+	waitOutput(first + "\n" + second + "\n")
+}
+
+func TestPathTriggerDuringRestart(t *testing.T) {
+	dir := t.TempDir()
+	watched := filepath.Join(dir, "watched")
+	os.Mkdir(watched, 0755)
+	out := filepath.Join(dir, "out")
+	script := `echo "$(echo $LDH_TRIGGER_FILES)" >> ` + out + `; exec sleep 1000`
+
+	sm := NewServiceManager()
+	err := sm.RegisterService(ServiceConfig{Name: "worker", Type: TypeDaemon, ExecPath: "/bin/sh", Args: []string{"-c", script},
+		Restart: "always", StartOnPath: []PathTrigger{{Changed: watched, Debounce: 50 * time.Millisecond}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sm.StopService("worker")
+	fired := make(chan PathEvent, 16)
+	sm.EventBus().Subscribe(EventPathChanged, func(e ServiceEvent) {
+		fired <- e.Data.(PathEvent)
+	})
+	if _, err := sm.StartTarget(TargetBasic, JobPartial); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.StartPathTriggers(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitOutput := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			data, _ := os.ReadFile(out)
+			if string(data) == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected output %q, got %q", want, data)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	first, second := filepath.Join(watched, "first"), filepath.Join(watched, "second")
+	os.WriteFile(first, nil, 0644)
+	waitOutput(first + "\n")
+
+	// 失败后自动重启：推迟的变化保留到重启后的进程停止，不与重启同时启动服务
+	os.WriteFile(second, nil, 0644)
+	time.Sleep(200 * time.Millisecond)
+	for len(fired) > 0 {
+		<-fired
+	}
+	status, _ := sm.GetServiceStatus("worker")
+	killChild(status.Pid)
+	waitOutput(first + "\n\n")
+	time.Sleep(300 * time.Millisecond)
+	if len(fired) > 0 {
+		t.Fatalf("Deferred path change fired during auto-restart: %+v", <-fired)
+	}
+
+	if err := sm.StopService("worker"); err != nil {
+		t.Fatal(err)
+	}
+	waitOutput(first + "\n\n" + second + "\n")
+}
+
+func TestCgroupMetrics(t *testing.T) {
+	// 假的 cgroup v2 根目录
+	root := t.TempDir()
+	dir := filepath.Join(root, "ldh", "worker")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "memory.current"), []byte("1048576\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "memory.max"), []byte("max\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "memory.pressure"),
+		[]byte("some avg10=2.50 avg60=1.00 avg300=0.00 total=100\nfull avg10=0.50 avg60=0.00 avg300=0.00 total=10\n"), 0644)
+
+	c := readCgroup(root, "/ldh/worker")
+	if c == nil || c.Path != "/ldh/worker" || c.MemoryCurrent != 1<<20 || c.MemoryMax != 0 {
+		t.Fatalf("Unexpected cgroup usage: %+v", c)
+	}
+	if c.MemoryPressure == nil || c.MemoryPressure.Some.Avg10 != 2.5 || c.CPUPressure != nil {
+		t.Errorf("Unexpected cgroup pressure: %+v", c)
+	}
+	if c := readCgroup(root, "/missing"); c != nil {
+		t.Errorf("Expected no usage for a missing cgroup, got %+v", c)
+	}
+
+	// 只有 v1 层级时没有 v2 路径
+	if path := parseCgroup("12:memory:/ldh/worker\n1:name=systemd:/\n"); path != "" {
+		t.Errorf("Expected no cgroup v2 path, got %q", path)
+	}
+	if path := parseCgroup("12:memory:/a\n0::/ldh/worker\n"); path != "/ldh/worker" {
+		t.Errorf("Expected cgroup v2 path /ldh/worker, got %q", path)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	sm := NewServiceManager()
+	// shell 派生一个子进程，采样应统计整个进程树
+	config := ServiceConfig{Name: "worker", Type: TypeDaemon, ExecPath: "/bin/sh", Args: []string{"-c", "sleep 30 & wait"}, Restart: "never"}
+	if err := sm.RegisterService(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.StartService("worker"); err != nil {
+		t.Fatal(err)
+	}
+	defer sm.StopService("worker")
+	time.Sleep(100 * time.Millisecond)
+
+	sm.sampleMetrics()
+	sm.sampleMetrics()
+	status, _ := sm.GetServiceStatus("worker")
+	u := status.Metrics
+	if u == nil || u.Pid != status.Pid || u.Processes != 2 || u.RSS == 0 || u.Threads < 2 || u.FDs == 0 {
+		t.Fatalf("Unexpected metrics: %+v", u)
+	}
+
+	metrics, err := sm.Metrics("worker", 0)
+	if err != nil || len(metrics.Samples) != 2 || !metrics.Samples[1].Time.After(metrics.Samples[0].Time) {
+		t.Fatalf("Expected 2 samples, got %+v, %v", metrics, err)
+	}
+	if all := sm.AllMetrics(1); len(all) != 1 || len(all[0].Samples) != 1 {
+		t.Fatalf("Expected the latest sample, got %+v", all)
+	}
+	if _, err := sm.Metrics("missing", 0); err == nil {
+		t.Error("Expected error for unknown service")
+	}
+
+	// 环形缓冲区只保留最近的采样
+	h := &metricsHistory{}
+	for i := 0; i < DefaultMetricsHistory+5; i++ {
+		h.add(ResourceUsage{Pid: i})
+	}
+	if list := h.list(0); len(list) != DefaultMetricsHistory || list[0].Pid != 5 || list[len(list)-1].Pid != DefaultMetricsHistory+4 {
+		t.Errorf("Unexpected history: first %d, len %d", list[0].Pid, len(list))
+	}
+	if latest, _ := h.latest(); latest.Pid != DefaultMetricsHistory+4 {
+		t.Errorf("Unexpected latest sample %d", latest.Pid)
+	}
+
+	sm.StopService("worker")
+	if status, _ := sm.GetServiceStatus("worker"); status.Metrics != nil {
+		t.Errorf("Expected no metrics for stopped service, got %+v", status.Metrics)
+	}
+}
+
+func TestParsePressure(t *testing.T) {
+	p, err := ParsePressure("some avg10=1.50 avg60=0.20 avg300=0.00 total=12345\nfull avg10=0.75 avg60=0.10 avg300=0.00 total=678\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Pressure{Some: PressureStats{1.5, 0.2, 0, 12345}, Full: PressureStats{0.75, 0.1, 0, 678}}
+	if p != want {
+		t.Errorf("ParsePressure() = %+v, want %+v", p, want)
+	}
+	for _, data := range []string{"", "partial avg10=1", "some avg10=x"} {
+		if _, err := ParsePressure(data); err == nil {
+			t.Errorf("Expected error for %q", data)
+		}
+	}
+}
+
+func TestOpenMetrics(t *testing.T) {
+	sm := NewServiceManager()
+	config := ServiceConfig{
+		Name: "web", Type: TypeDaemon, ExecPath: "/bin/sleep", Args: []string{"30"}, Restart: "never",
+		MCPConfig: MCPConfig{Functions: []string{"status"}},
+	}
+	if err := sm.RegisterService(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.StartService("web"); err != nil {
+		t.Fatal(err)
+	}
+	defer sm.StopService("web")
+	service, _ := sm.lookup("web")
+	service.ReportUnhealthy("probe timed out")
+	sm.HandleMCPRequest(&MCPRequest{Service: "web", Function: "status"})
+	sm.HandleMCPRequest(&MCPRequest{Service: SystemService, Function: "isolate"})
+
+	var buf strings.Builder
+	if err := sm.WriteOpenMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE ldh_service_state stateset\n",
+		`ldh_service_state{service="web",ldh_service_state="running"} 1` + "\n",
+		`ldh_service_state{service="web",ldh_service_state="failed"} 0` + "\n",
+		`ldh_service_restarts_total{service="web"} 0` + "\n",
+		`ldh_service_healthy{service="web"} 0` + "\n",
+		`ldh_service_unhealthy_reports_total{service="web"} 1` + "\n",
+		`ldh_service_uptime_seconds{service="web"} `,
+		`ldh_mcp_calls_total{service="web",function="status"} 1` + "\n",
+		`ldh_mcp_call_errors_total{service="system",function="isolate"} 1` + "\n",
+		`ldh_mcp_call_duration_seconds_bucket{service="web",function="status",le="+Inf"} 1` + "\n",
+		`ldh_mcp_call_duration_seconds_count{service="web",function="status"} 1` + "\n",
+		"ldh_events_dropped_total 0\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in output:\n%s", want, out)
+		}
+	}
+	if !strings.HasSuffix(out, "# EOF\n") {
+		t.Error("Expected output to end with # EOF")
+	}
+
+	if got := labels("service", "a\"b\\c\nd"); got != `service="a\"b\\c\nd"` {
+		t.Errorf("Unexpected label escaping %s", got)
+	}
+}
+
+func TestPressureMonitor(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setMemoryPressure := func(some float64) {
+		write("memory", fmt.Sprintf("some avg10=%.2f avg60=0.00 avg300=0.00 total=1\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n", some))
+	}
+	setMemoryPressure(50)
+	write("cpu", "some avg10=1.00 avg60=0.00 avg300=0.00 total=1\n")
+	write("meminfo", "MemTotal:       8000000 kB\nMemFree:        1000000 kB\nMemAvailable:   4000000 kB\nSwapTotal:            0 kB\nSwapFree:             0 kB\n")
+	write("vmstat", "nr_free_pages 1000\noom_kill 0\n")
+
+	sm := NewServiceManager()
+	high, low := 500, 100
+	for _, config := range []ServiceConfig{
+		{Name: "batch", Type: TypeDaemon, ExecPath: "/bin/sleep", Args: []string{"30"}, Restart: "never", OOMScoreAdj: &high, StopOnPressure: PressureWarning},
+		{Name: "inference", Type: TypeDaemon, ExecPath: "/bin/sleep", Args: []string{"30"}, Restart: "never", OOMScoreAdj: &low, StopOnPressure: PressureWarning},
+		{Name: "agent", Type: TypeDaemon, ExecPath: "/bin/sleep", Args: []string{"30"}, Restart: "never"},
+	} {
+		if err := sm.RegisterService(config); err != nil {
+			t.Fatal(err)
+		}
+		if err := sm.StartService(config.Name); err != nil {
+			t.Fatal(err)
+		}
+		defer sm.StopService(config.Name)
+	}
+	status, _ := sm.GetServiceStatus("batch")
+	if data, _ := os.ReadFile(fmt.Sprintf("/proc/%d/oom_score_adj", status.Pid)); strings.TrimSpace(string(data)) != "500" {
+		t.Errorf("Expected oom_score_adj 500, got %q", data)
+	}
+
+	m := newPressureMonitor(DefaultPressureConfig())
+	m.pressureDir, m.meminfo, m.vmstat = dir, filepath.Join(dir, "meminfo"), filepath.Join(dir, "vmstat")
+	sm.pressure = m
+	waitState := func(name string, want ServiceState) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			status, _ := sm.GetServiceStatus(name)
+			if status.State == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %s to be %s, got %s", name, want, status.State)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	// 内存压力达到 warning：先停止 oom_score_adj 最大的服务，间隔 shed_interval 后再停止下一个
+	start := time.Now()
+	sm.checkPressure(m, start)
+	waitState("batch", StateStopped)
+	sm.checkPressure(m, start.Add(10*time.Second))
+	if status, _ := sm.GetServiceStatus("inference"); status.State != StateRunning {
+		t.Fatalf("Expected inference to keep running within shed interval, got %s", status.State)
+	}
+	sm.checkPressure(m, start.Add(31*time.Second))
+	waitState("inference", StateStopped)
+	sm.checkPressure(m, start.Add(62*time.Second))
+	if status, _ := sm.GetServiceStatus("agent"); status.State != StateRunning {
+		t.Fatal("Expected services without stop_on_pressure to keep running")
+	}
+	st, err := sm.Pressure()
+	if err != nil || st.Resources[0].Level != PressureWarning || st.Resources[1].Level != PressureNormal ||
+		st.Memory.AvailablePercent != 50 || !reflect.DeepEqual(st.Shed, []string{"batch", "inference"}) {
+		t.Fatalf("Unexpected pressure status %+v, %v", st, err)
+	}
+
+	// 压力恢复后持续 recover_after 才降级，之后按相反顺序重新启动
+	setMemoryPressure(0)
+	sm.checkPressure(m, start.Add(70*time.Second))
+	if st, _ := sm.Pressure(); st.Resources[0].Level != PressureWarning {
+		t.Fatal("Expected level to stay at warning until recover_after elapses")
+	}
+	sm.checkPressure(m, start.Add(130*time.Second))
+	waitState("inference", StateRunning)
+	sm.checkPressure(m, start.Add(161*time.Second))
+	waitState("batch", StateRunning)
+
+	write("vmstat", "nr_free_pages 1000\noom_kill 3\n")
+	sm.checkPressure(m, start.Add(170*time.Second))
+
+	events, _, _ := sm.eventBus.History(0, EventFilter{Types: []EventType{EventPressure, EventOOMKill}}, 0)
+	var got []string
+	for _, e := range events {
+		switch data := e.Data.(type) {
+		case PressureEvent:
+			got = append(got, fmt.Sprintf("%s %s %s %s", e.Service, data.Resource, data.Level, data.Action))
+		case OOMEvent:
+			got = append(got, fmt.Sprintf("oom %d", data.Kills))
+		}
+	}
+	want := []string{
+		" memory warning ", "batch memory warning stop", "inference memory warning stop",
+		" memory normal ", "inference memory normal start", "batch memory normal start", "oom 3",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected events:\n%q\nwant\n%q", got, want)
+	}
+
+	bad := 2000
+	if err := sm.RegisterService(ServiceConfig{Name: "bad", ExecPath: "/bin/true", OOMScoreAdj: &bad}); err == nil {
+		t.Error("Expected error for out of range oom_score_adj")
+	}
+	if err := sm.RegisterService(ServiceConfig{Name: "bad", ExecPath: "/bin/true", StopOnPressure: "high"}); err == nil {
+		t.Error("Expected error for invalid stop_on_pressure")
+	}
+}
+
+func TestLoadPressureConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pressure.yaml")
+	if config, err := LoadPressureConfig(path); err != nil || !reflect.DeepEqual(config, DefaultPressureConfig()) {
+		t.Fatalf("Expected built-in config for missing file, got %+v, %v", config, err)
+	}
+	data := "interval: 2s\nmemory:\n  critical:\n    available_percent: 3\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadPressureConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := DefaultPressureConfig()
+	if config.Interval != 2*time.Second || config.RecoverAfter != defaults.RecoverAfter ||
+		config.Memory != (PressureThresholds{Critical: PressureThreshold{AvailablePercent: 3}}) || config.CPU != defaults.CPU {
+		t.Errorf("Unexpected config %+v", config)
+	}
+}
+
+func TestServiceWatchdog(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	sm := NewServiceManager()
+	if err := sm.StartNotify(filepath.Join(dir, "notify")); err != nil {
+		t.Fatal(err)
+	}
+	// 服务进程是本测试二进制中的 TestWatchdogHelper：喂狗几次后停止喂狗
+	err := sm.RegisterService(ServiceConfig{
+		Name: "hang", Type: TypeDaemon, ExecPath: os.Args[0], Args: []string{"-test.run=^TestWatchdogHelper$"},
+		Environment: map[string]string{"LDH_WATCHDOG_TEST_OUT": out}, Restart: "never", Watchdog: 300 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unhealthy := make(chan ServiceEvent, 10)
+	sm.eventBus.Subscribe(EventUnhealthy, func(e ServiceEvent) { unhealthy <- e })
+	if err := sm.StartService("hang"); err != nil {
+		t.Fatal(err)
+	}
+	defer sm.StopService("hang")
+	first, _ := sm.GetServiceStatus("hang")
+
+	select {
+	case e := <-unhealthy:
+		if lc := e.Data.(LifecycleEvent); lc.Reason != "watchdog timeout" || lc.Pid != first.Pid {
+			t.Errorf("Unexpected unhealthy event %+v", lc)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected watchdog to expire")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	var lines []string
+	for time.Now().Before(deadline) {
+		data, _ := os.ReadFile(out)
+		if lines = strings.Fields(string(data)); len(lines) >= 2 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	second, _ := sm.GetServiceStatus("hang")
+	if len(lines) < 2 || lines[0] != "300000" || second.Pid == first.Pid || second.State != StateRunning {
+		t.Fatalf("Expected service restarted with WATCHDOG_USEC=300000, got %q, %+v", lines, second)
+	}
+	if second.RestartCount != 1 {
+		t.Errorf("Expected watchdog restart to be counted, got %d", second.RestartCount)
+	}
+
+	// WATCHDOG=trigger 立即按超时处理，来自未知进程的通知被忽略
+	sm.handleNotify(1, "WATCHDOG=trigger")
+	sm.handleNotify(second.Pid, "WATCHDOG=trigger")
+	select {
+	case e := <-unhealthy:
+		if lc := e.Data.(LifecycleEvent); lc.Reason != "watchdog triggered by service" || lc.Pid != second.Pid {
+			t.Errorf("Unexpected unhealthy event %+v", lc)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected WATCHDOG=trigger to restart the service")
+	}
+}
+
+// TestWatchdogHelper 是 TestServiceWatchdog 的服务进程：记录 WATCHDOG_USEC，
+// 通过 NOTIFY_SOCKET 每 100ms 喂狗一次，共三次，然后挂起
+func TestWatchdogHelper(t *testing.T) {
+	out := os.Getenv("LDH_WATCHDOG_TEST_OUT")
+	if out == "" {
+		t.Skip("Only runs as a service with a watchdog")
+	}
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		os.Exit(2)
+	}
+	fmt.Fprintln(f, os.Getenv("WATCHDOG_USEC"))
+	f.Close()
+
+	conn, err := net.Dial("unixgram", os.Getenv("NOTIFY_SOCKET"))
+	if err != nil {
+		os.Exit(3)
+	}
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		conn.Write([]byte("WATCHDOG=1"))
+	}
+	select {}
+}
@@ -3,8 +3,24 @@ package service
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 )
 
+// MCPDurationBuckets MCP 调用耗时直方图各个桶的上界（秒）
+var MCPDurationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 60}
+
+// MCPCallStats 一个 MCP 功能的调用统计
+type MCPCallStats struct {
+	Service  string   `json:"service"`
+	Function string   `json:"function"`
+	Calls    uint64   `json:"calls"`
+	Errors   uint64   `json:"errors"`
+	Duration float64  `json:"duration_seconds"` // 累计耗时
+	Buckets  []uint64 `json:"buckets"`          // 耗时不超过 MCPDurationBuckets 对应上界的调用数（累计）
+}
+
 // MCPFunction 定义 MCP 功能处理函数类型
 type MCPFunction func(params map[string]interface{}) (interface{}, error)
 
@@ -25,17 +41,24 @@ type MCPResponse struct {
 // MCPHandler MCP 协议处理器
 type MCPHandler struct {
 	functions map[string]map[string]MCPFunction // service -> function -> handler
+	mu        sync.RWMutex
+	stats     map[string]*MCPCallStats // service.function -> 调用统计
+	statsMu   sync.Mutex
 }
 
 // NewMCPHandler 创建新的 MCP 处理器
 func NewMCPHandler() *MCPHandler {
 	return &MCPHandler{
 		functions: make(map[string]map[string]MCPFunction),
+		stats:     make(map[string]*MCPCallStats),
 	}
 }
 
 // RegisterFunction 注册 MCP 功能
 func (h *MCPHandler) RegisterFunction(service, name string, fn MCPFunction) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
 	if _, exists := h.functions[service]; !exists {
 		h.functions[service] = make(map[string]MCPFunction)
 	}
@@ -50,9 +73,13 @@ func (h *MCPHandler) RegisterFunction(service, name string, fn MCPFunction) erro
 
 // HandleRequest 处理 MCP 请求
 func (h *MCPHandler) HandleRequest(req *MCPRequest) *MCPResponse {
+	h.mu.RLock()
+	serviceFuncs, serviceExists := h.functions[req.Service]
+	fn, exists := serviceFuncs[req.Function]
+	h.mu.RUnlock()
+
 	// 检查服务是否存在
-	serviceFuncs, exists := h.functions[req.Service]
-	if !exists {
+	if !serviceExists {
 		return &MCPResponse{
 			Success: false,
 			Error:   fmt.Sprintf("service %s not found", req.Service),
@@ -60,7 +87,6 @@ func (h *MCPHandler) HandleRequest(req *MCPRequest) *MCPResponse {
 	}
 
 	// 检查功能是否存在
-	fn, exists := serviceFuncs[req.Function]
 	if !exists {
 		return &MCPResponse{
 			Success: false,
@@ -69,7 +95,9 @@ func (h *MCPHandler) HandleRequest(req *MCPRequest) *MCPResponse {
 	}
 
 	// 执行功能
+	start := time.Now()
 	result, err := fn(req.Params)
+	h.record(req.Service, req.Function, time.Since(start), err)
 	if err != nil {
 		return &MCPResponse{
 			Success: false,
@@ -99,6 +127,9 @@ func (h *MCPHandler) NotifyLLM(event ServiceEvent) error {
 
 // GetRegisteredFunctions 获取已注册的功能列表
 func (h *MCPHandler) GetRegisteredFunctions(service string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
 	if serviceFuncs, exists := h.functions[service]; exists {
 		functions := make([]string, 0, len(serviceFuncs))
 		for name := range serviceFuncs {
@@ -108,3 +139,47 @@ func (h *MCPHandler) GetRegisteredFunctions(service string) []string {
 	}
 	return nil
 }
+
+// record 记录一次调用的耗时和结果
+func (h *MCPHandler) record(service, function string, d time.Duration, err error) {
+	h.statsMu.Lock()
+	defer h.statsMu.Unlock()
+
+	key := service + "." + function
+	st, exists := h.stats[key]
+	if !exists {
+		st = &MCPCallStats{Service: service, Function: function, Buckets: make([]uint64, len(MCPDurationBuckets))}
+		h.stats[key] = st
+	}
+	st.Calls++
+	if err != nil {
+		st.Errors++
+	}
+	seconds := d.Seconds()
+	st.Duration += seconds
+	for i, le := range MCPDurationBuckets {
+		if seconds <= le {
+			st.Buckets[i]++
+		}
+	}
+}
+
+// Stats 返回被调用过的 MCP 功能的统计，按服务名和功能名排序
+func (h *MCPHandler) Stats() []MCPCallStats {
+	h.statsMu.Lock()
+	result := make([]MCPCallStats, 0, len(h.stats))
+	for _, st := range h.stats {
+		c := *st
+		c.Buckets = append([]uint64(nil), st.Buckets...)
+		result = append(result, c)
+	}
+	h.statsMu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Service != result[j].Service {
+			return result[i].Service < result[j].Service
+		}
+		return result[i].Function < result[j].Function
+	})
+	return result
+}
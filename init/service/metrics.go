@@ -0,0 +1,421 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"ldh-os/init/logging"
+)
+
+const (
+	// DefaultMetricsInterval 资源采样的默认间隔
+	DefaultMetricsInterval = 10 * time.Second
+	// DefaultMetricsHistory 每个服务在内存中保留的采样数
+	DefaultMetricsHistory = 60
+	// clockTicks /proc 中 CPU 时间的单位（USER_HZ）
+	clockTicks = 100
+	// cgroupRoot cgroup v2 的挂载点
+	cgroupRoot = "/sys/fs/cgroup"
+)
+
+// ResourceUsage 服务在一次采样时的资源使用，统计服务主进程及其所有子孙进程
+type ResourceUsage struct {
+	Time       time.Time    `json:"time"`
+	Pid        int          `json:"pid"`
+	Processes  int          `json:"processes"`
+	CPUTime    float64      `json:"cpu_seconds"` // 累计 CPU 时间（用户态和内核态），包括已回收的子进程
+	CPUPercent float64      `json:"cpu_percent"` // 与上一次采样之间的 CPU 使用率，100 表示占满一个核
+	RSS        uint64       `json:"rss_bytes"`
+	FDs        int          `json:"fds"`
+	Threads    int          `json:"threads"`
+	ReadBytes  uint64       `json:"read_bytes"`  // 累计从存储读取的字节数
+	WriteBytes uint64       `json:"write_bytes"` // 累计写入存储的字节数
+	Cgroup     *CgroupUsage `json:"cgroup,omitempty"`
+}
+
+// CgroupUsage 服务主进程所在 cgroup（v2）的统计，只在服务有自己的 cgroup 时采集
+type CgroupUsage struct {
+	Path           string    `json:"path"`
+	MemoryCurrent  uint64    `json:"memory_current"`
+	MemoryMax      uint64    `json:"memory_max,omitempty"` // 0 表示不限制
+	CPUPressure    *Pressure `json:"cpu_pressure,omitempty"`
+	MemoryPressure *Pressure `json:"memory_pressure,omitempty"`
+	IOPressure     *Pressure `json:"io_pressure,omitempty"`
+}
+
+// Pressure PSI 压力统计：some 为至少一个任务因资源不足停顿的时间比例，full 为所有任务都停顿的比例
+type Pressure struct {
+	Some PressureStats `json:"some"`
+	Full PressureStats `json:"full"`
+}
+
+// PressureStats 最近 10、60、300 秒的平均停顿百分比和累计停顿时间
+type PressureStats struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	Total  uint64  `json:"total"` // 微秒
+}
+
+// ServiceMetrics 服务的资源使用时间序列，按时间升序
+type ServiceMetrics struct {
+	Service string          `json:"service"`
+	Samples []ResourceUsage `json:"samples"`
+}
+
+// metricsHistory 服务最近的采样，受 Service.mu 保护
+type metricsHistory struct {
+	samples []ResourceUsage // 环形缓冲区
+	next    int
+	full    bool
+}
+
+func (h *metricsHistory) add(u ResourceUsage) {
+	if len(h.samples) < DefaultMetricsHistory {
+		h.samples = append(h.samples, u)
+		return
+	}
+	h.samples[h.next] = u
+	h.next = (h.next + 1) % DefaultMetricsHistory
+	h.full = true
+}
+
+// latest 返回最近一次采样
+func (h *metricsHistory) latest() (ResourceUsage, bool) {
+	if len(h.samples) == 0 {
+		return ResourceUsage{}, false
+	}
+	i := len(h.samples) - 1
+	if h.full {
+		i = (h.next + DefaultMetricsHistory - 1) % DefaultMetricsHistory
+	}
+	return h.samples[i], true
+}
+
+// list 按时间顺序返回最近的 limit 个采样，limit 为 0 时返回全部
+func (h *metricsHistory) list(limit int) []ResourceUsage {
+	result := make([]ResourceUsage, 0, len(h.samples))
+	if h.full {
+		result = append(result, h.samples[h.next:]...)
+		result = append(result, h.samples[:h.next]...)
+	} else {
+		result = append(result, h.samples...)
+	}
+	if limit > 0 && len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result
+}
+
+// StartMetrics 按 interval 定期采样所有运行中服务的资源使用
+func (sm *ServiceManager) StartMetrics(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultMetricsInterval
+	}
+	go func() {
+		for {
+			sm.sampleMetrics()
+			time.Sleep(interval)
+		}
+	}()
+	logging.Debug("Collecting service metrics", "interval", interval)
+}
+
+// sampleMetrics 扫描一次 /proc，为每个运行中的服务记录一个采样
+func (sm *ServiceManager) sampleMetrics() {
+	sm.mu.RLock()
+	services := make([]*Service, 0, len(sm.services))
+	for _, service := range sm.services {
+		services = append(services, service)
+	}
+	sm.mu.RUnlock()
+
+	children := processChildren()
+	// 只有 cgroupRoot 挂载的是 cgroup v2 时才读取 cgroup 统计
+	cgroup2 := isCgroup2(cgroupRoot)
+	initCgroup := ""
+	if cgroup2 {
+		initCgroup = processCgroup(os.Getpid())
+	}
+	now := time.Now()
+	for _, service := range services {
+		status := service.GetStatus()
+		if status.State != StateRunning || status.Pid <= 0 {
+			continue
+		}
+		usage := sampleProcessTree(status.Pid, children)
+		usage.Time = now
+		// 与 init 同在一个 cgroup 时统计的是 init 所在的整个 cgroup，不归入服务
+		if cgroup2 {
+			if path := processCgroup(status.Pid); path != "" && path != "/" && path != initCgroup {
+				usage.Cgroup = readCgroup(cgroupRoot, path)
+			}
+		}
+		service.recordUsage(usage)
+	}
+}
+
+// recordUsage 计算 CPU 使用率并保存采样
+func (s *Service) recordUsage(u ResourceUsage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.metrics == nil {
+		s.metrics = &metricsHistory{}
+	}
+	if prev, ok := s.metrics.latest(); ok && prev.Pid == u.Pid && u.Time.After(prev.Time) && u.CPUTime >= prev.CPUTime {
+		u.CPUPercent = (u.CPUTime - prev.CPUTime) / u.Time.Sub(prev.Time).Seconds() * 100
+	}
+	s.metrics.add(u)
+}
+
+// Metrics 返回服务最近的 limit 个采样（0 表示全部）
+func (sm *ServiceManager) Metrics(name string, limit int) (ServiceMetrics, error) {
+	service, exists := sm.lookup(name)
+	if !exists {
+		return ServiceMetrics{}, fmt.Errorf("service %s not found", name)
+	}
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	result := ServiceMetrics{Service: name, Samples: []ResourceUsage{}}
+	if service.metrics != nil {
+		result.Samples = service.metrics.list(limit)
+	}
+	return result, nil
+}
+
+// AllMetrics 返回所有服务最近的 limit 个采样，按服务名排序，没有采样的服务被省略
+func (sm *ServiceManager) AllMetrics(limit int) []ServiceMetrics {
+	sm.mu.RLock()
+	names := make([]string, 0, len(sm.services))
+	for name := range sm.services {
+		names = append(names, name)
+	}
+	sm.mu.RUnlock()
+	sort.Strings(names)
+
+	result := []ServiceMetrics{}
+	for _, name := range names {
+		if m, err := sm.Metrics(name, limit); err == nil && len(m.Samples) > 0 {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// processChildren 扫描 /proc 建立父进程到子进程的映射
+func processChildren() map[int][]int {
+	children := make(map[int][]int)
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return children
+	}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if ppid, _, ok := readStatFields(pid); ok {
+			children[ppid] = append(children[ppid], pid)
+		}
+	}
+	return children
+}
+
+// readStatFields 读取 /proc/<pid>/stat 中 comm 之后的字段，fields[0] 是状态，fields[1] 是父进程号
+func readStatFields(pid int) (ppid int, fields []string, ok bool) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, nil, false
+	}
+	s := string(data)
+	i := strings.LastIndexByte(s, ')')
+	if i < 0 {
+		return 0, nil, false
+	}
+	fields = strings.Fields(s[i+1:])
+	if len(fields) < 22 {
+		return 0, nil, false
+	}
+	ppid, err = strconv.Atoi(fields[1])
+	return ppid, fields, err == nil
+}
+
+// sampleProcessTree 汇总进程及其子孙进程的资源使用
+func sampleProcessTree(pid int, children map[int][]int) ResourceUsage {
+	u := ResourceUsage{Pid: pid}
+	pageSize := uint64(os.Getpagesize())
+	queue := []int{pid}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = append(queue[1:], children[p]...)
+
+		_, fields, ok := readStatFields(p)
+		if !ok || fields[0] == "Z" {
+			continue
+		}
+		u.Processes++
+		// utime、stime、cutime、cstime 是第 14-17 个字段，num_threads 是第 20 个，rss 是第 24 个
+		for _, f := range fields[11:15] {
+			ticks, _ := strconv.ParseUint(f, 10, 64)
+			u.CPUTime += float64(ticks) / clockTicks
+		}
+		threads, _ := strconv.Atoi(fields[17])
+		u.Threads += threads
+		rss, _ := strconv.ParseUint(fields[21], 10, 64)
+		u.RSS += rss * pageSize
+
+		if fds, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/fd", p)); err == nil {
+			u.FDs += len(fds)
+		}
+		read, write := readProcIO(p)
+		u.ReadBytes += read
+		u.WriteBytes += write
+	}
+	return u
+}
+
+// readProcIO 读取 /proc/<pid>/io 中的存储读写字节数
+func readProcIO(pid int) (read, write uint64) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/io", pid))
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ": ")
+		if !ok {
+			continue
+		}
+		n, _ := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		switch key {
+		case "read_bytes":
+			read = n
+		case "write_bytes":
+			write = n
+		}
+	}
+	return read, write
+}
+
+// isCgroup2 判断 root 上挂载的是否是 cgroup v2
+func isCgroup2(root string) bool {
+	var st unix.Statfs_t
+	return unix.Statfs(root, &st) == nil && st.Type == unix.CGROUP2_SUPER_MAGIC
+}
+
+// processCgroup 返回进程所在的 cgroup v2 路径，进程不在 v2 层级中时为空
+func processCgroup(pid int) string {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return ""
+	}
+	return parseCgroup(string(data))
+}
+
+// parseCgroup 从 /proc/<pid>/cgroup 的内容中取出 v2 层级的路径（"0::" 行），只有 v1 层级时为空
+func parseCgroup(data string) string {
+	for _, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::")
+		}
+	}
+	return ""
+}
+
+// readCgroup 读取 root 下 cgroup 的内存用量和压力统计，cgroup 文件不存在时返回 nil
+func readCgroup(root, path string) *CgroupUsage {
+	dir := filepath.Join(root, path)
+	current, err := readUintFile(filepath.Join(dir, "memory.current"))
+	if err != nil {
+		return nil
+	}
+	c := &CgroupUsage{Path: path, MemoryCurrent: current}
+	c.MemoryMax, _ = readUintFile(filepath.Join(dir, "memory.max"))
+	c.CPUPressure = readPressure(filepath.Join(dir, "cpu.pressure"))
+	c.MemoryPressure = readPressure(filepath.Join(dir, "memory.pressure"))
+	c.IOPressure = readPressure(filepath.Join(dir, "io.pressure"))
+	return c
+}
+
+// readUintFile 读取只含一个数字的文件，"max" 读作 0
+func readUintFile(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(data))
+	if s == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// readPressure 读取 PSI 文件，不存在时返回 nil
+func readPressure(path string) *Pressure {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	p, err := ParsePressure(string(data))
+	if err != nil {
+		return nil
+	}
+	return &p
+}
+
+// ParsePressure 解析 PSI 文件内容：
+// "some avg10=0.00 avg60=0.00 avg300=0.00 total=0"，以及可选的 full 行
+func ParsePressure(data string) (Pressure, error) {
+	var p Pressure
+	found := false
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var stats *PressureStats
+		switch fields[0] {
+		case "some":
+			stats = &p.Some
+		case "full":
+			stats = &p.Full
+		default:
+			return p, fmt.Errorf("unexpected pressure line %q", line)
+		}
+		for _, kv := range fields[1:] {
+			key, value, ok := strings.Cut(kv, "=")
+			if !ok {
+				return p, fmt.Errorf("malformed pressure field %q", kv)
+			}
+			var err error
+			switch key {
+			case "avg10":
+				stats.Avg10, err = strconv.ParseFloat(value, 64)
+			case "avg60":
+				stats.Avg60, err = strconv.ParseFloat(value, 64)
+			case "avg300":
+				stats.Avg300, err = strconv.ParseFloat(value, 64)
+			case "total":
+				stats.Total, err = strconv.ParseUint(value, 10, 64)
+			}
+			if err != nil {
+				return p, fmt.Errorf("malformed pressure field %q", kv)
+			}
+		}
+		found = true
+	}
+	if !found {
+		return p, fmt.Errorf("empty pressure data")
+	}
+	return p, nil
+}
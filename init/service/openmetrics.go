@@ -0,0 +1,201 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenMetricsContentType OpenMetrics 文本格式的 Content-Type
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// serviceStates 导出的所有服务状态，stateset 中每个状态一个样本
+var serviceStates = []ServiceState{StateStarting, StateRunning, StateStopping, StateStopped, StateFailed, StateUnknown}
+
+// WriteOpenMetrics 以 OpenMetrics 文本格式输出服务状态、重启次数、运行时长、健康状况、
+// 资源使用、MCP 调用统计和事件总线统计
+func (sm *ServiceManager) WriteOpenMetrics(w io.Writer) error {
+	sm.mu.RLock()
+	services := make([]*Service, 0, len(sm.services))
+	for _, service := range sm.services {
+		services = append(services, service)
+	}
+	sm.mu.RUnlock()
+	sort.Slice(services, func(i, j int) bool { return services[i].Config.Name < services[j].Config.Name })
+
+	now := time.Now()
+	m := &metricsWriter{w: bufio.NewWriter(w)}
+
+	type snapshot struct {
+		name      string
+		status    ServiceStatus
+		since     time.Time
+		healthy   bool
+		unhealthy uint64
+	}
+	snapshots := make([]snapshot, 0, len(services))
+	for _, service := range services {
+		status := service.GetStatus()
+		service.mu.Lock()
+		snapshots = append(snapshots, snapshot{
+			name:      service.Config.Name,
+			status:    status,
+			since:     service.since,
+			healthy:   service.healthy,
+			unhealthy: service.unhealthy,
+		})
+		service.mu.Unlock()
+	}
+
+	m.family("ldh_service_state", "stateset", "", "Current state of the service.")
+	for _, s := range snapshots {
+		for _, state := range serviceStates {
+			m.sample("ldh_service_state", labels("service", s.name, "ldh_service_state", string(state)), boolValue(s.status.State == state))
+		}
+	}
+	m.family("ldh_service_state_duration_seconds", "gauge", "seconds", "Time since the service entered its current state.")
+	for _, s := range snapshots {
+		if !s.since.IsZero() {
+			m.sample("ldh_service_state_duration_seconds", labels("service", s.name), now.Sub(s.since).Seconds())
+		}
+	}
+	m.family("ldh_service_restarts", "counter", "", "Restarts of the service, automatic and manual, kept across reboots.")
+	for _, s := range snapshots {
+		m.sample("ldh_service_restarts_total", labels("service", s.name), float64(s.status.RestartCount))
+	}
+	m.family("ldh_service_uptime_seconds", "gauge", "seconds", "Time since the running service process was started.")
+	for _, s := range snapshots {
+		if s.status.State == StateRunning && !s.status.StartTime.IsZero() {
+			m.sample("ldh_service_uptime_seconds", labels("service", s.name), now.Sub(s.status.StartTime).Seconds())
+		}
+	}
+	m.family("ldh_service_healthy", "gauge", "", "Whether the running service has had no unhealthy reports since it started.")
+	for _, s := range snapshots {
+		if s.status.State == StateRunning {
+			m.sample("ldh_service_healthy", labels("service", s.name), boolValue(s.healthy))
+		}
+	}
+	m.family("ldh_service_unhealthy_reports", "counter", "", "Failed health checks and watchdog timeouts of the service.")
+	for _, s := range snapshots {
+		m.sample("ldh_service_unhealthy_reports_total", labels("service", s.name), float64(s.unhealthy))
+	}
+
+	m.family("ldh_service_cpu_seconds", "counter", "seconds", "CPU time used by the service process tree, as of the latest sample.")
+	for _, s := range snapshots {
+		if u := s.status.Metrics; u != nil {
+			m.sample("ldh_service_cpu_seconds_total", labels("service", s.name), u.CPUTime)
+		}
+	}
+	m.family("ldh_service_memory_rss_bytes", "gauge", "bytes", "Resident memory of the service process tree, as of the latest sample.")
+	for _, s := range snapshots {
+		if u := s.status.Metrics; u != nil {
+			m.sample("ldh_service_memory_rss_bytes", labels("service", s.name), float64(u.RSS))
+		}
+	}
+
+	calls := sm.mcpHandler.Stats()
+	m.family("ldh_mcp_calls", "counter", "", "MCP function calls.")
+	for _, c := range calls {
+		m.sample("ldh_mcp_calls_total", labels("service", c.Service, "function", c.Function), float64(c.Calls))
+	}
+	m.family("ldh_mcp_call_errors", "counter", "", "MCP function calls that returned an error.")
+	for _, c := range calls {
+		m.sample("ldh_mcp_call_errors_total", labels("service", c.Service, "function", c.Function), float64(c.Errors))
+	}
+	m.family("ldh_mcp_call_duration_seconds", "histogram", "seconds", "Latency of MCP function calls.")
+	for _, c := range calls {
+		for i, le := range MCPDurationBuckets {
+			m.sample("ldh_mcp_call_duration_seconds_bucket",
+				labels("service", c.Service, "function", c.Function, "le", formatValue(le)), float64(c.Buckets[i]))
+		}
+		l := labels("service", c.Service, "function", c.Function)
+		m.sample("ldh_mcp_call_duration_seconds_bucket", l+`,le="+Inf"`, float64(c.Calls))
+		m.sample("ldh_mcp_call_duration_seconds_count", l, float64(c.Calls))
+		m.sample("ldh_mcp_call_duration_seconds_sum", l, c.Duration)
+	}
+
+	bus := sm.eventBus.Stats()
+	m.family("ldh_events", "counter", "", "Events published on the event bus.")
+	m.sample("ldh_events_total", "", float64(bus.Emitted))
+	m.family("ldh_events_dropped", "counter", "", "Events dropped because a subscriber queue was full.")
+	m.sample("ldh_events_dropped_total", "", float64(bus.Dropped))
+	m.family("ldh_event_subscribers", "gauge", "", "Event bus subscribers.")
+	m.sample("ldh_event_subscribers", "", float64(len(bus.Subscribers)))
+
+	m.printf("# EOF\n")
+	if m.err != nil {
+		return m.err
+	}
+	return m.w.Flush()
+}
+
+// metricsWriter 输出 OpenMetrics 文本，记录第一个写入错误
+type metricsWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (m *metricsWriter) printf(format string, args ...interface{}) {
+	if m.err == nil {
+		_, m.err = fmt.Fprintf(m.w, format, args...)
+	}
+}
+
+// family 输出指标族的元数据
+func (m *metricsWriter) family(name, typ, unit, help string) {
+	m.printf("# TYPE %s %s\n", name, typ)
+	if unit != "" {
+		m.printf("# UNIT %s %s\n", name, unit)
+	}
+	m.printf("# HELP %s %s\n", name, help)
+}
+
+// sample 输出一个样本，labels 为 labels() 的结果
+func (m *metricsWriter) sample(name, labels string, value float64) {
+	if labels == "" {
+		m.printf("%s %s\n", name, formatValue(value))
+		return
+	}
+	m.printf("%s{%s} %s\n", name, labels, formatValue(value))
+}
+
+// labels 把成对的标签名和值拼成 name="value" 列表，值中的反斜杠、引号和换行被转义
+func labels(pairs ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		for _, r := range pairs[i+1] {
+			switch r {
+			case '\\':
+				b.WriteString(`\\`)
+			case '"':
+				b.WriteString(`\"`)
+			case '\n':
+				b.WriteString(`\n`)
+			default:
+				b.WriteRune(r)
+			}
+		}
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
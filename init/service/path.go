@@ -0,0 +1,409 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"ldh-os/init/logging"
+)
+
+const (
+	// DefaultPathDebounce 路径变化后默认的等待时间
+	DefaultPathDebounce = 500 * time.Millisecond
+	// pathRetry 监视的目录尚不存在时重试的间隔
+	pathRetry = 5 * time.Second
+	// maxPathFiles 一次触发最多记录的文件数
+	maxPathFiles = 64
+)
+
+// 路径条件
+const (
+	pathExists      = "exists"
+	pathChanged     = "changed"
+	pathDirNotEmpty = "dir_not_empty"
+)
+
+// pathMask 监视目录时关心的 inotify 事件
+const pathMask = unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM |
+	unix.IN_DELETE | unix.IN_ATTRIB | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+// pathWatch 一个服务的一个路径条件
+type pathWatch struct {
+	service  string
+	kind     string
+	path     string
+	dir      string // inotify 监视的目录
+	name     string // 只关心 dir 中的这个文件，为空时关心所有文件
+	debounce time.Duration
+	wd       int        // 监视描述符，尚未监视时为 -1
+	pending  *PathEvent // 防抖期间累积的变化
+	deferred *PathEvent // 服务运行期间发生的变化，服务停止后再触发
+	timer    *time.Timer
+}
+
+// pathWatcher 所有服务的路径监视，共用一个 inotify 实例
+type pathWatcher struct {
+	fd       int
+	watches  []*pathWatch
+	retrying bool // 正在重试监视不存在的目录
+	mu       sync.Mutex
+}
+
+// validate 检查路径条件并返回条件类型和路径
+func (p PathTrigger) validate() (kind, path string, err error) {
+	set := 0
+	for _, c := range []struct{ kind, path string }{
+		{pathExists, p.Exists}, {pathChanged, p.Changed}, {pathDirNotEmpty, p.DirNotEmpty},
+	} {
+		if c.path != "" {
+			kind, path = c.kind, c.path
+			set++
+		}
+	}
+	if set != 1 {
+		return "", "", fmt.Errorf("path trigger needs exactly one of exists, changed, dir_not_empty")
+	}
+	if !filepath.IsAbs(path) {
+		return "", "", fmt.Errorf("path trigger %q is not absolute", path)
+	}
+	return kind, filepath.Clean(path), nil
+}
+
+// newPathWatch 根据条件确定监视的目录：exists 和普通文件的 changed 监视父目录，
+// dir_not_empty 和目录的 changed 监视目录本身
+func newPathWatch(service string, trigger PathTrigger) (*pathWatch, error) {
+	kind, path, err := trigger.validate()
+	if err != nil {
+		return nil, err
+	}
+	w := &pathWatch{service: service, kind: kind, path: path, wd: -1, debounce: trigger.Debounce}
+	if w.debounce <= 0 {
+		w.debounce = DefaultPathDebounce
+	}
+	info, err := os.Stat(path)
+	isDir := err == nil && info.IsDir()
+	if kind == pathDirNotEmpty || (kind == pathChanged && isDir) {
+		w.dir = path
+	} else {
+		w.dir, w.name = filepath.Dir(path), filepath.Base(path)
+	}
+	return w, nil
+}
+
+// holds 判断 exists、dir_not_empty 条件当前是否成立，changed 总是不成立
+func (w *pathWatch) holds() bool {
+	switch w.kind {
+	case pathExists:
+		_, err := os.Lstat(w.path)
+		return err == nil
+	case pathDirNotEmpty:
+		d, err := os.Open(w.path)
+		if err != nil {
+			return false
+		}
+		defer d.Close()
+		_, err = d.Readdirnames(1)
+		return err == nil
+	}
+	return false
+}
+
+// StartPathTriggers 开始监视服务的路径条件，启动时已经成立的 exists、dir_not_empty 条件立即触发服务。
+// 应在启动目标之后调用
+func (sm *ServiceManager) StartPathTriggers() error {
+	sm.mu.RLock()
+	var watches []*pathWatch
+	for name, service := range sm.services {
+		for _, trigger := range service.Config.StartOnPath {
+			w, err := newPathWatch(name, trigger)
+			if err != nil {
+				sm.mu.RUnlock()
+				return err
+			}
+			watches = append(watches, w)
+		}
+	}
+	sm.mu.RUnlock()
+	if len(watches) == 0 {
+		return nil
+	}
+
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("failed to initialize inotify: %v", err)
+	}
+	pw := &pathWatcher{fd: fd, watches: watches}
+	pw.mu.Lock()
+	if pw.addWatchesLocked() {
+		pw.retrying = true
+		go sm.retryPathWatches(pw)
+	}
+	pw.mu.Unlock()
+	go sm.readPathEvents(pw)
+	// 服务失败时同样触发累积的变化，否则它们会丢失；
+	// 随后自动重启时保留这些变化，等重启后的进程停止再触发
+	sm.eventBus.subscribeInternal(EventFilter{Types: []EventType{EventStopped, EventFailed}}, func(event ServiceEvent) {
+		if detail, ok := event.Data.(LifecycleEvent); ok && detail.Restarting {
+			return
+		}
+		sm.handlePathStopped(pw, event.Service)
+	})
+
+	for _, w := range watches {
+		if w.holds() {
+			sm.firePath(pw, w, &PathEvent{Trigger: w.kind, Path: w.path, Files: []string{w.path}, Ops: []string{"exists"}})
+		}
+	}
+	return nil
+}
+
+// addWatchesLocked 为尚未监视的条件添加 inotify 监视，返回是否仍有目录不存在。调用方需持有 pw.mu
+func (pw *pathWatcher) addWatchesLocked() bool {
+	missing := false
+	for _, w := range pw.watches {
+		if w.wd >= 0 {
+			continue
+		}
+		wd, err := unix.InotifyAddWatch(pw.fd, w.dir, pathMask)
+		if err != nil {
+			if err != unix.ENOENT && err != unix.ENOTDIR {
+				logging.Warn("Failed to watch path", "service", w.service, "path", w.dir, "error", err)
+			}
+			missing = true
+			continue
+		}
+		w.wd = wd
+	}
+	return missing
+}
+
+// retryPathWatches 定期重试监视尚不存在的目录，目录出现后立即检查条件
+func (sm *ServiceManager) retryPathWatches(pw *pathWatcher) {
+	for {
+		time.Sleep(pathRetry)
+		pw.mu.Lock()
+		var added []*pathWatch
+		for _, w := range pw.watches {
+			if w.wd < 0 {
+				added = append(added, w)
+			}
+		}
+		missing := pw.addWatchesLocked()
+		pw.retrying = missing
+		pw.mu.Unlock()
+
+		for _, w := range added {
+			if w.wd >= 0 && w.holds() {
+				sm.schedulePath(pw, w, w.path, "create")
+			}
+		}
+		if !missing {
+			return
+		}
+	}
+}
+
+// readPathEvents 读取 inotify 事件并交给匹配的条件
+func (sm *ServiceManager) readPathEvents(pw *pathWatcher) {
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := unix.Read(pw.fd, buf)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			logging.Warn("Path watcher stopped", "error", err)
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			name := string(bytes.TrimRight(buf[offset+unix.SizeofInotifyEvent:offset+unix.SizeofInotifyEvent+int(ev.Len)], "\x00"))
+			offset += unix.SizeofInotifyEvent + int(ev.Len)
+			sm.handlePathEvent(pw, int(ev.Wd), ev.Mask, name)
+		}
+	}
+}
+
+// handlePathEvent 处理一个 inotify 事件
+func (sm *ServiceManager) handlePathEvent(pw *pathWatcher, wd int, mask uint32, name string) {
+	op := pathOp(mask)
+	pw.mu.Lock()
+	var matched []*pathWatch
+	for _, w := range pw.watches {
+		if w.wd != wd {
+			continue
+		}
+		// 监视的目录被删除或移走，之后重新等待它出现
+		if mask&(unix.IN_IGNORED|unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0 {
+			if mask&unix.IN_IGNORED != 0 {
+				w.wd = -1
+				if !pw.retrying {
+					pw.retrying = true
+					go sm.retryPathWatches(pw)
+				}
+			}
+			if w.kind == pathChanged && w.name == "" && mask&unix.IN_IGNORED == 0 {
+				matched = append(matched, w)
+			}
+			continue
+		}
+		if w.name != "" && name != w.name {
+			continue
+		}
+		// exists、dir_not_empty 只关心新出现的文件
+		if w.kind != pathChanged && op != "create" && op != "move" {
+			continue
+		}
+		matched = append(matched, w)
+	}
+	pw.mu.Unlock()
+
+	for _, w := range matched {
+		file := w.dir
+		if name != "" {
+			file = filepath.Join(w.dir, name)
+		}
+		sm.schedulePath(pw, w, file, op)
+	}
+}
+
+// pathOp 把 inotify 事件掩码转换为操作名称
+func pathOp(mask uint32) string {
+	switch {
+	case mask&unix.IN_CREATE != 0:
+		return "create"
+	case mask&unix.IN_CLOSE_WRITE != 0:
+		return "write"
+	case mask&(unix.IN_MOVED_TO|unix.IN_MOVED_FROM|unix.IN_MOVE_SELF) != 0:
+		return "move"
+	case mask&(unix.IN_DELETE|unix.IN_DELETE_SELF) != 0:
+		return "delete"
+	case mask&unix.IN_ATTRIB != 0:
+		return "attrib"
+	}
+	return "other"
+}
+
+// schedulePath 累积一次变化，防抖时间内没有新的变化后触发
+func (sm *ServiceManager) schedulePath(pw *pathWatcher, w *pathWatch, file, op string) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	if w.pending == nil {
+		w.pending = &PathEvent{Trigger: w.kind, Path: w.path}
+	}
+	w.pending.add(file, op)
+	if w.timer != nil {
+		w.timer.Reset(w.debounce)
+		return
+	}
+	w.timer = time.AfterFunc(w.debounce, func() {
+		pw.mu.Lock()
+		event := w.pending
+		w.pending, w.timer = nil, nil
+		pw.mu.Unlock()
+		if event != nil {
+			sm.firePath(pw, w, event)
+		}
+	})
+}
+
+// add 记录变化的文件和操作，去掉重复
+func (e *PathEvent) add(file, op string) {
+	if !containsName(e.Files, file) && len(e.Files) < maxPathFiles {
+		e.Files = append(e.Files, file)
+	}
+	if !containsName(e.Ops, op) {
+		e.Ops = append(e.Ops, op)
+	}
+}
+
+// merge 合并另一个事件的变化
+func (e *PathEvent) merge(other *PathEvent) {
+	for _, f := range other.Files {
+		if !containsName(e.Files, f) && len(e.Files) < maxPathFiles {
+			e.Files = append(e.Files, f)
+		}
+	}
+	for _, op := range other.Ops {
+		if !containsName(e.Ops, op) {
+			e.Ops = append(e.Ops, op)
+		}
+	}
+}
+
+// firePath 发布路径事件，条件成立时启动服务。服务正在运行时记下变化，服务停止后再触发
+func (sm *ServiceManager) firePath(pw *pathWatcher, w *pathWatch, event *PathEvent) {
+	sort.Strings(event.Ops)
+	sm.eventBus.Emit(ServiceEvent{Type: EventPathChanged, Service: w.service, Data: *event})
+
+	if w.kind != pathChanged && !w.holds() {
+		return
+	}
+	service, exists := sm.lookup(w.service)
+	if !exists {
+		return
+	}
+	sm.mu.RLock()
+	allowed := sm.inTargetLocked(w.service) && !sm.masked[w.service]
+	sm.mu.RUnlock()
+	if !allowed {
+		logging.Debug("Path trigger for inactive service", "service", w.service, "path", w.path)
+		return
+	}
+	if state := service.GetStatus().State; state == StateRunning || state == StateStarting || state == StateStopping {
+		pw.mu.Lock()
+		if w.deferred == nil {
+			w.deferred = event
+		} else {
+			w.deferred.merge(event)
+		}
+		pw.mu.Unlock()
+		return
+	}
+
+	logging.Info("Path triggered, starting service", "service", w.service, "path", w.path, "ops", strings.Join(event.Ops, ","))
+	service.setTriggerEnv(pathEnv(event))
+	if err := sm.startTriggered(service); err != nil {
+		logging.Warn("Failed to start path-triggered service", "service", w.service, "error", err)
+	}
+}
+
+// handlePathStopped 服务停止或失败后触发它运行期间累积的变化
+func (sm *ServiceManager) handlePathStopped(pw *pathWatcher, service string) {
+	pw.mu.Lock()
+	var fire []*pathWatch
+	var events []*PathEvent
+	for _, w := range pw.watches {
+		if w.service == service && w.deferred != nil {
+			fire = append(fire, w)
+			events = append(events, w.deferred)
+			w.deferred = nil
+		}
+	}
+	pw.mu.Unlock()
+
+	for i, w := range fire {
+		sm.firePath(pw, w, events[i])
+	}
+}
+
+// pathEnv 传给被触发的服务进程的环境变量，文件列表以换行分隔
+func pathEnv(event *PathEvent) []string {
+	return []string{
+		"LDH_TRIGGER=path",
+		"LDH_TRIGGER_TYPE=" + event.Trigger,
+		"LDH_TRIGGER_PATH=" + event.Path,
+		"LDH_TRIGGER_FILES=" + strings.Join(event.Files, "\n"),
+		"LDH_TRIGGER_OPS=" + strings.Join(event.Ops, ","),
+	}
+}
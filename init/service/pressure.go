@@ -0,0 +1,502 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"ldh-os/init/logging"
+)
+
+// PressureLevel 资源压力级别
+type PressureLevel string
+
+const (
+	PressureNormal   PressureLevel = "normal"
+	PressureWarning  PressureLevel = "warning"
+	PressureCritical PressureLevel = "critical"
+)
+
+// rank 返回级别的严重程度，未知级别为 -1
+func (l PressureLevel) rank() int {
+	switch l {
+	case PressureNormal:
+		return 0
+	case PressureWarning:
+		return 1
+	case PressureCritical:
+		return 2
+	}
+	return -1
+}
+
+// 监视的资源，与 /proc/pressure 下的文件名相同
+const (
+	ResourceMemory = "memory"
+	ResourceCPU    = "cpu"
+	ResourceIO     = "io"
+)
+
+var pressureResources = []string{ResourceMemory, ResourceCPU, ResourceIO}
+
+// PressureConfig 压力监视配置（/etc/ldh-os/pressure.yaml）
+type PressureConfig struct {
+	Interval     time.Duration      `yaml:"interval,omitempty"`      // 检查间隔，默认 5s
+	RecoverAfter time.Duration      `yaml:"recover_after,omitempty"` // 低于阈值持续该时长后才降低级别，默认 60s
+	ShedInterval time.Duration      `yaml:"shed_interval,omitempty"` // 两次停止或重新启动服务之间的最短间隔，默认 30s
+	Memory       PressureThresholds `yaml:"memory"`
+	CPU          PressureThresholds `yaml:"cpu"`
+	IO           PressureThresholds `yaml:"io"`
+}
+
+// PressureThresholds 一种资源的 warning 和 critical 阈值
+type PressureThresholds struct {
+	Warning  PressureThreshold `yaml:"warning"`
+	Critical PressureThreshold `yaml:"critical"`
+}
+
+// PressureThreshold 达到任一设置的条件即达到阈值，零值的条件不检查
+type PressureThreshold struct {
+	SomeAvg10        float64 `yaml:"some_avg10,omitempty"`        // PSI some 最近 10 秒的平均停顿百分比
+	FullAvg10        float64 `yaml:"full_avg10,omitempty"`        // PSI full 最近 10 秒的平均停顿百分比
+	AvailablePercent float64 `yaml:"available_percent,omitempty"` // 只用于 memory：可用内存不高于总内存的该百分比
+}
+
+// MemoryStats /proc/meminfo 中的内存统计（字节）
+type MemoryStats struct {
+	Total            uint64  `json:"total"`
+	Available        uint64  `json:"available"`
+	AvailablePercent float64 `json:"available_percent"`
+	SwapTotal        uint64  `json:"swap_total"`
+	SwapFree         uint64  `json:"swap_free"`
+}
+
+// ResourcePressure 一种资源的当前压力
+type ResourcePressure struct {
+	Resource string        `json:"resource"`
+	Level    PressureLevel `json:"level"`
+	Since    time.Time     `json:"since"` // 进入当前级别的时间
+	Pressure *Pressure     `json:"pressure,omitempty"`
+}
+
+// PressureStatus system.pressure 的返回值
+type PressureStatus struct {
+	Resources []ResourcePressure `json:"resources"`
+	Memory    MemoryStats        `json:"memory"`
+	OOMKills  uint64             `json:"oom_kills"`
+	Shed      []string           `json:"shed"` // 因内存压力停止、等待恢复后重新启动的服务，按停止顺序排列
+}
+
+// DefaultPressureConfig 返回内置的压力监视配置
+func DefaultPressureConfig() *PressureConfig {
+	return &PressureConfig{
+		Interval:     5 * time.Second,
+		RecoverAfter: time.Minute,
+		ShedInterval: 30 * time.Second,
+		Memory: PressureThresholds{
+			Warning:  PressureThreshold{SomeAvg10: 20, AvailablePercent: 10},
+			Critical: PressureThreshold{FullAvg10: 10, AvailablePercent: 5},
+		},
+		CPU: PressureThresholds{Warning: PressureThreshold{SomeAvg10: 80}},
+		IO:  PressureThresholds{Warning: PressureThreshold{FullAvg10: 30}},
+	}
+}
+
+// LoadPressureConfig 读取压力监视配置，文件不存在时返回内置配置。
+// 文件中设置了阈值的资源覆盖内置阈值，省略的间隔使用默认值
+func LoadPressureConfig(file string) (*PressureConfig, error) {
+	config := DefaultPressureConfig()
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pressure config: %v", err)
+	}
+
+	var loaded PressureConfig
+	if err := yaml.Unmarshal(data, &loaded); err != nil {
+		return nil, fmt.Errorf("failed to parse pressure config: %v", err)
+	}
+	if loaded.Interval > 0 {
+		config.Interval = loaded.Interval
+	}
+	if loaded.RecoverAfter > 0 {
+		config.RecoverAfter = loaded.RecoverAfter
+	}
+	if loaded.ShedInterval > 0 {
+		config.ShedInterval = loaded.ShedInterval
+	}
+	for _, t := range []struct{ loaded, config *PressureThresholds }{
+		{&loaded.Memory, &config.Memory},
+		{&loaded.CPU, &config.CPU},
+		{&loaded.IO, &config.IO},
+	} {
+		if *t.loaded != (PressureThresholds{}) {
+			*t.config = *t.loaded
+		}
+	}
+	return config, nil
+}
+
+func (c *PressureConfig) thresholds(resource string) PressureThresholds {
+	switch resource {
+	case ResourceMemory:
+		return c.Memory
+	case ResourceCPU:
+		return c.CPU
+	}
+	return c.IO
+}
+
+// level 返回压力和内存统计达到的级别
+func (t PressureThresholds) level(p *Pressure, mem *MemoryStats) PressureLevel {
+	if t.Critical.reached(p, mem) {
+		return PressureCritical
+	}
+	if t.Warning.reached(p, mem) {
+		return PressureWarning
+	}
+	return PressureNormal
+}
+
+func (t PressureThreshold) reached(p *Pressure, mem *MemoryStats) bool {
+	if p != nil {
+		if t.SomeAvg10 > 0 && p.Some.Avg10 >= t.SomeAvg10 {
+			return true
+		}
+		if t.FullAvg10 > 0 && p.Full.Avg10 >= t.FullAvg10 {
+			return true
+		}
+	}
+	return mem != nil && mem.Total > 0 && t.AvailablePercent > 0 && mem.AvailablePercent <= t.AvailablePercent
+}
+
+// resourceLevel 一种资源的级别和降级计时
+type resourceLevel struct {
+	level      PressureLevel
+	since      time.Time
+	lowerSince time.Time // 开始低于当前级别的时间，未低于时为零值
+	pressure   *Pressure
+}
+
+// pressureMonitor 系统资源压力监视器
+type pressureMonitor struct {
+	config      *PressureConfig
+	pressureDir string // /proc/pressure，测试时替换
+	meminfo     string
+	vmstat      string
+	levels      map[string]*resourceLevel
+	memory      MemoryStats
+	oomKills    uint64
+	oomKnown    bool
+	shed        []string  // 因内存压力停止的服务
+	lastAction  time.Time // 上次停止或重新启动服务的时间
+	mu          sync.Mutex
+}
+
+func newPressureMonitor(config *PressureConfig) *pressureMonitor {
+	m := &pressureMonitor{
+		config:      config,
+		pressureDir: "/proc/pressure",
+		meminfo:     "/proc/meminfo",
+		vmstat:      "/proc/vmstat",
+		levels:      make(map[string]*resourceLevel),
+	}
+	for _, resource := range pressureResources {
+		m.levels[resource] = &resourceLevel{level: PressureNormal}
+	}
+	return m
+}
+
+// StartPressureMonitor 开始监视系统的 PSI 和内存统计：压力级别变化时发布 pressure 事件，
+// 内存压力达到服务的 stop_on_pressure 级别时按 oom_score_adj 从大到小逐个停止服务，恢复后重新启动。
+// config 为 nil 时使用内置配置
+func (sm *ServiceManager) StartPressureMonitor(config *PressureConfig) {
+	if config == nil {
+		config = DefaultPressureConfig()
+	}
+	m := newPressureMonitor(config)
+	if _, err := os.Stat(m.pressureDir); err != nil {
+		logging.Warn("Kernel has no PSI support, monitoring available memory only")
+	}
+
+	sm.mu.Lock()
+	sm.pressure = m
+	sm.mu.Unlock()
+
+	go func() {
+		for {
+			sm.checkPressure(m, time.Now())
+			time.Sleep(config.Interval)
+		}
+	}()
+}
+
+// checkPressure 读取一次压力和内存统计，更新级别并处理内存压力
+func (sm *ServiceManager) checkPressure(m *pressureMonitor, now time.Time) {
+	var events []ServiceEvent
+
+	m.mu.Lock()
+	if mem, err := readMemInfo(m.meminfo); err == nil {
+		m.memory = mem
+	}
+	for _, resource := range pressureResources {
+		r := m.levels[resource]
+		r.pressure = readPressure(filepath.Join(m.pressureDir, resource))
+		var mem *MemoryStats
+		if resource == ResourceMemory {
+			mem = &m.memory
+		}
+		target := m.config.thresholds(resource).level(r.pressure, mem)
+		if previous, changed := m.update(r, target, now); changed {
+			data := PressureEvent{Resource: resource, Level: r.level, Previous: previous, Pressure: r.pressure}
+			if mem != nil {
+				memory := *mem
+				data.Memory = &memory
+			}
+			events = append(events, ServiceEvent{Type: EventPressure, Data: data})
+		}
+	}
+	if total, ok := readVMStat(m.vmstat, "oom_kill"); ok {
+		if m.oomKnown && total > m.oomKills {
+			events = append(events, ServiceEvent{Type: EventOOMKill, Data: OOMEvent{Kills: total - m.oomKills, Total: total}})
+		}
+		m.oomKills, m.oomKnown = total, true
+	}
+	level := m.levels[ResourceMemory].level
+	m.mu.Unlock()
+
+	for _, event := range events {
+		sm.eventBus.Emit(event)
+	}
+	sm.respondToPressure(m, level, now)
+}
+
+// update 把资源的级别更新为 target：升高立即生效，降低需要持续 RecoverAfter
+func (m *pressureMonitor) update(r *resourceLevel, target PressureLevel, now time.Time) (PressureLevel, bool) {
+	previous := r.level
+	switch {
+	case target.rank() > r.level.rank():
+		r.level, r.since, r.lowerSince = target, now, time.Time{}
+		return previous, true
+	case target.rank() < r.level.rank():
+		if r.lowerSince.IsZero() {
+			r.lowerSince = now
+		}
+		if now.Sub(r.lowerSince) >= m.config.RecoverAfter {
+			r.level, r.since, r.lowerSince = target, now, time.Time{}
+			return previous, true
+		}
+	default:
+		r.lowerSince = time.Time{}
+	}
+	return previous, false
+}
+
+// respondToPressure 内存压力达到服务的 stop_on_pressure 级别时停止一个服务，
+// 压力恢复正常后重新启动一个之前停止的服务。两次操作至少间隔 ShedInterval，给内核回收内存的时间
+func (sm *ServiceManager) respondToPressure(m *pressureMonitor, level PressureLevel, now time.Time) {
+	m.mu.Lock()
+	if !m.lastAction.IsZero() && now.Sub(m.lastAction) < m.config.ShedInterval {
+		m.mu.Unlock()
+		return
+	}
+	shed := append([]string(nil), m.shed...)
+	m.mu.Unlock()
+
+	if level == PressureNormal {
+		if len(shed) > 0 {
+			sm.restoreShed(m, shed[len(shed)-1], now)
+		}
+		return
+	}
+
+	candidates := sm.shedCandidates(level)
+	if len(candidates) == 0 {
+		return
+	}
+	name := candidates[0]
+	m.mu.Lock()
+	m.shed = append(m.shed, name)
+	m.lastAction = now
+	memory := m.memory
+	m.mu.Unlock()
+
+	logging.Warn("Stopping service to relieve memory pressure", "service", name, "level", level)
+	sm.eventBus.Emit(ServiceEvent{Type: EventPressure, Service: name, Data: PressureEvent{
+		Resource: ResourceMemory, Level: level, Action: "stop", Memory: &memory,
+	}})
+	go func() {
+		if err := sm.StopService(name); err != nil {
+			logging.Warn("Failed to stop service under memory pressure", "service", name, "error", err)
+		}
+	}()
+}
+
+// shedCandidates 返回内存压力达到 level 时可以停止的运行中服务，按 oom_score_adj 从大到小排序
+func (sm *ServiceManager) shedCandidates(level PressureLevel) []string {
+	type candidate struct {
+		name  string
+		score int
+	}
+	var candidates []candidate
+	sm.mu.RLock()
+	for name, service := range sm.services {
+		stopAt := service.Config.StopOnPressure
+		if stopAt == "" || stopAt.rank() > level.rank() {
+			continue
+		}
+		if state := service.GetStatus().State; state != StateRunning && state != StateStarting {
+			continue
+		}
+		c := candidate{name: name}
+		if service.Config.OOMScoreAdj != nil {
+			c.score = *service.Config.OOMScoreAdj
+		}
+		candidates = append(candidates, c)
+	}
+	sm.mu.RUnlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	names := make([]string, len(candidates))
+	for i, c := range candidates {
+		names[i] = c.name
+	}
+	return names
+}
+
+// restoreShed 重新启动因内存压力停止的服务。服务已被手动启动、已不属于当前目标或被屏蔽时只移出列表
+func (sm *ServiceManager) restoreShed(m *pressureMonitor, name string, now time.Time) {
+	m.mu.Lock()
+	for i, n := range m.shed {
+		if n == name {
+			m.shed = append(m.shed[:i], m.shed[i+1:]...)
+			break
+		}
+	}
+	m.mu.Unlock()
+
+	service, exists := sm.lookup(name)
+	if !exists {
+		return
+	}
+	sm.mu.RLock()
+	allowed := sm.inTargetLocked(name) && !sm.masked[name]
+	sm.mu.RUnlock()
+	if state := service.GetStatus().State; !allowed || state == StateRunning || state == StateStarting {
+		return
+	}
+
+	m.mu.Lock()
+	m.lastAction = now
+	m.mu.Unlock()
+	logging.Info("Memory pressure relieved, restarting service", "service", name)
+	sm.eventBus.Emit(ServiceEvent{Type: EventPressure, Service: name, Data: PressureEvent{
+		Resource: ResourceMemory, Level: PressureNormal, Action: "start",
+	}})
+	go func() {
+		if err := sm.StartService(name); err != nil {
+			logging.Warn("Failed to restart service after memory pressure", "service", name, "error", err)
+		}
+	}()
+}
+
+// Pressure 返回系统资源压力的当前状态
+func (sm *ServiceManager) Pressure() (PressureStatus, error) {
+	sm.mu.RLock()
+	m := sm.pressure
+	sm.mu.RUnlock()
+	if m == nil {
+		return PressureStatus{}, fmt.Errorf("pressure monitor is not running")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := PressureStatus{Memory: m.memory, OOMKills: m.oomKills, Shed: append([]string{}, m.shed...)}
+	for _, resource := range pressureResources {
+		r := m.levels[resource]
+		status.Resources = append(status.Resources, ResourcePressure{
+			Resource: resource, Level: r.level, Since: r.since, Pressure: r.pressure,
+		})
+	}
+	return status, nil
+}
+
+// applyOOMScoreAdj 设置服务进程的 oom_score_adj，之后创建的子进程继承该值
+func (s *Service) applyOOMScoreAdj(pid int) {
+	if s.Config.OOMScoreAdj == nil {
+		return
+	}
+	path := fmt.Sprintf("/proc/%d/oom_score_adj", pid)
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(*s.Config.OOMScoreAdj)), 0644); err != nil {
+		logging.Warn("Failed to set oom_score_adj", "service", s.Config.Name, "error", err)
+	}
+}
+
+// readMemInfo 读取 /proc/meminfo 中的总内存、可用内存和交换空间
+func readMemInfo(path string) (MemoryStats, error) {
+	var mem MemoryStats
+	f, err := os.Open(path)
+	if err != nil {
+		return mem, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			mem.Total = kb * 1024
+		case "MemAvailable:":
+			mem.Available = kb * 1024
+		case "SwapTotal:":
+			mem.SwapTotal = kb * 1024
+		case "SwapFree:":
+			mem.SwapFree = kb * 1024
+		}
+	}
+	if mem.Total == 0 {
+		return mem, fmt.Errorf("no MemTotal in %s", path)
+	}
+	mem.AvailablePercent = float64(mem.Available) / float64(mem.Total) * 100
+	return mem, scanner.Err()
+}
+
+// readVMStat 读取 /proc/vmstat 中的一个计数器
+func readVMStat(path, key string) (uint64, bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if name, value, ok := strings.Cut(line, " "); ok && name == key {
+			n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
@@ -4,154 +4,535 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"sync"
 	"syscall"
 	"time"
+
+	"golang.org/x/sys/unix"
+
+	"ldh-os/init/logging"
 )
 
-// Service 表示一个服务实例
+// Service 表示一个服务实例。
+// 所有生命周期操作（启动、停止、重启、崩溃处理）由 opMu 串行化，
+// status 和当前运行实例由 mu 保护；事件在释放 mu 之后发送
 type Service struct {
-	Config   ServiceConfig
-	Status   ServiceStatus
+	Config    ServiceConfig
+	status    ServiceStatus
+	current   *run // 当前进程，未运行时为 nil
+	completed bool // oneshot 已成功完成，受 mu 保护
+	eventBus  *EventBus
+	states    *StateManager
+	output    OutputFunc // 标准输出和标准错误的去处，受 mu 保护
+	sockets   *socketSet // 套接字激活的监听套接字，受 mu 保护
+	trigger   []string   // 下一次启动额外设置的环境变量（触发的详情），启动后清除，受 mu 保护
+	metrics   *metricsHistory
+	since     time.Time // 进入当前状态的时间，受 mu 保护
+	unhealthy uint64    // 不健康报告的次数，受 mu 保护
+	healthy   bool      // 本次运行以来没有不健康报告，受 mu 保护
+	notify    string    // 通知套接字的路径，未启用时为空，受 mu 保护
+	watchdog  *watchdogState
+	opMu      sync.Mutex
+	mu        sync.Mutex
+}
+
+// OutputFunc 返回服务进程的标准输出和标准错误（例如 journal 管道的写端）。
+// 返回的文件由调用方持有，服务进程得到它的副本
+type OutputFunc func(service string) (*os.File, error)
+
+// run 表示服务的一次运行（一个进程的生命周期）
+type run struct {
 	cmd      *exec.Cmd
-	eventBus *EventBus
-	stopChan chan struct{}
+	process  *os.Process
+	stopping bool          // 由 Stop 主动停止，受 Service.mu 保护
+	exit     ExitStatus    // 退出状态，exited 关闭后有效
+	exited   chan struct{} // 进程退出后关闭
 }
 
+// DefaultStopTimeout 服务收到 SIGTERM 后等待退出的默认时间，超时则发送 SIGKILL
+const DefaultStopTimeout = 10 * time.Second
+
+// killTimeout 发送 SIGKILL 后等待进程被回收的时间
+const killTimeout = 5 * time.Second
+
 // NewService 创建新的服务实例
 func NewService(config ServiceConfig, eventBus *EventBus) *Service {
 	return &Service{
 		Config: config,
-		Status: ServiceStatus{
+		status: ServiceStatus{
 			State:        StateUnknown,
 			StartTime:    time.Time{},
 			RestartCount: 0,
 		},
 		eventBus: eventBus,
-		stopChan: make(chan struct{}),
 	}
 }
 
 // Start 启动服务
 func (s *Service) Start() error {
-	if s.Status.State == StateRunning {
+	s.opMu.Lock()
+	defer s.opMu.Unlock()
+	return s.start()
+}
+
+// start 启动服务进程，调用方需持有 opMu
+func (s *Service) start() error {
+	if s.GetStatus().State == StateRunning {
 		return fmt.Errorf("service %s is already running", s.Config.Name)
 	}
 
-	s.updateState(StateStarting)
+	s.mu.Lock()
+	s.completed = false
+	s.mu.Unlock()
+	s.setState(StateStarting, EventStarting, LifecycleEvent{})
 
 	// 准备命令
-	s.cmd = exec.Command(s.Config.ExecPath, s.Config.Args...)
+	cmd := exec.Command(s.Config.ExecPath, s.Config.Args...)
 
 	// 设置环境变量
-	if len(s.Config.Environment) > 0 {
+	s.mu.Lock()
+	trigger := append(s.trigger, s.watchdogEnv()...)
+	s.trigger = nil
+	s.mu.Unlock()
+	if len(s.Config.Environment) > 0 || len(trigger) > 0 {
 		env := os.Environ()
 		for k, v := range s.Config.Environment {
 			env = append(env, fmt.Sprintf("%s=%s", k, v))
 		}
-		s.cmd.Env = env
+		cmd.Env = append(env, trigger...)
+	}
+
+	// 套接字激活的服务从 init 接收监听套接字
+	s.mu.Lock()
+	sockets := s.sockets
+	s.mu.Unlock()
+	if sockets != nil {
+		env := cmd.Env
+		if env == nil {
+			env = os.Environ()
+		}
+		activated, err := activationCommand(s.Config, sockets, env)
+		if err != nil {
+			s.fail(err.Error())
+			return fmt.Errorf("failed to start service %s: %v", s.Config.Name, err)
+		}
+		cmd = activated
+	}
+
+	// 终端服务在新会话中运行，以终端为控制终端
+	if s.Config.TTY != "" {
+		tty, err := attachTTY(cmd, s.Config.TTY)
+		if err != nil {
+			s.fail(err.Error())
+			return fmt.Errorf("failed to start service %s: %v", s.Config.Name, err)
+		}
+		defer tty.Close()
+	} else if output := s.outputFunc(); output != nil {
+		if w, err := output(s.Config.Name); err != nil {
+			logging.Warn("Service output discarded", "service", s.Config.Name, "error", err)
+		} else {
+			cmd.Stdout, cmd.Stderr = w, w
+		}
 	}
 
 	// 启动进程
-	if err := s.cmd.Start(); err != nil {
-		s.updateState(StateFailed)
+	if err := cmd.Start(); err != nil {
+		s.fail(err.Error())
 		return fmt.Errorf("failed to start service %s: %v", s.Config.Name, err)
 	}
 
-	s.Status.Pid = s.cmd.Process.Pid
-	s.Status.StartTime = time.Now()
-	s.updateState(StateRunning)
+	s.applyOOMScoreAdj(cmd.Process.Pid)
+	r := &run{cmd: cmd, process: cmd.Process, exited: make(chan struct{})}
+	s.mu.Lock()
+	s.current = r
+	s.status.Pid = cmd.Process.Pid
+	s.status.StartTime = time.Now()
+	s.mu.Unlock()
+	s.setState(StateRunning, EventStarted, LifecycleEvent{})
+	if !s.runsToCompletion() {
+		s.emit(EventReady, LifecycleEvent{})
+	}
 
 	// 监控进程
-	go s.monitor()
+	go s.monitor(r, func() (ExitStatus, error) {
+		err := cmd.Wait()
+		return exitStatusOf(err), err
+	})
+
+	return nil
+}
+
+// setTriggerEnv 设置下一次启动额外传给进程的环境变量
+func (s *Service) setTriggerEnv(env []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trigger = env
+}
+
+// outputFunc 返回当前的输出设置
+func (s *Service) outputFunc() OutputFunc {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.output
+}
+
+// Adopt 接管一个仍在运行的服务进程，用于 init 重启后恢复监管
+func (s *Service) Adopt(pid int, startTime time.Time) error {
+	s.opMu.Lock()
+	defer s.opMu.Unlock()
+
+	if s.GetStatus().State == StateRunning {
+		return fmt.Errorf("service %s is already running", s.Config.Name)
+	}
+
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return fmt.Errorf("failed to adopt pid %d for service %s: %v", pid, s.Config.Name, err)
+	}
+
+	r := &run{process: proc, exited: make(chan struct{})}
+	s.mu.Lock()
+	s.current = r
+	s.status.Pid = pid
+	s.status.StartTime = startTime
+	s.mu.Unlock()
+	s.setState(StateRunning, EventStarted, LifecycleEvent{Adopted: true})
+	if !s.runsToCompletion() {
+		s.emit(EventReady, LifecycleEvent{})
+	}
+
+	go s.monitor(r, func() (ExitStatus, error) { return waitPid(pid) })
 
 	return nil
 }
 
 // Stop 停止服务
 func (s *Service) Stop() error {
-	if s.Status.State != StateRunning {
+	timeout := s.Config.StopTimeout
+	if timeout <= 0 {
+		timeout = DefaultStopTimeout
+	}
+	return s.StopTimeout(timeout)
+}
+
+// StopTimeout 发送 SIGTERM 并等待进程退出，超过 timeout 后发送 SIGKILL
+func (s *Service) StopTimeout(timeout time.Duration) error {
+	s.opMu.Lock()
+	defer s.opMu.Unlock()
+	return s.stop(timeout)
+}
+
+// stop 停止当前进程，调用方需持有 opMu
+func (s *Service) stop(timeout time.Duration) error {
+	s.mu.Lock()
+	r := s.current
+	if s.status.State != StateRunning || r == nil {
+		s.mu.Unlock()
 		return fmt.Errorf("service %s is not running", s.Config.Name)
 	}
+	r.stopping = true
+	s.mu.Unlock()
 
-	s.updateState(StateStopping)
-	close(s.stopChan)
+	s.setState(StateStopping, EventStopping, LifecycleEvent{})
 
-	if s.cmd != nil && s.cmd.Process != nil {
-		if err := s.cmd.Process.Signal(syscall.SIGTERM); err != nil {
-			// 如果 SIGTERM 失败，尝试 SIGKILL
-			if err := s.cmd.Process.Kill(); err != nil {
-				s.updateState(StateFailed)
-				return fmt.Errorf("failed to kill service %s: %v", s.Config.Name, err)
-			}
+	if err := r.process.Signal(syscall.SIGTERM); err != nil {
+		// 如果 SIGTERM 失败，尝试 SIGKILL
+		if err := r.process.Kill(); err != nil && !r.hasExited() {
+			s.fail(fmt.Sprintf("failed to kill process: %v", err))
+			return fmt.Errorf("failed to kill service %s: %v", s.Config.Name, err)
 		}
 	}
 
-	s.updateState(StateStopped)
+	if !r.waitExit(timeout) {
+		r.process.Kill()
+		if !r.waitExit(killTimeout) {
+			s.fail("process did not exit after SIGKILL")
+			return fmt.Errorf("service %s did not exit after SIGKILL", s.Config.Name)
+		}
+	}
+
+	s.mu.Lock()
+	if s.current == r {
+		s.current = nil
+	}
+	s.mu.Unlock()
+	exit := r.exit
+	s.emit(EventExited, LifecycleEvent{Exit: &exit})
+	s.setState(StateStopped, EventStopped, LifecycleEvent{})
 	return nil
 }
 
 // Restart 重启服务
 func (s *Service) Restart() error {
-	if err := s.Stop(); err != nil {
+	s.opMu.Lock()
+	defer s.opMu.Unlock()
+
+	timeout := s.Config.StopTimeout
+	if timeout <= 0 {
+		timeout = DefaultStopTimeout
+	}
+	if err := s.stop(timeout); err != nil {
 		return err
 	}
 
-	s.Status.RestartCount++
-	s.stopChan = make(chan struct{}) // 重新创建停止通道
+	s.beginRestart()
+	return s.start()
+}
 
-	return s.Start()
+// beginRestart 增加重启计数并发送 restarting 事件，调用方需持有 opMu
+func (s *Service) beginRestart() {
+	s.mu.Lock()
+	s.status.RestartCount++
+	attempt := s.status.RestartCount
+	s.mu.Unlock()
+	s.emit(EventRestarting, LifecycleEvent{Attempt: attempt})
 }
 
-// monitor 监控服务进程
-func (s *Service) monitor() {
-	if s.cmd == nil {
-		return
+// runsToCompletion 判断服务进程是否应当自行运行结束（oneshot、periodic）
+func (s *Service) runsToCompletion() bool {
+	return s.Config.Type == TypeOneshot || s.Config.Type == TypePeriodic
+}
+
+// hasExited 判断进程是否已经退出
+func (r *run) hasExited() bool {
+	select {
+	case <-r.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitExit 等待进程退出，返回是否在超时前退出
+func (r *run) waitExit(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-r.exited:
+		return true
+	case <-timer.C:
+		return false
 	}
+}
 
+// monitor 监控服务进程，wait 阻塞直到进程退出
+func (s *Service) monitor(r *run, wait func() (ExitStatus, error)) {
 	// 等待进程结束
-	err := s.cmd.Wait()
+	exit, err := wait()
+	r.exit = exit
+	close(r.exited)
 
-	// 检查是否是正常停止
-	select {
-	case <-s.stopChan:
-		// 正常停止，不需要特殊处理
+	// 终端服务启动即退出时延迟处理，避免在终端不可用时空转重启
+	if s.Config.TTY != "" && time.Since(s.GetStatus().StartTime) < ttyMinRun {
+		time.Sleep(ttyRespawnDelay)
+	}
+
+	// 崩溃处理与其他生命周期操作串行执行
+	s.opMu.Lock()
+	defer s.opMu.Unlock()
+
+	s.mu.Lock()
+	// 正常停止，或者该进程已经不是当前实例，不需要特殊处理
+	if r.stopping || s.current != r {
+		s.mu.Unlock()
 		return
-	default:
+	}
+	s.current = nil
+	s.mu.Unlock()
+
+	s.emit(EventExited, LifecycleEvent{Exit: &exit})
+
+	// 根据重启策略处理，停止和失败事件注明随后会自动重启
+	restart := s.Config.Restart == "always" || (s.Config.Restart == "on-failure" && err != nil)
+	if err == nil && s.runsToCompletion() {
+		// oneshot 成功完成
+		s.mu.Lock()
+		s.completed = true
+		s.mu.Unlock()
+		s.emit(EventReady, LifecycleEvent{})
+		s.setState(StateStopped, EventStopped, LifecycleEvent{Restarting: restart})
+	} else if err == nil && s.Config.TTY != "" {
+		// 终端会话正常结束（例如用户登出），不算失败
+		s.setState(StateStopped, EventStopped, LifecycleEvent{Restarting: restart})
+	} else {
 		// 异常停止
-		s.Status.LastError = err
-		s.updateState(StateFailed)
-
-		// 根据重启策略处理
-		if s.Config.Restart == "always" || (s.Config.Restart == "on-failure" && err != nil) {
-			s.Status.RestartCount++
-			s.stopChan = make(chan struct{})
-			if err := s.Start(); err != nil {
-				s.Status.LastError = err
+		reason := "process exited unexpectedly"
+		if err != nil {
+			reason = err.Error()
+		}
+		s.failWith(LifecycleEvent{Reason: reason, Restarting: restart})
+	}
+
+	if restart {
+		s.beginRestart()
+		if err := s.start(); err != nil {
+			logging.Warn("Failed to restart service", "service", s.Config.Name, "error", err)
+		}
+	}
+}
+
+// restoreCounters 恢复持久化的重启计数和最近错误
+func (s *Service) restoreCounters(restartCount int, lastError string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.RestartCount = restartCount
+	if lastError != "" {
+		s.status.LastError = lastError
+	}
+}
+
+// ReportUnhealthy 报告运行中的服务不健康（例如健康检查或看门狗超时），不改变服务状态
+func (s *Service) ReportUnhealthy(reason string) {
+	s.mu.Lock()
+	s.unhealthy++
+	s.healthy = false
+	s.mu.Unlock()
+	s.emit(EventUnhealthy, LifecycleEvent{Reason: reason})
+}
+
+// fail 记录失败原因并进入 failed 状态
+func (s *Service) fail(reason string) {
+	s.failWith(LifecycleEvent{Reason: reason})
+}
+
+// failWith 记录 detail 中的失败原因并进入 failed 状态
+func (s *Service) failWith(detail LifecycleEvent) {
+	s.mu.Lock()
+	s.status.LastError = detail.Reason
+	s.mu.Unlock()
+	s.setState(StateFailed, EventFailed, detail)
+}
+
+// setState 更新服务状态并持久化，然后发送 eventType 事件。
+// 持久化和事件都在释放 mu 后进行
+func (s *Service) setState(state ServiceState, eventType EventType, detail LifecycleEvent) {
+	s.mu.Lock()
+	if state != s.status.State {
+		s.since = time.Now()
+		// 新的一次运行（包括接管的进程）从健康开始
+		if state == StateStarting || state == StateRunning {
+			s.healthy = true
+		}
+	}
+	s.status.State = state
+	status := s.status
+	s.mu.Unlock()
+
+	if s.states != nil {
+		if err := s.states.Record(s.Config.Name, status); err != nil {
+			logging.Warn("Failed to persist service state", "service", s.Config.Name, "error", err)
+		}
+	}
+	s.publish(eventType, status, detail)
+}
+
+// emit 发送不改变服务状态的事件
+func (s *Service) emit(eventType EventType, detail LifecycleEvent) {
+	s.publish(eventType, s.GetStatus(), detail)
+}
+
+// publish 用状态快照补全事件负载并发送
+func (s *Service) publish(eventType EventType, status ServiceStatus, detail LifecycleEvent) {
+	if s.eventBus == nil {
+		return
+	}
+	detail.State = status.State
+	detail.Pid = status.Pid
+	detail.RestartCount = status.RestartCount
+
+	// 异步发送：生命周期操作持有 opMu，不能等待可能回调本服务的订阅者
+	s.eventBus.Emit(ServiceEvent{
+		Type:      eventType,
+		Service:   s.Config.Name,
+		Data:      detail,
+		Timestamp: time.Now(),
+	})
+}
+
+// waitPid 等待被接管的进程退出。
+// 如果进程是本进程的子进程则通过 wait4 回收，否则轮询 /proc 直到进程消失
+func waitPid(pid int) (ExitStatus, error) {
+	start, _ := procStartTime(pid)
+	for {
+		var ws unix.WaitStatus
+		_, err := unix.Wait4(pid, &ws, 0, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if err == nil {
+			exit := waitExitStatus(ws)
+			if exit.Success() {
+				return exit, nil
 			}
+			return exit, fmt.Errorf("process %d exited: %v", pid, describeWaitStatus(ws))
+		}
+		if err != unix.ECHILD {
+			return ExitStatus{Code: -1}, err
 		}
+		break
 	}
+
+	// 不是子进程，无法获取退出码
+	for processMatches(pid, start) {
+		time.Sleep(time.Second)
+	}
+	return ExitStatus{Code: -1}, fmt.Errorf("adopted process %d exited", pid)
 }
 
-// updateState 更新服务状态并发送事件
-func (s *Service) updateState(state ServiceState) {
-	oldState := s.Status.State
-	s.Status.State = state
+// waitExitStatus 把 wait 状态转换为 ExitStatus
+func waitExitStatus(ws unix.WaitStatus) ExitStatus {
+	switch {
+	case ws.Exited():
+		return ExitStatus{Code: ws.ExitStatus()}
+	case ws.Signaled():
+		return ExitStatus{Code: -1, Signal: unix.SignalName(ws.Signal())}
+	default:
+		return ExitStatus{Code: -1}
+	}
+}
 
-	// 只有当状态真正发生变化时才发送事件
-	if oldState != state && s.eventBus != nil {
-		event := ServiceEvent{
-			Type:      EventType(state),
-			Service:   s.Config.Name,
-			Data:      s.Status,
-			Timestamp: time.Now(),
+// exitStatusOf 从 exec.Cmd.Wait 的返回值获取退出状态
+func exitStatusOf(err error) ExitStatus {
+	if err == nil {
+		return ExitStatus{}
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return waitExitStatus(unix.WaitStatus(ws))
 		}
+		return ExitStatus{Code: exitErr.ExitCode()}
+	}
+	return ExitStatus{Code: -1}
+}
 
-		// 同步发送事件，确保状态更新在返回前完成
-		s.eventBus.EmitSync(event)
+// describeWaitStatus 将进程退出状态格式化为可读文本
+func describeWaitStatus(ws unix.WaitStatus) string {
+	switch {
+	case ws.Exited():
+		return fmt.Sprintf("exit status %d", ws.ExitStatus())
+	case ws.Signaled():
+		return fmt.Sprintf("signal: %v", ws.Signal())
+	default:
+		return fmt.Sprintf("wait status %#x", uint32(ws))
 	}
 }
 
-// GetStatus 获取服务状态
+// active 判断服务是否满足依赖：正在运行，或是已成功完成的 oneshot
+func (s *Service) active() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status.State == StateRunning || s.completed
+}
+
+// GetStatus 获取服务状态的快照，运行中的服务附带最近一次资源采样
 func (s *Service) GetStatus() ServiceStatus {
-	return s.Status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.status
+	if st.State == StateRunning && s.metrics != nil {
+		if u, ok := s.metrics.latest(); ok && u.Pid == st.Pid {
+			st.Metrics = &u
+		}
+	}
+	return st
 }
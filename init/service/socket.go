@@ -0,0 +1,360 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"ldh-os/init/logging"
+)
+
+// unix 判断是否是 unix 套接字
+func (c SocketConfig) unix() bool {
+	return strings.HasPrefix(c.Listen, "/")
+}
+
+const (
+	// ActivationExecEnv 启动跳板使用的环境变量：服务进程先以 init 自身的二进制启动，
+	// 设置 LISTEN_PID 为自己的 PID 后再 exec 该变量指定的程序。
+	// 跳板由 init 的 main 显式调用 RunActivationTrampoline 处理，本包在导入时没有副作用
+	ActivationExecEnv = "LDH_ACTIVATION_EXEC"
+	// activationPoll 等待连接和检查服务状态的间隔
+	activationPoll = 500 * time.Millisecond
+	// activationRetryDelay 激活失败后重新等待连接之前的延迟，避免积压的连接导致反复启动
+	activationRetryDelay = 5 * time.Second
+	// maxIdleCheck 空闲检查的最长间隔
+	maxIdleCheck = 5 * time.Second
+)
+
+// socketSet 一个服务的监听套接字
+type socketSet struct {
+	files []*os.File
+	names []string
+	ports []int    // TCP 端口，用于统计连接，unix 套接字为 0
+	paths []string // unix 套接字路径，TCP 为空
+}
+
+// socketActivated 判断服务是否由套接字激活：init 已在监听它的套接字
+func (s *Service) socketActivated() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sockets != nil
+}
+
+// ActivateSockets 为配置了 sockets 的服务打开监听套接字并等待连接，连接到达时启动服务。
+// inherited 返回 re-exec 继承的同名描述符（没有时返回 nil），新打开的套接字通过 keep 登记为跨 re-exec 保留
+func (sm *ServiceManager) ActivateSockets(inherited func(name string) *os.File, keep func(name string, f *os.File)) error {
+	sm.mu.RLock()
+	var services []*Service
+	for _, service := range sm.services {
+		if len(service.Config.Sockets) > 0 {
+			services = append(services, service)
+		}
+	}
+	sm.mu.RUnlock()
+
+	var errs []string
+	for _, service := range services {
+		set, err := openSockets(service.Config, inherited, keep)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", service.Config.Name, err))
+			continue
+		}
+		service.mu.Lock()
+		service.sockets = set
+		service.mu.Unlock()
+		go sm.watchSockets(service, set)
+		logging.Info("Listening for service", "service", service.Config.Name, "sockets", strings.Join(socketAddresses(service.Config), ","))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to open sockets: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// socketFileName 跨 re-exec 保留的套接字名称
+func socketFileName(service string, index int) string {
+	return fmt.Sprintf("socket:%s:%d", service, index)
+}
+
+func socketAddresses(config ServiceConfig) []string {
+	result := make([]string, len(config.Sockets))
+	for i, s := range config.Sockets {
+		result[i] = s.Listen
+	}
+	return result
+}
+
+// openSockets 打开（或从继承的描述符恢复）服务的所有监听套接字
+func openSockets(config ServiceConfig, inherited func(string) *os.File, keep func(string, *os.File)) (*socketSet, error) {
+	set := &socketSet{}
+	for i, sc := range config.Sockets {
+		name := socketFileName(config.Name, i)
+		f := inherited(name)
+		if f == nil {
+			var err error
+			if f, err = listen(sc); err != nil {
+				set.close()
+				return nil, err
+			}
+			keep(name, f)
+		}
+
+		fdName := sc.Name
+		if fdName == "" {
+			fdName = config.Name
+		}
+		set.files = append(set.files, f)
+		set.names = append(set.names, fdName)
+		if sc.unix() {
+			set.ports = append(set.ports, 0)
+			set.paths = append(set.paths, sc.Listen)
+		} else {
+			_, port, _ := net.SplitHostPort(sc.Listen)
+			p, _ := strconv.Atoi(port)
+			set.ports = append(set.ports, p)
+			set.paths = append(set.paths, "")
+		}
+	}
+	return set, nil
+}
+
+// listen 创建监听套接字，返回阻塞模式的描述符：它会原样传给服务进程
+func listen(sc SocketConfig) (*os.File, error) {
+	var f *os.File
+	if sc.unix() {
+		os.Remove(sc.Listen)
+		l, err := net.ListenUnix("unix", &net.UnixAddr{Name: sc.Listen, Net: "unix"})
+		if err != nil {
+			return nil, err
+		}
+		l.SetUnlinkOnClose(false)
+		mode := os.FileMode(0666)
+		if sc.Mode != 0 {
+			mode = os.FileMode(sc.Mode)
+		}
+		if err := os.Chmod(sc.Listen, mode); err != nil {
+			l.Close()
+			return nil, err
+		}
+		f, err = l.File()
+		l.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		l, err := net.Listen("tcp", sc.Listen)
+		if err != nil {
+			return nil, err
+		}
+		f, err = l.(*net.TCPListener).File()
+		l.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := unix.SetNonblock(int(f.Fd()), false); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (set *socketSet) close() {
+	for _, f := range set.files {
+		f.Close()
+	}
+}
+
+// watchSockets 服务未运行时等待任一套接字上有连接到达并启动服务；
+// 服务运行且配置了 idle_timeout 时，没有连接超过该时长后停止服务
+func (sm *ServiceManager) watchSockets(service *Service, set *socketSet) {
+	name := service.Config.Name
+	var idleSince time.Time
+	for {
+		if state := service.GetStatus().State; state == StateRunning || state == StateStarting {
+			if service.Config.IdleTimeout > 0 {
+				idleSince = sm.checkIdle(service, set, idleSince)
+			}
+			time.Sleep(idleInterval(service.Config.IdleTimeout))
+			continue
+		}
+		idleSince = time.Time{}
+
+		if !set.pending(activationPoll) {
+			continue
+		}
+		sm.mu.RLock()
+		allowed := sm.inTargetLocked(name) && !sm.masked[name]
+		sm.mu.RUnlock()
+		if !allowed {
+			logging.Debug("Connection for inactive socket service ignored", "service", name)
+			time.Sleep(activationRetryDelay)
+			continue
+		}
+
+		logging.Info("Connection received, starting service", "service", name)
+		if err := sm.StartService(name); err != nil {
+			logging.Warn("Socket activation failed", "service", name, "error", err)
+			time.Sleep(activationRetryDelay)
+		}
+	}
+}
+
+// idleInterval 空闲检查的间隔
+func idleInterval(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return activationPoll
+	}
+	interval := timeout / 4
+	if interval > maxIdleCheck {
+		interval = maxIdleCheck
+	}
+	return interval
+}
+
+// checkIdle 没有连接且没有等待接受的连接时开始计时，超过 idle_timeout 后停止服务。
+// 返回新的空闲起始时间，有连接时为零值
+func (sm *ServiceManager) checkIdle(service *Service, set *socketSet, idleSince time.Time) time.Time {
+	if set.pending(0) || set.connections() > 0 {
+		return time.Time{}
+	}
+	if idleSince.IsZero() {
+		return time.Now()
+	}
+	if time.Since(idleSince) < service.Config.IdleTimeout {
+		return idleSince
+	}
+
+	logging.Info("Stopping idle socket-activated service", "service", service.Config.Name, "idle", service.Config.IdleTimeout)
+	if err := sm.StopService(service.Config.Name); err != nil {
+		logging.Warn("Failed to stop idle service", "service", service.Config.Name, "error", err)
+	}
+	return time.Time{}
+}
+
+// pending 判断是否有等待接受的连接，最多等待 timeout
+func (set *socketSet) pending(timeout time.Duration) bool {
+	fds := make([]unix.PollFd, len(set.files))
+	for i, f := range set.files {
+		fds[i] = unix.PollFd{Fd: int32(f.Fd()), Events: unix.POLLIN}
+	}
+	n, err := unix.Poll(fds, int(timeout/time.Millisecond))
+	if err != nil && err != unix.EINTR {
+		time.Sleep(timeout)
+		return false
+	}
+	return n > 0
+}
+
+// connections 统计服务已接受的连接数
+func (set *socketSet) connections() int {
+	count := 0
+	var ports []int
+	for i, port := range set.ports {
+		if port != 0 {
+			ports = append(ports, port)
+		} else {
+			count += countProcNet("/proc/net/unix", func(fields []string) bool {
+				return unixConnected(fields, set.paths[i])
+			})
+		}
+	}
+	if len(ports) > 0 {
+		for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+			count += countProcNet(path, func(fields []string) bool {
+				return tcpConnected(fields, ports)
+			})
+		}
+	}
+	return count
+}
+
+// countProcNet 统计 /proc/net 表中满足条件的行，跳过表头
+func countProcNet(path string, match func(fields []string) bool) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	for scanner.Scan() {
+		if match(strings.Fields(scanner.Text())) {
+			count++
+		}
+	}
+	return count
+}
+
+// tcpConnected 判断 /proc/net/tcp 的一行是否是本地端口在 ports 中的已建立连接。
+// 格式："sl local_address rem_address st ..."，地址为十六进制 IP:端口，st 01 为 ESTABLISHED
+func tcpConnected(fields []string, ports []int) bool {
+	if len(fields) < 4 || fields[3] != "01" {
+		return false
+	}
+	i := strings.LastIndexByte(fields[1], ':')
+	if i < 0 {
+		return false
+	}
+	port, err := strconv.ParseInt(fields[1][i+1:], 16, 32)
+	if err != nil {
+		return false
+	}
+	for _, p := range ports {
+		if int(port) == p {
+			return true
+		}
+	}
+	return false
+}
+
+// unixConnected 判断 /proc/net/unix 的一行是否是绑定在 path 上的已连接套接字（被接受的连接继承监听地址）。
+// 格式："Num RefCount Protocol Flags Type St Inode Path"，St 03 为已连接
+func unixConnected(fields []string, path string) bool {
+	return len(fields) >= 8 && fields[5] == "03" && fields[7] == path
+}
+
+// RunActivationTrampoline 处理套接字激活的跳板：进程以 init 自身的二进制启动且设置了 ActivationExecEnv 时，
+// 设置 LISTEN_PID 后 exec 真正的程序，不返回；否则直接返回。
+// LISTEN_PID 只能在 fork 之后设置，应在 main（以及测试的 TestMain）开头调用
+func RunActivationTrampoline() {
+	path := os.Getenv(ActivationExecEnv)
+	if path == "" {
+		return
+	}
+	os.Unsetenv(ActivationExecEnv)
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	err := unix.Exec(path, os.Args, os.Environ())
+	fmt.Fprintf(os.Stderr, "failed to exec %s: %v\n", path, err)
+	os.Exit(127)
+}
+
+// activationCommand 构造套接字激活的服务进程：以 init 自身的二进制为跳板设置 LISTEN_PID，
+// 套接字从描述符 3 开始依次传入
+func activationCommand(config ServiceConfig, set *socketSet, env []string) (*exec.Cmd, error) {
+	path, err := exec.LookPath(config.ExecPath)
+	if err != nil {
+		return nil, err
+	}
+	cmd := &exec.Cmd{
+		Path: "/proc/self/exe",
+		Args: append([]string{config.ExecPath}, config.Args...),
+		Env: append(env,
+			ActivationExecEnv+"="+path,
+			fmt.Sprintf("LISTEN_FDS=%d", len(set.files)),
+			"LISTEN_FDNAMES="+strings.Join(set.names, ":"),
+		),
+		ExtraFiles: set.files,
+	}
+	return cmd, nil
+}
@@ -1,13 +1,21 @@
 package service
 
 import (
+	"errors"
+	"os"
 	"sync"
+	"time"
+
+	"ldh-os/init/logging"
 )
 
 // StateManager 管理服务状态
 type StateManager struct {
 	states       map[string]ServiceState
 	dependencies map[string][]string
+	records      map[string]ServiceRecord
+	storePath    string // 为空时不持久化
+	bootID       string
 	mu           sync.RWMutex
 }
 
@@ -16,7 +24,118 @@ func NewStateManager() *StateManager {
 	return &StateManager{
 		states:       make(map[string]ServiceState),
 		dependencies: make(map[string][]string),
+		records:      make(map[string]ServiceRecord),
+		bootID:       currentBootID(),
+	}
+}
+
+// Open 打开持久化存储并返回上次保存的服务记录。
+// sameBoot 表示记录是否来自本次启动（即记录中的 PID 是否仍可能有效）。
+// 状态文件内容损坏时改名为 .corrupt 保留，从空记录开始，持久化仍然启用；
+// 读取失败或版本不符时返回错误且不启用持久化，避免覆盖无法识别的文件
+func (sm *StateManager) Open(path string) (records map[string]ServiceRecord, sameBoot bool, err error) {
+	sf, err := readStateFile(path)
+	var corrupt *corruptStateError
+	if errors.As(err, &corrupt) {
+		aside := path + ".corrupt"
+		if rerr := os.Rename(path, aside); rerr != nil {
+			logging.Warn("Ignoring corrupt state file", "path", path, "error", err, "rename_error", rerr)
+		} else {
+			logging.Warn("Moved corrupt state file aside", "path", path, "moved_to", aside, "error", err)
+		}
+		sf = &stateFile{Version: stateFileVersion, Services: make(map[string]ServiceRecord)}
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.storePath = path
+	for name, rec := range sf.Services {
+		if old, exists := sm.records[name]; !exists {
+			sm.records[name] = rec
+		} else if old.LastTrigger.IsZero() {
+			old.LastTrigger = rec.LastTrigger
+			sm.records[name] = old
+		}
+	}
+
+	return sf.Services, sf.BootID != "" && sf.BootID == sm.bootID, nil
+}
+
+// Record 记录服务的完整运行时状态，启用持久化时同步写盘
+func (sm *StateManager) Record(service string, status ServiceStatus) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.states[service] = status.State
+
+	var procStart uint64
+	old, exists := sm.records[service]
+	if exists && old.Pid == status.Pid {
+		procStart = old.ProcStart
+	}
+	rec := newServiceRecord(status, procStart)
+	rec.LastTrigger = old.LastTrigger
+	sm.records[service] = rec
+
+	return sm.save()
+}
+
+// RecordTrigger 记录定时器触发服务的时间，启用持久化时同步写盘
+func (sm *StateManager) RecordTrigger(service string, t time.Time) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	rec := sm.records[service]
+	rec.LastTrigger = t
+	sm.records[service] = rec
+	return sm.save()
+}
+
+// LastTrigger 返回定时器上次触发服务的时间，没有记录时为零值
+func (sm *StateManager) LastTrigger(service string) time.Time {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.records[service].LastTrigger
+}
+
+// newServiceRecord 根据运行时状态生成可持久化的记录，
+// procStart 为已知的进程启动时间，为 0 时从 /proc 读取
+func newServiceRecord(status ServiceStatus, procStart uint64) ServiceRecord {
+	rec := ServiceRecord{
+		State:        status.State,
+		StartTime:    status.StartTime,
+		RestartCount: status.RestartCount,
+		LastError:    status.LastError,
+	}
+	if status.Pid > 0 && (status.State == StateRunning || status.State == StateStopping) {
+		rec.Pid = status.Pid
+		rec.ProcStart = procStart
+		if rec.ProcStart == 0 {
+			rec.ProcStart, _ = procStartTime(status.Pid)
+		}
+	}
+	return rec
+}
+
+// save 将当前记录写入磁盘，调用方需持有写锁
+func (sm *StateManager) save() error {
+	if sm.storePath == "" {
+		return nil
+	}
+
+	sf := &stateFile{
+		Version:  stateFileVersion,
+		BootID:   sm.bootID,
+		SavedAt:  time.Now(),
+		Services: make(map[string]ServiceRecord, len(sm.records)),
+	}
+	for name, rec := range sm.records {
+		sf.Services[name] = rec
 	}
+	return writeStateFile(sm.storePath, sf)
 }
 
 // UpdateState 更新服务状态
@@ -86,4 +205,6 @@ func (sm *StateManager) RemoveService(service string) {
 
 	delete(sm.states, service)
 	delete(sm.dependencies, service)
+	delete(sm.records, service)
+	sm.save()
 }
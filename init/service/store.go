@@ -0,0 +1,152 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stateFileVersion 状态文件格式版本
+const stateFileVersion = 1
+
+// ServiceRecord 持久化到磁盘的服务运行时记录
+type ServiceRecord struct {
+	State        ServiceState `json:"state"`
+	Pid          int          `json:"pid,omitempty"`
+	ProcStart    uint64       `json:"proc_start,omitempty"` // /proc/<pid>/stat 中的进程启动时间（时钟节拍）
+	StartTime    time.Time    `json:"start_time"`
+	RestartCount int          `json:"restart_count"`
+	LastError    string       `json:"last_error,omitempty"`
+	LastTrigger  time.Time    `json:"last_trigger"` // 定时器上次触发的时间，跨重启保留，零值表示从未触发
+}
+
+// stateFile 状态文件的磁盘格式
+type stateFile struct {
+	Version  int                      `json:"version"`
+	BootID   string                   `json:"boot_id"`
+	SavedAt  time.Time                `json:"saved_at"`
+	Services map[string]ServiceRecord `json:"services"`
+}
+
+// corruptStateError 状态文件内容无法解析
+type corruptStateError struct {
+	err error
+}
+
+func (e *corruptStateError) Error() string {
+	return fmt.Sprintf("failed to parse state file: %v", e.err)
+}
+
+// readStateFile 读取状态文件，文件不存在时返回空记录；内容无法解析时返回 *corruptStateError
+func readStateFile(path string) (*stateFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &stateFile{Version: stateFileVersion, Services: make(map[string]ServiceRecord)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %v", err)
+	}
+
+	var sf stateFile
+	if err := json.Unmarshal(data, &sf); err != nil {
+		return nil, &corruptStateError{err: err}
+	}
+	if sf.Version != stateFileVersion {
+		return nil, fmt.Errorf("unsupported state file version %d", sf.Version)
+	}
+	if sf.Services == nil {
+		sf.Services = make(map[string]ServiceRecord)
+	}
+	return &sf, nil
+}
+
+// writeStateFile 以 写临时文件 + fsync + rename 的方式原子地写入状态文件，
+// 掉电或崩溃时磁盘上要么是旧文件要么是新文件
+func writeStateFile(path string, sf *stateFile) error {
+	data, err := json.MarshalIndent(sf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %v", err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	// 同步目录项，确保 rename 本身落盘
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// currentBootID 返回本次启动的 boot id，用于判断持久化的 PID 是否仍然有意义
+func currentBootID() string {
+	data, err := ioutil.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// procStartTime 读取进程的启动时间（自系统启动以来的时钟节拍），
+// 与 PID 一起可以唯一标识一个进程，避免 PID 复用导致误判
+func procStartTime(pid int) (uint64, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	// comm 字段可能包含空格和括号，从最后一个 ')' 之后开始解析
+	s := string(data)
+	idx := strings.LastIndexByte(s, ')')
+	if idx < 0 {
+		return 0, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	fields := strings.Fields(s[idx+1:])
+	// fields[0] 是第 3 个字段 (state)，starttime 是第 22 个字段
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	if fields[0] == "Z" || fields[0] == "X" {
+		return 0, fmt.Errorf("process %d is a zombie", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// processMatches 判断 PID 对应的进程是否仍是记录中的那个进程
+func processMatches(pid int, procStart uint64) bool {
+	if pid <= 0 || procStart == 0 {
+		return false
+	}
+	start, err := procStartTime(pid)
+	return err == nil && start == procStart
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// EventStream 先补发历史事件、再持续推送新事件的有序事件流，供外部消费者使用。
+// 流内事件序号严格递增且不重复；订阅队列溢出时自动从历史中补齐丢失的事件
+type EventStream struct {
+	C        <-chan ServiceEvent
+	Complete bool // false 表示起点之后的部分历史事件已无法找回
+
+	sub  *Subscription
+	done chan struct{}
+	once sync.Once
+}
+
+// Follow 创建从序号 since 之后开始的事件流。
+// 先订阅再读取历史，保证两者之间不会遗漏事件，重复的事件按序号去重
+func (eb *EventBus) Follow(since uint64, filter EventFilter, queueSize int) (*EventStream, error) {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+
+	live := make(chan ServiceEvent, queueSize)
+	s := &EventStream{done: make(chan struct{})}
+	s.sub = eb.SubscribeFilter(filter, func(event ServiceEvent) {
+		select {
+		case live <- event:
+		case <-s.done:
+		}
+	}, queueSize)
+
+	replay, complete, err := eb.History(since, filter, 0)
+	if err != nil {
+		s.sub.Cancel()
+		return nil, err
+	}
+	s.Complete = complete
+
+	out := make(chan ServiceEvent)
+	s.C = out
+	go s.run(eb, out, live, since, filter, replay)
+	return s, nil
+}
+
+// run 按序号顺序把事件写入输出通道，直到流被关闭
+func (s *EventStream) run(eb *EventBus, out chan<- ServiceEvent, live <-chan ServiceEvent, last uint64, filter EventFilter, replay []ServiceEvent) {
+	defer close(out)
+
+	send := func(events []ServiceEvent) bool {
+		for _, e := range events {
+			if e.Seq <= last {
+				continue
+			}
+			select {
+			case out <- e:
+				last = e.Seq
+			case <-s.done:
+				return false
+			}
+		}
+		return true
+	}
+
+	if !send(replay) {
+		return
+	}
+
+	var dropped uint64
+	for {
+		select {
+		case e := <-live:
+			// 订阅队列溢出过，丢失的事件从历史中补齐
+			if n := atomic.LoadUint64(&s.sub.dropped); n != dropped {
+				dropped = n
+				missed, _, _ := eb.History(last, filter, 0)
+				if !send(missed) {
+					return
+				}
+			}
+			if !send([]ServiceEvent{e}) {
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Close 结束事件流并取消底层订阅，C 随后被关闭
+func (s *EventStream) Close() {
+	s.once.Do(func() {
+		s.sub.Cancel()
+		close(s.done)
+	})
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"ldh-os/init/logging"
+)
+
+// SystemService 内置 MCP 功能使用的保留服务名，例如 system.events
+const SystemService = "system"
+
+const (
+	// defaultEventsLimit system.events 单次返回的默认事件数
+	defaultEventsLimit = 100
+	// maxEventsWait system.events 长轮询的最长等待时间
+	maxEventsWait = 60 * time.Second
+)
+
+// EventsResult system.events 的返回值。调用方用 LastSeq 作为下一次请求的 since
+type EventsResult struct {
+	Events   []ServiceEvent `json:"events"`
+	LastSeq  uint64         `json:"last_seq"`
+	Complete bool           `json:"complete"`
+}
+
+// registerSystemFunctions 注册 init 自身提供的 MCP 功能
+func (sm *ServiceManager) registerSystemFunctions() {
+	sm.mcpHandler.RegisterFunction(SystemService, "events", sm.mcpEvents)
+	sm.mcpHandler.RegisterFunction(SystemService, "event_stats", func(map[string]interface{}) (interface{}, error) {
+		return sm.eventBus.Stats(), nil
+	})
+	sm.mcpHandler.RegisterFunction(SystemService, "jobs", func(map[string]interface{}) (interface{}, error) {
+		return sm.Jobs(), nil
+	})
+	sm.mcpHandler.RegisterFunction(SystemService, "targets", func(map[string]interface{}) (interface{}, error) {
+		return sm.Targets(), nil
+	})
+	sm.mcpHandler.RegisterFunction(SystemService, "isolate", sm.mcpIsolate)
+	sm.mcpHandler.RegisterFunction(SystemService, "timers", func(map[string]interface{}) (interface{}, error) {
+		return sm.Timers(), nil
+	})
+	sm.mcpHandler.RegisterFunction(SystemService, "metrics", sm.mcpMetrics)
+	sm.mcpHandler.RegisterFunction(SystemService, "pressure", func(map[string]interface{}) (interface{}, error) {
+		return sm.Pressure()
+	})
+	sm.mcpHandler.RegisterFunction(SystemService, "log_level", mcpLogLevel)
+}
+
+// RegisterSystemFunction 注册内置 MCP 功能（system.<name>），供 init 的其他模块提供查询接口
+func (sm *ServiceManager) RegisterSystemFunction(name string, fn MCPFunction) error {
+	return sm.mcpHandler.RegisterFunction(SystemService, name, fn)
+}
+
+// LogLevelResult system.log_level 的返回值
+type LogLevelResult struct {
+	Level logging.Level `json:"level"`
+}
+
+// mcpLogLevel 查询日志级别，给出 level 参数时先修改为该级别
+func mcpLogLevel(params map[string]interface{}) (interface{}, error) {
+	if v, ok := params["level"]; ok && v != nil {
+		name, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("parameter level must be a string")
+		}
+		level, err := logging.ParseLevel(name)
+		if err != nil {
+			return nil, err
+		}
+		logging.SetLevel(level)
+		logging.Info("Log level changed", "level", level)
+	}
+	return LogLevelResult{Level: logging.GetLevel()}, nil
+}
+
+// mcpEvents 实现 MCP 事件订阅：返回 since 之后的事件，
+// 没有新事件且 wait > 0 时最多等待 wait 秒（长轮询）。
+// 参数：since, service, types, limit, wait
+func (sm *ServiceManager) mcpEvents(params map[string]interface{}) (interface{}, error) {
+	since, err := paramUint(params, "since")
+	if err != nil {
+		return nil, err
+	}
+	limit, err := paramUint(params, "limit")
+	if err != nil {
+		return nil, err
+	}
+	if limit == 0 {
+		limit = defaultEventsLimit
+	}
+	wait, err := paramUint(params, "wait")
+	if err != nil {
+		return nil, err
+	}
+	filter, err := paramFilter(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// 先记录当前序号：此后没有返回匹配事件时，游标可以安全地前移到这里
+	last := sm.eventBus.LastSeq()
+	events, complete, err := sm.eventBus.History(since, filter, int(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 && wait > 0 {
+		timeout := time.Duration(wait) * time.Second
+		if timeout > maxEventsWait {
+			timeout = maxEventsWait
+		}
+		events, err = sm.waitEvents(since, filter, int(limit), timeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := EventsResult{Events: events, LastSeq: last, Complete: complete}
+	if n := len(events); n > 0 {
+		result.LastSeq = events[n-1].Seq
+	} else {
+		result.Events = []ServiceEvent{}
+	}
+	return result, nil
+}
+
+// mcpIsolate 切换到指定目标。参数：target, rollback
+func (sm *ServiceManager) mcpIsolate(params map[string]interface{}) (interface{}, error) {
+	target, ok := params["target"].(string)
+	if !ok || target == "" {
+		return nil, fmt.Errorf("parameter target must be a non-empty string")
+	}
+	rollback, err := paramBool(params, "rollback")
+	if err != nil {
+		return nil, err
+	}
+	mode := JobPartial
+	if rollback {
+		mode = JobRollback
+	}
+	return sm.IsolateTarget(target, mode)
+}
+
+// mcpMetrics 返回服务最近的资源采样。参数 service 为空时返回所有服务，
+// limit 为每个服务返回的采样数，省略时返回全部
+func (sm *ServiceManager) mcpMetrics(params map[string]interface{}) (interface{}, error) {
+	limit, err := paramUint(params, "limit")
+	if err != nil {
+		return nil, err
+	}
+	filter, err := paramFilter(params)
+	if err != nil {
+		return nil, err
+	}
+	if filter.Service != "" {
+		return sm.Metrics(filter.Service, int(limit))
+	}
+	return sm.AllMetrics(int(limit)), nil
+}
+
+// waitEvents 等待 since 之后的第一个匹配事件，并收集随后已就绪的事件
+func (sm *ServiceManager) waitEvents(since uint64, filter EventFilter, limit int, timeout time.Duration) ([]ServiceEvent, error) {
+	stream, err := sm.eventBus.Follow(since, filter, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var events []ServiceEvent
+	select {
+	case e := <-stream.C:
+		events = append(events, e)
+	case <-timer.C:
+		return nil, nil
+	}
+	for len(events) < limit {
+		select {
+		case e := <-stream.C:
+			events = append(events, e)
+		default:
+			return events, nil
+		}
+	}
+	return events, nil
+}
+
+// paramUint 读取非负整数参数，JSON 数字解码后是 float64
+func paramUint(params map[string]interface{}, name string) (uint64, error) {
+	v, ok := params[name]
+	if !ok || v == nil {
+		return 0, nil
+	}
+	f, ok := v.(float64)
+	if !ok || f < 0 || f != float64(uint64(f)) {
+		return 0, fmt.Errorf("parameter %s must be a non-negative integer", name)
+	}
+	return uint64(f), nil
+}
+
+// paramBool 读取布尔参数，省略时为 false
+func paramBool(params map[string]interface{}, name string) (bool, error) {
+	v, ok := params[name]
+	if !ok || v == nil {
+		return false, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("parameter %s must be a boolean", name)
+	}
+	return b, nil
+}
+
+// paramFilter 从 service 和 types 参数构造事件过滤条件
+func paramFilter(params map[string]interface{}) (EventFilter, error) {
+	var filter EventFilter
+	if v, ok := params["service"]; ok && v != nil {
+		s, ok := v.(string)
+		if !ok {
+			return filter, fmt.Errorf("parameter service must be a string")
+		}
+		filter.Service = s
+	}
+	if v, ok := params["types"]; ok && v != nil {
+		list, ok := v.([]interface{})
+		if !ok {
+			return filter, fmt.Errorf("parameter types must be a list of strings")
+		}
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return filter, fmt.Errorf("parameter types must be a list of strings")
+			}
+			filter.Types = append(filter.Types, EventType(s))
+		}
+	}
+	return filter, nil
+}
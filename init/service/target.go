@@ -0,0 +1,350 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+
+	"ldh-os/init/logging"
+)
+
+// 内置目标，按启动阶段依次包含
+const (
+	TargetBasic   = "basic"   // 基础服务；未声明 targets 的服务属于这里
+	TargetNetwork = "network" // 网络服务
+	TargetLLM     = "llm"     // 模型推理服务
+	TargetAgent   = "agent"   // Agent 服务，默认目标
+	TargetRescue  = "rescue"  // 救援模式，只运行明确属于它的服务
+)
+
+// EventTargetChanged 当前目标变化事件，负载为 TargetEvent
+const EventTargetChanged EventType = "target-changed"
+
+// TargetConfig 定义一个目标：一组服务，以及它包含的其他目标
+type TargetConfig struct {
+	Description string   `yaml:"description"`
+	Requires    []string `yaml:"requires,omitempty"` // 被包含的目标，其服务同样属于本目标
+	Services    []string `yaml:"services,omitempty"` // 除了在服务配置中用 targets 声明的服务之外的成员
+}
+
+// TargetsConfig 目标配置（/etc/ldh-os/targets.yaml）
+type TargetsConfig struct {
+	Default string                  `yaml:"default"` // 内核命令行未指定 ldh.target 时启动的目标
+	Targets map[string]TargetConfig `yaml:"targets"`
+}
+
+// TargetStatus 目标的当前状态
+type TargetStatus struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Requires    []string `json:"requires,omitempty"`
+	Services    []string `json:"services"` // 含被包含目标的服务
+	Current     bool     `json:"current"`
+	Default     bool     `json:"default"`
+}
+
+// TargetEvent 目标变化事件的负载
+type TargetEvent struct {
+	Target   string `json:"target"`
+	Previous string `json:"previous,omitempty"`
+	Job      uint64 `json:"job"`
+	Isolate  bool   `json:"isolate"` // 是否停止了不属于新目标的服务
+}
+
+// DefaultTargets 返回内置的目标配置
+func DefaultTargets() *TargetsConfig {
+	return &TargetsConfig{
+		Default: TargetAgent,
+		Targets: map[string]TargetConfig{
+			TargetBasic:   {Description: "Basic system services"},
+			TargetNetwork: {Description: "Network services", Requires: []string{TargetBasic}},
+			TargetLLM:     {Description: "LLM inference services", Requires: []string{TargetNetwork}},
+			TargetAgent:   {Description: "LLM agent services", Requires: []string{TargetLLM}},
+			TargetRescue:  {Description: "Rescue mode"},
+		},
+	}
+}
+
+// LoadTargets 读取目标配置，文件不存在时返回内置配置。
+// 文件中的目标覆盖同名的内置目标
+func LoadTargets(file string) (*TargetsConfig, error) {
+	config := DefaultTargets()
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read targets config: %v", err)
+	}
+
+	var loaded TargetsConfig
+	if err := yaml.Unmarshal(data, &loaded); err != nil {
+		return nil, fmt.Errorf("failed to parse targets config: %v", err)
+	}
+	if loaded.Default != "" {
+		config.Default = loaded.Default
+	}
+	for name, target := range loaded.Targets {
+		config.Targets[name] = target
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// validate 检查默认目标和被包含的目标都存在，且包含关系无环
+func (c *TargetsConfig) validate() error {
+	if _, ok := c.Targets[c.Default]; !ok {
+		return fmt.Errorf("default target %s is not defined", c.Default)
+	}
+
+	visited := make(map[string]int) // 1: 访问中，2: 已完成
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch visited[name] {
+		case 1:
+			return fmt.Errorf("target %s requires itself", name)
+		case 2:
+			return nil
+		}
+		visited[name] = 1
+		for _, req := range c.Targets[name].Requires {
+			if _, ok := c.Targets[req]; !ok {
+				return fmt.Errorf("target %s requires undefined target %s", name, req)
+			}
+			if err := visit(req); err != nil {
+				return err
+			}
+		}
+		visited[name] = 2
+		return nil
+	}
+	for name := range c.Targets {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// included 返回 name 及其直接或间接包含的所有目标
+func (c *TargetsConfig) included(name string) []string {
+	var result []string
+	var visit func(name string)
+	visit = func(name string) {
+		if containsName(result, name) {
+			return
+		}
+		result = append(result, name)
+		for _, req := range c.Targets[name].Requires {
+			visit(req)
+		}
+	}
+	visit(name)
+	return result
+}
+
+// SetTargets 替换目标配置，配置需已通过 LoadTargets 校验
+func (sm *ServiceManager) SetTargets(config *TargetsConfig) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.targets = config
+
+	for name, service := range sm.services {
+		for _, t := range service.Config.Targets {
+			if _, ok := config.Targets[t]; !ok {
+				logging.Warn("Service belongs to undefined target", "service", name, "target", t)
+			}
+		}
+	}
+}
+
+// DefaultTarget 返回配置的默认目标
+func (sm *ServiceManager) DefaultTarget() string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.targets.Default
+}
+
+// CurrentTarget 返回最近一次成功启动的目标，尚未启动任何目标时为空
+func (sm *ServiceManager) CurrentTarget() string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.target
+}
+
+// Targets 返回所有目标的状态，按名称排序
+func (sm *ServiceManager) Targets() []TargetStatus {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	names := make([]string, 0, len(sm.targets.Targets))
+	for name := range sm.targets.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]TargetStatus, 0, len(names))
+	for _, name := range names {
+		target := sm.targets.Targets[name]
+		result = append(result, TargetStatus{
+			Name:        name,
+			Description: target.Description,
+			Requires:    target.Requires,
+			Services:    sm.targetServicesLocked(name),
+			Current:     name == sm.target,
+			Default:     name == sm.targets.Default,
+		})
+	}
+	return result
+}
+
+// targetServices 返回属于目标（含被包含的目标）的服务，按名称排序
+func (sm *ServiceManager) targetServices(name string) ([]string, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	if _, ok := sm.targets.Targets[name]; !ok {
+		return nil, fmt.Errorf("target %s not found", name)
+	}
+	return sm.targetServicesLocked(name), nil
+}
+
+// targetServicesLocked 同 targetServices，调用方需持有 sm.mu
+func (sm *ServiceManager) targetServicesLocked(name string) []string {
+	targets := sm.targets.included(name)
+
+	var result []string
+	for _, t := range targets {
+		for _, s := range sm.targets.Targets[t].Services {
+			if _, ok := sm.services[s]; ok {
+				result = append(result, s)
+			}
+		}
+	}
+	for svc, service := range sm.services {
+		member := service.Config.Targets
+		if len(member) == 0 {
+			member = []string{TargetBasic}
+		}
+		for _, t := range member {
+			if containsName(targets, t) {
+				result = append(result, svc)
+				break
+			}
+		}
+	}
+	result = mergeNames(result)
+	sort.Strings(result)
+	return result
+}
+
+// inTargetLocked 判断服务是否属于当前目标，尚未启动任何目标时总是成立。
+// 调用方需持有 sm.mu
+func (sm *ServiceManager) inTargetLocked(name string) bool {
+	if sm.target == "" {
+		return true
+	}
+	return containsName(sm.targetServicesLocked(sm.target), name)
+}
+
+// StartTarget 启动目标中的服务及其依赖，不影响其他正在运行的服务
+func (sm *ServiceManager) StartTarget(name string, mode JobMode) (Job, error) {
+	return sm.switchTarget(name, mode, false)
+}
+
+// IsolateTarget 切换到目标：先停止不属于目标、也不被目标中服务依赖的服务，
+// 再启动目标中的服务
+func (sm *ServiceManager) IsolateTarget(name string, mode JobMode) (Job, error) {
+	return sm.switchTarget(name, mode, true)
+}
+
+func (sm *ServiceManager) switchTarget(name string, mode JobMode, isolate bool) (Job, error) {
+	members, err := sm.targetServices(name)
+	if err != nil {
+		return Job{}, err
+	}
+	names := sm.startable(members)
+
+	sm.jobMu.Lock()
+	defer sm.jobMu.Unlock()
+
+	if isolate {
+		reasons, _ := sm.closure(members)
+		if err := sm.stopOutside(reasons); err != nil {
+			logging.Warn("Failed to isolate target", "target", name, "error", err)
+		}
+	}
+
+	job, err := sm.startJob(names, mode)
+	if job.State == JobFailed || job.State == JobRolledBack {
+		return job, fmt.Errorf("failed to reach target %s: %v", name, err)
+	}
+
+	sm.mu.Lock()
+	previous := sm.target
+	sm.target = name
+	sm.mu.Unlock()
+
+	logging.Info("Reached target", "target", name, "job", job.ID)
+	sm.eventBus.Emit(ServiceEvent{
+		Type: EventTargetChanged,
+		Data: TargetEvent{Target: name, Previous: previous, Job: job.ID, Isolate: isolate},
+	})
+	return job, nil
+}
+
+// stopOutside 按依赖顺序的反序停止不在 keep 中的运行中服务
+func (sm *ServiceManager) stopOutside(keep map[string]string) error {
+	services := sm.dependencyOrder()
+
+	var errs []string
+	for i := len(services) - 1; i >= 0; i-- {
+		name := services[i].Config.Name
+		if _, ok := keep[name]; ok || services[i].GetStatus().State != StateRunning {
+			continue
+		}
+		logging.Info("Stopping service outside the new target", "service", name)
+		if err := sm.StopService(name); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to stop services outside target: %v", errs)
+	}
+	return nil
+}
+
+// startable 按依赖顺序返回 names 中可以启动的服务：跳过设备尚未出现的设备触发服务、
+// 等待连接的套接字激活服务、定时器和路径触发的服务以及被屏蔽的服务，互斥的服务只保留排在前面的一个
+func (sm *ServiceManager) startable(names []string) []string {
+	var result []string
+	for _, service := range sm.dependencyOrder() {
+		name := service.Config.Name
+		if !containsName(names, name) {
+			continue
+		}
+		if len(service.Config.StartOnDevice) > 0 && !sm.devices.present(service.Config.StartOnDevice) {
+			continue
+		}
+		if service.socketActivated() || service.Config.Timer != nil || len(service.Config.StartOnPath) > 0 {
+			continue
+		}
+		if sm.IsMasked(name) {
+			logging.Info("Service is masked, not starting", "service", name)
+			continue
+		}
+		if other := firstConflict(sm.conflicting(name), result); other != "" {
+			logging.Warn("Not starting conflicting service", "service", name, "conflict", other)
+			continue
+		}
+		result = append(result, name)
+	}
+	return result
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"ldh-os/init/calendar"
+	"ldh-os/init/logging"
+)
+
+// timerRecheck 等待触发时重新计算剩余时间的最长间隔，系统时钟被校正（例如 NTP 同步）后按新时间触发
+const timerRecheck = time.Minute
+
+// TimerStatus 定时器的状态
+type TimerStatus struct {
+	Service    string    `json:"service"`
+	Calendar   []string  `json:"calendar,omitempty"`
+	OnBoot     string    `json:"on_boot,omitempty"`
+	Persistent bool      `json:"persistent,omitempty"`
+	Next       time.Time `json:"next"` // 下一次触发时间，不再触发时为零值
+	Last       time.Time `json:"last"` // 上次触发时间，从未触发时为零值
+}
+
+// timer 一个服务的定时器
+type timer struct {
+	service   string
+	config    TimerConfig
+	schedules []*calendar.Schedule
+	next      time.Time
+	last      time.Time
+	mu        sync.Mutex
+}
+
+// newTimer 解析服务的定时器配置
+func newTimer(name string, config TimerConfig) (*timer, error) {
+	if len(config.OnCalendar) == 0 && config.OnBoot <= 0 {
+		return nil, fmt.Errorf("timer needs on_calendar or on_boot")
+	}
+	t := &timer{service: name, config: config}
+	for _, expr := range config.OnCalendar {
+		s, err := calendar.Parse(expr)
+		if err != nil {
+			return nil, err
+		}
+		t.schedules = append(t.schedules, s)
+	}
+	return t, nil
+}
+
+// schedule 计算下一次触发时间。on_boot 在本次启动尚未触发过时有效；
+// catchUp 为 true 且启用 persistent 时，停机期间错过的日历触发立即补上
+func (t *timer) schedule(now, boot time.Time, catchUp bool) (next time.Time, missed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	earliest := func(c time.Time) {
+		if !c.IsZero() && (next.IsZero() || c.Before(next)) {
+			next = c
+		}
+	}
+	if t.config.OnBoot > 0 && t.last.Before(boot) {
+		earliest(boot.Add(t.config.OnBoot))
+	}
+	for _, s := range t.schedules {
+		if catchUp && t.config.Persistent && !t.last.IsZero() {
+			if due := s.Next(t.last); !due.IsZero() && !due.After(now) {
+				earliest(now)
+				missed = true
+				continue
+			}
+		}
+		earliest(s.Next(now))
+	}
+	if !next.IsZero() && t.config.RandomDelay > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(t.config.RandomDelay))))
+	}
+	t.next = next
+	return next, missed
+}
+
+func (t *timer) status() TimerStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := TimerStatus{
+		Service:    t.service,
+		Calendar:   t.config.OnCalendar,
+		Persistent: t.config.Persistent,
+		Next:       t.next,
+		Last:       t.last,
+	}
+	if t.config.OnBoot > 0 {
+		st.OnBoot = t.config.OnBoot.String()
+	}
+	return st
+}
+
+// StartTimers 开始运行所有服务的定时器，上次触发时间从持久化的服务记录中恢复。
+// 应在 EnablePersistence 之后调用
+func (sm *ServiceManager) StartTimers() {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	boot := bootTime()
+	for _, t := range sm.timers {
+		t.mu.Lock()
+		t.last = sm.stateManager.LastTrigger(t.service)
+		t.mu.Unlock()
+		go sm.runTimer(t, boot)
+	}
+}
+
+// runTimer 等待定时器到期并启动服务，直到定时器不再触发
+func (sm *ServiceManager) runTimer(t *timer, boot time.Time) {
+	next, missed := t.schedule(time.Now(), boot, true)
+	if missed {
+		logging.Info("Timer missed a trigger while the system was down, catching up", "service", t.service)
+	}
+	for !next.IsZero() {
+		wait := time.Until(next)
+		if wait > timerRecheck {
+			wait = timerRecheck
+		}
+		time.Sleep(wait)
+		if time.Now().Before(next) {
+			continue
+		}
+		sm.triggerTimer(t)
+		next, _ = t.schedule(time.Now(), boot, false)
+	}
+	logging.Info("Timer has no future triggers", "service", t.service)
+}
+
+// triggerTimer 记录触发时间并启动服务。服务仍在运行、不属于当前目标或被屏蔽时跳过这次触发
+func (sm *ServiceManager) triggerTimer(t *timer) {
+	now := time.Now()
+	t.mu.Lock()
+	t.last = now
+	t.mu.Unlock()
+	if err := sm.stateManager.RecordTrigger(t.service, now); err != nil {
+		logging.Warn("Failed to save timer state", "service", t.service, "error", err)
+	}
+
+	service, exists := sm.lookup(t.service)
+	if !exists {
+		return
+	}
+	sm.mu.RLock()
+	allowed := sm.inTargetLocked(t.service) && !sm.masked[t.service]
+	sm.mu.RUnlock()
+	if !allowed {
+		logging.Debug("Timer elapsed for inactive service", "service", t.service)
+		return
+	}
+	if state := service.GetStatus().State; state == StateRunning || state == StateStarting {
+		logging.Info("Timer elapsed while service is still running, skipping", "service", t.service)
+		return
+	}
+
+	logging.Info("Timer elapsed, starting service", "service", t.service)
+	if err := sm.startTriggered(service); err != nil {
+		logging.Warn("Failed to start timer service", "service", t.service, "error", err)
+	}
+}
+
+// startTriggered 启动被触发的服务，已成功完成的 oneshot、periodic 服务再运行一次
+func (sm *ServiceManager) startTriggered(service *Service) error {
+	service.mu.Lock()
+	service.completed = false
+	service.mu.Unlock()
+	return sm.StartService(service.Config.Name)
+}
+
+// Timers 返回所有定时器的状态，按下一次触发时间排序，不再触发的排在最后
+func (sm *ServiceManager) Timers() []TimerStatus {
+	sm.mu.RLock()
+	result := make([]TimerStatus, 0, len(sm.timers))
+	for _, t := range sm.timers {
+		result = append(result, t.status())
+	}
+	sm.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i].Next, result[j].Next
+		if a.IsZero() != b.IsZero() {
+			return b.IsZero()
+		}
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return result[i].Service < result[j].Service
+	})
+	return result
+}
+
+// bootTime 返回系统启动的时间
+func bootTime() time.Time {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_BOOTTIME, &ts); err != nil {
+		return time.Now()
+	}
+	return time.Now().Add(-time.Duration(ts.Nano()))
+}
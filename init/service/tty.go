@@ -0,0 +1,67 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ttyMinRun 终端服务运行时间短于它时视为启动即退出（例如终端不存在）
+	ttyMinRun = 5 * time.Second
+	// ttyRespawnDelay 终端服务快速退出后重新启动前的等待时间，避免空转
+	ttyRespawnDelay = 2 * time.Second
+)
+
+// ttyPath 把 tty 选项转换为设备路径：ttyS0 -> /dev/ttyS0，console -> /dev/console
+func ttyPath(tty string) string {
+	if filepath.IsAbs(tty) {
+		return tty
+	}
+	return filepath.Join("/dev", tty)
+}
+
+// attachTTY 让服务在新会话中运行，并以终端作为标准输入输出和控制终端。
+// 返回的文件在进程启动后由调用方关闭
+func attachTTY(cmd *exec.Cmd, tty string) (*os.File, error) {
+	path := ttyPath(tty)
+	f, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open terminal: %v", err)
+	}
+	if _, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a terminal", path)
+	}
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = f, f, f
+	// Ctty 是子进程中的描述符编号，即标准输入
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	if !hasEnv(cmd.Env, "TERM") {
+		term := "linux"
+		if strings.HasPrefix(filepath.Base(path), "ttyS") {
+			term = "vt220"
+		}
+		cmd.Env = append(cmd.Env, "TERM="+term)
+	}
+	return f, nil
+}
+
+func hasEnv(env []string, key string) bool {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			return true
+		}
+	}
+	return false
+}
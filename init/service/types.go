@@ -1,6 +1,7 @@
 package service
 
 import (
+	"path"
 	"time"
 )
 
@@ -27,15 +28,61 @@ const (
 
 // ServiceConfig 定义服务的配置结构
 type ServiceConfig struct {
-	Name         string            `yaml:"name"`
-	Description  string            `yaml:"description"`
-	Type         ServiceType       `yaml:"type"`
-	ExecPath     string            `yaml:"exec"`
-	Args         []string          `yaml:"args,omitempty"`
-	Dependencies []string          `yaml:"dependencies,omitempty"`
-	Environment  map[string]string `yaml:"environment,omitempty"`
-	Restart      string            `yaml:"restart"`
-	MCPConfig    MCPConfig         `yaml:"mcp"`
+	Name           string            `yaml:"name"`
+	Description    string            `yaml:"description"`
+	Type           ServiceType       `yaml:"type"`
+	ExecPath       string            `yaml:"exec"`
+	Args           []string          `yaml:"args,omitempty"`
+	Dependencies   []string          `yaml:"dependencies,omitempty"` // 兼容旧配置，等价于 requires
+	Requires       []string          `yaml:"requires,omitempty"`     // 必须先运行；它们停止或失败时本服务随之停止
+	Wants          []string          `yaml:"wants,omitempty"`        // 随本服务一起启动，失败不影响本服务
+	After          []string          `yaml:"after,omitempty"`        // 仅排序：在这些服务之后启动
+	Before         []string          `yaml:"before,omitempty"`       // 仅排序：在这些服务之前启动
+	BindsTo        []string          `yaml:"binds_to,omitempty"`     // 同 requires，且它们以任何方式停止时本服务都停止
+	Conflicts      []string          `yaml:"conflicts,omitempty"`    // 互斥：启动本服务时停止这些服务，反之亦然
+	Environment    map[string]string `yaml:"environment,omitempty"`
+	TTY            string            `yaml:"tty,omitempty"` // 终端（例如 ttyS0、tty1），作为标准输入输出和控制终端
+	Restart        string            `yaml:"restart"`
+	StopTimeout    time.Duration     `yaml:"stop_timeout,omitempty"`     // SIGTERM 后等待退出的时间，默认 10s
+	StartOnDevice  []DeviceMatch     `yaml:"start_on_device,omitempty"`  // 匹配的设备出现时才启动
+	StartOnPath    []PathTrigger     `yaml:"start_on_path,omitempty"`    // 路径满足条件时启动，启动目标时不启动
+	Targets        []string          `yaml:"targets,omitempty"`          // 所属的目标，默认 basic
+	Sockets        []SocketConfig    `yaml:"sockets,omitempty"`          // 由 init 监听，首个连接到达时才启动服务
+	IdleTimeout    time.Duration     `yaml:"idle_timeout,omitempty"`     // 套接字激活的服务没有连接超过该时长后停止
+	Timer          *TimerConfig      `yaml:"timer,omitempty"`            // 由定时器触发启动，启动目标时不启动
+	OOMScoreAdj    *int              `yaml:"oom_score_adj,omitempty"`    // -1000 到 1000，越大越先被 OOM killer 杀死、被内存压力处理停止
+	Watchdog       time.Duration     `yaml:"watchdog,omitempty"`         // 服务需要在该时长内通过 NOTIFY_SOCKET 发送 WATCHDOG=1，否则被重新启动
+	StopOnPressure PressureLevel     `yaml:"stop_on_pressure,omitempty"` // 内存压力达到该级别（warning、critical）时停止服务，恢复后重新启动
+	MCPConfig      MCPConfig         `yaml:"mcp"`
+}
+
+// DeviceMatch 描述触发服务启动的设备
+type DeviceMatch struct {
+	Subsystem string `yaml:"subsystem"`         // 例如 sound、input、block
+	DevName   string `yaml:"devname,omitempty"` // /dev 下的相对路径，支持通配符
+}
+
+// PathTrigger 触发服务启动的路径条件，exists、changed、dir_not_empty 只能设置一个，路径必须是绝对路径
+type PathTrigger struct {
+	Exists      string        `yaml:"exists,omitempty"`        // 路径存在
+	Changed     string        `yaml:"changed,omitempty"`       // 文件被写入、创建、删除或移动；为目录时包括其中的文件
+	DirNotEmpty string        `yaml:"dir_not_empty,omitempty"` // 目录中有文件
+	Debounce    time.Duration `yaml:"debounce,omitempty"`      // 最后一次变化之后等待该时长再触发，默认 500ms
+}
+
+// SocketConfig 描述套接字激活的监听套接字
+type SocketConfig struct {
+	Listen string `yaml:"listen"`         // TCP 地址（host:port、:port）或 unix 套接字的绝对路径
+	Name   string `yaml:"name,omitempty"` // 通过 LISTEN_FDNAMES 传给服务的名称，默认为服务名
+	Mode   uint32 `yaml:"mode,omitempty"` // unix 套接字文件的权限，默认 0666
+}
+
+// TimerConfig 定时触发服务的条件，满足任一条件时启动服务
+type TimerConfig struct {
+	OnCalendar  []string      `yaml:"on_calendar,omitempty"`  // cron（"*/5 * * * *"）或 OnCalendar 格式（"Mon..Fri 09:00"）
+	OnBoot      time.Duration `yaml:"on_boot,omitempty"`      // 系统启动后经过该时长触发一次
+	RandomDelay time.Duration `yaml:"random_delay,omitempty"` // 每次触发随机推迟 0 到该时长，错开同时到期的定时器
+	Persistent  bool          `yaml:"persistent,omitempty"`   // 启动时补上停机期间错过的日历触发
 }
 
 // MCPConfig 定义 MCP 相关配置
@@ -46,21 +93,117 @@ type MCPConfig struct {
 
 // ServiceStatus 定义服务的运行时状态
 type ServiceStatus struct {
-	State        ServiceState
-	Pid          int
-	StartTime    time.Time
-	RestartCount int
-	LastError    error
+	State        ServiceState   `json:"state"`
+	Pid          int            `json:"pid,omitempty"`
+	StartTime    time.Time      `json:"start_time"`
+	RestartCount int            `json:"restart_count"`
+	LastError    string         `json:"last_error,omitempty"`
+	Metrics      *ResourceUsage `json:"metrics,omitempty"` // 最近一次资源采样，只在运行中且已采样时存在
 }
 
 // EventType 定义事件类型
 type EventType string
 
+// 服务生命周期事件，负载均为 LifecycleEvent
 const (
-	EventStarting EventType = "starting"
-	EventStarted  EventType = "started"
-	EventStopping EventType = "stopping"
-	EventStopped  EventType = "stopped"
-	EventFailed   EventType = "failed"
-	EventRestart  EventType = "restart"
+	EventStarting   EventType = "starting"   // 正在启动进程
+	EventStarted    EventType = "started"    // 进程已启动或已被接管
+	EventReady      EventType = "ready"      // 服务可用：daemon 启动后，oneshot 成功完成后
+	EventStopping   EventType = "stopping"   // 开始停止
+	EventStopped    EventType = "stopped"    // 已停止
+	EventExited     EventType = "exited"     // 进程退出，携带退出状态
+	EventFailed     EventType = "failed"     // 服务失败，携带原因
+	EventRestarting EventType = "restarting" // 即将重启，携带重启次数
+	EventUnhealthy  EventType = "unhealthy"  // 服务运行但不健康，携带原因
+
+	EventDeviceAdded   EventType = "device-added"
+	EventDeviceRemoved EventType = "device-removed"
+	EventDeviceChanged EventType = "device-changed"
+
+	EventLinkUp   EventType = "link-up"
+	EventLinkDown EventType = "link-down"
+
+	EventPathChanged EventType = "path-changed" // 服务监视的路径发生变化，负载为 PathEvent
+
+	EventPressure EventType = "pressure" // 系统资源压力级别变化，或因内存压力停止、重新启动服务，负载为 PressureEvent
+	EventOOMKill  EventType = "oom-kill" // 内核 OOM killer 杀死了进程，负载为 OOMEvent
 )
+
+// LifecycleEvent 服务生命周期事件的负载，是事件发生时服务状态的快照，
+// 与服务内部状态不共享任何数据，发送后不再修改
+type LifecycleEvent struct {
+	State        ServiceState `json:"state"`
+	Pid          int          `json:"pid,omitempty"`
+	RestartCount int          `json:"restart_count"`
+	Adopted      bool         `json:"adopted,omitempty"`    // started：接管的已有进程
+	Exit         *ExitStatus  `json:"exit,omitempty"`       // exited：退出状态
+	Reason       string       `json:"reason,omitempty"`     // failed、unhealthy：原因
+	Attempt      int          `json:"attempt,omitempty"`    // restarting：第几次重启
+	Restarting   bool         `json:"restarting,omitempty"` // stopped、failed：随后按重启策略自动重启
+}
+
+// ExitStatus 进程的退出状态
+type ExitStatus struct {
+	Code   int    `json:"code"`             // 退出码，被信号终止或无法获知时为 -1
+	Signal string `json:"signal,omitempty"` // 终止进程的信号
+}
+
+// Success 判断进程是否正常退出
+func (e ExitStatus) Success() bool {
+	return e.Code == 0 && e.Signal == ""
+}
+
+// LinkEvent 网络接口状态变化事件的负载
+type LinkEvent struct {
+	Interface string `json:"interface"`
+	Index     int    `json:"index"`
+	Up        bool   `json:"up"` // 接口已启用且物理链路就绪
+}
+
+// PathEvent 路径触发事件的负载，一次防抖期间的变化合并为一个事件
+type PathEvent struct {
+	Trigger string   `json:"trigger"`         // exists、changed 或 dir_not_empty
+	Path    string   `json:"path"`            // 配置中的路径
+	Files   []string `json:"files,omitempty"` // 发生变化的文件
+	Ops     []string `json:"ops,omitempty"`   // 发生的操作：create、write、delete、move、attrib，启动时满足条件为 exists
+}
+
+// PressureEvent 资源压力事件的负载。Action 为空时表示 Resource 的压力级别从 Previous 变为 Level；
+// 否则表示因内存压力对服务（事件的 Service）采取的措施：stop 或 start
+type PressureEvent struct {
+	Resource string        `json:"resource"` // memory、cpu 或 io
+	Level    PressureLevel `json:"level"`
+	Previous PressureLevel `json:"previous,omitempty"`
+	Action   string        `json:"action,omitempty"`
+	Pressure *Pressure     `json:"pressure,omitempty"` // 内核不支持 PSI 时为空
+	Memory   *MemoryStats  `json:"memory,omitempty"`   // 只用于 memory
+}
+
+// OOMEvent OOM killer 事件的负载
+type OOMEvent struct {
+	Kills uint64 `json:"kills"` // 上次检查以来被杀死的进程数
+	Total uint64 `json:"total"` // 本次启动以来的总数
+}
+
+// DeviceEvent 设备热插拔事件的负载
+type DeviceEvent struct {
+	Action    string            `json:"action"`
+	DevPath   string            `json:"devpath"`
+	Subsystem string            `json:"subsystem"`
+	DevName   string            `json:"devname,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Matches 判断设备是否满足匹配条件
+func (m DeviceMatch) Matches(dev DeviceEvent) bool {
+	if m.Subsystem != "" && m.Subsystem != dev.Subsystem {
+		return false
+	}
+	if m.DevName != "" {
+		ok, err := path.Match(m.DevName, dev.DevName)
+		if err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
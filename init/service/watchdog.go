@@ -0,0 +1,222 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"ldh-os/init/logging"
+)
+
+// maxNotifyMessage 单条通知的最大长度
+const maxNotifyMessage = 4096
+
+// watchdogState 服务软件看门狗的状态，受 Service.mu 保护
+type watchdogState struct {
+	pid     int           // 被监视的进程，进程变化后旧的计时失效
+	timeout time.Duration // 服务通过 WATCHDOG_USEC= 修改的超时，0 表示使用配置
+	timer   *time.Timer
+}
+
+// StartNotify 在 path 上接收服务的通知（sd_notify 协议，unix 数据报套接字）。
+// 配置了 watchdog 的服务通过环境变量 NOTIFY_SOCKET 和 WATCHDOG_USEC 得知地址和超时，
+// 需要在超时内定期发送 WATCHDOG=1，否则被视为挂起并重新启动
+func (sm *ServiceManager) StartNotify(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	os.Remove(path)
+
+	fd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to create notify socket: %v", err)
+	}
+	// 内核为每条消息附上发送者的凭据，据此找到服务
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_PASSCRED, 1); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("failed to enable credentials on notify socket: %v", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrUnix{Name: path}); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("failed to bind notify socket %s: %v", path, err)
+	}
+	if err := os.Chmod(path, 0666); err != nil {
+		unix.Close(fd)
+		return err
+	}
+
+	sm.mu.Lock()
+	sm.notify = path
+	for _, service := range sm.services {
+		service.mu.Lock()
+		service.notify = path
+		service.mu.Unlock()
+	}
+	sm.mu.Unlock()
+
+	go sm.readNotify(fd)
+	return nil
+}
+
+// readNotify 读取通知并交给发送者所属的服务
+func (sm *ServiceManager) readNotify(fd int) {
+	buf := make([]byte, maxNotifyMessage)
+	oob := make([]byte, unix.CmsgSpace(unix.SizeofUcred))
+	for {
+		n, oobn, _, _, err := unix.Recvmsg(fd, buf, oob, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			logging.Warn("Notify socket closed", "error", err)
+			return
+		}
+		pid := 0
+		if msgs, err := unix.ParseSocketControlMessage(oob[:oobn]); err == nil {
+			for i := range msgs {
+				if cred, err := unix.ParseUnixCredentials(&msgs[i]); err == nil {
+					pid = int(cred.Pid)
+				}
+			}
+		}
+		sm.handleNotify(pid, string(buf[:n]))
+	}
+}
+
+// handleNotify 处理一条通知。支持 WATCHDOG=1（喂狗）、WATCHDOG=trigger（立即按超时处理）
+// 和 WATCHDOG_USEC=（修改超时），其他字段被忽略
+func (sm *ServiceManager) handleNotify(pid int, message string) {
+	service := sm.serviceForPid(pid)
+	if service == nil {
+		logging.Debug("Notification from unknown process", "pid", pid)
+		return
+	}
+	for _, line := range strings.Split(message, "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		switch {
+		case key == "WATCHDOG" && value == "1":
+			sm.pingWatchdog(service)
+		case key == "WATCHDOG" && value == "trigger":
+			sm.watchdogExpired(service, service.GetStatus().Pid, "watchdog triggered by service")
+		case key == "WATCHDOG_USEC":
+			usec, err := strconv.ParseUint(value, 10, 64)
+			if err != nil || usec == 0 {
+				logging.Debug("Invalid WATCHDOG_USEC", "service", service.Config.Name, "value", value)
+				continue
+			}
+			service.mu.Lock()
+			if w := service.watchdog; w != nil {
+				w.timeout = time.Duration(usec) * time.Microsecond
+			}
+			service.mu.Unlock()
+			sm.pingWatchdog(service)
+		}
+	}
+}
+
+// serviceForPid 返回进程所属的服务：服务的主进程或其子孙进程
+func (sm *ServiceManager) serviceForPid(pid int) *Service {
+	if pid <= 1 {
+		return nil
+	}
+	sm.mu.RLock()
+	byPid := make(map[int]*Service, len(sm.services))
+	for _, service := range sm.services {
+		if status := service.GetStatus(); status.Pid > 0 {
+			byPid[status.Pid] = service
+		}
+	}
+	sm.mu.RUnlock()
+
+	// 沿父进程向上查找，限制层数以防 /proc 在读取期间变化形成环
+	for depth := 0; depth < 64 && pid > 1; depth++ {
+		if service, ok := byPid[pid]; ok {
+			return service
+		}
+		ppid, _, ok := readStatFields(pid)
+		if !ok {
+			return nil
+		}
+		pid = ppid
+	}
+	return nil
+}
+
+// handleWatchdogEvent 服务进程启动（或被接管）时开始计时，停止时取消
+func (sm *ServiceManager) handleWatchdogEvent(event ServiceEvent) {
+	service, exists := sm.lookup(event.Service)
+	if !exists || service.Config.Watchdog <= 0 {
+		return
+	}
+	lc, _ := event.Data.(LifecycleEvent)
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if w := service.watchdog; w != nil {
+		w.timer.Stop()
+		service.watchdog = nil
+	}
+	if event.Type != EventStarted || lc.Pid <= 0 || service.status.Pid != lc.Pid {
+		return
+	}
+	w := &watchdogState{pid: lc.Pid}
+	w.timer = time.AfterFunc(service.Config.Watchdog, func() {
+		sm.watchdogExpired(service, w.pid, "watchdog timeout")
+	})
+	service.watchdog = w
+}
+
+// pingWatchdog 收到 WATCHDOG=1 后重新计时
+func (sm *ServiceManager) pingWatchdog(service *Service) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	w := service.watchdog
+	if w == nil {
+		return
+	}
+	timeout := service.Config.Watchdog
+	if w.timeout > 0 {
+		timeout = w.timeout
+	}
+	w.timer.Reset(timeout)
+}
+
+// watchdogExpired 服务在超时内没有喂狗：报告不健康并重新启动服务。
+// pid 是计时开始时的进程，服务已经重启或停止时不做处理
+func (sm *ServiceManager) watchdogExpired(service *Service, pid int, reason string) {
+	service.mu.Lock()
+	w := service.watchdog
+	current := w != nil && w.pid == pid && service.status.State == StateRunning && service.status.Pid == pid
+	if current {
+		w.timer.Stop()
+		service.watchdog = nil
+	}
+	service.mu.Unlock()
+	if !current {
+		return
+	}
+
+	logging.Warn("Service watchdog expired, restarting service", "service", service.Config.Name, "pid", pid, "reason", reason)
+	service.ReportUnhealthy(reason)
+	go func() {
+		if err := sm.RestartService(service.Config.Name); err != nil {
+			logging.Warn("Failed to restart service after watchdog timeout", "service", service.Config.Name, "error", err)
+		}
+	}()
+}
+
+// watchdogEnv 返回配置了 watchdog 的服务进程的通知地址和超时，调用方需持有 s.mu
+func (s *Service) watchdogEnv() []string {
+	if s.Config.Watchdog <= 0 || s.notify == "" {
+		return nil
+	}
+	return []string{
+		"NOTIFY_SOCKET=" + s.notify,
+		fmt.Sprintf("WATCHDOG_USEC=%d", s.Config.Watchdog/time.Microsecond),
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"ldh-os/init/logging"
+	"ldh-os/init/mount"
+
+	"golang.org/x/sys/unix"
+)
+
+// shutdownAction 关机流程最终执行的动作
+type shutdownAction string
+
+const (
+	actionPoweroff shutdownAction = "poweroff"
+	actionReboot   shutdownAction = "reboot"
+	actionHalt     shutdownAction = "halt"
+	actionKexec    shutdownAction = "kexec"
+)
+
+// rebootCommands 关机动作对应的 reboot(2) 命令
+var rebootCommands = map[shutdownAction]int{
+	actionPoweroff: unix.LINUX_REBOOT_CMD_POWER_OFF,
+	actionReboot:   unix.LINUX_REBOOT_CMD_RESTART,
+	actionHalt:     unix.LINUX_REBOOT_CMD_HALT,
+	actionKexec:    unix.LINUX_REBOOT_CMD_KEXEC,
+}
+
+const (
+	// servicesStopTimeout 停止所有服务的总时限
+	servicesStopTimeout = 30 * time.Second
+	// processTermTimeout 向剩余进程发送 SIGTERM 后等待的时间
+	processTermTimeout = 5 * time.Second
+)
+
+// systemReboot 以 PID 1 运行时的最终系统调用
+func systemReboot(cmd int) error {
+	return unix.Reboot(cmd)
+}
+
+// testModeReboot 非 PID 1 运行时代替 reboot(2)，直接退出进程
+func testModeReboot(cmd int) error {
+	logging.Info("Not running as PID 1, exiting instead of reboot", "cmd", fmt.Sprintf("%#x", cmd))
+	os.Exit(0)
+	return nil
+}
+
+// shutdown 执行完整的关机流程：按依赖反序停止服务，终止剩余进程，
+// 同步并卸载所有文件系统，最后调用 reboot(2)。以 PID 1 运行时不会返回
+func (i *InitSystem) shutdown(action shutdownAction) {
+	i.mu.Lock()
+	if i.state == "shutdown" {
+		i.mu.Unlock()
+		return
+	}
+	i.state = "shutdown"
+	i.mu.Unlock()
+	logging.Info("Starting shutdown sequence", "action", action)
+	i.shutdownWatchdog()
+
+	logging.Info("Shutting down all services")
+	if err := i.serviceManager.StopAllWithin(servicesStopTimeout); err != nil {
+		logging.Error("Failed to stop services", "error", err)
+	}
+
+	logging.Info("Sending SIGTERM to remaining processes")
+	if !i.killRemaining(syscall.SIGTERM, processTermTimeout) {
+		logging.Info("Sending SIGKILL to remaining processes")
+		i.killRemaining(syscall.SIGKILL, processTermTimeout)
+	}
+
+	logging.Info("Syncing filesystems")
+	i.serviceManager.EventBus().FlushJournal(journalFlushTimeout)
+	i.closeJournal()
+	i.closeInitLog()
+	unix.Sync()
+
+	if i.pid1 {
+		logging.Info("Unmounting filesystems")
+		mounts, err := mount.ReadMountInfo()
+		if err != nil {
+			logging.Error("Failed to read mount table", "error", err)
+		}
+		for _, r := range mount.UnmountAll(i.mounter, mounts) {
+			switch {
+			case r.Err != nil:
+				logging.Warn("Failed to unmount", "path", r.MountPoint, "error", r.Err)
+			case r.ReadOnly:
+				logging.Info("Remounted read-only", "path", r.MountPoint)
+			}
+		}
+		unix.Sync()
+	}
+
+	logging.Info("System going down", "action", action)
+	cmd := rebootCommands[action]
+	if err := i.rebootHook(cmd); err != nil {
+		logging.Error("reboot(2) failed", "action", action, "error", err)
+		if action == actionKexec {
+			// 没有加载 kexec 内核时退回普通重启
+			err = i.rebootHook(unix.LINUX_REBOOT_CMD_RESTART)
+		}
+		if err != nil {
+			logging.Error("Unable to complete shutdown, halting in place", "action", action)
+		}
+	}
+
+	// PID 1 永远不能退出，否则内核会 panic
+	select {}
+}
+
+// killRemaining 向所有剩余进程发送信号并回收，返回是否在超时前全部退出
+func (i *InitSystem) killRemaining(sig syscall.Signal, timeout time.Duration) bool {
+	for _, pid := range i.remainingProcesses() {
+		unix.Kill(pid, sig)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		reapChildren()
+		if len(i.remainingProcesses()) == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// remainingProcesses 列出关机时需要终止的进程。
+// 以 PID 1 运行时是除内核线程外的所有进程，否则只包括本进程的后代
+func (i *InitSystem) remainingProcesses() []int {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	self := os.Getpid()
+	parents := make(map[int]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		ppid, state, ok := readProcStat(pid)
+		if !ok || state == "Z" {
+			continue
+		}
+		// 内核线程都是 kthreadd (pid 2) 的子进程
+		if pid == 2 || ppid == 2 {
+			continue
+		}
+		parents[pid] = ppid
+	}
+
+	var pids []int
+	for pid := range parents {
+		if i.pid1 || isDescendant(pid, self, parents) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// isDescendant 判断 pid 是否是 ancestor 的后代
+func isDescendant(pid, ancestor int, parents map[int]int) bool {
+	for depth := 0; depth < len(parents)+1; depth++ {
+		ppid, ok := parents[pid]
+		if !ok {
+			return false
+		}
+		if ppid == ancestor {
+			return true
+		}
+		pid = ppid
+	}
+	return false
+}
+
+// readProcStat 读取进程的父进程号和状态
+func readProcStat(pid int) (ppid int, state string, ok bool) {
+	data, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, "", false
+	}
+	s := string(data)
+	idx := strings.LastIndexByte(s, ')')
+	if idx < 0 {
+		return 0, "", false
+	}
+	fields := strings.Fields(s[idx+1:])
+	if len(fields) < 2 {
+		return 0, "", false
+	}
+	ppid, err = strconv.Atoi(fields[1])
+	return ppid, fields[0], err == nil
+}
+
+// reapChildren 回收所有已退出的子进程
+func reapChildren() {
+	for {
+		var ws unix.WaitStatus
+		pid, err := unix.Wait4(-1, &ws, unix.WNOHANG, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if pid <= 0 || err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"ldh-os/init/logging"
+)
+
+const (
+	// defaultWatchdogDevice 硬件看门狗设备
+	defaultWatchdogDevice = "/dev/watchdog"
+	// watchdogFileName 跨 re-exec 保留的看门狗设备描述符名称
+	watchdogFileName = "watchdog"
+	// shutdownWatchdogTimeout 关机期间不再喂狗，把超时放宽到足以完成关机；
+	// 关机卡住超过该时长时由硬件重启
+	shutdownWatchdogTimeout = 10 * time.Minute
+	// reexecWatchdogTimeout re-exec 期间的超时，新进程进入主循环时恢复配置的超时
+	reexecWatchdogTimeout = 5 * time.Minute
+)
+
+// watchdogDevice 硬件看门狗，测试时用假设备代替
+type watchdogDevice interface {
+	// SetTimeout 设置超时，返回驱动实际采用的超时
+	SetTimeout(timeout time.Duration) (time.Duration, error)
+	Ping() error
+	// Close 停止看门狗并关闭设备
+	Close() error
+}
+
+// devWatchdog /dev/watchdog 字符设备
+type devWatchdog struct {
+	f *os.File
+}
+
+// openWatchdog 打开看门狗设备，打开即开始计时
+func openWatchdog(path string) (*devWatchdog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &devWatchdog{f: f}, nil
+}
+
+func (d *devWatchdog) SetTimeout(timeout time.Duration) (time.Duration, error) {
+	seconds := int32(timeout / time.Second)
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, d.f.Fd(), unix.WDIOC_SETTIMEOUT, uintptr(unsafe.Pointer(&seconds)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func (d *devWatchdog) Ping() error {
+	_, err := d.f.Write([]byte{0})
+	return err
+}
+
+// Close 先写入魔术字符 V，驱动据此在关闭时停止看门狗（编译了 nowayout 的驱动除外）
+func (d *devWatchdog) Close() error {
+	d.f.Write([]byte("V"))
+	return d.f.Close()
+}
+
+// startWatchdog 打开硬件看门狗并由主循环定期喂狗：主循环卡住时停止喂狗，超时后由硬件重启系统。
+// re-exec 后沿用上一个 init 进程保留的设备描述符，期间生效的是 re-exec 前放宽的超时
+func (i *InitSystem) startWatchdog(path string, timeout time.Duration) error {
+	i.mu.Lock()
+	f := i.files[watchdogFileName]
+	i.mu.Unlock()
+
+	dev := &devWatchdog{f: f}
+	if f == nil {
+		var err error
+		if dev, err = openWatchdog(path); err != nil {
+			return fmt.Errorf("failed to open watchdog %s: %v", path, err)
+		}
+		i.keepFile(watchdogFileName, dev.f)
+	}
+	i.useWatchdog(dev, timeout)
+	return nil
+}
+
+// releaseWatchdog 看门狗未启用时停止 re-exec 之前打开的看门狗
+func (i *InitSystem) releaseWatchdog() {
+	i.mu.Lock()
+	f := i.files[watchdogFileName]
+	delete(i.files, watchdogFileName)
+	i.mu.Unlock()
+	if f != nil {
+		logging.Info("Hardware watchdog no longer enabled, disarming it")
+		(&devWatchdog{f: f}).Close()
+	}
+}
+
+// useWatchdog 设置超时，并开始在主循环中以超时的三分之一为间隔喂狗：
+// 主循环卡住（包括执行耗时的操作）时停止喂狗，超时后由硬件重启系统
+func (i *InitSystem) useWatchdog(dev watchdogDevice, timeout time.Duration) {
+	actual, err := dev.SetTimeout(timeout)
+	if err != nil || actual <= 0 {
+		logging.Warn("Failed to set watchdog timeout, keeping driver default", "timeout", timeout, "error", err)
+		actual = timeout
+	}
+	dev.Ping()
+
+	i.mu.Lock()
+	i.watchdog = dev
+	i.watchdogTimeout = actual
+	i.mu.Unlock()
+	i.watchdogTicker = time.NewTicker(actual / 3)
+	i.watchdogTick = i.watchdogTicker.C
+	logging.Info("Hardware watchdog armed", "timeout", actual)
+}
+
+// petWatchdog 喂狗，在主循环中调用
+func (i *InitSystem) petWatchdog() {
+	i.mu.Lock()
+	dev := i.watchdog
+	i.mu.Unlock()
+	if dev == nil {
+		return
+	}
+	if err := dev.Ping(); err != nil {
+		logging.Warn("Failed to ping watchdog", "error", err)
+	}
+}
+
+// stopPetting 停止主循环喂狗，返回看门狗设备，未启用时返回 nil。
+// 与主循环在同一协程中调用，返回后主循环不会再喂狗
+func (i *InitSystem) stopPetting() watchdogDevice {
+	if i.watchdogTicker != nil {
+		i.watchdogTicker.Stop()
+		i.watchdogTicker = nil
+		i.watchdogTick = nil
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.watchdog
+}
+
+// reexecWatchdog re-exec 之前放宽看门狗超时，新进程接管之前不会被重启；
+// 返回的函数在 exec 失败时恢复原来的超时
+func (i *InitSystem) reexecWatchdog() (restore func()) {
+	i.mu.Lock()
+	dev, timeout := i.watchdog, i.watchdogTimeout
+	i.mu.Unlock()
+	if dev == nil {
+		return func() {}
+	}
+	if _, err := dev.SetTimeout(reexecWatchdogTimeout); err != nil {
+		logging.Warn("Failed to extend watchdog timeout for re-exec", "error", err)
+	}
+	dev.Ping()
+	return func() {
+		if _, err := dev.SetTimeout(timeout); err != nil {
+			logging.Warn("Failed to restore watchdog timeout", "error", err)
+		}
+		dev.Ping()
+	}
+}
+
+// shutdownWatchdog 关机开始时停止喂狗并放宽看门狗超时；驱动不支持时停止看门狗，避免在关机途中被重启
+func (i *InitSystem) shutdownWatchdog() {
+	dev := i.stopPetting()
+	if dev == nil {
+		return
+	}
+	if _, err := dev.SetTimeout(shutdownWatchdogTimeout); err != nil {
+		logging.Warn("Failed to extend watchdog timeout for shutdown, disarming it", "error", err)
+		dev.Close()
+		i.mu.Lock()
+		i.watchdog = nil
+		i.mu.Unlock()
+		return
+	}
+	dev.Ping()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWatchdog 记录调用的假看门狗设备
+type fakeWatchdog struct {
+	timeouts   []time.Duration
+	pings      int
+	closed     bool
+	setTimeout error
+	mu         sync.Mutex
+}
+
+func (f *fakeWatchdog) SetTimeout(timeout time.Duration) (time.Duration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.setTimeout != nil {
+		return 0, f.setTimeout
+	}
+	f.timeouts = append(f.timeouts, timeout)
+	return timeout, nil
+}
+
+func (f *fakeWatchdog) Ping() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pings++
+	return nil
+}
+
+func (f *fakeWatchdog) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeWatchdog) pingCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pings
+}
+
+func TestHardwareWatchdog(t *testing.T) {
+	i := NewInitSystem()
+	dev := &fakeWatchdog{}
+	i.useWatchdog(dev, 300*time.Millisecond)
+	go i.handleSignals()
+
+	time.Sleep(450 * time.Millisecond)
+	if n := dev.pingCount(); n < 3 {
+		t.Fatalf("Expected main loop to ping the watchdog, got %d pings", n)
+	}
+
+	// 主循环卡住期间不再喂狗
+	release := make(chan struct{})
+	i.actions <- func() { <-release }
+	time.Sleep(150 * time.Millisecond)
+	stalled := dev.pingCount()
+	time.Sleep(400 * time.Millisecond)
+	if n := dev.pingCount(); n != stalled {
+		t.Fatalf("Expected no pings while the main loop is stalled, got %d more", n-stalled)
+	}
+	close(release)
+	time.Sleep(250 * time.Millisecond)
+	if n := dev.pingCount(); n <= stalled {
+		t.Fatal("Expected pings to resume after the main loop recovers")
+	}
+
+	// 在主循环中停止喂狗后不再有新的 ping
+	done := make(chan struct{})
+	i.actions <- func() {
+		i.stopPetting()
+		close(done)
+	}
+	<-done
+	stopped := dev.pingCount()
+	time.Sleep(250 * time.Millisecond)
+	if n := dev.pingCount(); n != stopped {
+		t.Errorf("Expected no pings after stopping, got %d more", n-stopped)
+	}
+}
+
+func TestReexecWatchdog(t *testing.T) {
+	i := NewInitSystem()
+	dev := &fakeWatchdog{}
+	i.useWatchdog(dev, time.Minute)
+	defer i.stopPetting()
+
+	// re-exec 期间放宽超时，exec 失败时恢复
+	restore := i.reexecWatchdog()
+	restore()
+	want := []time.Duration{time.Minute, reexecWatchdogTimeout, time.Minute}
+	if !reflect.DeepEqual(dev.timeouts, want) {
+		t.Errorf("Expected timeouts %v, got %v", want, dev.timeouts)
+	}
+}
+
+func TestShutdownWatchdog(t *testing.T) {
+	i := NewInitSystem()
+	dev := &fakeWatchdog{}
+	i.useWatchdog(dev, time.Minute)
+	i.shutdownWatchdog()
+	if len(dev.timeouts) != 2 || dev.timeouts[1] != shutdownWatchdogTimeout || dev.closed || i.watchdogTick != nil {
+		t.Errorf("Expected shutdown to extend the timeout, got %+v", dev)
+	}
+
+	// 驱动不接受新的超时时停止看门狗
+	i = NewInitSystem()
+	dev = &fakeWatchdog{}
+	i.useWatchdog(dev, time.Minute)
+	dev.setTimeout = fmt.Errorf("not supported")
+	i.shutdownWatchdog()
+	if !dev.closed || i.watchdog != nil {
+		t.Errorf("Expected watchdog to be disarmed, got %+v", dev)
+	}
+}